@@ -0,0 +1,525 @@
+// Package client implements a Go client for Hagall servers.
+//
+// It takes care of the protocol details shared by every client: connecting
+// with the auth headers, correlating responses to requests by request id,
+// answering server pings and tracking the server clock. Broadcasts are
+// delivered to typed callbacks.
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/segmentio/encoding/json"
+	"golang.org/x/net/websocket"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	ErrTypeClosed             = "client-closed"
+	ErrTypeErrorResponse      = "error-response"
+	ErrTypeInvalidRequest     = "invalid-request"
+	ErrTypeUnexpectedResponse = "unexpected-response"
+
+	// The protobuf field number of the request id in requests and responses.
+	requestIDFieldNumber = 1337
+
+	// The number of received broadcasts waiting to be passed to callbacks
+	// before the client stops reading from the connection.
+	callbackQueueSize = 256
+
+	// The query parameter that makes the connection join sessions as a
+	// spectator.
+	spectatorQueryParam = "spectator"
+
+	// The query parameters that set the participant info.
+	displayNameQueryParam = "display_name"
+	avatarQueryParam      = "avatar"
+	deviceTypeQueryParam  = "device_type"
+	metadataQueryParam    = "metadata"
+
+	// The query parameters that set the info of the created sessions.
+	sessionNameQueryParam     = "session_name"
+	sessionMetadataQueryParam = "session_metadata"
+	privateSessionQueryParam  = "private_session"
+)
+
+// Options are the options to connect to a Hagall server.
+type Options struct {
+	// The Hagall server endpoint. http and https endpoints are converted to
+	// ws and wss.
+	Endpoint string
+
+	// The Hagall user token sent as a bearer token.
+	Token string
+
+	// The posemesh client id.
+	ClientID string
+
+	// The user agent sent in the connection request.
+	UserAgent string
+
+	// The origin sent in the connection request. Defaults to the endpoint.
+	Origin string
+
+	// Joins sessions as a spectator that receives the session state and
+	// broadcasts but can't modify the session.
+	Spectator bool
+
+	// The participant info given to the joined sessions.
+	DisplayName string
+	Avatar      string
+	DeviceType  string
+	Metadata    map[string]string
+
+	// The info of the sessions created when joining without session id.
+	// Private sessions are not listed by ListSessions.
+	SessionName     string
+	SessionMetadata map[string]string
+	PrivateSession  bool
+
+	// The token that resumes a participant of a session migrated from another
+	// server. See OnMigrate.
+	ResumeToken string
+}
+
+// Client is a connection to a Hagall server.
+//
+// Methods are safe to be called from multiple goroutines and from callbacks.
+type Client struct {
+	conn      *websocket.Conn
+	sendMutex sync.Mutex
+
+	requestID uint32
+
+	mutex         sync.Mutex
+	pending       map[uint32]chan hwebsocket.Msg
+	callbacks     map[protoreflect.EnumNumber][]func(hwebsocket.Msg)
+	sessionID     string
+	sessionUUID   string
+	participantID uint32
+
+	serverPending      map[uint32]chan serverMessage
+	hostCallbacks      []func(uint32)
+	leaveCallbacks     []func(uint32, LeaveReason)
+	goingAwayCallbacks []func(GoingAway)
+	migrateCallbacks   []func(Migration)
+	participantUpdates []func(Participant)
+	hostID             uint32
+
+	// The difference between the server and the local clock, in nanoseconds.
+	clockOffset int64
+
+	queue     chan hwebsocket.Msg
+	closed    int32
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Dial connects to a Hagall server.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	endpoint := strings.Replace(opts.Endpoint, "https://", "wss://", 1)
+	endpoint = strings.Replace(endpoint, "http://", "ws://", 1)
+
+	origin := opts.Origin
+	if origin == "" {
+		origin = opts.Endpoint
+	}
+
+	config, err := websocket.NewConfig(endpoint, origin)
+	if err != nil {
+		return nil, errors.New("creating websocket config failed").
+			WithTag("endpoint", opts.Endpoint).
+			Wrap(err)
+	}
+	if opts.Token != "" {
+		config.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	if opts.ClientID != "" {
+		config.Header.Set(httpcmn.HeaderPosemeshClientID, opts.ClientID)
+	}
+	if opts.UserAgent != "" {
+		config.Header.Set("User-Agent", opts.UserAgent)
+	}
+
+	query := config.Location.Query()
+	query.Set(serverMessagesQueryParam, "true")
+	if opts.Spectator {
+		query.Set(spectatorQueryParam, "true")
+	}
+	if opts.DisplayName != "" {
+		query.Set(displayNameQueryParam, opts.DisplayName)
+	}
+	if opts.Avatar != "" {
+		query.Set(avatarQueryParam, opts.Avatar)
+	}
+	if opts.DeviceType != "" {
+		query.Set(deviceTypeQueryParam, opts.DeviceType)
+	}
+	if len(opts.Metadata) != 0 {
+		metadata, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return nil, errors.New("encoding participant metadata failed").Wrap(err)
+		}
+		query.Set(metadataQueryParam, string(metadata))
+	}
+	if opts.SessionName != "" {
+		query.Set(sessionNameQueryParam, opts.SessionName)
+	}
+	if len(opts.SessionMetadata) != 0 {
+		metadata, err := json.Marshal(opts.SessionMetadata)
+		if err != nil {
+			return nil, errors.New("encoding session metadata failed").Wrap(err)
+		}
+		query.Set(sessionMetadataQueryParam, string(metadata))
+	}
+	if opts.PrivateSession {
+		query.Set(privateSessionQueryParam, "true")
+	}
+	if opts.ResumeToken != "" {
+		query.Set(resumeTokenQueryParam, opts.ResumeToken)
+	}
+	config.Location.RawQuery = query.Encode()
+
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, errors.New("dialing hagall server failed").
+			WithTag("endpoint", opts.Endpoint).
+			Wrap(err)
+	}
+
+	c := &Client{
+		conn:          conn,
+		pending:       make(map[uint32]chan hwebsocket.Msg),
+		callbacks:     make(map[protoreflect.EnumNumber][]func(hwebsocket.Msg)),
+		serverPending: make(map[uint32]chan serverMessage),
+		queue:         make(chan hwebsocket.Msg, callbackQueueSize),
+		done:          make(chan struct{}),
+	}
+	go c.receive()
+	go c.dispatch()
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.conn.Close()
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that closed the connection. It returns nil when the
+// connection is open or was closed with Close.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// SessionID returns the id of the joined session.
+func (c *Client) SessionID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sessionID
+}
+
+// SessionUUID returns the uuid of the joined session.
+func (c *Client) SessionUUID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sessionUUID
+}
+
+// ParticipantID returns the participant id attributed when joining a
+// session.
+func (c *Client) ParticipantID() uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.participantID
+}
+
+// ServerTime returns the current time of the server, estimated from the
+// clock synchronization messages and pings.
+func (c *Client) ServerTime() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.clockOffset)))
+}
+
+// Send sends a message that does not expect a response. The message timestamp
+// is set when empty.
+func (c *Client) Send(msg hwebsocket.ProtoMsg) error {
+	setTimestamp(msg.ProtoReflect())
+
+	m, err := hwebsocket.MsgFromProto(msg)
+	if err != nil {
+		return err
+	}
+	return c.send(m)
+}
+
+// Request sends a request and waits for its response, which is decoded into
+// res when res is not nil. The request id and timestamp are set by the
+// client.
+//
+// An error of type ErrTypeErrorResponse is returned when the server responds
+// with an error. Its code can be retrieved with ErrorCode.
+func (c *Client) Request(ctx context.Context, req hwebsocket.ProtoMsg, res hwebsocket.ProtoMsg) error {
+	m := req.ProtoReflect()
+	field := m.Descriptor().Fields().ByNumber(requestIDFieldNumber)
+	if field == nil || field.Kind() != protoreflect.Uint32Kind {
+		return errors.New("message is not a request").
+			WithType(ErrTypeInvalidRequest).
+			WithTag("msg_type", hwebsocket.ProtoMsgType(req))
+	}
+
+	requestID := atomic.AddUint32(&c.requestID, 1)
+	m.Set(field, protoreflect.ValueOfUint32(requestID))
+	setTimestamp(m)
+
+	msg, err := hwebsocket.MsgFromProto(req)
+	if err != nil {
+		return err
+	}
+
+	resc := make(chan hwebsocket.Msg, 1)
+	c.mutex.Lock()
+	c.pending[requestID] = resc
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, requestID)
+		c.mutex.Unlock()
+	}()
+
+	if err := c.send(msg); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case <-c.done:
+		return c.closedErr()
+
+	case resMsg := <-resc:
+		if resMsg.Type == hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE {
+			var errRes hagallpb.ErrorResponse
+			if err := resMsg.DataTo(&errRes); err != nil {
+				return err
+			}
+
+			return errors.New("request failed").
+				WithType(ErrTypeErrorResponse).
+				WithTag("msg_type", msg.TypeString()).
+				WithTag("code", errRes.Code.String())
+		}
+
+		if res == nil {
+			return nil
+		}
+
+		if err := resMsg.DataTo(res); err != nil {
+			return errors.New("decoding response failed").
+				WithType(ErrTypeUnexpectedResponse).
+				WithTag("msg_type", msg.TypeString()).
+				WithTag("response_type", resMsg.TypeString()).
+				Wrap(err)
+		}
+		return nil
+	}
+}
+
+// ErrorCode returns the code of an error response returned by Request. It
+// returns ERROR_CODE_UNKNOWN when err is not an error response.
+func ErrorCode(err error) hagallpb.ErrorCode {
+	if !errors.IsType(err, ErrTypeErrorResponse) {
+		return hagallpb.ErrorCode_ERROR_CODE_UNKNOWN
+	}
+	return hagallpb.ErrorCode(hagallpb.ErrorCode_value[errors.Tag(err, "code")])
+}
+
+// Handle registers a callback called with the messages of the given type that
+// are not responses to requests. msgType can be a message type of Hagall or
+// of a module. Callbacks are called in the order messages are received, on a
+// goroutine dedicated to callbacks.
+func (c *Client) Handle(msgType protoreflect.Enum, callback func(hwebsocket.Msg)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.callbacks[msgType.Number()] = append(c.callbacks[msgType.Number()], callback)
+}
+
+// on registers a callback that receives messages decoded as M.
+func on[M any, PM interface {
+	*M
+	hwebsocket.ProtoMsg
+}](c *Client, msgType protoreflect.Enum, callback func(PM)) {
+	c.Handle(msgType, func(msg hwebsocket.Msg) {
+		var v M
+		if err := msg.DataTo(PM(&v)); err != nil {
+			return
+		}
+		callback(&v)
+	})
+}
+
+// Ping sends a ping to the server and returns the round trip time.
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+
+	var res hagallpb.Response
+	if err := c.Request(ctx, &hagallpb.Request{
+		Type: hagallpb.MsgType_MSG_TYPE_PING_REQUEST,
+	}, &res); err != nil {
+		return 0, err
+	}
+
+	rtt := time.Since(start)
+	if res.Timestamp != nil {
+		// The server is assumed to respond halfway through the round trip.
+		offset := res.Timestamp.AsTime().Sub(start.Add(rtt / 2))
+		atomic.StoreInt64(&c.clockOffset, int64(offset))
+	}
+	return rtt, nil
+}
+
+func (c *Client) send(msg hwebsocket.Msg) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if _, err := hwebsocket.Send(c.conn, msg); err != nil {
+		if atomic.LoadInt32(&c.closed) == 1 {
+			return c.closedErr()
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) receive() {
+	defer close(c.queue)
+
+	for {
+		msg, _, err := hwebsocket.Receive(c.conn)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		switch msg.Type {
+		case hagallpb.MsgType_MSG_TYPE_PING_REQUEST:
+			c.handlePingRequest(msg)
+			continue
+
+		case hagallpb.MsgType_MSG_TYPE_SYNC_CLOCK:
+			atomic.StoreInt64(&c.clockOffset, int64(msg.Time.Sub(time.Now())))
+		}
+
+		if c.resolve(msg) {
+			continue
+		}
+
+		if sm, ok := decodeServerMessage(msg); ok && c.receiveServerMessage(sm) {
+			continue
+		}
+
+		select {
+		case c.queue <- msg:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		if atomic.LoadInt32(&c.closed) == 0 {
+			c.err = errors.New("connection closed").
+				WithType(ErrTypeClosed).
+				Wrap(err)
+		}
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Client) closedErr() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return errors.New("client is closed").WithType(ErrTypeClosed)
+}
+
+// resolve passes a response to the request that waits for it. It returns
+// false when msg is not a response to a pending request.
+func (c *Client) resolve(msg hwebsocket.Msg) bool {
+	var res hagallpb.Response
+	if err := msg.DataTo(&res); err != nil || res.RequestId == 0 {
+		return false
+	}
+
+	c.mutex.Lock()
+	resc, ok := c.pending[res.RequestId]
+	delete(c.pending, res.RequestId)
+	c.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	resc <- msg
+	return true
+}
+
+func (c *Client) handlePingRequest(msg hwebsocket.Msg) {
+	var req hagallpb.Request
+	if err := msg.DataTo(&req); err != nil {
+		return
+	}
+
+	c.Send(&hagallpb.Response{
+		Type:      hagallpb.MsgType_MSG_TYPE_PING_RESPONSE,
+		RequestId: req.RequestId,
+	})
+}
+
+func (c *Client) dispatch() {
+	for msg := range c.queue {
+		if sm, ok := decodeServerMessage(msg); ok {
+			c.dispatchServerMessage(sm)
+			continue
+		}
+
+		c.mutex.Lock()
+		callbacks := c.callbacks[msg.Type.Number()]
+		c.mutex.Unlock()
+
+		for _, callback := range callbacks {
+			callback(msg)
+		}
+	}
+}
+
+func setTimestamp(m protoreflect.Message) {
+	field := m.Descriptor().Fields().ByName("timestamp")
+	if field == nil || field.Message() == nil || m.Has(field) {
+		return
+	}
+	m.Set(field, protoreflect.ValueOfMessage(timestamppb.Now().ProtoReflect()))
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall-common/messages/vikjapb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	hagallhttp "github.com/aukilabs/hagall/http"
+	"github.com/aukilabs/hagall/matchmaking"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/modules"
+	"github.com/aukilabs/hagall/modules/odal"
+	"github.com/aukilabs/hagall/modules/vikja"
+	hagallwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type testDiscoveryService struct{}
+
+func (s testDiscoveryService) ServerID() string {
+	return "ted"
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	sessions := &models.SessionStore{DiscoveryService: testDiscoveryService{}}
+
+	var mux http.ServeMux
+	mux.Handle("/", websocket.Server{
+		Handshake: func(c *websocket.Config, r *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			h := &hagallwebsocket.RealtimeHandler{
+				ClientSyncClockInterval:    time.Millisecond * 100,
+				ClientIdleTimeout:          time.Minute,
+				FrameDuration:              time.Millisecond * 10,
+				Sessions:                   sessions,
+				EntityComponentHistorySize: 16,
+				Modules:                    []modules.Module{&vikja.Module{}, &odal.Module{}},
+			}
+			defer h.Close()
+
+			hagallwebsocket.Handle(context.Background(), conn, h)
+		},
+	})
+	mux.HandleFunc("/sessions", hagallhttp.HandleSessionDiscovery(sessions))
+
+	matchmaker := &matchmaking.Matchmaker{
+		Sessions: sessions,
+		CreateSession: (&hagallwebsocket.RealtimeHandler{
+			FrameDuration: time.Millisecond * 10,
+			Sessions:      sessions,
+		}).CreateSession,
+	}
+	mux.HandleFunc("/matchmaking", hagallhttp.HandleMatchmaking(matchmaker, time.Second*10))
+
+	return httptest.NewServer(&mux)
+}
+
+func dialTestClient(t *testing.T, server *httptest.Server) *Client {
+	c, err := Dial(context.Background(), Options{
+		Endpoint:  server.URL,
+		Token:     "token",
+		ClientID:  "client",
+		UserAgent: "ted",
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func receive[T any](t *testing.T, c chan T) T {
+	select {
+	case v := <-c:
+		return v
+
+	case <-time.After(time.Second * 2):
+		t.Fatal("callback not called")
+		var v T
+		return v
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	clientA := dialTestClient(t, server)
+	defer clientA.Close()
+
+	clientB := dialTestClient(t, server)
+	defer clientB.Close()
+
+	participantJoins := make(chan *hagallpb.ParticipantJoinBroadcast, 1)
+	clientA.OnParticipantJoin(func(b *hagallpb.ParticipantJoinBroadcast) {
+		participantJoins <- b
+	})
+
+	sessionStates := make(chan *hagallpb.SessionState, 1)
+	clientB.OnSessionState(func(s *hagallpb.SessionState) {
+		sessionStates <- s
+	})
+
+	entityAdds := make(chan *hagallpb.EntityAddBroadcast, 1)
+	clientB.OnEntityAdd(func(b *hagallpb.EntityAddBroadcast) {
+		entityAdds <- b
+	})
+
+	poseUpdates := make(chan *hagallpb.EntityUpdatePoseBroadcast, 1)
+	clientB.OnEntityUpdatePose(func(b *hagallpb.EntityUpdatePoseBroadcast) {
+		poseUpdates <- b
+	})
+
+	customMessages := make(chan *hagallpb.CustomMessageBroadcast, 1)
+	clientB.OnCustomMessage(func(b *hagallpb.CustomMessageBroadcast) {
+		customMessages <- b
+	})
+
+	entityActions := make(chan *vikjapb.EntityActionBroadcast, 1)
+	clientB.OnEntityAction(func(b *vikjapb.EntityActionBroadcast) {
+		entityActions <- b
+	})
+
+	var entityID uint32
+
+	t.Run("join", func(t *testing.T) {
+		res, err := clientA.Join(ctx, "")
+		require.NoError(t, err)
+		require.NotEmpty(t, res.SessionId)
+		require.Equal(t, res.SessionId, clientA.SessionID())
+		require.Equal(t, res.ParticipantId, clientA.ParticipantID())
+
+		res, err = clientB.Join(ctx, clientA.SessionID())
+		require.NoError(t, err)
+		require.Equal(t, clientA.SessionID(), res.SessionId)
+
+		require.Len(t, receive(t, sessionStates).Participants, 2)
+		require.Equal(t, clientB.ParticipantID(), receive(t, participantJoins).ParticipantId)
+	})
+
+	t.Run("add entity", func(t *testing.T) {
+		var err error
+		entityID, err = clientA.AddEntity(ctx, &hagallpb.Pose{Px: 1}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+		require.NoError(t, err)
+		require.NotZero(t, entityID)
+
+		b := receive(t, entityAdds)
+		require.Equal(t, entityID, b.Entity.Id)
+		require.Equal(t, clientA.ParticipantID(), b.Entity.ParticipantId)
+	})
+
+	t.Run("update entity pose", func(t *testing.T) {
+		err := clientA.UpdateEntityPose(entityID, &hagallpb.Pose{Px: 2})
+		require.NoError(t, err)
+
+		b := receive(t, poseUpdates)
+		require.Equal(t, entityID, b.EntityId)
+		require.Equal(t, float32(2), b.Pose.Px)
+	})
+
+	t.Run("send custom message", func(t *testing.T) {
+		err := clientA.SendCustomMessage([]byte("hello"), clientB.ParticipantID())
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), receive(t, customMessages).Body)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		err := clientB.DeleteEntity(ctx, entityID)
+		require.True(t, errors.IsType(err, ErrTypeErrorResponse))
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+	})
+
+	t.Run("entity components", func(t *testing.T) {
+		typeID, err := clientA.AddEntityComponentType(ctx, "label")
+		require.NoError(t, err)
+
+		id, err := clientB.GetEntityComponentTypeID(ctx, "label")
+		require.NoError(t, err)
+		require.Equal(t, typeID, id)
+
+		err = clientA.AddEntityComponent(ctx, typeID, entityID, []byte("ted"))
+		require.NoError(t, err)
+
+		entityComponents, err := clientB.ListEntityComponents(ctx, typeID)
+		require.NoError(t, err)
+		require.Len(t, entityComponents, 1)
+		require.Equal(t, []byte("ted"), entityComponents[0].Data)
+
+		err = clientA.DeleteEntityComponent(ctx, typeID, entityID)
+		require.NoError(t, err)
+	})
+
+	t.Run("vikja entity action", func(t *testing.T) {
+		err := clientA.SetEntityAction(ctx, entityID, "jump", []byte("high"))
+		require.NoError(t, err)
+
+		b := receive(t, entityActions)
+		require.Equal(t, "jump", b.EntityAction.Name)
+		require.Equal(t, []byte("high"), b.EntityAction.Data)
+	})
+
+	t.Run("odal asset instance", func(t *testing.T) {
+		assetInstanceID, err := clientA.AddAssetInstance(ctx, entityID, "tiger")
+		require.NoError(t, err)
+		require.NotZero(t, assetInstanceID)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		rtt, err := clientA.Ping(ctx)
+		require.NoError(t, err)
+		require.NotZero(t, rtt)
+		require.WithinDuration(t, time.Now(), clientA.ServerTime(), time.Second)
+	})
+
+	t.Run("request from callback", func(t *testing.T) {
+		rtts := make(chan time.Duration, 1)
+		clientB.Handle(hagallpb.MsgType_MSG_TYPE_SYNC_CLOCK, func(hwebsocket.Msg) {
+			rtt, err := clientB.Ping(ctx)
+			if err == nil {
+				select {
+				case rtts <- rtt:
+				default:
+				}
+			}
+		})
+		require.NotZero(t, receive(t, rtts))
+	})
+
+	t.Run("request after close", func(t *testing.T) {
+		require.NoError(t, clientA.Close())
+		<-clientA.Done()
+		require.NoError(t, clientA.Err())
+
+		_, err := clientA.Ping(ctx)
+		require.True(t, errors.IsType(err, ErrTypeClosed))
+	})
+}
+
+func TestClientSpectator(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	player := dialTestClient(t, server)
+	defer player.Close()
+
+	spectator, err := Dial(context.Background(), Options{
+		Endpoint:  server.URL,
+		ClientID:  "spectator",
+		UserAgent: "ted",
+		Spectator: true,
+	})
+	require.NoError(t, err)
+	defer spectator.Close()
+
+	participantJoins := make(chan *hagallpb.ParticipantJoinBroadcast, 1)
+	player.OnParticipantJoin(func(b *hagallpb.ParticipantJoinBroadcast) {
+		participantJoins <- b
+	})
+
+	sessionStates := make(chan *hagallpb.SessionState, 1)
+	spectator.OnSessionState(func(s *hagallpb.SessionState) {
+		sessionStates <- s
+	})
+
+	entityAdds := make(chan *hagallpb.EntityAddBroadcast, 1)
+	spectator.OnEntityAdd(func(b *hagallpb.EntityAddBroadcast) {
+		entityAdds <- b
+	})
+
+	errorResponses := make(chan *hagallpb.ErrorResponse, 1)
+	spectator.OnErrorResponse(func(res *hagallpb.ErrorResponse) {
+		errorResponses <- res
+	})
+
+	t.Run("spectator can't create a session", func(t *testing.T) {
+		_, err := spectator.Join(ctx, "")
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrorCode(err))
+	})
+
+	t.Run("spectator joins without being visible", func(t *testing.T) {
+		_, err := player.Join(ctx, "")
+		require.NoError(t, err)
+
+		_, err = spectator.Join(ctx, player.SessionID())
+		require.NoError(t, err)
+
+		state := receive(t, sessionStates)
+		require.Len(t, state.Participants, 1)
+		require.Equal(t, player.ParticipantID(), state.Participants[0].Id)
+
+		// The player is notified of the joins that follow the spectator one.
+		other := dialTestClient(t, server)
+		defer other.Close()
+
+		_, err = other.Join(ctx, player.SessionID())
+		require.NoError(t, err)
+		require.Equal(t, other.ParticipantID(), receive(t, participantJoins).ParticipantId)
+	})
+
+	t.Run("spectator receives broadcasts", func(t *testing.T) {
+		entityID, err := player.AddEntity(ctx, &hagallpb.Pose{Rw: 1}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+		require.NoError(t, err)
+		require.Equal(t, entityID, receive(t, entityAdds).Entity.Id)
+
+		err = spectator.SetEntityAction(ctx, entityID, "jump", nil)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+	})
+
+	t.Run("spectator can't modify the session", func(t *testing.T) {
+		_, err := spectator.AddEntity(ctx, &hagallpb.Pose{Rw: 1}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+
+		_, err = spectator.AddEntityComponentType(ctx, "color")
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+
+		err = spectator.SendCustomMessage([]byte("hello"))
+		require.NoError(t, err)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, receive(t, errorResponses).Code)
+	})
+}
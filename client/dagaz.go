@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+
+	"github.com/aukilabs/hagall-common/messages/dagazpb"
+)
+
+// SendQuadSamples sends quad samples to the Dagaz module.
+func (c *Client) SendQuadSamples(samples ...*dagazpb.Quad) error {
+	return c.Send(&dagazpb.DagazQuadSample{
+		Type:    dagazpb.MsgType_MSG_TYPE_DAGAZ_QUAD_SAMPLE,
+		Samples: samples,
+	})
+}
+
+// GetGroundPlane returns the ground quad intersected by the given ray.
+func (c *Client) GetGroundPlane(ctx context.Context, ray *dagazpb.Ray) (*dagazpb.Quad, error) {
+	var res dagazpb.DagazGetGroundPlaneResponse
+	if err := c.Request(ctx, &dagazpb.DagazGetGroundPlaneRequest{
+		Type: dagazpb.MsgType_MSG_TYPE_DAGAZ_GET_GROUND_PLANE_REQUEST,
+		Ray:  ray,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Ground, nil
+}
+
+// GetRegion returns the quads within the region delimited by min and max.
+func (c *Client) GetRegion(ctx context.Context, min, max *dagazpb.Point) ([]*dagazpb.Quad, error) {
+	var res dagazpb.DagazGetRegionResponse
+	if err := c.Request(ctx, &dagazpb.DagazGetRegionRequest{
+		Type: dagazpb.MsgType_MSG_TYPE_DAGAZ_GET_REGION_REQUEST,
+		Min:  min,
+		Max:  max,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Quads, nil
+}
+
+// GetDagazDebugInfo returns debug information about the Dagaz spatial
+// partition.
+func (c *Client) GetDagazDebugInfo(ctx context.Context) (*dagazpb.DagazGetDebugInfoResponse, error) {
+	var res dagazpb.DagazGetDebugInfoResponse
+	if err := c.Request(ctx, &dagazpb.DagazGetDebugInfoRequest{
+		Type: dagazpb.MsgType_MSG_TYPE_DAGAZ_GET_DEBUG_INFO_REQUEST,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,348 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+)
+
+// Join joins the session with the given id. A new session is created when
+// sessionID is empty.
+//
+// The session state is sent by the server right after the join response and
+// is passed to the OnSessionState callbacks.
+func (c *Client) Join(ctx context.Context, sessionID string) (*hagallpb.ParticipantJoinResponse, error) {
+	// The host of the joined session is sent by the server after the join
+	// response.
+	atomic.StoreUint32(&c.hostID, 0)
+
+	var res hagallpb.ParticipantJoinResponse
+	if err := c.Request(ctx, &hagallpb.ParticipantJoinRequest{
+		Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+		SessionId: sessionID,
+	}, &res); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.sessionID = res.SessionId
+	c.sessionUUID = res.SessionUuid
+	c.participantID = res.ParticipantId
+	c.mutex.Unlock()
+
+	return &res, nil
+}
+
+// AddEntity adds an entity to the joined session and returns its id.
+func (c *Client) AddEntity(ctx context.Context, pose *hagallpb.Pose, persist bool, flag hagallpb.EntityFlag) (uint32, error) {
+	var res hagallpb.EntityAddResponse
+	if err := c.Request(ctx, &hagallpb.EntityAddRequest{
+		Type:    hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		Pose:    pose,
+		Persist: persist,
+		Flag:    flag,
+	}, &res); err != nil {
+		return 0, err
+	}
+	return res.EntityId, nil
+}
+
+// DeleteEntity deletes an entity owned by the client.
+func (c *Client) DeleteEntity(ctx context.Context, entityID uint32) error {
+	return c.Request(ctx, &hagallpb.EntityDeleteRequest{
+		Type:     hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_REQUEST,
+		EntityId: entityID,
+	}, &hagallpb.EntityDeleteResponse{})
+}
+
+// UpdateEntityPose sets the pose of an entity owned by the client.
+func (c *Client) UpdateEntityPose(entityID uint32, pose *hagallpb.Pose) error {
+	return c.Send(&hagallpb.EntityUpdatePose{
+		Type:     hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE,
+		EntityId: entityID,
+		Pose:     pose,
+	})
+}
+
+// SendCustomMessage sends a custom message to the given participants, or to
+// all the other session participants when no participant ids are given.
+func (c *Client) SendCustomMessage(body []byte, participantIDs ...uint32) error {
+	return c.Send(&hagallpb.CustomMessage{
+		Type:           hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE,
+		ParticipantIds: participantIDs,
+		Body:           body,
+	})
+}
+
+// AddEntityComponentType adds an entity component type to the joined session
+// and returns its id.
+func (c *Client) AddEntityComponentType(ctx context.Context, name string) (uint32, error) {
+	var res hagallpb.EntityComponentTypeAddResponse
+	if err := c.Request(ctx, &hagallpb.EntityComponentTypeAddRequest{
+		Type:                    hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_ADD_REQUEST,
+		EntityComponentTypeName: name,
+	}, &res); err != nil {
+		return 0, err
+	}
+	return res.EntityComponentTypeId, nil
+}
+
+// GetEntityComponentTypeID returns the id of the named entity component type.
+func (c *Client) GetEntityComponentTypeID(ctx context.Context, name string) (uint32, error) {
+	var res hagallpb.EntityComponentTypeGetIdResponse
+	if err := c.Request(ctx, &hagallpb.EntityComponentTypeGetIdRequest{
+		Type:                    hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_GET_ID_REQUEST,
+		EntityComponentTypeName: name,
+	}, &res); err != nil {
+		return 0, err
+	}
+	return res.EntityComponentTypeId, nil
+}
+
+// GetEntityComponentTypeName returns the name of an entity component type.
+func (c *Client) GetEntityComponentTypeName(ctx context.Context, typeID uint32) (string, error) {
+	var res hagallpb.EntityComponentTypeGetNameResponse
+	if err := c.Request(ctx, &hagallpb.EntityComponentTypeGetNameRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_GET_NAME_REQUEST,
+		EntityComponentTypeId: typeID,
+	}, &res); err != nil {
+		return "", err
+	}
+	return res.EntityComponentTypeName, nil
+}
+
+// AddEntityComponent adds a component to an entity.
+func (c *Client) AddEntityComponent(ctx context.Context, typeID, entityID uint32, data []byte) error {
+	return c.Request(ctx, &hagallpb.EntityComponentAddRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_REQUEST,
+		EntityComponentTypeId: typeID,
+		EntityId:              entityID,
+		Data:                  data,
+	}, &hagallpb.EntityComponentAddResponse{})
+}
+
+// UpdateEntityComponent updates the component of an entity.
+func (c *Client) UpdateEntityComponent(typeID, entityID uint32, data []byte) error {
+	return c.Send(&hagallpb.EntityComponentUpdate{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE,
+		EntityComponentTypeId: typeID,
+		EntityId:              entityID,
+		Data:                  data,
+	})
+}
+
+// DeleteEntityComponent deletes the component of an entity.
+func (c *Client) DeleteEntityComponent(ctx context.Context, typeID, entityID uint32) error {
+	return c.Request(ctx, &hagallpb.EntityComponentDeleteRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_REQUEST,
+		EntityComponentTypeId: typeID,
+		EntityId:              entityID,
+	}, &hagallpb.EntityComponentDeleteResponse{})
+}
+
+// VersionedEntityComponent is an entity component with its version. Versions
+// start at 1 when the component is added and are incremented on each update.
+type VersionedEntityComponent struct {
+	EntityComponentTypeID uint32
+	EntityID              uint32
+	Data                  []byte
+	Version               uint64
+}
+
+// UpdateEntityComponentIfVersion updates the component of an entity when the
+// given version is its current version, and returns the stored component. On
+// a version conflict, it returns the current component with an error whose
+// code is ERROR_CODE_CONFLICT. A zero version updates the component
+// unconditionally.
+func (c *Client) UpdateEntityComponentIfVersion(ctx context.Context, typeID, entityID uint32, data []byte, version uint64) (VersionedEntityComponent, error) {
+	res, err := c.serverRequest(ctx, serverMessage{
+		Type:                  serverRequestUpdateEntityComponent,
+		EntityComponentTypeID: typeID,
+		EntityID:              entityID,
+		Data:                  data,
+		Version:               version,
+	})
+	return res.versionedEntityComponent(), err
+}
+
+// DeleteEntityComponentIfVersion deletes the component of an entity when the
+// given version is its current version, and returns the deleted component. On
+// a version conflict, it returns the current component with an error whose
+// code is ERROR_CODE_CONFLICT. A zero version deletes the component
+// unconditionally.
+func (c *Client) DeleteEntityComponentIfVersion(ctx context.Context, typeID, entityID uint32, version uint64) (VersionedEntityComponent, error) {
+	res, err := c.serverRequest(ctx, serverMessage{
+		Type:                  serverRequestDeleteEntityComponent,
+		EntityComponentTypeID: typeID,
+		EntityID:              entityID,
+		Version:               version,
+	})
+	return res.versionedEntityComponent(), err
+}
+
+// EntityComponentChange is a change recorded in the history of an entity
+// component.
+type EntityComponentChange struct {
+	// The position of the change in the session history.
+	Sequence uint64 `json:"sequence"`
+
+	// The operation: "add", "update" or "delete".
+	Op string `json:"op"`
+
+	EntityComponentTypeID uint32    `json:"entity_component_type_id"`
+	EntityID              uint32    `json:"entity_id"`
+	Data                  []byte    `json:"data,omitempty"`
+	Version               uint64    `json:"version"`
+	ParticipantID         uint32    `json:"participant_id"`
+	Time                  time.Time `json:"time"`
+}
+
+// EntityComponentHistory returns the recorded changes of the components of an
+// entity, from the oldest to the newest. The changes are restricted to the
+// given component type when typeID is not zero. No changes are returned when
+// the server doesn't record the history.
+func (c *Client) EntityComponentHistory(ctx context.Context, entityID, typeID uint32) ([]EntityComponentChange, error) {
+	res, err := c.serverRequest(ctx, serverMessage{
+		Type:                  serverRequestEntityComponentHistory,
+		EntityComponentTypeID: typeID,
+		EntityID:              entityID,
+	})
+	return res.Changes, err
+}
+
+// ListEntityComponents returns the entity components of the given type.
+func (c *Client) ListEntityComponents(ctx context.Context, typeID uint32) ([]*hagallpb.EntityComponent, error) {
+	var res hagallpb.EntityComponentListResponse
+	if err := c.Request(ctx, &hagallpb.EntityComponentListRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_LIST_REQUEST,
+		EntityComponentTypeId: typeID,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.EntityComponents, nil
+}
+
+// SubscribeEntityComponentType subscribes to the changes of the entity
+// components of the given type.
+func (c *Client) SubscribeEntityComponentType(ctx context.Context, typeID uint32) error {
+	return c.Request(ctx, &hagallpb.EntityComponentTypeSubscribeRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_SUBSCRIBE_REQUEST,
+		EntityComponentTypeId: typeID,
+	}, &hagallpb.EntityComponentTypeSubscribeResponse{})
+}
+
+// EntityComponentSubscriptionOptions represents the options of a subscription
+// to an entity component type.
+type EntityComponentSubscriptionOptions struct {
+	// The ids of the entities to follow. All entities are followed when empty.
+	EntityIDs []uint32
+
+	// The maximum number of update broadcasts per second. Updates are not
+	// throttled when zero.
+	MaxUpdateRate float64
+}
+
+// SubscribeEntityComponentTypeWithOptions subscribes to the changes of the
+// entity components of the given type with the given options. Subscribing
+// again replaces the previous options.
+func (c *Client) SubscribeEntityComponentTypeWithOptions(ctx context.Context, typeID uint32, opts EntityComponentSubscriptionOptions) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:                  serverRequestSubscribeEntityComponent,
+		EntityComponentTypeID: typeID,
+		EntityIDs:             opts.EntityIDs,
+		MaxUpdateRate:         opts.MaxUpdateRate,
+	})
+	return err
+}
+
+// UnsubscribeEntityComponentType unsubscribes from the changes of the entity
+// components of the given type.
+func (c *Client) UnsubscribeEntityComponentType(ctx context.Context, typeID uint32) error {
+	return c.Request(ctx, &hagallpb.EntityComponentTypeUnsubscribeRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_UNSUBSCRIBE_REQUEST,
+		EntityComponentTypeId: typeID,
+	}, &hagallpb.EntityComponentTypeUnsubscribeResponse{})
+}
+
+// Undo reverts the last entity or entity component change made by the client
+// in the joined session. The reverted state is broadcast to all the
+// participants, the client included. It returns an error with the
+// ERROR_CODE_NOT_FOUND code when there is nothing to undo, and
+// ERROR_CODE_CONFLICT when the change can no longer be reverted.
+func (c *Client) Undo(ctx context.Context) error {
+	_, err := c.serverRequest(ctx, serverMessage{Type: serverRequestUndo})
+	return err
+}
+
+// Redo reapplies the last change reverted with Undo. It returns the same
+// errors as Undo.
+func (c *Client) Redo(ctx context.Context) error {
+	_, err := c.serverRequest(ctx, serverMessage{Type: serverRequestRedo})
+	return err
+}
+
+// OnSessionState registers a callback called with the session state sent after
+// joining a session.
+func (c *Client) OnSessionState(callback func(*hagallpb.SessionState)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_SESSION_STATE, callback)
+}
+
+// OnParticipantJoin registers a callback called when a participant joins the
+// session.
+func (c *Client) OnParticipantJoin(callback func(*hagallpb.ParticipantJoinBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_BROADCAST, callback)
+}
+
+// OnParticipantLeave registers a callback called when a participant leaves the
+// session.
+func (c *Client) OnParticipantLeave(callback func(*hagallpb.ParticipantLeaveBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_PARTICIPANT_LEAVE_BROADCAST, callback)
+}
+
+// OnEntityAdd registers a callback called when an entity is added.
+func (c *Client) OnEntityAdd(callback func(*hagallpb.EntityAddBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_BROADCAST, callback)
+}
+
+// OnEntityDelete registers a callback called when an entity is deleted.
+func (c *Client) OnEntityDelete(callback func(*hagallpb.EntityDeleteBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST, callback)
+}
+
+// OnEntityUpdatePose registers a callback called when the pose of an entity is
+// updated.
+func (c *Client) OnEntityUpdatePose(callback func(*hagallpb.EntityUpdatePoseBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE_BROADCAST, callback)
+}
+
+// OnCustomMessage registers a callback called when a custom message is
+// received.
+func (c *Client) OnCustomMessage(callback func(*hagallpb.CustomMessageBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE_BROADCAST, callback)
+}
+
+// OnEntityComponentAdd registers a callback called when an entity component of
+// a subscribed type is added.
+func (c *Client) OnEntityComponentAdd(callback func(*hagallpb.EntityComponentAddBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_BROADCAST, callback)
+}
+
+// OnEntityComponentUpdate registers a callback called when an entity component
+// of a subscribed type is updated.
+func (c *Client) OnEntityComponentUpdate(callback func(*hagallpb.EntityComponentUpdateBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE_BROADCAST, callback)
+}
+
+// OnEntityComponentDelete registers a callback called when an entity component
+// of a subscribed type is deleted.
+func (c *Client) OnEntityComponentDelete(callback func(*hagallpb.EntityComponentDeleteBroadcast)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_BROADCAST, callback)
+}
+
+// OnErrorResponse registers a callback called with the error responses that
+// are not related to a request.
+func (c *Client) OnErrorResponse(callback func(*hagallpb.ErrorResponse)) {
+	on(c, hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE, callback)
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	"github.com/segmentio/encoding/json"
+)
+
+// doHTTP sends a request with the given JSON body to a path of the Hagall
+// server HTTP endpoint and decodes the JSON response into out.
+func doHTTP(ctx context.Context, opts Options, method, path string, body, out any) error {
+	endpoint := strings.Replace(opts.Endpoint, "wss://", "https://", 1)
+	endpoint = strings.Replace(endpoint, "ws://", "http://", 1)
+	endpoint = strings.TrimSuffix(endpoint, "/") + path
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.New("encoding request body failed").
+				WithType(ErrTypeInvalidRequest).
+				Wrap(err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return errors.New("creating http request failed").
+			WithTag("endpoint", endpoint).
+			Wrap(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	if opts.ClientID != "" {
+		req.Header.Set(httpcmn.HeaderPosemeshClientID, opts.ClientID)
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.New("sending http request failed").
+			WithTag("endpoint", endpoint).
+			Wrap(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("unexpected http response").
+			WithType(ErrTypeUnexpectedResponse).
+			WithTag("endpoint", endpoint).
+			WithTag("status", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return errors.New("decoding http response failed").
+			WithType(ErrTypeUnexpectedResponse).
+			WithTag("endpoint", endpoint).
+			Wrap(err)
+	}
+	return nil
+}
+
+// HTTPStatus returns the status code of an unexpected HTTP response error. It
+// returns 0 when the error is not an HTTP response error.
+func HTTPStatus(err error) int {
+	if !errors.IsType(err, ErrTypeUnexpectedResponse) {
+		return 0
+	}
+	status, _ := strconv.Atoi(errors.Tag(err, "status"))
+	return status
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+
+	"github.com/aukilabs/hagall-common/messages/odalpb"
+)
+
+// AddAssetInstance associates an asset with an entity with the Odal module and
+// returns the id of the asset instance.
+func (c *Client) AddAssetInstance(ctx context.Context, entityID uint32, assetID string) (uint32, error) {
+	var res odalpb.AssetInstanceAddResponse
+	if err := c.Request(ctx, &odalpb.AssetInstanceAddRequest{
+		Type:     odalpb.MsgType_MSG_TYPE_ODAL_ASSET_INSTANCE_ADD_REQUEST,
+		EntityId: entityID,
+		AssetId:  assetID,
+	}, &res); err != nil {
+		return 0, err
+	}
+	return res.AssetInstanceId, nil
+}
+
+// OnAssetInstanceAdd registers a callback called when an asset instance is
+// added by another participant.
+func (c *Client) OnAssetInstanceAdd(callback func(*odalpb.AssetInstanceAddBroadcast)) {
+	on(c, odalpb.MsgType_MSG_TYPE_ODAL_ASSET_INSTANCE_ADD_BROADCAST, callback)
+}
+
+// OnOdalState registers a callback called with the Odal state sent after
+// joining a session.
+func (c *Client) OnOdalState(callback func(*odalpb.State)) {
+	on(c, odalpb.MsgType_MSG_TYPE_ODAL_STATE, callback)
+}
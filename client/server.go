@@ -0,0 +1,446 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// The query parameter that makes the server exchange server messages with
+	// the connection.
+	serverMessagesQueryParam = "server_messages"
+
+	// The query parameter that resumes a participant of a migrated session.
+	resumeTokenQueryParam = "resume_token"
+
+	// The participant id used by the server to exchange server messages
+	// through custom messages.
+	serverMessagesParticipantID = 0
+)
+
+// The types of the server messages.
+const (
+	serverMessageHostChanged              = "host_changed"
+	serverMessageParticipantLeft          = "participant_left"
+	serverMessageParticipants             = "participants"
+	serverMessageParticipantUpdated       = "participant_updated"
+	serverMessageGoingAway                = "going_away"
+	serverMessageMigrate                  = "migrate"
+	serverMessageResponse                 = "response"
+	serverRequestTransferHost             = "transfer_host"
+	serverRequestUpdateSessionInfo        = "update_session_info"
+	serverRequestUpdateSessionSettings    = "update_session_settings"
+	serverRequestUpdateParticipantInfo    = "update_participant_info"
+	serverRequestKick                     = "kick"
+	serverRequestUpdateEntityComponent    = "update_entity_component"
+	serverRequestDeleteEntityComponent    = "delete_entity_component"
+	serverRequestSubscribeEntityComponent = "subscribe_entity_component"
+	serverRequestEntityComponentHistory   = "entity_component_history"
+	serverRequestListSessions             = "list_sessions"
+	serverRequestUndo                     = "undo"
+	serverRequestRedo                     = "redo"
+)
+
+// LeaveReason describes why a participant left a session.
+type LeaveReason string
+
+const (
+	// The participant left or disconnected.
+	LeaveReasonLeft LeaveReason = "left"
+
+	// The participant was kicked by the host or an operator.
+	LeaveReasonKicked LeaveReason = "kicked"
+
+	// The participant was kicked and banned from the session.
+	LeaveReasonBanned LeaveReason = "banned"
+
+	// The participant reconnects to the server where the session migrated.
+	LeaveReasonMigrated LeaveReason = "migrated"
+)
+
+// serverMessage is a message exchanged with the server through custom
+// messages, for the features that have no message type in hagall-common.
+type serverMessage struct {
+	Type             string           `json:"type"`
+	RequestID        uint32           `json:"request_id,omitempty"`
+	Error            string           `json:"error,omitempty"`
+	HostID           uint32           `json:"host_id,omitempty"`
+	ParticipantID    uint32           `json:"participant_id,omitempty"`
+	SessionInfo      *SessionInfo     `json:"session_info,omitempty"`
+	SessionSettings  *SessionSettings `json:"session_settings,omitempty"`
+	Reason           LeaveReason      `json:"reason,omitempty"`
+	Ban              bool             `json:"ban,omitempty"`
+	Participant      *ParticipantInfo `json:"participant,omitempty"`
+	Presence         Presence         `json:"presence,omitempty"`
+	Participants     []Participant    `json:"participants,omitempty"`
+	ReconnectDelayMS int64            `json:"reconnect_delay_ms,omitempty"`
+	Endpoint         string           `json:"endpoint,omitempty"`
+	SessionID        string           `json:"session_id,omitempty"`
+	ResumeToken      string           `json:"resume_token,omitempty"`
+
+	EntityComponentTypeID uint32 `json:"entity_component_type_id,omitempty"`
+	EntityID              uint32 `json:"entity_id,omitempty"`
+	Data                  []byte `json:"data,omitempty"`
+	Version               uint64 `json:"version,omitempty"`
+
+	EntityIDs     []uint32 `json:"entity_ids,omitempty"`
+	MaxUpdateRate float64  `json:"max_update_rate,omitempty"`
+
+	Changes []EntityComponentChange `json:"changes,omitempty"`
+
+	Sessions []SessionListing `json:"sessions,omitempty"`
+}
+
+// SessionInfo describes a session.
+type SessionInfo struct {
+	Name     string            `json:"name,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Private  bool              `json:"private,omitempty"`
+}
+
+// ParticipantInfo describes a participant to the other participants.
+type ParticipantInfo struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Avatar      string            `json:"avatar,omitempty"`
+	DeviceType  string            `json:"device_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Presence is the presence state of a participant.
+type Presence string
+
+const (
+	// The participant sends heartbeats.
+	PresenceActive Presence = "active"
+
+	// The participant didn't send heartbeats for a while.
+	PresenceIdle Presence = "idle"
+
+	// The participant didn't send heartbeats for a long time, usually because
+	// its app is in the background.
+	PresenceBackgrounded Presence = "backgrounded"
+)
+
+// Participant describes a participant of the joined session.
+type Participant struct {
+	ID       uint32          `json:"participant_id"`
+	Info     ParticipantInfo `json:"participant"`
+	Presence Presence        `json:"presence"`
+}
+
+// SessionSettings restricts the participants that can join a session.
+type SessionSettings struct {
+	// Prevents clients from joining the session, except the ones with a
+	// reserved client id.
+	Locked bool `json:"locked,omitempty"`
+
+	// The maximum number of participants, spectators excluded. Unlimited when
+	// zero.
+	MaxParticipants int `json:"max_participants,omitempty"`
+
+	// The client ids of expected participants. Each keeps a slot within
+	// MaxParticipants until its client joins.
+	ReservedClientIDs []string `json:"reserved_client_ids,omitempty"`
+}
+
+// GoingAway describes a server that is draining before shutting down.
+type GoingAway struct {
+	// The delay suggested before reconnecting.
+	ReconnectDelay time.Duration
+
+	// The endpoint of another server to reconnect to. Empty when the server
+	// did not suggest one.
+	Endpoint string
+}
+
+// Migration describes a session that migrated to another server.
+type Migration struct {
+	// The endpoint of the server where the session migrated.
+	Endpoint string
+
+	// The id of the session on that server.
+	SessionID string
+
+	// The token given in Options.ResumeToken to join the session as the same
+	// participant. Empty when the participant can't resume.
+	ResumeToken string
+}
+
+// decodeServerMessage returns the server message carried by msg. It returns
+// false when msg is not a server message.
+func decodeServerMessage(msg hwebsocket.Msg) (serverMessage, bool) {
+	if msg.Type != hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE_BROADCAST {
+		return serverMessage{}, false
+	}
+
+	var broadcast hagallpb.CustomMessageBroadcast
+	if err := msg.DataTo(&broadcast); err != nil || broadcast.ParticipantId != serverMessagesParticipantID {
+		return serverMessage{}, false
+	}
+
+	var sm serverMessage
+	if err := json.Unmarshal(broadcast.Body, &sm); err != nil {
+		return serverMessage{}, false
+	}
+	return sm, true
+}
+
+// receiveServerMessage updates the client state from a received server
+// message. It returns false when the message must be passed to callbacks.
+func (c *Client) receiveServerMessage(sm serverMessage) bool {
+	switch sm.Type {
+	case serverMessageResponse:
+		c.mutex.Lock()
+		resc, ok := c.serverPending[sm.RequestID]
+		delete(c.serverPending, sm.RequestID)
+		c.mutex.Unlock()
+
+		if ok {
+			resc <- sm
+		}
+		return true
+
+	case serverMessageHostChanged:
+		atomic.StoreUint32(&c.hostID, sm.HostID)
+		return false
+
+	case serverMessageParticipantLeft, serverMessageParticipants, serverMessageParticipantUpdated,
+		serverMessageGoingAway, serverMessageMigrate:
+		return false
+
+	default:
+		return true
+	}
+}
+
+// dispatchServerMessage passes a server message to its callbacks.
+func (c *Client) dispatchServerMessage(sm serverMessage) {
+	c.mutex.Lock()
+	hostCallbacks := c.hostCallbacks
+	leaveCallbacks := c.leaveCallbacks
+	goingAwayCallbacks := c.goingAwayCallbacks
+	migrateCallbacks := c.migrateCallbacks
+	participantUpdates := c.participantUpdates
+	c.mutex.Unlock()
+
+	switch sm.Type {
+	case serverMessageHostChanged:
+		for _, callback := range hostCallbacks {
+			callback(sm.HostID)
+		}
+
+	case serverMessageParticipantLeft:
+		for _, callback := range leaveCallbacks {
+			callback(sm.ParticipantID, sm.Reason)
+		}
+
+	case serverMessageParticipants:
+		for _, p := range sm.Participants {
+			for _, callback := range participantUpdates {
+				callback(p)
+			}
+		}
+
+	case serverMessageParticipantUpdated:
+		p := Participant{
+			ID:       sm.ParticipantID,
+			Presence: sm.Presence,
+		}
+		if sm.Participant != nil {
+			p.Info = *sm.Participant
+		}
+		for _, callback := range participantUpdates {
+			callback(p)
+		}
+
+	case serverMessageGoingAway:
+		goingAway := GoingAway{
+			ReconnectDelay: time.Duration(sm.ReconnectDelayMS) * time.Millisecond,
+			Endpoint:       sm.Endpoint,
+		}
+		for _, callback := range goingAwayCallbacks {
+			callback(goingAway)
+		}
+
+	case serverMessageMigrate:
+		migration := Migration{
+			Endpoint:    sm.Endpoint,
+			SessionID:   sm.SessionID,
+			ResumeToken: sm.ResumeToken,
+		}
+		for _, callback := range migrateCallbacks {
+			callback(migration)
+		}
+	}
+}
+
+// serverRequest sends a server request and waits for its response. The
+// response is also returned when the request failed.
+func (c *Client) serverRequest(ctx context.Context, req serverMessage) (serverMessage, error) {
+	req.RequestID = atomic.AddUint32(&c.requestID, 1)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return serverMessage{}, errors.New("encoding server request failed").
+			WithType(ErrTypeInvalidRequest).
+			Wrap(err)
+	}
+
+	resc := make(chan serverMessage, 1)
+	c.mutex.Lock()
+	c.serverPending[req.RequestID] = resc
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.serverPending, req.RequestID)
+		c.mutex.Unlock()
+	}()
+
+	if err := c.SendCustomMessage(body, serverMessagesParticipantID); err != nil {
+		return serverMessage{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return serverMessage{}, ctx.Err()
+
+	case <-c.done:
+		return serverMessage{}, c.closedErr()
+
+	case res := <-resc:
+		if res.Error != "" {
+			return res, errors.New("request failed").
+				WithType(ErrTypeErrorResponse).
+				WithTag("msg_type", req.Type).
+				WithTag("code", res.Error)
+		}
+		return res, nil
+	}
+}
+
+func (m serverMessage) versionedEntityComponent() VersionedEntityComponent {
+	return VersionedEntityComponent{
+		EntityComponentTypeID: m.EntityComponentTypeID,
+		EntityID:              m.EntityID,
+		Data:                  m.Data,
+		Version:               m.Version,
+	}
+}
+
+// Host returns the participant id of the host of the joined session. It
+// returns 0 when the session has no host.
+func (c *Client) Host() uint32 {
+	return atomic.LoadUint32(&c.hostID)
+}
+
+// IsHost reports whether the client is the host of the joined session.
+func (c *Client) IsHost() bool {
+	id := c.ParticipantID()
+	return id != 0 && id == c.Host()
+}
+
+// OnHostChange registers a callback called with the participant id of the new
+// host when the host of the joined session changes. It is also called after
+// joining a session.
+func (c *Client) OnHostChange(callback func(hostID uint32)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hostCallbacks = append(c.hostCallbacks, callback)
+}
+
+// TransferHost makes the given participant the host of the joined session.
+// Only the host can transfer the host.
+func (c *Client) TransferHost(ctx context.Context, participantID uint32) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:          serverRequestTransferHost,
+		ParticipantID: participantID,
+	})
+	return err
+}
+
+// UpdateSessionInfo replaces the info of the joined session. Only the host can
+// update the session info.
+func (c *Client) UpdateSessionInfo(ctx context.Context, info SessionInfo) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:        serverRequestUpdateSessionInfo,
+		SessionInfo: &info,
+	})
+	return err
+}
+
+// UpdateSessionSettings replaces the settings of the joined session. Only the
+// host can update the session settings.
+func (c *Client) UpdateSessionSettings(ctx context.Context, settings SessionSettings) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:            serverRequestUpdateSessionSettings,
+		SessionSettings: &settings,
+	})
+	return err
+}
+
+// OnLeaveReason registers a callback called with the reason why a participant
+// left the joined session. It is called in addition to the OnParticipantLeave
+// callbacks, and with the client participant id when the client is kicked.
+func (c *Client) OnLeaveReason(callback func(participantID uint32, reason LeaveReason)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.leaveCallbacks = append(c.leaveCallbacks, callback)
+}
+
+// OnParticipantUpdate registers a callback called with the info and presence
+// of a participant of the joined session. It is called for each participant
+// after joining a session, and when a participant joins or its info or
+// presence changes.
+func (c *Client) OnParticipantUpdate(callback func(Participant)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.participantUpdates = append(c.participantUpdates, callback)
+}
+
+// UpdateParticipantInfo replaces the info of the client participant in the
+// joined session. Spectators can't update their info.
+func (c *Client) UpdateParticipantInfo(ctx context.Context, info ParticipantInfo) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:        serverRequestUpdateParticipantInfo,
+		Participant: &info,
+	})
+	return err
+}
+
+// Kick makes the given participant leave the joined session. When ban is true,
+// the participant can't join the session again with the same client id or
+// wallet address. Only the host can kick participants.
+func (c *Client) Kick(ctx context.Context, participantID uint32, ban bool) error {
+	_, err := c.serverRequest(ctx, serverMessage{
+		Type:          serverRequestKick,
+		ParticipantID: participantID,
+		Ban:           ban,
+	})
+	return err
+}
+
+// OnGoingAway registers a callback called when the server is draining before
+// shutting down. The client should leave and join a session on another server,
+// after the suggested delay.
+func (c *Client) OnGoingAway(callback func(GoingAway)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.goingAwayCallbacks = append(c.goingAwayCallbacks, callback)
+}
+
+// OnMigrate registers a callback called when the session migrates to another
+// server. The server disconnects the client right after: the client should
+// dial the migration endpoint with the resume token and join the migrated
+// session to keep its participant id and entities.
+func (c *Client) OnMigrate(callback func(Migration)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.migrateCallbacks = append(c.migrateCallbacks, callback)
+}
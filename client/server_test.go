@@ -0,0 +1,560 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	hostChanges := make(chan uint32, 2)
+	hostClient := dialTestClient(t, server)
+	defer hostClient.Close()
+
+	_, err := hostClient.Join(ctx, "")
+	require.NoError(t, err)
+	require.Eventually(t, hostClient.IsHost, time.Second, time.Millisecond*10)
+
+	otherClient := dialTestClient(t, server)
+	defer otherClient.Close()
+	otherClient.OnHostChange(func(hostID uint32) {
+		hostChanges <- hostID
+	})
+
+	_, err = otherClient.Join(ctx, hostClient.SessionID())
+	require.NoError(t, err)
+	require.Equal(t, hostClient.ParticipantID(), receive(t, hostChanges))
+	require.False(t, otherClient.IsHost())
+
+	t.Run("participant can't use host privileges", func(t *testing.T) {
+		err := otherClient.TransferHost(ctx, otherClient.ParticipantID())
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+
+		err = otherClient.UpdateSessionInfo(ctx, SessionInfo{Name: "Mine"})
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+	})
+
+	t.Run("host updates the session info", func(t *testing.T) {
+		err := hostClient.UpdateSessionInfo(ctx, SessionInfo{
+			Name:     "Tour",
+			Metadata: map[string]string{"stop": "1"},
+		})
+		require.NoError(t, err)
+
+		sessions, err := ListSessions(ctx, Options{
+			Endpoint: server.URL,
+			Token:    "token",
+		})
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, "Tour", sessions[0].Name)
+		require.Equal(t, map[string]string{"stop": "1"}, sessions[0].Metadata)
+	})
+
+	t.Run("host can't be transferred to an unknown participant", func(t *testing.T) {
+		err := hostClient.TransferHost(ctx, 42)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+
+	t.Run("next participant becomes host when the host leaves", func(t *testing.T) {
+		hostClient.Close()
+
+		require.Equal(t, otherClient.ParticipantID(), receive(t, hostChanges))
+		require.True(t, otherClient.IsHost())
+	})
+}
+
+func TestKick(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	dial := func(t *testing.T, clientID string) *Client {
+		c, err := Dial(ctx, Options{
+			Endpoint: server.URL,
+			Token:    "token",
+			ClientID: clientID,
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	hostClient := dial(t, "host")
+	defer hostClient.Close()
+
+	type leave struct {
+		participantID uint32
+		reason        LeaveReason
+	}
+	leaves := make(chan leave, 4)
+	hostClient.OnLeaveReason(func(participantID uint32, reason LeaveReason) {
+		leaves <- leave{participantID: participantID, reason: reason}
+	})
+
+	_, err := hostClient.Join(ctx, "")
+	require.NoError(t, err)
+	sessionID := hostClient.SessionID()
+
+	t.Run("participant can't kick", func(t *testing.T) {
+		c := dial(t, "participant")
+		defer c.Close()
+
+		_, err := c.Join(ctx, sessionID)
+		require.NoError(t, err)
+
+		err = c.Kick(ctx, hostClient.ParticipantID(), false)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+		require.NoError(t, c.Close())
+		require.Equal(t, leave{participantID: c.ParticipantID(), reason: LeaveReasonLeft}, receive(t, leaves))
+	})
+
+	t.Run("kicked participant can join again", func(t *testing.T) {
+		c := dial(t, "kicked")
+		defer c.Close()
+
+		kicks := make(chan LeaveReason, 1)
+		c.OnLeaveReason(func(participantID uint32, reason LeaveReason) {
+			if participantID == c.ParticipantID() {
+				kicks <- reason
+			}
+		})
+
+		_, err := c.Join(ctx, sessionID)
+		require.NoError(t, err)
+
+		err = hostClient.Kick(ctx, c.ParticipantID(), false)
+		require.NoError(t, err)
+		require.Equal(t, LeaveReasonKicked, receive(t, kicks))
+		require.Equal(t, leave{participantID: c.ParticipantID(), reason: LeaveReasonKicked}, receive(t, leaves))
+		<-c.Done()
+
+		c = dial(t, "kicked")
+		_, err = c.Join(ctx, sessionID)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Close())
+		require.Equal(t, leave{participantID: c.ParticipantID(), reason: LeaveReasonLeft}, receive(t, leaves))
+	})
+
+	t.Run("banned participant can't join again", func(t *testing.T) {
+		c := dial(t, "banned")
+		defer c.Close()
+
+		_, err := c.Join(ctx, sessionID)
+		require.NoError(t, err)
+
+		err = hostClient.Kick(ctx, c.ParticipantID(), true)
+		require.NoError(t, err)
+		require.Equal(t, leave{participantID: c.ParticipantID(), reason: LeaveReasonBanned}, receive(t, leaves))
+		<-c.Done()
+
+		c = dial(t, "banned")
+		defer c.Close()
+
+		_, err = c.Join(ctx, sessionID)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+	})
+
+	t.Run("host can't kick itself", func(t *testing.T) {
+		err := hostClient.Kick(ctx, hostClient.ParticipantID(), false)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrorCode(err))
+	})
+}
+
+func TestSessionSettings(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	dial := func(t *testing.T, clientID string) *Client {
+		c, err := Dial(ctx, Options{
+			Endpoint: server.URL,
+			Token:    "token",
+			ClientID: clientID,
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	hostClient := dial(t, "host")
+	defer hostClient.Close()
+
+	_, err := hostClient.Join(ctx, "")
+	require.NoError(t, err)
+	sessionID := hostClient.SessionID()
+
+	t.Run("locked session", func(t *testing.T) {
+		err := hostClient.UpdateSessionSettings(ctx, SessionSettings{
+			Locked:            true,
+			ReservedClientIDs: []string{"guest"},
+		})
+		require.NoError(t, err)
+
+		c := dial(t, "participant")
+		defer c.Close()
+
+		_, err = c.Join(ctx, sessionID)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_CONFLICT, ErrorCode(err))
+
+		guest := dial(t, "guest")
+		defer guest.Close()
+
+		_, err = guest.Join(ctx, sessionID)
+		require.NoError(t, err)
+
+		err = guest.UpdateSessionSettings(ctx, SessionSettings{})
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrorCode(err))
+	})
+
+	t.Run("full session", func(t *testing.T) {
+		err := hostClient.UpdateSessionSettings(ctx, SessionSettings{MaxParticipants: 1})
+		require.NoError(t, err)
+
+		c := dial(t, "participant")
+		defer c.Close()
+
+		_, err = c.Join(ctx, sessionID)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_SERVER_TOO_BUSY, ErrorCode(err))
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		err := hostClient.UpdateSessionSettings(ctx, SessionSettings{MaxParticipants: -1})
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrorCode(err))
+	})
+}
+
+func TestEntityComponentVersions(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c := dialTestClient(t, server)
+	defer c.Close()
+
+	errorResponses := make(chan *hagallpb.ErrorResponse, 1)
+	c.OnErrorResponse(func(res *hagallpb.ErrorResponse) {
+		errorResponses <- res
+	})
+
+	_, err := c.Join(ctx, "")
+	require.NoError(t, err)
+
+	entityID, err := c.AddEntity(ctx, &hagallpb.Pose{}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	require.NoError(t, err)
+
+	typeID, err := c.AddEntityComponentType(ctx, "label")
+	require.NoError(t, err)
+
+	err = c.AddEntityComponent(ctx, typeID, entityID, []byte("a"))
+	require.NoError(t, err)
+
+	t.Run("update with the current version", func(t *testing.T) {
+		ec, err := c.UpdateEntityComponentIfVersion(ctx, typeID, entityID, []byte("b"), 1)
+		require.NoError(t, err)
+		require.Equal(t, VersionedEntityComponent{
+			EntityComponentTypeID: typeID,
+			EntityID:              entityID,
+			Data:                  []byte("b"),
+			Version:               2,
+		}, ec)
+	})
+
+	t.Run("update with a stale version returns the current component", func(t *testing.T) {
+		ec, err := c.UpdateEntityComponentIfVersion(ctx, typeID, entityID, []byte("c"), 1)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_CONFLICT, ErrorCode(err))
+		require.Equal(t, []byte("b"), ec.Data)
+		require.Equal(t, uint64(2), ec.Version)
+	})
+
+	t.Run("delete with a stale version returns the current component", func(t *testing.T) {
+		ec, err := c.DeleteEntityComponentIfVersion(ctx, typeID, entityID, 1)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_CONFLICT, ErrorCode(err))
+		require.Equal(t, uint64(2), ec.Version)
+	})
+
+	t.Run("delete with the current version", func(t *testing.T) {
+		ec, err := c.DeleteEntityComponentIfVersion(ctx, typeID, entityID, 2)
+		require.NoError(t, err)
+		require.Equal(t, []byte("b"), ec.Data)
+
+		_, err = c.UpdateEntityComponentIfVersion(ctx, typeID, entityID, []byte("c"), 2)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+
+	t.Run("failed update returns an error response", func(t *testing.T) {
+		err := c.UpdateEntityComponent(typeID, entityID, []byte("c"))
+		require.NoError(t, err)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, receive(t, errorResponses).Code)
+	})
+}
+
+func TestEntityComponentSubscriptionOptions(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	clientA := dialTestClient(t, server)
+	defer clientA.Close()
+
+	_, err := clientA.Join(ctx, "")
+	require.NoError(t, err)
+
+	clientB := dialTestClient(t, server)
+	defer clientB.Close()
+
+	adds := make(chan *hagallpb.EntityComponentAddBroadcast, 4)
+	clientB.OnEntityComponentAdd(func(b *hagallpb.EntityComponentAddBroadcast) {
+		adds <- b
+	})
+	updates := make(chan *hagallpb.EntityComponentUpdateBroadcast, 4)
+	clientB.OnEntityComponentUpdate(func(b *hagallpb.EntityComponentUpdateBroadcast) {
+		updates <- b
+	})
+
+	_, err = clientB.Join(ctx, clientA.SessionID())
+	require.NoError(t, err)
+
+	followedID, err := clientA.AddEntity(ctx, &hagallpb.Pose{}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	require.NoError(t, err)
+	otherID, err := clientA.AddEntity(ctx, &hagallpb.Pose{}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	require.NoError(t, err)
+
+	typeID, err := clientA.AddEntityComponentType(ctx, "label")
+	require.NoError(t, err)
+
+	t.Run("unknown type is rejected", func(t *testing.T) {
+		err := clientB.SubscribeEntityComponentTypeWithOptions(ctx, typeID+1, EntityComponentSubscriptionOptions{})
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+
+	err = clientB.SubscribeEntityComponentTypeWithOptions(ctx, typeID, EntityComponentSubscriptionOptions{
+		EntityIDs: []uint32{followedID},
+	})
+	require.NoError(t, err)
+
+	t.Run("adds are broadcast to the session", func(t *testing.T) {
+		require.NoError(t, clientA.AddEntityComponent(ctx, typeID, otherID, []byte("a")))
+		require.Equal(t, otherID, receive(t, adds).EntityComponent.EntityId)
+
+		require.NoError(t, clientA.AddEntityComponent(ctx, typeID, followedID, []byte("a")))
+		require.Equal(t, followedID, receive(t, adds).EntityComponent.EntityId)
+	})
+
+	t.Run("updates of followed entities only are broadcast", func(t *testing.T) {
+		require.NoError(t, clientA.UpdateEntityComponent(typeID, otherID, []byte("b")))
+		require.NoError(t, clientA.UpdateEntityComponent(typeID, followedID, []byte("b")))
+		require.Equal(t, followedID, receive(t, updates).EntityComponent.EntityId)
+	})
+
+	t.Run("throttled updates are coalesced", func(t *testing.T) {
+		err := clientB.SubscribeEntityComponentTypeWithOptions(ctx, typeID, EntityComponentSubscriptionOptions{
+			MaxUpdateRate: 2,
+		})
+		require.NoError(t, err)
+
+		// Plain updates are coalesced by the server within a frame, unlike
+		// update requests.
+		for _, data := range []string{"c", "d", "e"} {
+			_, err := clientA.UpdateEntityComponentIfVersion(ctx, typeID, followedID, []byte(data), 0)
+			require.NoError(t, err)
+		}
+		require.Equal(t, []byte("c"), receive(t, updates).EntityComponent.Data)
+		require.Equal(t, []byte("e"), receive(t, updates).EntityComponent.Data)
+		require.Empty(t, updates)
+	})
+}
+
+func TestUndo(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c := dialTestClient(t, server)
+	defer c.Close()
+
+	adds := make(chan *hagallpb.EntityAddBroadcast, 1)
+	c.OnEntityAdd(func(b *hagallpb.EntityAddBroadcast) {
+		adds <- b
+	})
+	deletes := make(chan *hagallpb.EntityDeleteBroadcast, 1)
+	c.OnEntityDelete(func(b *hagallpb.EntityDeleteBroadcast) {
+		deletes <- b
+	})
+
+	_, err := c.Join(ctx, "")
+	require.NoError(t, err)
+
+	t.Run("nothing to undo", func(t *testing.T) {
+		err := c.Undo(ctx)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+
+	entityID, err := c.AddEntity(ctx, &hagallpb.Pose{}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	require.NoError(t, err)
+
+	t.Run("undo entity add", func(t *testing.T) {
+		require.NoError(t, c.Undo(ctx))
+		require.Equal(t, entityID, receive(t, deletes).EntityId)
+	})
+
+	t.Run("redo entity add", func(t *testing.T) {
+		require.NoError(t, c.Redo(ctx))
+		require.Equal(t, entityID, receive(t, adds).Entity.Id)
+
+		err := c.Redo(ctx)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+}
+
+func TestEntityComponentHistory(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c := dialTestClient(t, server)
+	defer c.Close()
+
+	_, err := c.Join(ctx, "")
+	require.NoError(t, err)
+
+	entityID, err := c.AddEntity(ctx, &hagallpb.Pose{}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	require.NoError(t, err)
+
+	labelID, err := c.AddEntityComponentType(ctx, "label")
+	require.NoError(t, err)
+	colorID, err := c.AddEntityComponentType(ctx, "color")
+	require.NoError(t, err)
+
+	require.NoError(t, c.AddEntityComponent(ctx, labelID, entityID, []byte("a")))
+	require.NoError(t, c.AddEntityComponent(ctx, colorID, entityID, []byte("red")))
+	_, err = c.UpdateEntityComponentIfVersion(ctx, labelID, entityID, []byte("b"), 1)
+	require.NoError(t, err)
+
+	t.Run("history of an entity", func(t *testing.T) {
+		changes, err := c.EntityComponentHistory(ctx, entityID, 0)
+		require.NoError(t, err)
+		require.Len(t, changes, 3)
+		require.Equal(t, colorID, changes[1].EntityComponentTypeID)
+	})
+
+	t.Run("history of an entity component", func(t *testing.T) {
+		changes, err := c.EntityComponentHistory(ctx, entityID, labelID)
+		require.NoError(t, err)
+		require.Len(t, changes, 2)
+
+		require.Equal(t, "add", changes[0].Op)
+		require.Equal(t, []byte("a"), changes[0].Data)
+		require.Equal(t, "update", changes[1].Op)
+		require.Equal(t, []byte("b"), changes[1].Data)
+		require.Equal(t, uint64(2), changes[1].Version)
+		require.Equal(t, c.ParticipantID(), changes[1].ParticipantID)
+	})
+
+	t.Run("entity id is required", func(t *testing.T) {
+		_, err := c.EntityComponentHistory(ctx, 0, labelID)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrorCode(err))
+	})
+}
+
+func TestParticipantInfo(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	dial := func(t *testing.T, displayName string) (*Client, chan Participant) {
+		c, err := Dial(ctx, Options{
+			Endpoint:    server.URL,
+			Token:       "token",
+			DisplayName: displayName,
+		})
+		require.NoError(t, err)
+
+		updates := make(chan Participant, 4)
+		c.OnParticipantUpdate(func(p Participant) {
+			updates <- p
+		})
+		return c, updates
+	}
+
+	clientA, updatesA := dial(t, "Ted")
+	defer clientA.Close()
+
+	_, err := clientA.Join(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, Participant{
+		ID:       clientA.ParticipantID(),
+		Info:     ParticipantInfo{DisplayName: "Ted"},
+		Presence: PresenceActive,
+	}, receive(t, updatesA))
+
+	clientB, updatesB := dial(t, "Ann")
+	defer clientB.Close()
+
+	_, err = clientB.Join(ctx, clientA.SessionID())
+	require.NoError(t, err)
+
+	t.Run("joining participant receives the participants", func(t *testing.T) {
+		names := map[uint32]string{}
+		for i := 0; i < 2; i++ {
+			p := receive(t, updatesB)
+			names[p.ID] = p.Info.DisplayName
+		}
+		require.Equal(t, map[uint32]string{
+			clientA.ParticipantID(): "Ted",
+			clientB.ParticipantID(): "Ann",
+		}, names)
+	})
+
+	t.Run("participants receive the joining participant", func(t *testing.T) {
+		p := receive(t, updatesA)
+		require.Equal(t, clientB.ParticipantID(), p.ID)
+		require.Equal(t, "Ann", p.Info.DisplayName)
+	})
+
+	t.Run("participant updates its info", func(t *testing.T) {
+		err := clientB.UpdateParticipantInfo(ctx, ParticipantInfo{
+			DisplayName: "Anna",
+			Metadata:    map[string]string{"team": "red"},
+		})
+		require.NoError(t, err)
+
+		p := receive(t, updatesA)
+		require.Equal(t, clientB.ParticipantID(), p.ID)
+		require.Equal(t, ParticipantInfo{
+			DisplayName: "Anna",
+			Metadata:    map[string]string{"team": "red"},
+		}, p.Info)
+		require.Equal(t, "Anna", receive(t, updatesB).Info.DisplayName)
+	})
+
+	t.Run("too large info is rejected", func(t *testing.T) {
+		err := clientB.UpdateParticipantInfo(ctx, ParticipantInfo{
+			DisplayName: strings.Repeat("a", 4097),
+		})
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrorCode(err))
+	})
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// SessionListing is a session returned by ListSessions.
+type SessionListing struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Metadata         map[string]string `json:"metadata"`
+	ParticipantCount int               `json:"participant_count"`
+	MaxParticipants  int               `json:"max_participants"`
+	Locked           bool              `json:"locked"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+// ListSessions returns the sessions of a Hagall server that can be joined with
+// the app key of the token in the given options. Private sessions are not
+// listed.
+func ListSessions(ctx context.Context, opts Options) ([]SessionListing, error) {
+	var res struct {
+		Sessions []SessionListing `json:"sessions"`
+	}
+	if err := doHTTP(ctx, opts, http.MethodGet, "/sessions", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Sessions, nil
+}
+
+// ListSessions returns the sessions of the server the client is connected to
+// that can be joined with the app key of the client. Private sessions are not
+// listed. The client must have joined a session.
+func (c *Client) ListSessions(ctx context.Context) ([]SessionListing, error) {
+	res, err := c.serverRequest(ctx, serverMessage{Type: serverRequestListSessions})
+	return res.Sessions, err
+}
+
+// MatchRequest describes the group a client wants to be part of.
+type MatchRequest struct {
+	// The number of clients in the group, the client included.
+	GroupSize int `json:"group_size"`
+
+	// The client is only grouped with clients that have the same tags.
+	Tags []string `json:"tags,omitempty"`
+
+	// The client skill, used when the server limits the skill gap in groups.
+	Skill float64 `json:"skill,omitempty"`
+
+	// The time to wait for a group, in seconds. The server maximum wait time
+	// is used when zero.
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+// Match is the session formed for a group of clients.
+type Match struct {
+	SessionID string `json:"session_id"`
+	GroupSize int    `json:"group_size"`
+}
+
+// Matchmake waits until the server groups the client with other clients of
+// its app key, and returns the session the group has to join. Canceling the
+// context cancels the request. When no group is formed in time, the returned
+// error has a 408 HTTPStatus.
+func Matchmake(ctx context.Context, opts Options, req MatchRequest) (Match, error) {
+	var match Match
+	err := doHTTP(ctx, opts, http.MethodPost, "/matchmaking", req, &match)
+	return match, err
+}
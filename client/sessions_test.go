@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSessions(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	dial := func(t *testing.T, opts Options) *Client {
+		opts.Endpoint = server.URL
+		opts.Token = "token"
+		c, err := Dial(ctx, opts)
+		require.NoError(t, err)
+
+		_, err = c.Join(ctx, "")
+		require.NoError(t, err)
+		return c
+	}
+
+	public := dial(t, Options{
+		SessionName:     "Lobby",
+		SessionMetadata: map[string]string{"map": "park"},
+	})
+	defer public.Close()
+
+	private := dial(t, Options{PrivateSession: true})
+	defer private.Close()
+
+	sessions, err := ListSessions(ctx, Options{
+		Endpoint: server.URL,
+		Token:    "token",
+	})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, public.SessionID(), sessions[0].ID)
+	require.Equal(t, "Lobby", sessions[0].Name)
+	require.Equal(t, map[string]string{"map": "park"}, sessions[0].Metadata)
+	require.Equal(t, 1, sessions[0].ParticipantCount)
+	require.False(t, sessions[0].CreatedAt.IsZero())
+
+	joined, err := private.ListSessions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, sessions, joined)
+}
+
+func TestJoinNamedSession(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	dial := func(t *testing.T, opts Options) *Client {
+		opts.Endpoint = server.URL
+		opts.Token = "token"
+		c, err := Dial(ctx, opts)
+		require.NoError(t, err)
+		return c
+	}
+
+	t.Run("spectator can't create a named session", func(t *testing.T) {
+		spectator := dial(t, Options{SessionName: "Room", Spectator: true})
+		defer spectator.Close()
+
+		_, err := spectator.Join(ctx, "")
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, ErrorCode(err))
+	})
+
+	t.Run("participants with the same session name join the same session", func(t *testing.T) {
+		first := dial(t, Options{SessionName: "Room"})
+		defer first.Close()
+		_, err := first.Join(ctx, "")
+		require.NoError(t, err)
+
+		second := dial(t, Options{SessionName: "Room"})
+		defer second.Close()
+		_, err = second.Join(ctx, "")
+		require.NoError(t, err)
+		require.Equal(t, first.SessionID(), second.SessionID())
+
+		other := dial(t, Options{SessionName: "Other room"})
+		defer other.Close()
+		_, err = other.Join(ctx, "")
+		require.NoError(t, err)
+		require.NotEqual(t, first.SessionID(), other.SessionID())
+	})
+}
+
+func TestMatchmake(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	opts := Options{
+		Endpoint: server.URL,
+		Token:    "token",
+	}
+
+	t.Run("clients are grouped in the same session", func(t *testing.T) {
+		matches := make(chan Match, 2)
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				match, err := Matchmake(ctx, opts, MatchRequest{
+					GroupSize: 2,
+					Tags:      []string{"coop"},
+				})
+				matches <- match
+				errs <- err
+			}()
+		}
+
+		first, second := <-matches, <-matches
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+		require.NotEmpty(t, first.SessionID)
+		require.Equal(t, first, second)
+
+		c, err := Dial(ctx, opts)
+		require.NoError(t, err)
+		defer c.Close()
+
+		_, err = c.Join(ctx, first.SessionID)
+		require.NoError(t, err)
+	})
+
+	t.Run("no group is formed in time", func(t *testing.T) {
+		_, err := Matchmake(ctx, opts, MatchRequest{
+			GroupSize: 2,
+			Timeout:   0.05,
+		})
+		require.Equal(t, http.StatusRequestTimeout, HTTPStatus(err))
+	})
+
+	t.Run("invalid group size", func(t *testing.T) {
+		_, err := Matchmake(ctx, opts, MatchRequest{})
+		require.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+	})
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+
+	"github.com/aukilabs/hagall-common/messages/vikjapb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SetEntityAction sets an action on an entity with the Vikja module.
+func (c *Client) SetEntityAction(ctx context.Context, entityID uint32, name string, data []byte) error {
+	return c.Request(ctx, &vikjapb.EntityActionRequest{
+		Type: vikjapb.MsgType_MSG_TYPE_VIKJA_ENTITY_ACTION_REQUEST,
+		EntityAction: &vikjapb.EntityAction{
+			EntityId:  entityID,
+			Name:      name,
+			Timestamp: timestamppb.New(c.ServerTime()),
+			Data:      data,
+		},
+	}, &vikjapb.EntityActionResponse{})
+}
+
+// OnEntityAction registers a callback called when an entity action is set by
+// another participant.
+func (c *Client) OnEntityAction(callback func(*vikjapb.EntityActionBroadcast)) {
+	on(c, vikjapb.MsgType_MSG_TYPE_VIKJA_ENTITY_ACTION_BROADCAST, callback)
+}
+
+// OnVikjaState registers a callback called with the Vikja state sent after
+// joining a session.
+func (c *Client) OnVikjaState(callback func(*vikjapb.State)) {
+	on(c, vikjapb.MsgType_MSG_TYPE_VIKJA_STATE, callback)
+}
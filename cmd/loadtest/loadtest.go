@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"math"
+	mathrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/client"
+	"github.com/google/uuid"
+)
+
+const (
+	// The name of the entity component type updated by participants.
+	componentTypeName = "loadtest"
+
+	// The time given to in-flight broadcasts to be received once participants
+	// stop sending messages.
+	drainDuration = time.Second * 2
+
+	requestTimeout = time.Second * 10
+)
+
+// loadTest is a load test that simulates participants spread across sessions.
+type loadTest struct {
+	conf config
+
+	sessions []*loadTestSession
+
+	poses          trafficStats
+	customMessages trafficStats
+	components     trafficStats
+
+	connectFailures uint64
+	sendFailures    uint64
+	disconnections  uint64
+}
+
+// loadTestSession is a session shared by simulated participants.
+type loadTestSession struct {
+	// Closed once the first participant created the session.
+	created chan struct{}
+	id      string
+
+	mutex        sync.Mutex
+	participants int
+}
+
+func (s *loadTestSession) join() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.participants++
+}
+
+// recipients returns the number of participants that receive a broadcast sent
+// by a participant of the session.
+func (s *loadTestSession) recipients() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.participants - 1
+}
+
+func run(ctx context.Context, conf config) (report, error) {
+	lt := &loadTest{
+		conf:           conf,
+		sessions:       make([]*loadTestSession, conf.Sessions),
+		poses:          trafficStats{Name: "entity pose"},
+		customMessages: trafficStats{Name: "custom message"},
+		components:     trafficStats{Name: "entity component"},
+	}
+	for i := range lt.sessions {
+		lt.sessions[i] = &loadTestSession{created: make(chan struct{})}
+	}
+
+	logs.WithTag("participants", conf.Participants).
+		WithTag("sessions", conf.Sessions).
+		WithTag("ramp_up", conf.RampUp).
+		Info("connecting participants")
+
+	participants := lt.connect(ctx)
+	defer func() {
+		for _, p := range participants {
+			p.client.Close()
+		}
+	}()
+
+	if len(participants) == 0 {
+		return report{}, errors.New("no participant joined a session").
+			WithTag("connect_failures", lt.connectFailures)
+	}
+	if ctx.Err() != nil {
+		return report{}, ctx.Err()
+	}
+
+	var waitServerUsage func() (serverUsage, error)
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	defer stopMonitor()
+	if conf.MetricsEndpoint != "" {
+		waitServerUsage = monitorServer(monitorCtx, conf.MetricsEndpoint)
+	}
+
+	logs.WithTag("participants", len(participants)).
+		WithTag("duration", conf.Duration).
+		Info("sending traffic")
+
+	start := time.Now()
+	trafficCtx, stopTraffic := context.WithTimeout(ctx, conf.Duration)
+	defer stopTraffic()
+
+	var wg sync.WaitGroup
+	for _, p := range participants {
+		wg.Add(1)
+		go func(p *participant) {
+			defer wg.Done()
+			p.sendTraffic(trafficCtx)
+		}(p)
+	}
+	wg.Wait()
+	duration := time.Since(start)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(drainDuration):
+	}
+	stopMonitor()
+
+	r := report{
+		Participants:    conf.Participants,
+		Connected:       len(participants),
+		Sessions:        conf.Sessions,
+		Duration:        duration,
+		ConnectFailures: atomic.LoadUint64(&lt.connectFailures),
+		SendFailures:    atomic.LoadUint64(&lt.sendFailures),
+		Disconnections:  atomic.LoadUint64(&lt.disconnections),
+		Traffic:         []*trafficStats{&lt.poses, &lt.customMessages, &lt.components},
+	}
+	if waitServerUsage != nil {
+		r.Server, r.ServerMetricsErr = waitServerUsage()
+	}
+	return r, nil
+}
+
+// connect connects and joins the participants over the ramp up duration. It
+// returns the participants that joined a session.
+func (lt *loadTest) connect(ctx context.Context) []*participant {
+	var interval time.Duration
+	if lt.conf.Participants > 1 {
+		interval = lt.conf.RampUp / time.Duration(lt.conf.Participants-1)
+	}
+
+	var mutex sync.Mutex
+	var participants []*participant
+
+	var wg sync.WaitGroup
+	for i := 0; i < lt.conf.Participants; i++ {
+		if i != 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return participants
+			case <-time.After(interval):
+			}
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			p, err := lt.newParticipant(ctx, i)
+			if err != nil {
+				atomic.AddUint64(&lt.connectFailures, 1)
+				logs.WithTag("participant", i).Warn(err)
+				return
+			}
+
+			mutex.Lock()
+			participants = append(participants, p)
+			mutex.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+	return participants
+}
+
+// participant is a simulated participant that owns an entity with a
+// component.
+type participant struct {
+	lt              *loadTest
+	index           int
+	session         *loadTestSession
+	client          *client.Client
+	entityID        uint32
+	componentTypeID uint32
+}
+
+func (lt *loadTest) newParticipant(ctx context.Context, index int) (*participant, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	session := lt.sessions[index%len(lt.sessions)]
+
+	c, err := client.Dial(ctx, client.Options{
+		Endpoint:  lt.conf.Endpoint,
+		Token:     lt.conf.Token,
+		ClientID:  uuid.NewString(),
+		UserAgent: "hagall-loadtest",
+	})
+	if err != nil {
+		if lt.isSessionCreator(index) {
+			close(session.created)
+		}
+		return nil, err
+	}
+
+	p := &participant{
+		lt:      lt,
+		index:   index,
+		session: session,
+		client:  c,
+	}
+	if err := p.join(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// isSessionCreator reports whether the participant with the given index is the
+// first participant of its session, which creates the session.
+func (lt *loadTest) isSessionCreator(index int) bool {
+	return index < len(lt.sessions)
+}
+
+func (p *participant) join(ctx context.Context) error {
+	p.client.OnEntityUpdatePose(func(b *hagallpb.EntityUpdatePoseBroadcast) {
+		p.lt.poses.recordReceived(time.Since(b.OriginTimestamp.AsTime()))
+	})
+	p.client.OnCustomMessage(func(b *hagallpb.CustomMessageBroadcast) {
+		p.lt.customMessages.recordReceived(time.Since(b.OriginTimestamp.AsTime()))
+	})
+	p.client.OnEntityComponentUpdate(func(b *hagallpb.EntityComponentUpdateBroadcast) {
+		p.lt.components.recordReceived(time.Since(b.OriginTimestamp.AsTime()))
+	})
+
+	creator := p.lt.isSessionCreator(p.index)
+	var sessionID string
+	if !creator {
+		select {
+		case <-ctx.Done():
+			return errors.New("waiting for session creation failed").Wrap(ctx.Err())
+		case <-p.session.created:
+			sessionID = p.session.id
+		}
+
+		if sessionID == "" {
+			return errors.New("session creation failed")
+		}
+	}
+
+	res, err := p.client.Join(ctx, sessionID)
+	if creator {
+		if err == nil {
+			p.session.id = res.SessionId
+		}
+		close(p.session.created)
+	}
+	if err != nil {
+		return errors.New("joining session failed").Wrap(err)
+	}
+
+	entityID, err := p.client.AddEntity(ctx, &hagallpb.Pose{Rw: 1}, false, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+	if err != nil {
+		return errors.New("adding entity failed").Wrap(err)
+	}
+	p.entityID = entityID
+
+	typeID, err := p.client.AddEntityComponentType(ctx, componentTypeName)
+	if err != nil {
+		return errors.New("adding entity component type failed").Wrap(err)
+	}
+	p.componentTypeID = typeID
+
+	if err := p.client.AddEntityComponent(ctx, typeID, entityID, randomBytes(p.lt.conf.ComponentSize)); err != nil {
+		return errors.New("adding entity component failed").Wrap(err)
+	}
+	if err := p.client.SubscribeEntityComponentType(ctx, typeID); err != nil {
+		return errors.New("subscribing to entity component type failed").Wrap(err)
+	}
+
+	p.session.join()
+	return nil
+}
+
+// sendTraffic sends pose updates, custom messages and entity component updates
+// at the configured rates until the given context is done.
+func (p *participant) sendTraffic(ctx context.Context) {
+	var wg sync.WaitGroup
+	send := func(rate float64, stats *trafficStats, sendMsg func(step int) error) {
+		if rate <= 0 {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			interval := time.Duration(float64(time.Second) / rate)
+
+			// Participants start at a random offset to not send their
+			// messages at the same time.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(mathrand.Int63n(int64(interval) + 1))):
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for step := 0; ; step++ {
+				if err := sendMsg(step); err != nil {
+					atomic.AddUint64(&p.lt.sendFailures, 1)
+					logs.WithTag("participant", p.index).Debug(err)
+				} else {
+					stats.recordSent(p.session.recipients())
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+
+				case <-p.client.Done():
+					return
+
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	send(p.lt.conf.PoseRate, &p.lt.poses, func(step int) error {
+		angle := float64(step) / 10
+		return p.client.UpdateEntityPose(p.entityID, &hagallpb.Pose{
+			Px: float32(math.Cos(angle)),
+			Pz: float32(math.Sin(angle)),
+			Rw: 1,
+		})
+	})
+
+	send(p.lt.conf.CustomMessageRate, &p.lt.customMessages, func(step int) error {
+		return p.client.SendCustomMessage(randomBytes(p.lt.conf.CustomMessageSize))
+	})
+
+	send(p.lt.conf.ComponentRate, &p.lt.components, func(step int) error {
+		return p.client.UpdateEntityComponent(p.componentTypeID, p.entityID, randomBytes(p.lt.conf.ComponentSize))
+	})
+
+	wg.Wait()
+
+	select {
+	case <-p.client.Done():
+		atomic.AddUint64(&p.lt.disconnections, 1)
+		logs.WithTag("participant", p.index).Warn(p.client.Err())
+	default:
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
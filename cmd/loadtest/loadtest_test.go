@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type testDiscoveryService struct{}
+
+func (s testDiscoveryService) ServerID() string {
+	return "ted"
+}
+
+func TestRun(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: testDiscoveryService{}}
+
+	server := httptest.NewServer(websocket.Server{
+		Handshake: func(c *websocket.Config, r *http.Request) error {
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			h := &hwebsocket.RealtimeHandler{
+				ClientSyncClockInterval: time.Second,
+				ClientIdleTimeout:       time.Minute,
+				FrameDuration:           time.Millisecond * 5,
+				Sessions:                sessions,
+			}
+			defer h.Close()
+
+			hwebsocket.Handle(context.Background(), conn, h)
+		},
+	})
+	defer server.Close()
+
+	metricsServer := httptest.NewServer(promhttp.Handler())
+	defer metricsServer.Close()
+
+	r, err := run(context.Background(), config{
+		Endpoint:          server.URL,
+		MetricsEndpoint:   metricsServer.URL,
+		Participants:      4,
+		Sessions:          2,
+		Duration:          time.Millisecond * 500,
+		PoseRate:          20,
+		CustomMessageRate: 10,
+		CustomMessageSize: 16,
+		ComponentRate:     10,
+		ComponentSize:     16,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, r.Connected)
+	require.Zero(t, r.ConnectFailures)
+	require.NoError(t, r.ServerMetricsErr)
+	require.NotZero(t, r.Server.Samples)
+	require.NotZero(t, r.Server.MaxGoroutines)
+
+	for _, traffic := range r.Traffic {
+		require.NotZero(t, traffic.Sent(), traffic.Name)
+		require.Equal(t, traffic.Sent(), traffic.Expected(), traffic.Name)
+		require.NotZero(t, traffic.Received(), traffic.Name)
+	}
+
+	// Custom messages are never merged by the server.
+	require.Zero(t, r.Traffic[1].Loss())
+
+	var out bytes.Buffer
+	r.Print(&out)
+	require.Contains(t, out.String(), "custom message")
+}
+
+func TestHistogram(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		var h histogram
+		require.Zero(t, h.Percentile(99))
+	})
+
+	t.Run("percentiles", func(t *testing.T) {
+		var h histogram
+		for i := 1; i <= 100; i++ {
+			h.Record(time.Duration(i) * time.Millisecond)
+		}
+
+		require.InEpsilon(t, float64(50*time.Millisecond), float64(h.Percentile(50)), 0.01)
+		require.InEpsilon(t, float64(90*time.Millisecond), float64(h.Percentile(90)), 0.01)
+		require.InEpsilon(t, float64(99*time.Millisecond), float64(h.Percentile(99)), 0.01)
+		require.Equal(t, 100*time.Millisecond, h.Percentile(100))
+		require.Equal(t, 100*time.Millisecond, h.Max())
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		var h histogram
+		h.Record(-time.Second)
+		h.Record(time.Hour * 24)
+		require.Equal(t, time.Microsecond, h.Percentile(50))
+		require.Equal(t, time.Hour*24, h.Percentile(100))
+	})
+}
+
+func TestParseMetrics(t *testing.T) {
+	metrics, err := parseMetrics(strings.NewReader(`# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+process_cpu_seconds_total 1.5
+process_resident_memory_bytes 2.097152e+07
+ws_sent_msgs{type="MSG_TYPE_SYNC_CLOCK"} 3
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{
+		"go_goroutines":                 42,
+		"process_cpu_seconds_total":     1.5,
+		"process_resident_memory_bytes": 20971520,
+	}, metrics)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/cli"
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/segmentio/encoding/json"
+)
+
+// This will effectively disable obfuscation of the config struct. Without it, the keys would get obfuscated causing the cli package to generate garbled command-line options.
+// https://github.com/burrowers/garble/issues/403
+var _ = reflect.TypeOf(config{})
+
+type config struct {
+	Endpoint          string        `cli:"" env:"HAGALL_LOADTEST_ENDPOINT"            help:"The endpoint of the Hagall server to test."`
+	Token             string        `cli:"" env:"HAGALL_LOADTEST_TOKEN"               help:"The Hagall user token used by the simulated participants."`
+	MetricsEndpoint   string        `cli:"" env:"HAGALL_LOADTEST_METRICS_ENDPOINT"    help:"The admin metrics endpoint of the Hagall server, used to report its CPU and memory usage (empty disables it)."`
+	Participants      int           `cli:"" env:"HAGALL_LOADTEST_PARTICIPANTS"        help:"The number of simulated participants."`
+	Sessions          int           `cli:"" env:"HAGALL_LOADTEST_SESSIONS"            help:"The number of sessions the participants are spread across."`
+	RampUp            time.Duration `cli:"" env:"HAGALL_LOADTEST_RAMP_UP"             help:"The duration over which participants connect."`
+	Duration          time.Duration `cli:"" env:"HAGALL_LOADTEST_DURATION"            help:"The duration of the traffic once all the participants joined."`
+	PoseRate          float64       `cli:"" env:"HAGALL_LOADTEST_POSE_RATE"           help:"The number of entity pose updates sent per second by each participant."`
+	CustomMessageRate float64       `cli:"" env:"HAGALL_LOADTEST_CUSTOM_MESSAGE_RATE" help:"The number of custom messages sent per second by each participant."`
+	CustomMessageSize int           `cli:"" env:"HAGALL_LOADTEST_CUSTOM_MESSAGE_SIZE" help:"The size in bytes of custom messages."`
+	ComponentRate     float64       `cli:"" env:"HAGALL_LOADTEST_COMPONENT_RATE"      help:"The number of entity component updates sent per second by each participant."`
+	ComponentSize     int           `cli:"" env:"HAGALL_LOADTEST_COMPONENT_SIZE"      help:"The size in bytes of entity component data."`
+	LogLevel          string        `cli:"" env:"HAGALL_LOADTEST_LOG_LEVEL"           help:"Log level (debug|info|warning|error)."`
+	Help              bool          `cli:"" env:"-"                                   help:"Show help."`
+}
+
+func main() {
+	conf := config{
+		Endpoint:          "http://localhost:4000",
+		MetricsEndpoint:   "http://localhost:18190/metrics",
+		Participants:      10,
+		Sessions:          1,
+		RampUp:            time.Second * 10,
+		Duration:          time.Minute,
+		PoseRate:          10,
+		CustomMessageRate: 1,
+		CustomMessageSize: 64,
+		ComponentRate:     2,
+		ComponentSize:     64,
+		LogLevel:          logs.InfoLevel.String(),
+	}
+
+	ctx, cancel := cli.ContextWithSignals(context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer cancel()
+
+	cli.Register().
+		Help("Simulates participants to load test a Hagall server.").
+		Options(&conf)
+	cli.Load()
+
+	if err := validateConfig(conf); err != nil {
+		logs.Fatal(err)
+	}
+
+	logs.SetLevel(logs.ParseLevel(conf.LogLevel))
+	logs.Encoder = json.Marshal
+	errors.Encoder = json.Marshal
+
+	report, err := run(ctx, conf)
+	if err != nil {
+		logs.Fatal(err)
+	}
+	report.Print(os.Stdout)
+}
+
+func validateConfig(conf config) error {
+	if _, err := url.ParseRequestURI(conf.Endpoint); err != nil {
+		return errors.New("invalid endpoint").Wrap(err)
+	}
+	if conf.MetricsEndpoint != "" {
+		if _, err := url.ParseRequestURI(conf.MetricsEndpoint); err != nil {
+			return errors.New("invalid metrics endpoint").Wrap(err)
+		}
+	}
+	if conf.Participants <= 0 {
+		return errors.New("participants must be greater than 0")
+	}
+	if conf.Sessions <= 0 || conf.Sessions > conf.Participants {
+		return errors.New("sessions must be between 1 and the number of participants").
+			WithTag("sessions", conf.Sessions).
+			WithTag("participants", conf.Participants)
+	}
+	if conf.PoseRate < 0 || conf.CustomMessageRate < 0 || conf.ComponentRate < 0 {
+		return errors.New("rates must not be negative")
+	}
+	return nil
+}
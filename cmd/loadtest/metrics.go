@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+)
+
+const (
+	metricCPUSeconds      = "process_cpu_seconds_total"
+	metricResidentMemory  = "process_resident_memory_bytes"
+	metricHeapInUse       = "go_memstats_heap_inuse_bytes"
+	metricGoroutines      = "go_goroutines"
+	serverMetricsInterval = time.Second * 5
+)
+
+// serverUsage is the resource usage of the tested server, computed from its
+// Prometheus metrics.
+type serverUsage struct {
+	Samples int
+
+	// The average CPU usage, where 1 is one core fully used.
+	CPU float64
+
+	MaxResidentMemory float64
+	MaxHeapInUse      float64
+	MaxGoroutines     float64
+
+	firstTime time.Time
+	firstCPU  float64
+}
+
+func (u *serverUsage) add(t time.Time, metrics map[string]float64) {
+	if u.Samples == 0 {
+		u.firstTime = t
+		u.firstCPU = metrics[metricCPUSeconds]
+	} else if elapsed := t.Sub(u.firstTime).Seconds(); elapsed > 0 {
+		u.CPU = (metrics[metricCPUSeconds] - u.firstCPU) / elapsed
+	}
+
+	u.Samples++
+	u.MaxResidentMemory = max(u.MaxResidentMemory, metrics[metricResidentMemory])
+	u.MaxHeapInUse = max(u.MaxHeapInUse, metrics[metricHeapInUse])
+	u.MaxGoroutines = max(u.MaxGoroutines, metrics[metricGoroutines])
+}
+
+// monitorServer samples the server metrics until the given context is
+// canceled. The returned function waits for the monitoring to end and returns
+// the server usage.
+func monitorServer(ctx context.Context, endpoint string) func() (serverUsage, error) {
+	type result struct {
+		usage serverUsage
+		err   error
+	}
+	resc := make(chan result, 1)
+
+	go func() {
+		var usage serverUsage
+
+		sample := func() error {
+			// The last sample is taken after ctx is done.
+			reqCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			metrics, err := scrapeMetrics(reqCtx, endpoint)
+			if err != nil {
+				return err
+			}
+			usage.add(time.Now(), metrics)
+			return nil
+		}
+
+		if err := sample(); err != nil {
+			resc <- result{err: err}
+			return
+		}
+
+		ticker := time.NewTicker(serverMetricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				err := sample()
+				resc <- result{usage: usage, err: err}
+				return
+
+			case <-ticker.C:
+				if err := sample(); err != nil {
+					logs.Warn(err)
+				}
+			}
+		}
+	}()
+
+	return func() (serverUsage, error) {
+		res := <-resc
+		return res.usage, res.err
+	}
+}
+
+func scrapeMetrics(ctx context.Context, endpoint string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.New("creating metrics request failed").Wrap(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.New("getting server metrics failed").
+			WithTag("endpoint", endpoint).
+			Wrap(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("getting server metrics failed").
+			WithTag("endpoint", endpoint).
+			WithTag("status", res.StatusCode)
+	}
+	return parseMetrics(res.Body)
+}
+
+// parseMetrics returns the unlabeled samples of metrics in the Prometheus text
+// format.
+func parseMetrics(r io.Reader) (map[string]float64, error) {
+	metrics := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "{") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		metrics[fields[0]] = v
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("reading server metrics failed").Wrap(err)
+	}
+	return metrics, nil
+}
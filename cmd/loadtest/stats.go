@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// The ratio between the bounds of a latency histogram bucket. Percentiles
+	// are reported with a 1% precision.
+	histogramBucketRatio = 1.01
+
+	// The number of buckets of a latency histogram. It covers latencies from 1
+	// microsecond to about 18 hours.
+	histogramBuckets = 2500
+)
+
+// histogram records latencies in buckets with exponentially growing bounds,
+// so memory stays bounded whatever the number of samples.
+type histogram struct {
+	mutex   sync.Mutex
+	buckets [histogramBuckets]uint64
+	count   uint64
+	max     time.Duration
+}
+
+func (h *histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	i := 0
+	if us := float64(d) / float64(time.Microsecond); us >= 1 {
+		i = int(math.Log(us)/math.Log(histogramBucketRatio)) + 1
+	}
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.buckets[i]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Percentile returns the upper bound of the bucket that contains the given
+// percentile, between 0 and 100.
+func (h *histogram) Percentile(p float64) time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var cumulated uint64
+	for i, c := range h.buckets {
+		cumulated += c
+		if cumulated < rank {
+			continue
+		}
+
+		// The last bucket also contains the latencies that are out of range.
+		if i == histogramBuckets-1 {
+			return h.max
+		}
+
+		upperBound := time.Duration(math.Pow(histogramBucketRatio, float64(i)) * float64(time.Microsecond))
+		if upperBound > h.max {
+			return h.max
+		}
+		return upperBound
+	}
+	return h.max
+}
+
+func (h *histogram) Max() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.max
+}
+
+// trafficStats are the stats of a type of broadcasted message.
+type trafficStats struct {
+	Name string
+
+	// The number of messages sent by participants.
+	sent uint64
+
+	// The number of broadcasts the session participants should receive for
+	// the sent messages.
+	expected uint64
+
+	// The number of broadcasts received by participants.
+	received uint64
+
+	latency histogram
+}
+
+func (s *trafficStats) recordSent(recipients int) {
+	atomic.AddUint64(&s.sent, 1)
+	atomic.AddUint64(&s.expected, uint64(recipients))
+}
+
+func (s *trafficStats) recordReceived(latency time.Duration) {
+	atomic.AddUint64(&s.received, 1)
+	s.latency.Record(latency)
+}
+
+func (s *trafficStats) Sent() uint64 {
+	return atomic.LoadUint64(&s.sent)
+}
+
+func (s *trafficStats) Expected() uint64 {
+	return atomic.LoadUint64(&s.expected)
+}
+
+func (s *trafficStats) Received() uint64 {
+	return atomic.LoadUint64(&s.received)
+}
+
+// Loss returns the ratio of expected broadcasts that were not received.
+func (s *trafficStats) Loss() float64 {
+	expected := s.Expected()
+	received := s.Received()
+	if expected == 0 || received >= expected {
+		return 0
+	}
+	return float64(expected-received) / float64(expected)
+}
+
+// report is the result of a load test.
+type report struct {
+	Participants     int
+	Connected        int
+	Sessions         int
+	Duration         time.Duration
+	ConnectFailures  uint64
+	SendFailures     uint64
+	Disconnections   uint64
+	Traffic          []*trafficStats
+	Server           serverUsage
+	ServerMetricsErr error
+}
+
+func (r report) Print(w io.Writer) {
+	fmt.Fprintf(w, "participants:      %v/%v connected across %v sessions\n", r.Connected, r.Participants, r.Sessions)
+	fmt.Fprintf(w, "duration:          %v\n", r.Duration)
+	fmt.Fprintf(w, "connect failures:  %v\n", r.ConnectFailures)
+	fmt.Fprintf(w, "send failures:     %v\n", r.SendFailures)
+	fmt.Fprintf(w, "disconnections:    %v\n", r.Disconnections)
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRAFFIC\tSENT\tEXPECTED\tRECEIVED\tLOSS\tP50\tP90\tP99\tMAX")
+	for _, t := range r.Traffic {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%.2f%%\t%v\t%v\t%v\t%v\n",
+			t.Name,
+			t.Sent(),
+			t.Expected(),
+			t.Received(),
+			t.Loss()*100,
+			t.latency.Percentile(50).Round(time.Microsecond),
+			t.latency.Percentile(90).Round(time.Microsecond),
+			t.latency.Percentile(99).Round(time.Microsecond),
+			t.latency.Max().Round(time.Microsecond),
+		)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	if r.ServerMetricsErr != nil {
+		fmt.Fprintf(w, "server usage:      unavailable (%v)\n", r.ServerMetricsErr)
+		return
+	}
+	if r.Server.Samples == 0 {
+		return
+	}
+	fmt.Fprintf(w, "server cpu:        %.1f%% average\n", r.Server.CPU*100)
+	fmt.Fprintf(w, "server memory:     %.1f MiB max resident, %.1f MiB max heap in use\n",
+		r.Server.MaxResidentMemory/(1<<20),
+		r.Server.MaxHeapInUse/(1<<20),
+	)
+	fmt.Fprintf(w, "server goroutines: %.0f max\n", r.Server.MaxGoroutines)
+}
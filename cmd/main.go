@@ -9,6 +9,7 @@ import (
 	"net/http/pprof"
 	"net/url"
 	"os"
+	"os/signal"
 	"reflect"
 	"strings"
 	"sync"
@@ -26,15 +27,17 @@ import (
 	httpcmn "github.com/aukilabs/hagall-common/http"
 	"github.com/aukilabs/hagall-common/ncsclient"
 	hsmoketest "github.com/aukilabs/hagall-common/smoketest"
+	hconfig "github.com/aukilabs/hagall/config"
 	"github.com/aukilabs/hagall/featureflag"
 	hagallhttp "github.com/aukilabs/hagall/http"
+	"github.com/aukilabs/hagall/matchmaking"
 	"github.com/aukilabs/hagall/models"
 	"github.com/aukilabs/hagall/modules"
-	"github.com/aukilabs/hagall/modules/dagaz"
-	"github.com/aukilabs/hagall/modules/odal"
-	"github.com/aukilabs/hagall/modules/vikja"
+	"github.com/aukilabs/hagall/modules/builtin"
 	"github.com/aukilabs/hagall/receipt"
+	"github.com/aukilabs/hagall/recording"
 	"github.com/aukilabs/hagall/smoketest"
+	"github.com/aukilabs/hagall/webhook"
 	hwebsocket "github.com/aukilabs/hagall/websocket"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/prometheus/client_golang/prometheus"
@@ -63,7 +66,7 @@ type config struct {
 	Addr               string             `cli:""        env:"HAGALL_ADDR"                  help:"Listening address for client connections."`
 	AdminAddr          string             `cli:""        env:"HAGALL_ADMIN_ADDR"            help:"Admin listening address."`
 	PublicEndpoint     string             `cli:""        env:"HAGALL_PUBLIC_ENDPOINT"       help:"The public endpoint where this Hagall server is reachable."`
-	PrivateKey         string             `cli:""        env:"HAGALL_PRIVATE_KEY"           help:"The private key of a Hagall server-unique Ethereum-compatible wallet."`
+	PrivateKey         string             `cli:""        env:"HAGALL_PRIVATE_KEY"           help:"The private key of a Hagall server-unique Ethereum-compatible wallet." secret:"true"`
 	PrivateKeyFile     string             `cli:""        env:"HAGALL_PRIVATE_KEY_FILE"      help:"The file that contains the private key of a Hagall server-unique Ethereum-compatible wallet."`
 	LogLevel           string             `cli:""        env:"HAGALL_LOG_LEVEL"             help:"Log level (debug|info|warning|error)."`
 	LogIndent          bool               `cli:""        env:"HAGALL_LOG_INDENT"            help:"Indent logs."`
@@ -75,6 +78,18 @@ type config struct {
 	Events             eventsConfig       `cli:",hidden" env:"-"                            help:"Event pusher configuration."`
 	FeatureFlags       []string           `cli:",hidden" env:"HAGALL_FEATURE_FLAGS"         help:"Comma separated feature flags"`
 	NCSEndpoint        string             `cli:",hidden" env:"HAGALL_NCS_ENDPOINT"          help:"Network Credit Service Endpoint."`
+	ComponentSchemas   string             `cli:",hidden" env:"HAGALL_COMPONENT_SCHEMAS"     help:"The JSON file that contains the entity component schemas."`
+	ComponentHistory   int                `cli:",hidden" env:"HAGALL_COMPONENT_HISTORY"     help:"The number of changes retained for each entity component (0 disables history)."`
+	Presence           presenceConfig     `cli:",hidden" env:"-"                            help:"Participant presence configuration."`
+	Matchmaking        matchmakingConfig  `cli:",hidden" env:"-"                            help:"Matchmaking configuration."`
+	Webhooks           webhooksConfig     `cli:",hidden" env:"-"                            help:"Session lifecycle webhooks configuration."`
+	Recording          recordingConfig    `cli:",hidden" env:"-"                            help:"Session recording configuration."`
+	Drain              drainConfig        `cli:",hidden" env:"-"                            help:"Drain configuration."`
+	Migration          migrationConfig    `cli:",hidden" env:"-"                            help:"Session migration configuration."`
+	Reload             reloadConfig       `cli:",hidden" env:"-"                            help:"Configuration reload configuration."`
+	Modules            builtin.Config     `cli:",hidden" env:"-"                            help:"Modules configuration."`
+	Config             string             `cli:""        env:"HAGALL_CONFIG"                help:"The YAML file that contains the configuration. Environment variables and flags override its settings."`
+	CheckConfig        bool               `cli:""        env:"-"                            help:"Validate the configuration and print it with secrets redacted."`
 	Version            bool               `cli:""        env:"-"                            help:"Show version."`
 	Help               bool               `cli:""        env:"-"                            help:"Show help."`
 	ClockChecker       clockCheckerConfig `cli:""        env:"-"                            help:"Clock (time skew) checker configuration."`
@@ -94,6 +109,44 @@ type eventsConfig struct {
 	QueueSize     int           `cli:",hidden" env:"HAGALL_EVENTS_QUEUE_SIZE"     help:"The size of the queue where events are stored."`
 }
 
+type webhooksConfig struct {
+	URLs        []string      `cli:",hidden" env:"HAGALL_WEBHOOKS_URLS"         help:"Comma separated URLs where session lifecycle events are posted."`
+	Secret      string        `cli:",hidden" env:"HAGALL_WEBHOOKS_SECRET"       help:"The secret used to sign webhook requests." secret:"true"`
+	QueueDir    string        `cli:",hidden" env:"HAGALL_WEBHOOKS_QUEUE_DIR"    help:"The directory where pending webhook deliveries are stored."`
+	MaxAttempts int           `cli:",hidden" env:"HAGALL_WEBHOOKS_MAX_ATTEMPTS" help:"The number of delivery attempts before an event is dropped."`
+	MaxBackoff  time.Duration `cli:",hidden" env:"HAGALL_WEBHOOKS_MAX_BACKOFF"  help:"The maximum delay between delivery attempts."`
+}
+
+type presenceConfig struct {
+	IdleAfter         time.Duration `cli:",hidden" env:"HAGALL_PRESENCE_IDLE_AFTER"         help:"The time without activity before a participant is idle (0 disables idle presence)."`
+	BackgroundedAfter time.Duration `cli:",hidden" env:"HAGALL_PRESENCE_BACKGROUNDED_AFTER" help:"The time without any message before a participant is backgrounded (0 disables backgrounded presence)."`
+}
+
+type matchmakingConfig struct {
+	MaxWait      time.Duration `cli:",hidden" env:"HAGALL_MATCHMAKING_MAX_WAIT"       help:"The maximum time a matchmaking request waits for its group."`
+	JoinTimeout  time.Duration `cli:",hidden" env:"HAGALL_MATCHMAKING_JOIN_TIMEOUT"   help:"The time clients have to join the session of their group before it is removed."`
+	MaxGroupSize int           `cli:",hidden" env:"HAGALL_MATCHMAKING_MAX_GROUP_SIZE" help:"The maximum number of clients in a group."`
+	MaxSkillGap  float64       `cli:",hidden" env:"HAGALL_MATCHMAKING_MAX_SKILL_GAP"  help:"The maximum skill difference between the clients of a group (0 ignores skill)."`
+}
+
+type recordingConfig struct {
+	Dir     string   `cli:",hidden" env:"HAGALL_RECORDING_DIR"      help:"The directory where session recordings are written."`
+	AppKeys []string `cli:",hidden" env:"HAGALL_RECORDING_APP_KEYS" help:"Comma separated app keys whose sessions are recorded."`
+}
+
+type drainConfig struct {
+	Timeout        time.Duration `cli:",hidden" env:"HAGALL_DRAIN_TIMEOUT"         help:"The maximum time to wait for sessions to empty before shutting down (0 shuts down immediately)."`
+	ReconnectDelay time.Duration `cli:",hidden" env:"HAGALL_DRAIN_RECONNECT_DELAY" help:"The delay suggested to clients before reconnecting when the server is draining."`
+	Endpoint       string        `cli:",hidden" env:"HAGALL_DRAIN_ENDPOINT"        help:"The endpoint of another server suggested to clients when the server is draining."`
+}
+
+type migrationConfig struct {
+	Secret            string        `cli:",hidden" env:"HAGALL_MIGRATION_SECRET"              help:"The secret shared with the servers that migrate sessions to each other (empty disables imports)." secret:"true"`
+	PeerAdminEndpoint string        `cli:",hidden" env:"HAGALL_MIGRATION_PEER_ADMIN_ENDPOINT" help:"The admin endpoint of the server where sessions migrate when draining."`
+	PeerEndpoint      string        `cli:",hidden" env:"HAGALL_MIGRATION_PEER_ENDPOINT"       help:"The public endpoint of the server where sessions migrate when draining."`
+	ResumeTimeout     time.Duration `cli:",hidden" env:"HAGALL_MIGRATION_RESUME_TIMEOUT"      help:"The time participants of an imported session have to reconnect before their entities are removed."`
+}
+
 type clockCheckerConfig struct {
 	InitialDelay     time.Duration `cli:"" env:"HAGALL_CLOCK_CHECKER_INITIAL_DELAY" help:"Initial delay before starting the first check."`
 	SecondCheckDelay time.Duration `cli:"" env:"HAGALL_CLOCK_CHECKER_SECOND_CHECK_DELAY" help:"Delay before starting the second check."`
@@ -126,6 +179,33 @@ func main() {
 			QueueSize:     events.DefaultQueueSize,
 		},
 		NCSEndpoint: "http://localhost:4040",
+		Webhooks: webhooksConfig{
+			MaxAttempts: webhook.DefaultMaxAttempts,
+			MaxBackoff:  webhook.DefaultMaxBackoff,
+		},
+		Presence: presenceConfig{
+			IdleAfter:         time.Minute,
+			BackgroundedAfter: time.Second * 30,
+		},
+		Matchmaking: matchmakingConfig{
+			MaxWait:      time.Minute,
+			JoinTimeout:  matchmaking.DefaultJoinTimeout,
+			MaxGroupSize: matchmaking.DefaultMaxGroupSize,
+		},
+		Recording: recordingConfig{
+			Dir: "recordings",
+		},
+		Drain: drainConfig{
+			Timeout:        time.Second * 30,
+			ReconnectDelay: time.Second * 5,
+		},
+		Migration: migrationConfig{
+			ResumeTimeout: time.Minute,
+		},
+		Reload: reloadConfig{
+			Interval: time.Second * 10,
+		},
+		Modules: builtin.DefaultConfig(),
 		ClockChecker: clockCheckerConfig{
 			InitialDelay:     clockchecker.DefaultInitialDelay,
 			SecondCheckDelay: clockchecker.DefaultSecondCheckDelay,
@@ -139,12 +219,25 @@ func main() {
 	// set the information gauge to 1, useful for SUM query
 	infoGauge.Set(1)
 
-	ctx, cancel := cli.ContextWithSignals(context.Background(),
-		os.Interrupt,
-		syscall.SIGTERM,
-	)
+	// The first signal drains the server before shutting it down, the second
+	// one shuts it down immediately.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// The configuration file is loaded before environment variables and flags,
+	// which override its settings.
+	if filename, ok := hconfig.LookupOption(os.Args[1:], "config", "HAGALL_CONFIG"); ok {
+		if err := hconfig.LoadFile(filename, &conf); err != nil {
+			logs.Fatal(err)
+		}
+	}
+	if err := hconfig.ValidateEnv(&conf); err != nil {
+		logs.Fatal(err)
+	}
+
 	cli.Register().
 		Help("Starts Hagall server.").
 		Options(&conf)
@@ -155,6 +248,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if conf.CheckConfig {
+		checkConfig(conf)
+	}
+
 	if err := validateConfig(conf); err != nil {
 		logs.Fatal(err)
 	}
@@ -165,6 +262,11 @@ func main() {
 		logs.Fatal(errors.New("error loading private key").Wrap(err))
 	}
 
+	moduleRegistry, err := builtin.NewRegistry(conf.Modules)
+	if err != nil {
+		logs.Fatal(err)
+	}
+
 	logs.SetLevel(logs.ParseLevel(conf.LogLevel))
 	logs.Encoder = json.Marshal
 	if conf.LogIndent {
@@ -216,8 +318,73 @@ func main() {
 	})
 	clockChecker.Start(ctx)
 
+	sessions := models.SessionStore{
+		DiscoveryService: hdsClient,
+	}
+
+	pairCtx, stopPairing := context.WithCancel(ctx)
+	defer stopPairing()
+
+	migrator := hwebsocket.Migrator{
+		ResumeTimeout: conf.Migration.ResumeTimeout,
+		Secret:        conf.Migration.Secret,
+		HTTPClient:    &http.Client{Transport: transport, Timeout: time.Second * 30},
+	}
+	migrationPeer := hwebsocket.MigrationPeer{
+		AdminEndpoint: conf.Migration.PeerAdminEndpoint,
+		Endpoint:      conf.Migration.PeerEndpoint,
+	}
+
+	drainer := hwebsocket.Drainer{
+		Sessions: &sessions,
+		OnDrain: func() {
+			stopPairing()
+			if err := hdsClient.Unpair(); err != nil {
+				logs.Warn(errors.New("unpair with hds failed").Wrap(err))
+			}
+			logs.Info("draining hagall server")
+
+			// Sessions move to the peer server instead of waiting for their
+			// participants to leave.
+			if migrationPeer.AdminEndpoint != "" && migrationPeer.Endpoint != "" {
+				migrator.MigrateAll(ctx, migrationPeer)
+			}
+		},
+	}
+	drainNotice := hwebsocket.DrainNotice{
+		ReconnectDelay: conf.Drain.ReconnectDelay,
+		Endpoint:       conf.Drain.Endpoint,
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		drainCtx, cancelDrain := context.WithTimeout(ctx, conf.Drain.Timeout)
+		defer cancelDrain()
+		go func() {
+			select {
+			case <-drainCtx.Done():
+			case <-signals:
+				cancelDrain()
+			}
+		}()
+
+		drainer.Drain(drainNotice)
+		if err := drainer.Wait(drainCtx); err != nil {
+			logs.Warn(errors.New("draining hagall server timed out").
+				WithTag("participants", drainer.ParticipantCount()).
+				Wrap(err))
+		}
+		cancel()
+	}()
+
 	service.HandleFunc("/registrations", hdsClient.HandleServerRegistration)
-	service.Handle("/health", hagallhttp.HandleWithCORS(http.HandlerFunc(hdsClient.HandleHealthCheck)))
+	service.Handle("/health", hagallhttp.HandleWithCORS(hagallhttp.RejectWhenDraining(&drainer,
+		http.HandlerFunc(hdsClient.HandleHealthCheck))))
 	service.Handle("/version", hagallhttp.HandleWithCORS(http.HandlerFunc(hagallhttp.HandleVersion(version))))
 	service.Handle("/pms/metrics", crypt.HandleWithEncryption(
 		crypt.NewHagallSecretProvider(hdsClient),
@@ -233,14 +400,60 @@ func main() {
 	})))
 
 	readinessCheck := func() bool {
-		return hdsClient.GetRegistrationStatus() == hds.RegistrationStatusRegistered
+		return hdsClient.GetRegistrationStatus() == hds.RegistrationStatusRegistered &&
+			!drainer.Draining()
 	}
 	service.Handle("/ready", hagallhttp.HandleWithCORS(http.HandlerFunc(hagallhttp.HandleReadyCheck(readinessCheck))))
 
-	sessions := models.SessionStore{
-		DiscoveryService: hdsClient,
+	if len(conf.Webhooks.URLs) != 0 {
+		webhookDispatcher := webhook.Dispatcher{
+			URLs:        conf.Webhooks.URLs,
+			Secret:      conf.Webhooks.Secret,
+			QueueDir:    conf.Webhooks.QueueDir,
+			MaxAttempts: conf.Webhooks.MaxAttempts,
+			MaxBackoff:  conf.Webhooks.MaxBackoff,
+			Transport:   transport,
+		}
+		if err := webhookDispatcher.Start(ctx); err != nil {
+			logs.Fatal(err)
+		}
+		sessions.HandleEvents(webhookDispatcher.Handle)
+	}
+
+	recorder := recording.Recorder{
+		Dir:     conf.Recording.Dir,
+		AppKeys: conf.Recording.AppKeys,
+	}
+	defer recorder.Close()
+	sessions.HandleEvents(recorder.HandleEvent)
+
+	var componentSchemas *models.EntityComponentSchemaRegistry
+	if conf.ComponentSchemas != "" {
+		if componentSchemas, err = models.LoadEntityComponentSchemas(conf.ComponentSchemas); err != nil {
+			logs.Fatal(err)
+		}
 	}
 
+	// Feature flags are evaluated by session, with the configured flags set by
+	// default.
+	var featureFlags featureflag.Registry
+	featureFlags.SetDefaults(conf.FeatureFlags)
+	sessions.HandleEvents(func(e models.SessionEvent) {
+		if e.Type == models.SessionEventSessionClosed {
+			featureFlags.DeleteSessionRules(e.SessionUUID)
+		}
+	})
+
+	serverParticipant := hwebsocket.ServerParticipant{
+		Sessions:     &sessions,
+		FeatureFlags: &featureFlags,
+	}
+
+	// The settings read by connections are copied since the reloader changes
+	// them while the server runs.
+	runtimeSettings := newRuntimeSettings(conf)
+	reloader := newReloader(&conf, runtimeSettings, &featureFlags)
+
 	receiptChan := make(chan ncsclient.ReceiptPayload, 128)
 	receiptHandler := receipt.ReceiptHandler{
 		NCSEndpoint: conf.NCSEndpoint,
@@ -248,32 +461,64 @@ func main() {
 	}
 	receiptHandler.HandleReceipts(ctx)
 
-	service.Handle("/", hagallhttp.HandleWithCORS(websocket.Server{
+	service.Handle("/", hagallhttp.HandleWithCORS(hagallhttp.RejectWhenDraining(&drainer, websocket.Server{
 		Handshake: hagallhttp.VerifyAuthToken(ctx, hdsClient),
 		Handler: func(conn *websocket.Conn) {
 			defer conn.Close()
 
+			settings := runtimeSettings.load()
+
 			var rh hwebsocket.Handler = &hwebsocket.RealtimeHandler{
-				ClientSyncClockInterval: conf.SyncClockInterval,
-				ClientIdleTimeout:       conf.ClientIdleTimeout,
-				FrameDuration:           conf.FrameDuration,
-				Sessions:                &sessions,
-				Modules: []modules.Module{
-					&vikja.Module{},
-					&odal.Module{},
-					&dagaz.Module{},
-				},
-				FeatureFlags: featureflag.New(conf.FeatureFlags),
-				ReceiptChan:  receiptChan,
-				PrivateKey:   privateKey,
+				ClientSyncClockInterval:      settings.SyncClockInterval,
+				ClientIdleTimeout:            settings.ClientIdleTimeout,
+				FrameDuration:                conf.FrameDuration,
+				Sessions:                     &sessions,
+				Modules:                      moduleRegistry.New(),
+				FeatureFlags:                 &featureFlags,
+				EntityComponentSchemas:       componentSchemas,
+				EntityComponentHistorySize:   conf.ComponentHistory,
+				ParticipantIdleAfter:         conf.Presence.IdleAfter,
+				ParticipantBackgroundedAfter: conf.Presence.BackgroundedAfter,
+				ReceiptChan:                  receiptChan,
+				PrivateKey:                   privateKey,
+				Drainer:                      &drainer,
 			}
-			h := hwebsocket.HandlerWithLogs(rh, conf.LogSummaryInterval)
+			h := hwebsocket.HandlerWithLogs(rh, settings.LogSummaryInterval)
 			h = hwebsocket.HandlerWithMetrics(h, conf.PublicEndpoint)
+			h = hwebsocket.HandlerWithRecording(h, &recorder)
 			defer h.Close()
 
 			hwebsocket.Handle(ctx, conn, h)
 		},
-	}))
+	})))
+
+	// Creates the sessions that are not created by a joining participant.
+	sessionFactory := hwebsocket.RealtimeHandler{
+		FrameDuration:                conf.FrameDuration,
+		Sessions:                     &sessions,
+		FeatureFlags:                 &featureFlags,
+		EntityComponentSchemas:       componentSchemas,
+		EntityComponentHistorySize:   conf.ComponentHistory,
+		ParticipantIdleAfter:         conf.Presence.IdleAfter,
+		ParticipantBackgroundedAfter: conf.Presence.BackgroundedAfter,
+		Modules:                      moduleRegistry.New(),
+	}
+	migrator.Handler = &sessionFactory
+
+	matchmaker := matchmaking.Matchmaker{
+		Sessions:      &sessions,
+		CreateSession: sessionFactory.CreateSession,
+		MaxSkillGap:   conf.Matchmaking.MaxSkillGap,
+		MaxGroupSize:  conf.Matchmaking.MaxGroupSize,
+		JoinTimeout:   conf.Matchmaking.JoinTimeout,
+	}
+	service.Handle("/matchmaking", hagallhttp.HandleWithCORS(hagallhttp.RejectWhenDraining(&drainer, http.HandlerFunc(
+		hagallhttp.VerifyAuthTokenHandler(hdsClient, hagallhttp.HandleMatchmaking(&matchmaker, conf.Matchmaking.MaxWait)),
+	))))
+
+	service.Handle("/sessions", hagallhttp.HandleWithCORS(http.HandlerFunc(
+		hagallhttp.VerifyAuthTokenHandler(hdsClient, hagallhttp.HandleSessionDiscovery(&sessions)),
+	)))
 
 	service.Handle("/ping", websocket.Server{
 		Handler: func(ws *websocket.Conn) {
@@ -284,13 +529,13 @@ func main() {
 
 	var wg sync.WaitGroup
 	wg.Add(1)
-	go func() {
+	go func(conf config) {
 		defer wg.Done()
-		err := pairWithHDS(ctx, hdsClient, conf)
+		err := pairWithHDS(pairCtx, hdsClient, conf, moduleRegistry, &featureFlags)
 		if err != nil && err != context.Canceled {
 			logs.Fatal(errors.New("registering with HDS failed").Wrap(err))
 		}
-	}()
+	}(conf)
 
 	var admin http.ServeMux
 	admin.Handle("/metrics", promhttp.Handler())
@@ -305,6 +550,18 @@ func main() {
 	admin.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 	admin.Handle("/debug/pprof/block", pprof.Handler("block"))
 	admin.HandleFunc("/ready", hagallhttp.HandleReadyCheck(readinessCheck))
+	admin.HandleFunc("/entity-component-history", hagallhttp.HandleEntityComponentHistory(&sessions))
+	admin.HandleFunc("/participants", hagallhttp.HandleParticipants(&serverParticipant))
+	admin.HandleFunc("/server/entities", hagallhttp.HandleServerEntities(&serverParticipant))
+	admin.HandleFunc("/server/entity-components", hagallhttp.HandleServerEntityComponents(&serverParticipant))
+	admin.HandleFunc("/server/messages", hagallhttp.HandleServerMessages(&serverParticipant))
+	admin.HandleFunc("/recordings", hagallhttp.HandleRecordings(&sessions, &recorder))
+	admin.HandleFunc("/drain", hagallhttp.HandleDrain(&drainer, drainNotice))
+	admin.HandleFunc("/sessions/migrate", hagallhttp.HandleSessionMigration(&migrator, migrationPeer))
+	admin.Handle(hwebsocket.MigrationImportPath, hagallhttp.RejectWhenDraining(&drainer,
+		hagallhttp.HandleSessionImport(&migrator)))
+	admin.HandleFunc("/config", hagallhttp.HandleConfig(reloader))
+	admin.HandleFunc("/feature-flags", hagallhttp.HandleFeatureFlags(&sessions, &featureFlags))
 
 	walletAddress := strings.ToLower(crypto.PubkeyToAddress(privateKey.PublicKey).Hex())
 	logs.WithTag("version", version).
@@ -313,6 +570,10 @@ func main() {
 		WithTag("wallet_address", walletAddress).
 		Info("starting hagall server")
 
+	if conf.Reload.File != "" {
+		go reloader.Watch(ctx, conf.Reload.File, conf.Reload.Interval)
+	}
+
 	hagallhttp.ListenAndServe(ctx,
 		&http.Server{Addr: conf.Addr, Handler: metrics.HTTPHandler(&service,
 			hagallhttp.MetricsPathFormatter)},
@@ -320,25 +581,33 @@ func main() {
 	)
 
 	wg.Wait()
-	// unpair on exit
+	// unpair on exit, unless draining already did
+	if drainer.Draining() {
+		return
+	}
 	if err = hdsClient.Unpair(); err != nil {
 		logs.Warn(errors.New("unpair with hds failed").Wrap(err))
 	}
 }
 
-func pairWithHDS(ctx context.Context, c *hds.Client, conf config) error {
+// pairWithHDS registers the server with HDS until the context is done. HDS is
+// given the default feature flags set when pairing starts: flags reloaded
+// afterwards are advertised after a restart.
+func pairWithHDS(ctx context.Context, c *hds.Client, conf config, moduleRegistry *modules.Registry, featureFlags *featureflag.Registry) error {
+	defaults := featureFlags.Defaults()
+	flags := make([]string, len(defaults))
+	for i, flag := range defaults {
+		flags[i] = string(flag)
+	}
+
 	return c.Pair(ctx, hds.PairIn{
 		Endpoint:             conf.PublicEndpoint,
 		RegistrationInterval: conf.HDS.RegistrationInterval,
 		HealthCheckTTL:       conf.HDS.HealthCheckTTL,
 		RegistrationRetries:  conf.HDS.RegistrationRetries,
 		Version:              version,
-		Modules: []string{
-			(&vikja.Module{}).Name(),
-			(&odal.Module{}).Name(),
-			(&dagaz.Module{}).Name(),
-		},
-		FeatureFlags: conf.FeatureFlags,
+		Modules:              moduleRegistry.Names(),
+		FeatureFlags:         flags,
 	})
 }
 
@@ -364,6 +633,21 @@ func loadPrivateKey(conf config) (*ecdsa.PrivateKey, error) {
 	return crypto.HexToECDSA(privateKey)
 }
 
+// checkConfig prints the given configuration with secrets redacted, and exits
+// with an error when it is invalid.
+func checkConfig(conf config) {
+	if err := hconfig.Print(os.Stdout, &conf); err != nil {
+		logs.Fatal(err)
+	}
+
+	if err := validateConfig(conf); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "configuration is valid")
+	os.Exit(0)
+}
+
 func validateConfig(conf config) error {
 	if _, err := url.ParseRequestURI(conf.PublicEndpoint); err != nil {
 		return errors.New("invalid public endpoint").Wrap(err)
@@ -379,5 +663,103 @@ func validateConfig(conf config) error {
 		return errors.New("have to specify either private key or private key file")
 	}
 
+	switch conf.LogLevel {
+	case logs.DebugLevel.String(), logs.InfoLevel.String(), logs.WarningLevel.String(), logs.ErrorLevel.String():
+	default:
+		return errors.New("invalid log level").WithTag("log_level", conf.LogLevel)
+	}
+
+	type endpoint struct {
+		setting  string
+		endpoint string
+		optional bool
+	}
+	endpoints := []endpoint{
+		{setting: "hds.endpoint", endpoint: conf.HDS.Endpoint},
+		{setting: "ncsendpoint", endpoint: conf.NCSEndpoint},
+		{setting: "events.endpoint", endpoint: conf.Events.Endpoint, optional: true},
+		{setting: "drain.endpoint", endpoint: conf.Drain.Endpoint, optional: true},
+		{setting: "migration.peer-admin-endpoint", endpoint: conf.Migration.PeerAdminEndpoint, optional: true},
+		{setting: "migration.peer-endpoint", endpoint: conf.Migration.PeerEndpoint, optional: true},
+	}
+	for _, u := range conf.Webhooks.URLs {
+		endpoints = append(endpoints, endpoint{setting: "webhooks.urls", endpoint: u})
+	}
+	for _, e := range endpoints {
+		if e.optional && e.endpoint == "" {
+			continue
+		}
+		if _, err := url.ParseRequestURI(e.endpoint); err != nil {
+			return errors.New("invalid endpoint").
+				WithTag("setting", e.setting).
+				Wrap(err)
+		}
+	}
+
+	// Durations used as intervals and timeouts have to be positive, other ones
+	// disable their feature when they are 0.
+	durations := []struct {
+		setting  string
+		value    time.Duration
+		positive bool
+	}{
+		{setting: "sync-clock-interval", value: conf.SyncClockInterval, positive: true},
+		{setting: "client-idle-timeout", value: conf.ClientIdleTimeout, positive: true},
+		{setting: "frame-duration", value: conf.FrameDuration, positive: true},
+		{setting: "log-summary-interval", value: conf.LogSummaryInterval, positive: true},
+		{setting: "hds.registration-interval", value: conf.HDS.RegistrationInterval, positive: true},
+		{setting: "hds.health-check-ttl", value: conf.HDS.HealthCheckTTL, positive: true},
+		{setting: "events.flush-interval", value: conf.Events.FlushInterval, positive: true},
+		{setting: "webhooks.max-backoff", value: conf.Webhooks.MaxBackoff},
+		{setting: "presence.idle-after", value: conf.Presence.IdleAfter},
+		{setting: "presence.backgrounded-after", value: conf.Presence.BackgroundedAfter},
+		{setting: "matchmaking.max-wait", value: conf.Matchmaking.MaxWait, positive: true},
+		{setting: "matchmaking.join-timeout", value: conf.Matchmaking.JoinTimeout},
+		{setting: "drain.timeout", value: conf.Drain.Timeout},
+		{setting: "drain.reconnect-delay", value: conf.Drain.ReconnectDelay},
+		{setting: "migration.resume-timeout", value: conf.Migration.ResumeTimeout, positive: true},
+		{setting: "reload.interval", value: conf.Reload.Interval, positive: conf.Reload.File != ""},
+		{setting: "clock-checker.initial-delay", value: conf.ClockChecker.InitialDelay},
+		{setting: "clock-checker.second-check-delay", value: conf.ClockChecker.SecondCheckDelay},
+		{setting: "clock-checker.check-interval", value: conf.ClockChecker.CheckInterval, positive: true},
+		{setting: "clock-checker.warning-threshold", value: conf.ClockChecker.WarningThreshold, positive: true},
+		{setting: "clock-checker.error-threshold", value: conf.ClockChecker.ErrorThreshold, positive: true},
+	}
+	for _, d := range durations {
+		if d.value < 0 || (d.positive && d.value == 0) {
+			return errors.New("invalid duration").
+				WithTag("setting", d.setting).
+				WithTag("value", d.value)
+		}
+	}
+
+	numbers := []struct {
+		setting string
+		value   float64
+	}{
+		{setting: "hds.registration-retries", value: float64(conf.HDS.RegistrationRetries)},
+		{setting: "events.batch-size", value: float64(conf.Events.BatchSize)},
+		{setting: "events.queue-size", value: float64(conf.Events.QueueSize)},
+		{setting: "component-history", value: float64(conf.ComponentHistory)},
+		{setting: "webhooks.max-attempts", value: float64(conf.Webhooks.MaxAttempts)},
+		{setting: "matchmaking.max-group-size", value: float64(conf.Matchmaking.MaxGroupSize)},
+		{setting: "matchmaking.max-skill-gap", value: conf.Matchmaking.MaxSkillGap},
+	}
+	for _, n := range numbers {
+		if n.value < 0 {
+			return errors.New("negative number").
+				WithTag("setting", n.setting).
+				WithTag("value", n.value)
+		}
+	}
+
+	if conf.ClockChecker.WarningThreshold > conf.ClockChecker.ErrorThreshold {
+		return errors.New("clock checker warning threshold is greater than the error threshold")
+	}
+
+	if conf.ClockChecker.NTPServerAddress == "" {
+		return errors.New("empty clock checker ntp server address")
+	}
+
 	return nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	hconfig "github.com/aukilabs/hagall/config"
+	"github.com/aukilabs/hagall/featureflag"
+)
+
+type reloadConfig struct {
+	File     string        `cli:",hidden" env:"HAGALL_RELOAD_FILE"     help:"The environment file whose settings are applied while the server runs."`
+	Interval time.Duration `cli:",hidden" env:"HAGALL_RELOAD_INTERVAL" help:"The duration between each check of the reload file."`
+}
+
+// connectionSettings are the settings that change while the server runs. They
+// are read when a connection starts.
+type connectionSettings struct {
+	SyncClockInterval  time.Duration
+	ClientIdleTimeout  time.Duration
+	LogSummaryInterval time.Duration
+}
+
+type runtimeSettings struct {
+	mutex    sync.RWMutex
+	settings connectionSettings
+}
+
+func newRuntimeSettings(conf config) *runtimeSettings {
+	return &runtimeSettings{
+		settings: connectionSettings{
+			SyncClockInterval:  conf.SyncClockInterval,
+			ClientIdleTimeout:  conf.ClientIdleTimeout,
+			LogSummaryInterval: conf.LogSummaryInterval,
+		},
+	}
+}
+
+func (s *runtimeSettings) load() connectionSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.settings
+}
+
+func (s *runtimeSettings) update(f func(*connectionSettings)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(&s.settings)
+}
+
+// newReloader returns a reloader that applies the log level, the default
+// feature flags and the connection settings at runtime. Other settings require
+// a restart.
+func newReloader(conf *config, settings *runtimeSettings, featureFlags *featureflag.Registry) *hconfig.Reloader {
+	return &hconfig.Reloader{
+		Config: conf,
+		Reloadable: map[string]func(any){
+			"log-level": func(v any) {
+				logs.SetLevel(logs.ParseLevel(v.(string)))
+			},
+			"feature-flags": func(v any) {
+				featureFlags.SetDefaults(v.([]string))
+			},
+			"sync-clock-interval": func(v any) {
+				settings.update(func(s *connectionSettings) {
+					s.SyncClockInterval = v.(time.Duration)
+				})
+			},
+			"client-idle-timeout": func(v any) {
+				settings.update(func(s *connectionSettings) {
+					s.ClientIdleTimeout = v.(time.Duration)
+				})
+			},
+			"log-summary-interval": func(v any) {
+				settings.update(func(s *connectionSettings) {
+					s.LogSummaryInterval = v.(time.Duration)
+				})
+			},
+		},
+		Validate: func(v any) error {
+			return validateConfig(*v.(*config))
+		},
+	}
+}
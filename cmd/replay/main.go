@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/cli"
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/modules/builtin"
+	"github.com/aukilabs/hagall/recording"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/segmentio/encoding/json"
+)
+
+// This will effectively disable obfuscation of the config struct. Without it, the keys would get obfuscated causing the cli package to generate garbled command-line options.
+// https://github.com/burrowers/garble/issues/403
+var _ = reflect.TypeOf(config{})
+
+type config struct {
+	File             string         `cli:"" env:"HAGALL_REPLAY_FILE"              help:"The session recording to replay."`
+	Speed            float64        `cli:"" env:"HAGALL_REPLAY_SPEED"             help:"The replay speed relative to the recorded timing (0 replays without waiting)."`
+	FrameDuration    time.Duration  `cli:"" env:"HAGALL_REPLAY_FRAME_DURATION"    help:"The duration of a session frame."`
+	FeatureFlags     []string       `cli:"" env:"HAGALL_REPLAY_FEATURE_FLAGS"     help:"Comma separated feature flags of the recording server."`
+	ComponentSchemas string         `cli:"" env:"HAGALL_REPLAY_COMPONENT_SCHEMAS" help:"The JSON file that contains the entity component schemas of the recording server."`
+	ComponentHistory int            `cli:"" env:"HAGALL_REPLAY_COMPONENT_HISTORY" help:"The number of changes retained for each entity component (0 disables history)."`
+	LogLevel         string         `cli:"" env:"HAGALL_REPLAY_LOG_LEVEL"         help:"Log level (debug|info|warning|error)."`
+	Modules          builtin.Config `cli:",hidden" env:"-"                        help:"Modules configuration of the recording server."`
+	Help             bool           `cli:"" env:"-"                               help:"Show help."`
+}
+
+func main() {
+	conf := config{
+		FrameDuration: time.Millisecond * 15,
+		LogLevel:      logs.WarningLevel.String(),
+		Modules:       builtin.DefaultConfig(),
+	}
+
+	ctx, cancel := cli.ContextWithSignals(context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer cancel()
+
+	cli.Register().
+		Help("Replays a session recording and prints the server-side state after each record.").
+		Options(&conf)
+	cli.Load()
+
+	logs.SetLevel(logs.ParseLevel(conf.LogLevel))
+	logs.Encoder = json.Marshal
+	errors.Encoder = json.Marshal
+
+	if conf.File == "" {
+		logs.Fatal(errors.New("missing recording file"))
+	}
+
+	if err := run(ctx, conf, os.Stdout); err != nil {
+		logs.Fatal(err)
+	}
+}
+
+// step is the printed result of a replayed record.
+type step struct {
+	Offset        time.Duration `json:"offset"`
+	Kind          string        `json:"kind"`
+	ConnectionID  uint32        `json:"connection_id"`
+	MsgType       string        `json:"msg_type,omitempty"`
+	SessionID     uint32        `json:"session_id,omitempty"`
+	ParticipantID uint32        `json:"participant_id,omitempty"`
+	Sent          []sentMsg     `json:"sent,omitempty"`
+	Error         string        `json:"error,omitempty"`
+}
+
+type sentMsg struct {
+	ConnectionID uint32 `json:"connection_id"`
+	MsgType      string `json:"msg_type"`
+}
+
+// state is the printed server-side state of the replayed session.
+type state struct {
+	Participants     []*hagallpb.Participant     `json:"participants"`
+	Entities         []*hagallpb.Entity          `json:"entities"`
+	EntityComponents []*hagallpb.EntityComponent `json:"entity_components"`
+}
+
+func run(ctx context.Context, conf config, w io.Writer) error {
+	f, err := os.Open(conf.File)
+	if err != nil {
+		return errors.New("opening recording failed").Wrap(err)
+	}
+	defer f.Close()
+
+	reader, err := recording.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	var componentSchemas *models.EntityComponentSchemaRegistry
+	if conf.ComponentSchemas != "" {
+		if componentSchemas, err = models.LoadEntityComponentSchemas(conf.ComponentSchemas); err != nil {
+			return err
+		}
+	}
+
+	moduleRegistry, err := builtin.NewRegistry(conf.Modules)
+	if err != nil {
+		return err
+	}
+
+	sessions := &models.SessionStore{}
+	encoder := json.NewEncoder(w)
+
+	var lastSession *models.Session
+	replayer := hwebsocket.Replayer{
+		NewHandler: func() *hwebsocket.RealtimeHandler {
+			return &hwebsocket.RealtimeHandler{
+				FrameDuration:              conf.FrameDuration,
+				Sessions:                   sessions,
+				Modules:                    moduleRegistry.New(),
+				FeatureFlags:               featureflag.New(conf.FeatureFlags),
+				EntityComponentSchemas:     componentSchemas,
+				EntityComponentHistorySize: conf.ComponentHistory,
+			}
+		},
+		Speed: conf.Speed,
+		OnStep: func(s hwebsocket.ReplayStep) {
+			out := step{
+				Offset:       s.Record.Offset,
+				Kind:         s.Record.Kind.String(),
+				ConnectionID: s.Record.ConnectionID,
+			}
+			if s.Msg.Type != nil {
+				out.MsgType = s.Msg.TypeString()
+			}
+			if s.Session != nil {
+				out.SessionID = s.Session.ID
+				lastSession = s.Session
+			}
+			if s.Participant != nil {
+				out.ParticipantID = s.Participant.ID
+			}
+			for _, msg := range s.Sent {
+				out.Sent = append(out.Sent, sentMsg{
+					ConnectionID: msg.ConnectionID,
+					MsgType:      msg.Msg.TypeString(),
+				})
+			}
+			if s.Err != nil {
+				out.Error = s.Err.Error()
+			}
+			encoder.Encode(out)
+		},
+	}
+	defer replayer.Close()
+
+	if err := replayer.Replay(ctx, reader); err != nil {
+		return errors.New("replaying recording failed").
+			WithTag("file", conf.File).
+			Wrap(err)
+	}
+
+	if lastSession == nil {
+		return nil
+	}
+	return encoder.Encode(struct {
+		State state `json:"state"`
+	}{
+		State: state{
+			Participants:     models.ParticipantsToProtobuf(lastSession.GetParticipants()),
+			Entities:         models.EntitiesToProtobuf(lastSession.Entities()),
+			EntityComponents: lastSession.GetEntityComponents().ListAll(),
+		},
+	})
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	ErrTypeUnknownSetting = "unknown-setting"
+	ErrTypeInvalidSetting = "invalid-setting"
+)
+
+// field is a configuration setting, named like its command-line option and
+// environment variable. Settings tagged with secret:"true" are secrets.
+type field struct {
+	name   string
+	envKey string
+	secret bool
+	value  reflect.Value
+}
+
+// fields returns the settings of the given configuration struct, following the
+// cli and env struct tags. Nested structs are not settings: their fields are,
+// prefixed with the struct name.
+func fields(prefix string, v reflect.Value) []field {
+	var res []field
+
+	for i := 0; i < v.NumField(); i++ {
+		fval := v.Field(i)
+		if !fval.CanSet() {
+			continue
+		}
+
+		finfo := v.Type().Field(i)
+		name, _, _ := strings.Cut(finfo.Tag.Get("cli"), ",")
+		if name == "" {
+			name = finfo.Name
+		}
+		name = normalizeName(name, "-")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if fval.Kind() == reflect.Struct && fval.Type() != reflect.TypeOf(time.Time{}) {
+			res = append(res, fields(name, fval)...)
+			continue
+		}
+
+		envKey := finfo.Tag.Get("env")
+		if envKey == "" {
+			envKey = strings.ToUpper(normalizeName(name, "_"))
+		}
+
+		res = append(res, field{
+			name:   name,
+			envKey: envKey,
+			secret: finfo.Tag.Get("secret") == "true",
+			value:  fval,
+		})
+	}
+
+	return res
+}
+
+// findField returns the setting named by the given option name or environment
+// variable.
+func findField(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.name == key || (f.envKey == key && f.envKey != "-") {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// set parses the given value like an environment variable and sets it to the
+// setting. Lists also accept comma separated values.
+func (f field) set(s string) error {
+	v := reflect.New(f.value.Type()).Elem()
+
+	var err error
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err != nil {
+			var i int64
+			if i, err = strconv.ParseInt(s, 10, 64); err == nil {
+				d = time.Duration(i)
+			}
+		}
+		v.SetInt(int64(d))
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "["):
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(v.Type().Elem()))
+			}
+		}
+		v.Set(items)
+
+	default:
+		err = json.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+
+	if err != nil {
+		return errors.New("invalid setting value").
+			WithType(ErrTypeInvalidSetting).
+			WithTag("setting", f.name).
+			Wrap(err)
+	}
+
+	f.value.Set(v)
+	return nil
+}
+
+// setEnv is like set, but only accepts lists as JSON arrays like the cli
+// package does for environment variables and flags.
+func (f field) setEnv(s string) error {
+	if f.value.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+		return errors.New("invalid setting value: lists are JSON arrays, such as [\"a\",\"b\"]").
+			WithType(ErrTypeInvalidSetting).
+			WithTag("setting", f.name)
+	}
+	return f.set(s)
+}
+
+// normalizeName converts a Go field name to a lower case name whose words are
+// separated by sep, like the cli package does for options.
+func normalizeName(name string, sep string) string {
+	var b strings.Builder
+
+	write := func(s string) {
+		if s != "" {
+			if b.Len() != 0 {
+				b.WriteString(sep)
+			}
+			b.WriteString(strings.ToLower(s))
+		}
+	}
+
+	start := 0
+	for end := 0; end < len(name); end++ {
+		switch c := name[end]; {
+		case isUpperCase(c) && end > 0 && !isUpperCase(name[end-1]):
+			write(name[start:end])
+			start = end
+
+		case c == '-', c == '_', c == ' ', c == '\t', c == '.':
+			write(name[start:end])
+			start = end + 1
+		}
+	}
+
+	write(name[start:])
+	return b.String()
+}
+
+func isUpperCase(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the value of secrets in printed configurations.
+const Redacted = "REDACTED"
+
+// LoadFile sets the settings of the given YAML file to the given pointer to a
+// configuration struct. Keys are option names, and the settings of nested
+// structs are nested mappings:
+//
+//	log-level: debug
+//	hds:
+//	  registration-interval: 15s
+//
+// Values are formatted like environment variables, and lists can also be YAML
+// sequences. Nothing is set when a setting is unknown or a value is invalid.
+func LoadFile(filename string, v any) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return errors.New("reading configuration file failed").
+			WithTag("file", filename).
+			Wrap(err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return errors.New("parsing configuration file failed").
+			WithTag("file", filename).
+			Wrap(err)
+	}
+
+	current := reflect.ValueOf(v).Elem()
+	next := reflect.New(current.Type())
+	next.Elem().Set(current)
+
+	if len(doc.Content) != 0 {
+		if err := setMapping(fields("", next.Elem()), "", doc.Content[0]); err != nil {
+			return errors.New("invalid configuration file").
+				WithTag("file", filename).
+				Wrap(err)
+		}
+	}
+
+	current.Set(next.Elem())
+	return nil
+}
+
+func setMapping(fields []field, prefix string, n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return errors.New("settings are not a mapping").
+			WithType(ErrTypeInvalidSetting).
+			WithTag("setting", prefix).
+			WithTag("line", n.Line)
+	}
+
+	seen := make(map[string]struct{}, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+
+		name := key.Value
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if _, ok := seen[name]; ok {
+			return errors.New("duplicate setting").
+				WithType(ErrTypeInvalidSetting).
+				WithTag("setting", name).
+				WithTag("line", key.Line)
+		}
+		seen[name] = struct{}{}
+
+		if f, ok := fieldByName(fields, name); ok {
+			if f.envKey == "-" {
+				return errors.New("setting is a command-line option only").
+					WithType(ErrTypeUnknownSetting).
+					WithTag("setting", name).
+					WithTag("line", key.Line)
+			}
+			if err := f.setNode(value); err != nil {
+				return errors.New("invalid setting").
+					WithTag("line", value.Line).
+					Wrap(err)
+			}
+			continue
+		}
+
+		if isGroup(fields, name) {
+			if err := setMapping(fields, name, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		return errors.New("unknown setting").
+			WithType(ErrTypeUnknownSetting).
+			WithTag("setting", name).
+			WithTag("line", key.Line)
+	}
+
+	return nil
+}
+
+func (f field) setNode(n *yaml.Node) error {
+	switch {
+	case n.Kind == yaml.ScalarNode:
+		return f.set(n.Value)
+
+	case n.Kind == yaml.SequenceNode && f.value.Kind() == reflect.Slice:
+		v := reflect.New(f.value.Type())
+		if err := n.Decode(v.Interface()); err != nil {
+			return errors.New("invalid setting value").
+				WithType(ErrTypeInvalidSetting).
+				WithTag("setting", f.name).
+				Wrap(err)
+		}
+		f.value.Set(v.Elem())
+		return nil
+
+	default:
+		return errors.New("invalid setting value").
+			WithType(ErrTypeInvalidSetting).
+			WithTag("setting", f.name)
+	}
+}
+
+func fieldByName(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func isGroup(fields []field, name string) bool {
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the given pointer to a configuration struct as YAML, in the
+// format read by LoadFile. The values of secrets are redacted, and
+// command-line only options are omitted.
+func Print(w io.Writer, v any) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, f := range fields("", reflect.ValueOf(v).Elem()) {
+		if f.envKey == "-" {
+			continue
+		}
+
+		parent := root
+		path := strings.Split(f.name, ".")
+		for _, name := range path[:len(path)-1] {
+			parent = childMapping(parent, name)
+		}
+
+		value, err := f.node()
+		if err != nil {
+			return err
+		}
+		parent.Content = append(parent.Content, &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Value: path[len(path)-1],
+		}, value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return errors.New("encoding configuration failed").Wrap(err)
+	}
+	return enc.Close()
+}
+
+func childMapping(parent *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == name {
+			return parent.Content[i+1]
+		}
+	}
+
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Value: name,
+	}, child)
+	return child
+}
+
+func (f field) node() (*yaml.Node, error) {
+	if f.secret && !f.value.IsZero() {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: Redacted}, nil
+	}
+
+	if d, ok := f.value.Interface().(time.Duration); ok {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: d.String()}, nil
+	}
+
+	var n yaml.Node
+	if err := n.Encode(f.value.Interface()); err != nil {
+		return nil, errors.New("encoding setting failed").
+			WithTag("setting", f.name).
+			Wrap(err)
+	}
+	return &n, nil
+}
+
+// ValidateEnv reports environment variables of the given pointer to a
+// configuration struct whose value can't be parsed. The cli package ignores
+// them when it loads the configuration.
+func ValidateEnv(v any) error {
+	scratch := reflect.New(reflect.ValueOf(v).Elem().Type())
+
+	for _, f := range fields("", scratch.Elem()) {
+		if f.envKey == "-" {
+			continue
+		}
+
+		s, ok := os.LookupEnv(f.envKey)
+		if !ok {
+			continue
+		}
+
+		if err := f.setEnv(s); err != nil {
+			return errors.New("invalid environment variable").
+				WithTag("env", f.envKey).
+				Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// LookupOption returns the value of the given command-line option in the given
+// arguments, or the value of the given environment variable when the option is
+// not set. It is used to read options before the cli package loads them.
+func LookupOption(args []string, name, envKey string) (string, bool) {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+
+		if arg == name && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if value, ok := strings.CutPrefix(arg, name+"="); ok {
+			return value, true
+		}
+	}
+
+	return os.LookupEnv(envKey)
+}
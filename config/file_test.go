@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFile(t *testing.T) {
+	writeFile := func(t *testing.T, content string) string {
+		filename := filepath.Join(t.TempDir(), "hagall.yaml")
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+		return filename
+	}
+
+	t.Run("settings are loaded", func(t *testing.T) {
+		conf := testConfig{Addr: ":4000", LogLevel: "info"}
+		filename := writeFile(t, `
+log-level: debug
+idle-timeout: 2m
+feature-flags:
+  - a
+  - b
+hds:
+  endpoint: https://hds.example.com
+  retries: 3
+`)
+
+		require.NoError(t, LoadFile(filename, &conf))
+		require.Equal(t, testConfig{
+			Addr:         ":4000",
+			LogLevel:     "debug",
+			IdleTimeout:  time.Minute * 2,
+			FeatureFlags: []string{"a", "b"},
+			HDS: testHDSConfig{
+				Endpoint: "https://hds.example.com",
+				Retries:  3,
+			},
+		}, conf)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		conf := testConfig{Addr: ":4000"}
+		require.NoError(t, LoadFile(writeFile(t, ""), &conf))
+		require.Equal(t, testConfig{Addr: ":4000"}, conf)
+	})
+
+	tests := []struct {
+		scenario string
+		content  string
+		errType  string
+		setting  string
+		line     string
+	}{
+		{
+			scenario: "unknown setting",
+			content:  "log-level: debug\nlog-levle: info\n",
+			errType:  ErrTypeUnknownSetting,
+			setting:  "log-levle",
+			line:     "2",
+		},
+		{
+			scenario: "unknown nested setting",
+			content:  "hds:\n  endpoint: https://hds.example.com\n  retry: 3\n",
+			errType:  ErrTypeUnknownSetting,
+			setting:  "hds.retry",
+			line:     "3",
+		},
+		{
+			scenario: "command-line only setting",
+			content:  "version: true\n",
+			errType:  ErrTypeUnknownSetting,
+			setting:  "version",
+			line:     "1",
+		},
+		{
+			scenario: "invalid value",
+			content:  "idle-timeout: soon\n",
+			errType:  ErrTypeInvalidSetting,
+			setting:  "idle-timeout",
+			line:     "1",
+		},
+		{
+			scenario: "mapping instead of a value",
+			content:  "addr:\n  port: 4000\n",
+			errType:  ErrTypeInvalidSetting,
+			setting:  "addr",
+			line:     "2",
+		},
+		{
+			scenario: "value instead of a mapping",
+			content:  "hds: https://hds.example.com\n",
+			errType:  ErrTypeInvalidSetting,
+			setting:  "hds",
+			line:     "1",
+		},
+		{
+			scenario: "duplicate setting",
+			content:  "addr: :4000\naddr: :5000\n",
+			errType:  ErrTypeInvalidSetting,
+			setting:  "addr",
+			line:     "2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			conf := testConfig{Addr: ":4000"}
+
+			err := LoadFile(writeFile(t, test.content), &conf)
+			require.True(t, errors.IsType(err, test.errType), "%v", err)
+			require.Equal(t, test.setting, errors.Tag(err, "setting"))
+			require.Equal(t, test.line, errors.Tag(err, "line"))
+			require.Equal(t, testConfig{Addr: ":4000"}, conf)
+		})
+	}
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		var conf testConfig
+		require.Error(t, LoadFile(writeFile(t, "addr: [\n"), &conf))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		var conf testConfig
+		require.Error(t, LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), &conf))
+	})
+}
+
+func TestPrint(t *testing.T) {
+	conf := testConfig{
+		Addr:         ":4000",
+		LogLevel:     "info",
+		IdleTimeout:  time.Minute,
+		FeatureFlags: []string{"a"},
+		HDS:          testHDSConfig{Retries: 3},
+		Secret:       "hunter2",
+		Version:      true,
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, Print(&b, &conf))
+	require.Equal(t, `addr: :4000
+log-level: info
+idle-timeout: 1m0s
+feature-flags:
+  - a
+hds:
+  endpoint: ""
+  retries: 3
+secret: REDACTED
+`, b.String())
+
+	t.Run("printed configuration is loaded", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "hagall.yaml")
+		require.NoError(t, os.WriteFile(filename, b.Bytes(), 0600))
+
+		var loaded testConfig
+		require.NoError(t, LoadFile(filename, &loaded))
+
+		want := conf
+		want.Secret = Redacted
+		want.Version = false
+		require.Equal(t, want, loaded)
+	})
+}
+
+func TestValidateEnv(t *testing.T) {
+	t.Run("valid environment", func(t *testing.T) {
+		t.Setenv("TEST_IDLE_TIMEOUT", "30s")
+		t.Setenv("TEST_FEATURE_FLAGS", `["a","b"]`)
+		require.NoError(t, ValidateEnv(&testConfig{}))
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		t.Setenv("TEST_IDLE_TIMEOUT", "soon")
+
+		err := ValidateEnv(&testConfig{})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSetting))
+		require.Equal(t, "TEST_IDLE_TIMEOUT", errors.Tag(err, "env"))
+	})
+
+	t.Run("comma separated list", func(t *testing.T) {
+		t.Setenv("TEST_FEATURE_FLAGS", "a,b")
+
+		err := ValidateEnv(&testConfig{})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSetting))
+		require.Equal(t, "TEST_FEATURE_FLAGS", errors.Tag(err, "env"))
+	})
+}
+
+func TestLookupOption(t *testing.T) {
+	t.Setenv("TEST_CONFIG", "env.yaml")
+
+	tests := []struct {
+		args  []string
+		value string
+	}{
+		{args: []string{"--config", "a.yaml"}, value: "a.yaml"},
+		{args: []string{"-config=b.yaml", "--log-level", "debug"}, value: "b.yaml"},
+		{args: []string{"--log-level", "debug"}, value: "env.yaml"},
+		{args: []string{"--config"}, value: "env.yaml"},
+	}
+
+	for _, test := range tests {
+		value, ok := LookupOption(test.args, "config", "TEST_CONFIG")
+		require.True(t, ok)
+		require.Equal(t, test.value, value)
+	}
+
+	t.Run("option not set", func(t *testing.T) {
+		_, ok := LookupOption(nil, "config", "TEST_UNSET_CONFIG")
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+)
+
+// Change is a setting whose new value differs from the running configuration.
+// Values are not reported since settings can be secrets.
+type Change struct {
+	// The option name of the setting, such as log-level.
+	Name string `json:"name"`
+
+	// The environment variable of the setting, such as HAGALL_LOG_LEVEL.
+	EnvKey string `json:"env"`
+
+	// Reports whether the new value was applied. Changes that are not applied
+	// require a restart.
+	Applied bool `json:"applied"`
+}
+
+// Reloader applies configuration changes to a running server. Settings with
+// an apply function change at runtime. Changes to other settings are kept as
+// pending until the server restarts.
+type Reloader struct {
+	// A pointer to the running configuration struct, with the same cli and env
+	// tags as the ones given to the cli package.
+	Config any
+
+	// The functions that apply a setting at runtime, by option name. They are
+	// called with the new value after it is set in the running configuration.
+	Reloadable map[string]func(value any)
+
+	// Validates the configuration with the new values before they are applied.
+	// Optional.
+	Validate func(config any) error
+
+	mutex   sync.Mutex
+	pending map[string]Change
+}
+
+// Apply applies the given values, by option name or environment variable. The
+// values are parsed like environment variables. Nothing is applied when a
+// value is invalid or a setting is unknown. It returns the settings whose
+// value changed.
+func (r *Reloader) Apply(values map[string]string) ([]Change, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current := reflect.ValueOf(r.Config).Elem()
+	next := reflect.New(current.Type())
+	next.Elem().Set(current)
+
+	currentFields := fields("", current)
+	nextFields := fields("", next.Elem())
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	updated := make([]field, 0, len(keys))
+	for _, key := range keys {
+		f, ok := findField(nextFields, key)
+		if !ok {
+			return nil, errors.New("unknown setting").
+				WithType(ErrTypeUnknownSetting).
+				WithTag("setting", key)
+		}
+		if err := f.set(values[key]); err != nil {
+			return nil, err
+		}
+		updated = append(updated, f)
+	}
+	sort.Slice(updated, func(i, j int) bool {
+		return updated[i].name < updated[j].name
+	})
+
+	if r.Validate != nil {
+		if err := r.Validate(next.Interface()); err != nil {
+			return nil, errors.New("invalid configuration").
+				WithType(ErrTypeInvalidSetting).
+				Wrap(err)
+		}
+	}
+
+	if r.pending == nil {
+		r.pending = make(map[string]Change)
+	}
+
+	var changes []Change
+	for _, f := range updated {
+		running, _ := findField(currentFields, f.name)
+		if reflect.DeepEqual(running.value.Interface(), f.value.Interface()) {
+			delete(r.pending, f.name)
+			continue
+		}
+
+		change := Change{
+			Name:   f.name,
+			EnvKey: f.envKey,
+		}
+
+		if apply, ok := r.Reloadable[f.name]; ok {
+			running.value.Set(f.value)
+			apply(f.value.Interface())
+			change.Applied = true
+			delete(r.pending, f.name)
+		} else {
+			r.pending[f.name] = change
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Pending returns the changes that require a restart.
+func (r *Reloader) Pending() []Change {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changes := make([]Change, 0, len(r.pending))
+	for _, c := range r.pending {
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// ReloadableSettings returns the option names of the settings that change at
+// runtime.
+func (r *Reloader) ReloadableSettings() []string {
+	names := make([]string, 0, len(r.Reloadable))
+	for name := range r.Reloadable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyFile applies the values of the given environment file.
+func (r *Reloader) ApplyFile(filename string) ([]Change, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New("opening configuration file failed").
+			WithTag("file", filename).
+			Wrap(err)
+	}
+	defer f.Close()
+
+	values, err := ParseEnvFile(f)
+	if err != nil {
+		return nil, errors.New("parsing configuration file failed").
+			WithTag("file", filename).
+			Wrap(err)
+	}
+	return r.Apply(values)
+}
+
+// Watch applies the values of the given environment file each time it is
+// modified, until the context is done. The file is checked at the given
+// interval.
+func (r *Reloader) Watch(ctx context.Context, filename string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	for {
+		if info, err := os.Stat(filename); err != nil {
+			logs.Warn(errors.New("checking configuration file failed").
+				WithTag("file", filename).
+				Wrap(err))
+		} else if !info.ModTime().Equal(modTime) {
+			modTime = info.ModTime()
+			r.logChanges(r.ApplyFile(filename))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reloader) logChanges(changes []Change, err error) {
+	if err != nil {
+		logs.Warn(errors.New("reloading configuration failed").Wrap(err))
+		return
+	}
+
+	for _, c := range changes {
+		if c.Applied {
+			logs.WithTag("setting", c.Name).Info("setting reloaded")
+		} else {
+			logs.Warn(errors.New("setting requires a restart").
+				WithTag("setting", c.Name))
+		}
+	}
+}
+
+// ParseEnvFile parses KEY=value lines. Empty lines and lines starting with #
+// are ignored, and values can be quoted.
+func ParseEnvFile(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, errors.New("invalid line").WithTag("line", line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, errors.New("invalid quoted value").
+						WithTag("line", line).
+						Wrap(err)
+				}
+				value = unquoted
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		values[strings.TrimSpace(key)] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("reading lines failed").Wrap(err)
+	}
+	return values, nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Addr         string        `cli:""        env:"TEST_ADDR"          help:"Listening address."`
+	LogLevel     string        `cli:""        env:"TEST_LOG_LEVEL"     help:"Log level."`
+	IdleTimeout  time.Duration `cli:",hidden" env:"TEST_IDLE_TIMEOUT"  help:"Idle timeout."`
+	FeatureFlags []string      `cli:",hidden" env:"TEST_FEATURE_FLAGS" help:"Feature flags."`
+	HDS          testHDSConfig `cli:",hidden" env:"-"                  help:"HDS configuration."`
+	Secret       string        `cli:",hidden" env:"TEST_SECRET"        help:"Secret." secret:"true"`
+	Version      bool          `cli:""        env:"-"                  help:"Show version."`
+}
+
+type testHDSConfig struct {
+	Endpoint string `cli:",hidden" env:"TEST_HDS_ENDPOINT" help:"HDS endpoint."`
+	Retries  int    `cli:",hidden" env:"TEST_HDS_RETRIES"  help:"Retries."`
+}
+
+func TestReloader(t *testing.T) {
+	conf := testConfig{
+		Addr:         ":4000",
+		LogLevel:     "info",
+		IdleTimeout:  time.Minute,
+		FeatureFlags: []string{"a"},
+	}
+
+	applied := make(map[string]any)
+	reloader := Reloader{
+		Config: &conf,
+		Reloadable: map[string]func(any){
+			"log-level": func(v any) {
+				applied["log-level"] = v
+			},
+			"idle-timeout": func(v any) {
+				applied["idle-timeout"] = v
+			},
+			"feature-flags": func(v any) {
+				applied["feature-flags"] = v
+			},
+		},
+		Validate: func(v any) error {
+			if v.(*testConfig).LogLevel == "" {
+				return errors.New("empty log level")
+			}
+			return nil
+		},
+	}
+
+	t.Run("reloadable settings are applied", func(t *testing.T) {
+		changes, err := reloader.Apply(map[string]string{
+			"TEST_LOG_LEVEL":     "debug",
+			"idle-timeout":       "30s",
+			"TEST_FEATURE_FLAGS": "b, c",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []Change{
+			{Name: "feature-flags", EnvKey: "TEST_FEATURE_FLAGS", Applied: true},
+			{Name: "idle-timeout", EnvKey: "TEST_IDLE_TIMEOUT", Applied: true},
+			{Name: "log-level", EnvKey: "TEST_LOG_LEVEL", Applied: true},
+		}, changes)
+
+		require.Equal(t, "debug", conf.LogLevel)
+		require.Equal(t, time.Second*30, conf.IdleTimeout)
+		require.Equal(t, []string{"b", "c"}, conf.FeatureFlags)
+		require.Equal(t, "debug", applied["log-level"])
+		require.Equal(t, time.Second*30, applied["idle-timeout"])
+	})
+
+	t.Run("unchanged settings are not reported", func(t *testing.T) {
+		changes, err := reloader.Apply(map[string]string{
+			"TEST_LOG_LEVEL":     "debug",
+			"TEST_FEATURE_FLAGS": `["b","c"]`,
+		})
+		require.NoError(t, err)
+		require.Empty(t, changes)
+	})
+
+	t.Run("other settings require a restart", func(t *testing.T) {
+		changes, err := reloader.Apply(map[string]string{
+			"TEST_ADDR":        ":5000",
+			"TEST_HDS_RETRIES": "3",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []Change{
+			{Name: "addr", EnvKey: "TEST_ADDR"},
+			{Name: "hds.retries", EnvKey: "TEST_HDS_RETRIES"},
+		}, changes)
+		require.Equal(t, changes, reloader.Pending())
+
+		require.Equal(t, ":4000", conf.Addr)
+		require.Zero(t, conf.HDS.Retries)
+	})
+
+	t.Run("reverted settings are no longer pending", func(t *testing.T) {
+		_, err := reloader.Apply(map[string]string{
+			"hds.retries": "0",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []Change{
+			{Name: "addr", EnvKey: "TEST_ADDR"},
+		}, reloader.Pending())
+	})
+
+	t.Run("unknown setting", func(t *testing.T) {
+		_, err := reloader.Apply(map[string]string{
+			"TEST_LOG_LEVEL": "error",
+			"TEST_UNKNOWN":   "1",
+		})
+		require.True(t, errors.IsType(err, ErrTypeUnknownSetting))
+		require.Equal(t, "debug", conf.LogLevel)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		_, err := reloader.Apply(map[string]string{
+			"TEST_IDLE_TIMEOUT": "soon",
+		})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSetting))
+		require.Equal(t, time.Second*30, conf.IdleTimeout)
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := reloader.Apply(map[string]string{
+			"TEST_LOG_LEVEL": "",
+		})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSetting))
+		require.Equal(t, "debug", conf.LogLevel)
+	})
+
+	t.Run("apply a file", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "hagall.env")
+		require.NoError(t, os.WriteFile(filename, []byte("# Runtime settings\nTEST_LOG_LEVEL=warning\n"), 0600))
+
+		changes, err := reloader.ApplyFile(filename)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		require.Equal(t, "warning", conf.LogLevel)
+	})
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Run("parse values", func(t *testing.T) {
+		values, err := ParseEnvFile(strings.NewReader(`
+# Comment
+HAGALL_LOG_LEVEL=debug
+export HAGALL_CLIENT_IDLE_TIMEOUT = 1m
+HAGALL_DRAIN_ENDPOINT="https://hagall.example.com"
+HAGALL_FEATURE_FLAGS='["a"]'
+HAGALL_EMPTY=
+`))
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"HAGALL_LOG_LEVEL":           "debug",
+			"HAGALL_CLIENT_IDLE_TIMEOUT": "1m",
+			"HAGALL_DRAIN_ENDPOINT":      "https://hagall.example.com",
+			"HAGALL_FEATURE_FLAGS":       `["a"]`,
+			"HAGALL_EMPTY":               "",
+		}, values)
+	})
+
+	t.Run("invalid line", func(t *testing.T) {
+		_, err := ParseEnvFile(strings.NewReader("HAGALL_LOG_LEVEL\n"))
+		require.Error(t, err)
+	})
+}
+
+func TestNormalizeName(t *testing.T) {
+	require.Equal(t, "ncsendpoint", normalizeName("NCSEndpoint", "-"))
+	require.Equal(t, "public-endpoint", normalizeName("PublicEndpoint", "-"))
+	require.Equal(t, "hds", normalizeName("HDS", "-"))
+	require.Equal(t, "drain_reconnect_delay", normalizeName("drain.reconnect-delay", "_"))
+}
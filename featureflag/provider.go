@@ -0,0 +1,56 @@
+package featureflag
+
+// Scope is the session where feature flags are evaluated.
+type Scope struct {
+	// The app key of the session.
+	AppKey string
+
+	// The UUID of the session.
+	SessionUUID string
+}
+
+// Provider is the interface that provides the feature flags of a scope.
+type Provider interface {
+	// Reports whether the given flag is set in the given scope.
+	IsSet(flag Flag, scope Scope) bool
+}
+
+// IsSet reports whether the given flag is set. Flags are set in every scope.
+func (f FeatureFlag) IsSet(flag Flag, scope Scope) bool {
+	_, ok := f[flag]
+	return ok
+}
+
+// Scoped is a set of feature flags evaluated in a scope.
+type Scoped struct {
+	provider Provider
+	scope    Scope
+}
+
+// In returns the feature flags of the given provider evaluated in the given
+// scope. No flag is set when the provider is nil.
+func In(p Provider, scope Scope) Scoped {
+	return Scoped{
+		provider: p,
+		scope:    scope,
+	}
+}
+
+// IsSet reports whether the given flag is set.
+func (s Scoped) IsSet(flag Flag) bool {
+	return s.provider != nil && s.provider.IsSet(flag, s.scope)
+}
+
+// IfSet runs function `do` if flag is set.
+func (s Scoped) IfSet(flag Flag, do func()) {
+	if s.IsSet(flag) {
+		do()
+	}
+}
+
+// IfNotSet runs function `do` if flag is not set.
+func (s Scoped) IfNotSet(flag Flag, do func()) {
+	if !s.IsSet(flag) {
+		do()
+	}
+}
@@ -0,0 +1,207 @@
+package featureflag
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+)
+
+const (
+	ErrTypeInvalidRule = "invalid-feature-flag-rule"
+)
+
+// Rule sets a feature flag for a percentage of the sessions of a scope.
+// Session rules take precedence over app rules, which take precedence over
+// rules for every app.
+type Rule struct {
+	// The flag.
+	Flag Flag `json:"flag"`
+
+	// Optional, the app key whose sessions the rule applies to. Empty applies
+	// to every app.
+	AppKey string `json:"app_key,omitempty"`
+
+	// Optional, the UUID of the session the rule applies to.
+	SessionUUID string `json:"session_uuid,omitempty"`
+
+	// The percentage of sessions where the flag is set, from 0 (unset) to 100
+	// (set). A session is always in the same rollout bucket for a flag.
+	Percentage int `json:"percentage"`
+}
+
+func (r Rule) key() ruleKey {
+	return ruleKey{
+		flag:        r.Flag,
+		appKey:      r.AppKey,
+		sessionUUID: r.SessionUUID,
+	}
+}
+
+func (r Rule) applies(scope Scope) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte(r.Flag))
+	hash.Write([]byte{0})
+	hash.Write([]byte(scope.SessionUUID))
+	return int(hash.Sum32()%100) < r.Percentage
+}
+
+type ruleKey struct {
+	flag        Flag
+	appKey      string
+	sessionUUID string
+}
+
+// Registry is a provider of feature flags that change at runtime. Flags are
+// set by default flags, and by rules scoped to an app or a session.
+type Registry struct {
+	mutex    sync.RWMutex
+	defaults FeatureFlag
+	rules    map[ruleKey]Rule
+}
+
+// SetDefaults replaces the flags that are set when no rule applies.
+func (r *Registry) SetDefaults(flags []string) {
+	defaults := New(flags)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.defaults = defaults
+}
+
+// Defaults returns the flags that are set when no rule applies.
+func (r *Registry) Defaults() []Flag {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return sortedFlags(r.defaults)
+}
+
+// IsSet reports whether the given flag is set in the given scope.
+func (r *Registry) IsSet(flag Flag, scope Scope) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if scope.SessionUUID != "" {
+		if rule, ok := r.rules[ruleKey{flag: flag, sessionUUID: scope.SessionUUID}]; ok {
+			return rule.applies(scope)
+		}
+	}
+	if scope.AppKey != "" {
+		if rule, ok := r.rules[ruleKey{flag: flag, appKey: scope.AppKey}]; ok {
+			return rule.applies(scope)
+		}
+	}
+	if rule, ok := r.rules[ruleKey{flag: flag}]; ok {
+		return rule.applies(scope)
+	}
+
+	_, ok := r.defaults[flag]
+	return ok
+}
+
+// Flags returns the flags set in the given scope.
+func (r *Registry) Flags(scope Scope) []Flag {
+	r.mutex.RLock()
+	candidates := make(FeatureFlag, len(r.defaults)+len(r.rules))
+	for flag := range r.defaults {
+		candidates[flag] = struct{}{}
+	}
+	for k := range r.rules {
+		candidates[k.flag] = struct{}{}
+	}
+	r.mutex.RUnlock()
+
+	flags := make(FeatureFlag, len(candidates))
+	for flag := range candidates {
+		if r.IsSet(flag, scope) {
+			flags[flag] = struct{}{}
+		}
+	}
+	return sortedFlags(flags)
+}
+
+// SetRule adds or replaces the rule with the same flag and scope.
+func (r *Registry) SetRule(rule Rule) error {
+	if rule.Flag == "" {
+		return errors.New("feature flag rule without flag").
+			WithType(ErrTypeInvalidRule)
+	}
+	if rule.AppKey != "" && rule.SessionUUID != "" {
+		return errors.New("feature flag rule scoped to both an app and a session").
+			WithType(ErrTypeInvalidRule).
+			WithTag("flag", rule.Flag)
+	}
+	if rule.Percentage < 0 || rule.Percentage > 100 {
+		return errors.New("feature flag rule percentage out of range").
+			WithType(ErrTypeInvalidRule).
+			WithTag("flag", rule.Flag).
+			WithTag("percentage", rule.Percentage)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.rules == nil {
+		r.rules = make(map[ruleKey]Rule)
+	}
+	r.rules[rule.key()] = rule
+	return nil
+}
+
+// DeleteRule removes the rule with the given flag and scope. It reports
+// whether the rule existed.
+func (r *Registry) DeleteRule(flag Flag, appKey, sessionUUID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k := ruleKey{flag: flag, appKey: appKey, sessionUUID: sessionUUID}
+	_, ok := r.rules[k]
+	delete(r.rules, k)
+	return ok
+}
+
+// DeleteSessionRules removes the rules of the given session.
+func (r *Registry) DeleteSessionRules(sessionUUID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k := range r.rules {
+		if k.sessionUUID == sessionUUID {
+			delete(r.rules, k)
+		}
+	}
+}
+
+// Rules returns the rules sorted by flag, app key and session UUID.
+func (r *Registry) Rules() []Rule {
+	r.mutex.RLock()
+	rules := make([]Rule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, rule)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Flag != b.Flag {
+			return a.Flag < b.Flag
+		}
+		if a.AppKey != b.AppKey {
+			return a.AppKey < b.AppKey
+		}
+		return a.SessionUUID < b.SessionUUID
+	})
+	return rules
+}
+
+func sortedFlags(f FeatureFlag) []Flag {
+	flags := make([]Flag, 0, len(f))
+	for flag := range f {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i] < flags[j]
+	})
+	return flags
+}
@@ -0,0 +1,109 @@
+package featureflag
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	r.SetDefaults([]string{"feature1"})
+
+	appA := Scope{AppKey: "app-a", SessionUUID: "session-1"}
+	appB := Scope{AppKey: "app-b", SessionUUID: "session-2"}
+
+	t.Run("default flags are set", func(t *testing.T) {
+		require.True(t, r.IsSet("feature1", appA))
+		require.False(t, r.IsSet("feature2", appA))
+		require.Equal(t, []Flag{"feature1"}, r.Defaults())
+	})
+
+	t.Run("app rule overrides default flags", func(t *testing.T) {
+		require.NoError(t, r.SetRule(Rule{Flag: "feature1", AppKey: "app-a"}))
+		require.NoError(t, r.SetRule(Rule{Flag: "feature2", AppKey: "app-a", Percentage: 100}))
+
+		require.False(t, r.IsSet("feature1", appA))
+		require.True(t, r.IsSet("feature2", appA))
+		require.True(t, r.IsSet("feature1", appB))
+		require.False(t, r.IsSet("feature2", appB))
+		require.Equal(t, []Flag{"feature2"}, r.Flags(appA))
+		require.Equal(t, []Flag{"feature1"}, r.Flags(appB))
+	})
+
+	t.Run("session rule overrides app rule", func(t *testing.T) {
+		require.NoError(t, r.SetRule(Rule{Flag: "feature1", SessionUUID: "session-1", Percentage: 100}))
+		require.True(t, r.IsSet("feature1", appA))
+		require.False(t, r.IsSet("feature1", Scope{AppKey: "app-a", SessionUUID: "session-3"}))
+	})
+
+	t.Run("session rules are deleted", func(t *testing.T) {
+		r.DeleteSessionRules("session-1")
+		require.False(t, r.IsSet("feature1", appA))
+		require.Equal(t, []Rule{
+			{Flag: "feature1", AppKey: "app-a"},
+			{Flag: "feature2", AppKey: "app-a", Percentage: 100},
+		}, r.Rules())
+	})
+
+	t.Run("delete a rule", func(t *testing.T) {
+		require.True(t, r.DeleteRule("feature1", "app-a", ""))
+		require.False(t, r.DeleteRule("feature1", "app-a", ""))
+		require.True(t, r.IsSet("feature1", appA))
+	})
+
+	t.Run("rollout sets a flag for a percentage of sessions", func(t *testing.T) {
+		require.NoError(t, r.SetRule(Rule{Flag: "rollout", Percentage: 30}))
+
+		set := 0
+		for i := 0; i < 1000; i++ {
+			scope := Scope{SessionUUID: fmt.Sprintf("session-%d", i)}
+			if r.IsSet("rollout", scope) {
+				set++
+			}
+			require.Equal(t, r.IsSet("rollout", scope), r.IsSet("rollout", scope))
+		}
+		require.InDelta(t, 300, set, 60)
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		rules := []Rule{
+			{Percentage: 100},
+			{Flag: "feature1", Percentage: 101},
+			{Flag: "feature1", Percentage: -1},
+			{Flag: "feature1", AppKey: "app-a", SessionUUID: "session-1"},
+		}
+		for _, rule := range rules {
+			require.True(t, errors.IsType(r.SetRule(rule), ErrTypeInvalidRule))
+		}
+	})
+}
+
+func TestScoped(t *testing.T) {
+	t.Run("provider flags are evaluated in the scope", func(t *testing.T) {
+		var r Registry
+		require.NoError(t, r.SetRule(Rule{Flag: "feature1", AppKey: "app-a", Percentage: 100}))
+
+		var run bool
+		In(&r, Scope{AppKey: "app-a"}).IfSet("feature1", func() {
+			run = true
+		})
+		require.True(t, run)
+
+		run = false
+		In(&r, Scope{AppKey: "app-b"}).IfSet("feature1", func() {
+			run = true
+		})
+		require.False(t, run)
+	})
+
+	t.Run("nil provider has no flag set", func(t *testing.T) {
+		var run bool
+		In(nil, Scope{}).IfNotSet("feature1", func() {
+			run = true
+		})
+		require.True(t, run)
+	})
+}
@@ -0,0 +1,413 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/recording"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/segmentio/encoding/json"
+)
+
+// HandleEntityComponentHistory returns a handler that writes the recorded
+// entity component changes of an entity as JSON.
+//
+// Query parameters:
+//   - session_id: The global id of the session.
+//   - entity_id: The entity id.
+//   - entity_component_type_id: Optional, restricts the changes to a single
+//     entity component type.
+func HandleEntityComponentHistory(sessions *models.SessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		session, ok := sessions.GetByGlobalID(query.Get("session_id"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		entityID, err := strconv.ParseUint(query.Get("entity_id"), 10, 32)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var changes []models.EntityComponentChange
+		if v := query.Get("entity_component_type_id"); v != "" {
+			typeID, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			changes = session.GetEntityComponents().History(uint32(typeID), uint32(entityID))
+		} else {
+			changes = session.GetEntityComponents().HistoryByEntityID(uint32(entityID))
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Changes []models.EntityComponentChange `json:"changes"`
+		}{
+			Changes: changes,
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+type serverPose struct {
+	PX float32 `json:"px"`
+	PY float32 `json:"py"`
+	PZ float32 `json:"pz"`
+	RX float32 `json:"rx"`
+	RY float32 `json:"ry"`
+	RZ float32 `json:"rz"`
+	RW float32 `json:"rw"`
+}
+
+func (p serverPose) toModel() models.Pose {
+	return models.Pose{
+		PX: p.PX,
+		PY: p.PY,
+		PZ: p.PZ,
+		RX: p.RX,
+		RY: p.RY,
+		RZ: p.RZ,
+		RW: p.RW,
+	}
+}
+
+type serverEntityComponent struct {
+	TypeName string `json:"type_name"`
+	Data     []byte `json:"data"`
+}
+
+// HandleParticipants returns a handler that manages the participants of a
+// session.
+//
+// Methods:
+//   - GET: Returns the participants of the session identified by the
+//     session_id query parameter, with their info and presence.
+//   - PUT: Replaces the info of a participant from a JSON body with
+//     session_id, participant_id and info fields.
+//   - DELETE: Kicks the participant identified by the session_id and
+//     participant_id query parameters. The participant is also banned from
+//     the session when the ban query parameter is true.
+func HandleParticipants(p *hwebsocket.ServerParticipant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			session, ok := p.Sessions.GetByGlobalID(r.URL.Query().Get("session_id"))
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			type participantStatus struct {
+				ID        uint32                 `json:"id"`
+				Spectator bool                   `json:"spectator,omitempty"`
+				Host      bool                   `json:"host,omitempty"`
+				Info      models.ParticipantInfo `json:"info"`
+				Presence  models.Presence        `json:"presence"`
+			}
+
+			participants := session.GetParticipants()
+			sort.Slice(participants, func(i, j int) bool {
+				return participants[i].ID < participants[j].ID
+			})
+
+			res := make([]participantStatus, 0, len(participants))
+			for _, participant := range participants {
+				res = append(res, participantStatus{
+					ID:        participant.ID,
+					Spectator: participant.Spectator,
+					Host:      session.IsHost(participant),
+					Info:      participant.Info(),
+					Presence:  participant.Presence(),
+				})
+			}
+
+			writeJSON(w, http.StatusOK, struct {
+				Participants []participantStatus `json:"participants"`
+			}{
+				Participants: res,
+			})
+
+		case http.MethodPut:
+			var req struct {
+				SessionID     string                 `json:"session_id"`
+				ParticipantID uint32                 `json:"participant_id"`
+				Info          models.ParticipantInfo `json:"info"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if err := p.UpdateParticipantInfo(req.SessionID, req.ParticipantID, req.Info); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			query := r.URL.Query()
+
+			participantID, err := strconv.ParseUint(query.Get("participant_id"), 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ban, _ := strconv.ParseBool(query.Get("ban"))
+
+			if err := p.KickParticipant(query.Get("session_id"), uint32(participantID), ban); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleServerEntities returns a handler that manages the entities owned by
+// the server participant.
+//
+// Methods:
+//   - POST: Adds an entity from a JSON body with session_id, pose, flag and
+//     components fields. The entity id is returned as JSON.
+//   - PUT: Updates an entity pose from a JSON body with session_id, entity_id
+//     and pose fields.
+//   - DELETE: Deletes the entity identified by the session_id and entity_id
+//     query parameters.
+func HandleServerEntities(p *hwebsocket.ServerParticipant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req struct {
+				SessionID  string                  `json:"session_id"`
+				Pose       serverPose              `json:"pose"`
+				Flag       hagallpb.EntityFlag     `json:"flag"`
+				Components []serverEntityComponent `json:"components"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			entity, err := p.AddEntity(req.SessionID, req.Pose.toModel(), req.Flag)
+			if err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+
+			for _, c := range req.Components {
+				if err := p.SetEntityComponent(req.SessionID, c.TypeName, entity.ID, c.Data); err != nil {
+					writeServerParticipantError(w, err)
+					return
+				}
+			}
+
+			writeJSON(w, http.StatusCreated, struct {
+				EntityID uint32 `json:"entity_id"`
+			}{
+				EntityID: entity.ID,
+			})
+
+		case http.MethodPut:
+			var req struct {
+				SessionID string     `json:"session_id"`
+				EntityID  uint32     `json:"entity_id"`
+				Pose      serverPose `json:"pose"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if err := p.UpdateEntityPose(req.SessionID, req.EntityID, req.Pose.toModel()); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			query := r.URL.Query()
+
+			entityID, err := strconv.ParseUint(query.Get("entity_id"), 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if err := p.DeleteEntity(query.Get("session_id"), uint32(entityID)); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleServerEntityComponents returns a handler that manages entity
+// components on behalf of the server participant.
+//
+// Methods:
+//   - PUT: Adds or updates an entity component from a JSON body with
+//     session_id, entity_id, type_name and data (base64) fields.
+//   - DELETE: Deletes the entity component identified by the session_id,
+//     entity_id and type_name query parameters.
+func HandleServerEntityComponents(p *hwebsocket.ServerParticipant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			var req struct {
+				SessionID string `json:"session_id"`
+				EntityID  uint32 `json:"entity_id"`
+				serverEntityComponent
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if err := p.SetEntityComponent(req.SessionID, req.TypeName, req.EntityID, req.Data); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			query := r.URL.Query()
+
+			entityID, err := strconv.ParseUint(query.Get("entity_id"), 10, 32)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if err := p.DeleteEntityComponent(query.Get("session_id"), query.Get("type_name"), uint32(entityID)); err != nil {
+				writeServerParticipantError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleServerMessages returns a handler that sends custom messages from the
+// server participant. It accepts POST requests with a JSON body with
+// session_id, participant_ids (optional) and body (base64) fields.
+func HandleServerMessages(p *hwebsocket.ServerParticipant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			SessionID      string   `json:"session_id"`
+			ParticipantIDs []uint32 `json:"participant_ids"`
+			Body           []byte   `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := p.SendCustomMessage(req.SessionID, req.Body, req.ParticipantIDs...); err != nil {
+			writeServerParticipantError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleRecordings returns a handler that manages session recordings.
+//
+// Methods:
+//   - GET: Returns the paths of the recordings being written, indexed by
+//     session id.
+//   - POST: Starts recording the session identified by the session_id query
+//     parameter.
+//   - DELETE: Stops recording the session identified by the session_id query
+//     parameter.
+func HandleRecordings(sessions *models.SessionStore, recorder *recording.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.URL.Query().Get("session_id")
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, struct {
+				Recordings map[string]string `json:"recordings"`
+			}{
+				Recordings: recorder.Recordings(),
+			})
+
+		case http.MethodPost:
+			if _, ok := sessions.GetByGlobalID(sessionID); !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			recorder.Start(sessionID)
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			recorder.Stop(sessionID)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeServerParticipantError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.IsType(err, hwebsocket.ErrTypeSessionNotFound),
+		errors.IsType(err, hwebsocket.ErrTypeParticipantNotFound),
+		errors.IsType(err, hwebsocket.ErrTypeEntityNotFound),
+		errors.IsType(err, hwebsocket.ErrTypeEntityComponentNotFound):
+		status = http.StatusNotFound
+
+	case errors.IsType(err, hwebsocket.ErrTypeEntityNotOwned):
+		status = http.StatusForbidden
+
+	case errors.IsType(err, hwebsocket.ErrTypeCustomMessageTooLarge),
+		errors.IsType(err, models.ErrTypeParticipantInfoTooLarge):
+		status = http.StatusRequestEntityTooLarge
+
+	case errors.IsType(err, hwebsocket.ErrTypeEntityComponentTypeEmpty),
+		errors.IsType(err, models.ErrTypeEntityComponentInvalidData):
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{
+		Error: errors.Message(err),
+	})
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSession(t *testing.T, sessions *models.SessionStore) *models.Session {
+	session := models.NewSession(sessions.NewID(), time.Millisecond*10)
+	require.NoError(t, sessions.Add(context.Background(), session))
+	t.Cleanup(func() {
+		sessions.Remove(context.Background(), session)
+		session.Close()
+	})
+	return session
+}
+
+func TestHandleEntityComponentHistory(t *testing.T) {
+	sessions := &models.SessionStore{}
+	session := newTestSession(t, sessions)
+	sessionID := sessions.GlobalSessionID(session.ID)
+
+	store := session.GetEntityComponents()
+	store.SetHistorySize(4)
+	labelID := store.AddType("label")
+	colorID := store.AddType("color")
+
+	for _, ec := range []*hagallpb.EntityComponent{
+		{EntityComponentTypeId: labelID, EntityId: 1, Data: []byte("a")},
+		{EntityComponentTypeId: colorID, EntityId: 1, Data: []byte("red")},
+		{EntityComponentTypeId: labelID, EntityId: 2, Data: []byte("b")},
+	} {
+		require.NoError(t, store.Add(ec))
+		store.RecordChange(models.NewEntityComponentChange(models.EntityComponentOpAdd, ec, 1, 1))
+	}
+
+	handler := HandleEntityComponentHistory(sessions)
+
+	get := func(t *testing.T, query string) (int, []models.EntityComponentChange) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/entity-component-history?"+query, nil))
+
+		var res struct {
+			Changes []models.EntityComponentChange `json:"changes"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res.Changes
+	}
+
+	t.Run("history of an entity", func(t *testing.T) {
+		code, changes := get(t, "session_id="+sessionID+"&entity_id=1")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, changes, 2)
+		require.Equal(t, labelID, changes[0].EntityComponentTypeID)
+		require.Equal(t, colorID, changes[1].EntityComponentTypeID)
+	})
+
+	t.Run("history of an entity component", func(t *testing.T) {
+		code, changes := get(t, "session_id="+sessionID+"&entity_id=1&entity_component_type_id="+strconv.Itoa(int(colorID)))
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, changes, 1)
+		require.Equal(t, []byte("red"), changes[0].Data)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		code, _ := get(t, "session_id=unknown&entity_id=1")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("invalid ids", func(t *testing.T) {
+		code, _ := get(t, "session_id="+sessionID+"&entity_id=a")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = get(t, "session_id="+sessionID+"&entity_id=1&entity_component_type_id=a")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+}
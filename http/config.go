@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/config"
+	"github.com/segmentio/encoding/json"
+)
+
+// HandleConfig returns a handler that writes the settings that change at
+// runtime and the changes that require a restart as JSON.
+//
+// POST requests apply the settings of the JSON object in the request body,
+// whose keys are option names or environment variables and whose values are
+// formatted like environment variables, such as {"log-level": "debug"}.
+func HandleConfig(reloader *config.Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var changes []config.Change
+
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPost:
+			var values map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var err error
+			if changes, err = reloader.Apply(values); err != nil {
+				writeJSON(w, http.StatusBadRequest, struct {
+					Error   string `json:"error"`
+					Setting string `json:"setting,omitempty"`
+				}{
+					Error:   errors.Type(err),
+					Setting: errors.Tag(err, "setting"),
+				})
+				return
+			}
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Reloadable     []string        `json:"reloadable"`
+			PendingRestart []config.Change `json:"pending_restart"`
+			Changes        []config.Change `json:"changes,omitempty"`
+		}{
+			Reloadable:     reloader.ReloadableSettings(),
+			PendingRestart: reloader.Pending(),
+			Changes:        changes,
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aukilabs/hagall/config"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Addr     string `cli:"" env:"TEST_ADDR"      help:"Listening address."`
+	LogLevel string `cli:"" env:"TEST_LOG_LEVEL" help:"Log level."`
+}
+
+func TestHandleConfig(t *testing.T) {
+	conf := testConfig{
+		Addr:     ":4000",
+		LogLevel: "info",
+	}
+
+	var logLevel any
+	handler := HandleConfig(&config.Reloader{
+		Config: &conf,
+		Reloadable: map[string]func(any){
+			"log-level": func(v any) {
+				logLevel = v
+			},
+		},
+	})
+
+	type response struct {
+		Reloadable     []string        `json:"reloadable"`
+		PendingRestart []config.Change `json:"pending_restart"`
+		Changes        []config.Change `json:"changes"`
+		Error          string          `json:"error"`
+		Setting        string          `json:"setting"`
+	}
+
+	do := func(t *testing.T, method, body string) (int, response) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/config", strings.NewReader(body)))
+
+		var res response
+		if w.Body.Len() != 0 {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	t.Run("reloadable settings are written", func(t *testing.T) {
+		code, res := do(t, http.MethodGet, "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []string{"log-level"}, res.Reloadable)
+		require.Empty(t, res.PendingRestart)
+	})
+
+	t.Run("settings are applied", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, `{"log-level": "debug", "TEST_ADDR": ":5000"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []config.Change{
+			{Name: "addr", EnvKey: "TEST_ADDR"},
+			{Name: "log-level", EnvKey: "TEST_LOG_LEVEL", Applied: true},
+		}, res.Changes)
+		require.Equal(t, []config.Change{
+			{Name: "addr", EnvKey: "TEST_ADDR"},
+		}, res.PendingRestart)
+		require.Equal(t, "debug", logLevel)
+		require.Equal(t, ":4000", conf.Addr)
+	})
+
+	t.Run("invalid body is rejected", func(t *testing.T) {
+		code, _ := do(t, http.MethodPost, "{")
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("unknown setting is rejected", func(t *testing.T) {
+		code, res := do(t, http.MethodPost, `{"log-level": "warn", "unknown": "1"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Equal(t, config.ErrTypeUnknownSetting, res.Error)
+		require.Equal(t, "unknown", res.Setting)
+		require.Equal(t, "debug", conf.LogLevel)
+	})
+
+	t.Run("only get and post are allowed", func(t *testing.T) {
+		code, _ := do(t, http.MethodPut, "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+)
+
+// HandleDrain returns a handler that writes the drain status of the server as
+// JSON, and starts draining the server on POST requests.
+//
+// Query parameters of POST requests, which default to the given notice:
+//   - reconnect_delay: Optional, the delay suggested to participants before
+//     they reconnect, such as 5s.
+//   - endpoint: Optional, the endpoint of another server suggested to
+//     participants.
+func HandleDrain(drainer *hwebsocket.Drainer, notice hwebsocket.DrainNotice) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPost:
+			query := r.URL.Query()
+			if v := query.Get("reconnect_delay"); v != "" {
+				delay, err := time.ParseDuration(v)
+				if err != nil || delay < 0 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				notice.ReconnectDelay = delay
+			}
+			if v := query.Get("endpoint"); v != "" {
+				notice.Endpoint = v
+			}
+
+			status = http.StatusAccepted
+			if !drainer.Drain(notice) {
+				status = http.StatusConflict
+			}
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		current := drainer.Notice()
+		writeJSON(w, status, struct {
+			Draining         bool   `json:"draining"`
+			Participants     int    `json:"participants"`
+			ReconnectDelayMS int64  `json:"reconnect_delay_ms,omitempty"`
+			Endpoint         string `json:"endpoint,omitempty"`
+		}{
+			Draining:         drainer.Draining(),
+			Participants:     drainer.ParticipantCount(),
+			ReconnectDelayMS: current.ReconnectDelay.Milliseconds(),
+			Endpoint:         current.Endpoint,
+		})
+	}
+}
+
+// RejectWhenDraining returns a handler that responds with a service
+// unavailable status while the server is draining, and calls h otherwise.
+func RejectWhenDraining(drainer *hwebsocket.Drainer, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !drainer.Draining() {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if delay := drainer.Notice().ReconnectDelay; delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((delay+time.Second-1)/time.Second)))
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+}
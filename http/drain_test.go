@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDrain(t *testing.T) {
+	drainer := &hwebsocket.Drainer{Sessions: &models.SessionStore{}}
+	handler := HandleDrain(drainer, hwebsocket.DrainNotice{
+		ReconnectDelay: time.Second,
+	})
+	rejecter := RejectWhenDraining(drainer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	type status struct {
+		Draining         bool   `json:"draining"`
+		Participants     int    `json:"participants"`
+		ReconnectDelayMS int64  `json:"reconnect_delay_ms"`
+		Endpoint         string `json:"endpoint"`
+	}
+
+	drain := func(t *testing.T, method, query string) (int, status) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/drain"+query, nil))
+
+		var res status
+		if w.Code == http.StatusOK || w.Code == http.StatusAccepted || w.Code == http.StatusConflict {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	reject := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rejecter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	t.Run("status is written before draining", func(t *testing.T) {
+		code, res := drain(t, http.MethodGet, "")
+		require.Equal(t, http.StatusOK, code)
+		require.False(t, res.Draining)
+		require.Equal(t, http.StatusOK, reject().Code)
+	})
+
+	t.Run("invalid reconnect delay is rejected", func(t *testing.T) {
+		code, _ := drain(t, http.MethodPost, "?reconnect_delay=soon")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = drain(t, http.MethodPost, "?reconnect_delay=-1s")
+		require.Equal(t, http.StatusBadRequest, code)
+		require.False(t, drainer.Draining())
+	})
+
+	t.Run("drain is accepted", func(t *testing.T) {
+		code, res := drain(t, http.MethodPost, "?reconnect_delay=1500ms&endpoint=https://hagall.example.com")
+		require.Equal(t, http.StatusAccepted, code)
+		require.Equal(t, status{
+			Draining:         true,
+			ReconnectDelayMS: 1500,
+			Endpoint:         "https://hagall.example.com",
+		}, res)
+	})
+
+	t.Run("draining again conflicts", func(t *testing.T) {
+		code, res := drain(t, http.MethodPost, "")
+		require.Equal(t, http.StatusConflict, code)
+		require.True(t, res.Draining)
+		require.Equal(t, int64(1500), res.ReconnectDelayMS)
+	})
+
+	t.Run("requests are rejected while draining", func(t *testing.T) {
+		w := reject()
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "2", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("only get and post are allowed", func(t *testing.T) {
+		code, _ := drain(t, http.MethodDelete, "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
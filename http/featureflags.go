@@ -0,0 +1,117 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+)
+
+// HandleFeatureFlags returns a handler that writes the default feature flags
+// and the feature flag rules as JSON. With an app_key or session_id query
+// parameter, it also writes the flags set for that app or session.
+//
+// PUT requests add or replace a rule from a body with a flag, an optional
+// app_key or session_id, and an optional percentage that defaults to 100.
+//
+// DELETE requests remove the rule given by the flag, app_key and session_id
+// query parameters.
+func HandleFeatureFlags(sessions *models.SessionStore, registry *featureflag.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+
+			var flags *[]featureflag.Flag
+			if appKey, sessionID := query.Get("app_key"), query.Get("session_id"); appKey != "" || sessionID != "" {
+				scope, ok := featureFlagScope(sessions, appKey, sessionID)
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				scopeFlags := registry.Flags(scope)
+				flags = &scopeFlags
+			}
+
+			writeFeatureFlags(w, registry, flags)
+
+		case http.MethodPut:
+			var req struct {
+				Flag       featureflag.Flag `json:"flag"`
+				AppKey     string           `json:"app_key"`
+				SessionID  string           `json:"session_id"`
+				Percentage *int             `json:"percentage"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			scope, ok := featureFlagScope(sessions, req.AppKey, req.SessionID)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			rule := featureflag.Rule{
+				Flag:        req.Flag,
+				AppKey:      req.AppKey,
+				SessionUUID: scope.SessionUUID,
+				Percentage:  100,
+			}
+			if req.Percentage != nil {
+				rule.Percentage = *req.Percentage
+			}
+			if err := registry.SetRule(rule); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			writeFeatureFlags(w, registry, nil)
+
+		case http.MethodDelete:
+			query := r.URL.Query()
+
+			appKey := query.Get("app_key")
+			scope, ok := featureFlagScope(sessions, appKey, query.Get("session_id"))
+			if !ok || !registry.DeleteRule(featureflag.Flag(query.Get("flag")), appKey, scope.SessionUUID) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// featureFlagScope returns the scope of the given app key, or of the session
+// with the given global id. It reports false when the session does not exist.
+func featureFlagScope(sessions *models.SessionStore, appKey, sessionID string) (featureflag.Scope, bool) {
+	if sessionID == "" {
+		return featureflag.Scope{AppKey: appKey}, true
+	}
+
+	session, ok := sessions.GetByGlobalID(sessionID)
+	if !ok {
+		return featureflag.Scope{}, false
+	}
+	return featureflag.Scope{
+		AppKey:      session.AppKey,
+		SessionUUID: session.SessionUUID,
+	}, true
+}
+
+func writeFeatureFlags(w http.ResponseWriter, registry *featureflag.Registry, flags *[]featureflag.Flag) {
+	writeJSON(w, http.StatusOK, struct {
+		Defaults []featureflag.Flag  `json:"defaults"`
+		Rules    []featureflag.Rule  `json:"rules"`
+		Flags    *[]featureflag.Flag `json:"flags,omitempty"`
+	}{
+		Defaults: registry.Defaults(),
+		Rules:    registry.Rules(),
+		Flags:    flags,
+	})
+}
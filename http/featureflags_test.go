@@ -0,0 +1,109 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleFeatureFlags(t *testing.T) {
+	sessions := &models.SessionStore{}
+	session := newTestSession(t, sessions)
+	session.AppKey = "app"
+	sessionID := sessions.GlobalSessionID(session.ID)
+
+	var registry featureflag.Registry
+	registry.SetDefaults([]string{"feature1"})
+	handler := HandleFeatureFlags(sessions, &registry)
+
+	type response struct {
+		Defaults []featureflag.Flag  `json:"defaults"`
+		Rules    []featureflag.Rule  `json:"rules"`
+		Flags    *[]featureflag.Flag `json:"flags"`
+	}
+
+	do := func(t *testing.T, method, query, body string) (int, response) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/feature-flags"+query, strings.NewReader(body)))
+
+		var res response
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res
+	}
+
+	t.Run("defaults and rules are written", func(t *testing.T) {
+		code, res := do(t, http.MethodGet, "", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []featureflag.Flag{"feature1"}, res.Defaults)
+		require.Empty(t, res.Rules)
+		require.Nil(t, res.Flags)
+	})
+
+	t.Run("session rule is set", func(t *testing.T) {
+		code, res := do(t, http.MethodPut, "", `{"flag": "feature2", "session_id": "`+sessionID+`"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, []featureflag.Rule{
+			{Flag: "feature2", SessionUUID: session.SessionUUID, Percentage: 100},
+		}, res.Rules)
+
+		code, res = do(t, http.MethodGet, "?session_id="+sessionID, "")
+		require.Equal(t, http.StatusOK, code)
+		require.NotNil(t, res.Flags)
+		require.ElementsMatch(t, []featureflag.Flag{"feature1", "feature2"}, *res.Flags)
+	})
+
+	t.Run("app rule with percentage is set", func(t *testing.T) {
+		code, res := do(t, http.MethodPut, "", `{"flag": "feature1", "app_key": "app", "percentage": 0}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res.Rules, 2)
+
+		code, res = do(t, http.MethodGet, "?app_key=app", "")
+		require.Equal(t, http.StatusOK, code)
+		require.Empty(t, *res.Flags)
+	})
+
+	t.Run("invalid rules are rejected", func(t *testing.T) {
+		code, _ := do(t, http.MethodPut, "", "{")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = do(t, http.MethodPut, "", `{"app_key": "app"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = do(t, http.MethodPut, "", `{"flag": "feature2", "percentage": 101}`)
+		require.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("unknown session is not found", func(t *testing.T) {
+		code, _ := do(t, http.MethodGet, "?session_id=unknown", "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodPut, "", `{"flag": "feature2", "session_id": "unknown"}`)
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("rule is deleted", func(t *testing.T) {
+		code, _ := do(t, http.MethodDelete, "?flag=feature2&session_id="+sessionID, "")
+		require.Equal(t, http.StatusNoContent, code)
+
+		code, _ = do(t, http.MethodDelete, "?flag=feature2&session_id="+sessionID, "")
+		require.Equal(t, http.StatusNotFound, code)
+
+		_, res := do(t, http.MethodGet, "", "")
+		require.Equal(t, []featureflag.Rule{
+			{Flag: "feature1", AppKey: "app"},
+		}, res.Rules)
+	})
+
+	t.Run("only get, put and delete are allowed", func(t *testing.T) {
+		code, _ := do(t, http.MethodPost, "", "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
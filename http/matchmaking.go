@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	"github.com/aukilabs/hagall/matchmaking"
+	"github.com/segmentio/encoding/json"
+)
+
+// HandleMatchmaking returns a handler that groups the caller with other
+// clients of its app key and responds with the session to join.
+//
+// It accepts POST requests with a JSON body with group_size, tags (optional),
+// skill (optional) and timeout (optional, in seconds) fields. The request is
+// held until the group is formed, up to the given timeout capped by maxWait.
+// Closing the request cancels it.
+func HandleMatchmaking(m *matchmaking.Matchmaker, maxWait time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			GroupSize int      `json:"group_size"`
+			Tags      []string `json:"tags"`
+			Skill     float64  `json:"skill"`
+			Timeout   float64  `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		timeout := maxWait
+		if t := time.Duration(req.Timeout * float64(time.Second)); t > 0 && t < timeout {
+			timeout = t
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		match, err := m.Match(ctx, matchmaking.Request{
+			AppKey:    httpcmn.GetAppKeyFromHagallUserToken(httpcmn.GetUserTokenFromHTTPRequest(r)),
+			GroupSize: req.GroupSize,
+			Tags:      req.Tags,
+			Skill:     req.Skill,
+		})
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, struct {
+				SessionID string `json:"session_id"`
+				GroupSize int    `json:"group_size"`
+			}{
+				SessionID: match.SessionID,
+				GroupSize: match.GroupSize,
+			})
+
+		case errors.IsType(err, matchmaking.ErrTypeInvalidRequest):
+			writeJSON(w, http.StatusBadRequest, struct {
+				Error string `json:"error"`
+			}{
+				Error: errors.Message(err),
+			})
+
+		case errors.IsType(err, matchmaking.ErrTypeTimeout):
+			w.WriteHeader(http.StatusRequestTimeout)
+
+		case r.Context().Err() != nil:
+			// The client canceled the request.
+
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/matchmaking"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMatchmaking(t *testing.T) {
+	sessions := &models.SessionStore{}
+	t.Cleanup(func() {
+		for _, session := range sessions.List() {
+			sessions.Remove(context.Background(), session)
+		}
+	})
+
+	handler := HandleMatchmaking(&matchmaking.Matchmaker{
+		Sessions: sessions,
+		CreateSession: func(ctx context.Context, appKey string, info models.SessionInfo) (*models.Session, error) {
+			session := models.NewSession(sessions.NewID(), time.Millisecond*10)
+			session.AppKey = appKey
+			session.SetInfo(info)
+			return session, sessions.Add(ctx, session)
+		},
+		JoinTimeout: time.Minute,
+	}, time.Second*5)
+
+	match := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/matchmaking", strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("clients are grouped in the same session", func(t *testing.T) {
+		var wg sync.WaitGroup
+		responses := make([]*httptest.ResponseRecorder, 2)
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				responses[i] = match(http.MethodPost, `{"group_size": 2}`)
+			}(i)
+		}
+		wg.Wait()
+
+		var sessionIDs []string
+		for _, w := range responses {
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var res struct {
+				SessionID string `json:"session_id"`
+				GroupSize int    `json:"group_size"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			require.Equal(t, 2, res.GroupSize)
+			sessionIDs = append(sessionIDs, res.SessionID)
+		}
+		require.NotEmpty(t, sessionIDs[0])
+		require.Equal(t, sessionIDs[0], sessionIDs[1])
+	})
+
+	t.Run("invalid body is rejected", func(t *testing.T) {
+		w := match(http.MethodPost, "{")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid group size is rejected", func(t *testing.T) {
+		w := match(http.MethodPost, `{"group_size": 0}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		var res struct {
+			Error string `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.NotEmpty(t, res.Error)
+	})
+
+	t.Run("request times out without group", func(t *testing.T) {
+		w := match(http.MethodPost, `{"group_size": 2, "timeout": 0.01}`)
+		require.Equal(t, http.StatusRequestTimeout, w.Code)
+	})
+
+	t.Run("only post is allowed", func(t *testing.T) {
+		w := match(http.MethodGet, "")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
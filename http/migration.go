@@ -0,0 +1,101 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/segmentio/encoding/json"
+)
+
+// HandleSessionMigration returns a handler that migrates a session to a peer
+// server on POST requests.
+//
+// Query parameters, which default to the given peer:
+//   - session_id: The global id of the session.
+//   - admin_endpoint: Optional, the admin endpoint of the peer server.
+//   - endpoint: Optional, the public endpoint of the peer server.
+func HandleSessionMigration(migrator *hwebsocket.Migrator, peer hwebsocket.MigrationPeer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if v := query.Get("admin_endpoint"); v != "" {
+			peer.AdminEndpoint = v
+		}
+		if v := query.Get("endpoint"); v != "" {
+			peer.Endpoint = v
+		}
+		if peer.AdminEndpoint == "" || peer.Endpoint == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		session, ok := migrator.Handler.Sessions.GetByGlobalID(query.Get("session_id"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := migrator.Migrate(r.Context(), session, peer); err != nil {
+			writeJSON(w, http.StatusBadGateway, struct {
+				Error string `json:"error"`
+			}{
+				Error: errors.Message(err),
+			})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleSessionImport returns a handler that imports a session migrated from
+// a peer server. It accepts POST requests with a models.MigratedSession JSON
+// body and the migration secret in the hwebsocket.MigrationSecretHeader
+// header, and responds with a hwebsocket.MigrationResult. Imports are
+// disabled when the migrator has no secret.
+func HandleSessionImport(migrator *hwebsocket.Migrator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		secret := r.Header.Get(hwebsocket.MigrationSecretHeader)
+		if migrator.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(migrator.Secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var migration models.MigratedSession
+		if err := json.NewDecoder(r.Body).Decode(&migration); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res, err := migrator.Import(r.Context(), migration)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.IsType(err, models.ErrTypeInvalidMigration):
+				status = http.StatusBadRequest
+
+			case errors.IsType(err, models.ErrTypeSessionNameTaken):
+				status = http.StatusConflict
+			}
+
+			writeJSON(w, status, struct {
+				Error string `json:"error"`
+			}{
+				Error: errors.Message(err),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
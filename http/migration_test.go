@@ -0,0 +1,180 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	hwebsocket "github.com/aukilabs/hagall/websocket"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMigrator(t *testing.T, secret string) *hwebsocket.Migrator {
+	sessions := &models.SessionStore{}
+	t.Cleanup(func() {
+		for _, session := range sessions.List() {
+			sessions.Remove(context.Background(), session)
+		}
+	})
+
+	return &hwebsocket.Migrator{
+		Handler: &hwebsocket.RealtimeHandler{
+			FrameDuration: time.Millisecond * 10,
+			Sessions:      sessions,
+		},
+		ResumeTimeout: time.Minute,
+		Secret:        secret,
+	}
+}
+
+func TestHandleSessionMigration(t *testing.T) {
+	importer := newTestMigrator(t, "secret")
+	mux := http.NewServeMux()
+	mux.HandleFunc(hwebsocket.MigrationImportPath, HandleSessionImport(importer))
+	peer := httptest.NewServer(mux)
+	defer peer.Close()
+
+	exporter := newTestMigrator(t, "secret")
+	handler := HandleSessionMigration(exporter, hwebsocket.MigrationPeer{
+		AdminEndpoint: peer.URL,
+		Endpoint:      "wss://hagall.example.com",
+	})
+
+	migrate := func(method, query string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/sessions/migrate"+query, nil))
+		return w.Code
+	}
+
+	t.Run("session is migrated", func(t *testing.T) {
+		session := newTestSession(t, exporter.Handler.Sessions)
+		session.SetInfo(models.SessionInfo{Name: "Lobby"})
+
+		code := migrate(http.MethodPost, "?session_id="+exporter.Handler.Sessions.GlobalSessionID(session.ID))
+		require.Equal(t, http.StatusNoContent, code)
+
+		_, ok := importer.Handler.Sessions.GetByName("", "Lobby")
+		require.True(t, ok)
+	})
+
+	t.Run("unknown session is not found", func(t *testing.T) {
+		code := migrate(http.MethodPost, "?session_id=unknown")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("peer without endpoint is rejected", func(t *testing.T) {
+		handler := HandleSessionMigration(exporter, hwebsocket.MigrationPeer{})
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/sessions/migrate?admin_endpoint="+peer.URL, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("failed import is a bad gateway", func(t *testing.T) {
+		session := newTestSession(t, exporter.Handler.Sessions)
+
+		code := migrate(http.MethodPost, "?admin_endpoint="+peer.URL+"/unknown&session_id="+exporter.Handler.Sessions.GlobalSessionID(session.ID))
+		require.Equal(t, http.StatusBadGateway, code)
+		require.False(t, session.Settings().Locked)
+	})
+
+	t.Run("only post is allowed", func(t *testing.T) {
+		code := migrate(http.MethodGet, "")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
+
+func TestHandleSessionImport(t *testing.T) {
+	migrator := newTestMigrator(t, "secret")
+	handler := HandleSessionImport(migrator)
+
+	named := newTestSession(t, migrator.Handler.Sessions)
+	named.AppKey = "app"
+	named.SetInfo(models.SessionInfo{Name: "Lobby"})
+
+	importSession := func(secret string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, hwebsocket.MigrationImportPath, bytes.NewReader(body))
+		if secret != "" {
+			r.Header.Set(hwebsocket.MigrationSecretHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	encode := func(t *testing.T, migration models.MigratedSession) []byte {
+		body, err := json.Marshal(migration)
+		require.NoError(t, err)
+		return body
+	}
+
+	t.Run("session is imported", func(t *testing.T) {
+		w := importSession("secret", encode(t, models.MigratedSession{
+			AppKey:       "app",
+			Info:         models.SessionInfo{Name: "Tour"},
+			Participants: []models.MigratedParticipant{{ID: 1}},
+		}))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res hwebsocket.MigrationResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.NotEmpty(t, res.ResumeTokens[1])
+
+		session, ok := migrator.Handler.Sessions.GetByGlobalID(res.SessionID)
+		require.True(t, ok)
+		require.Equal(t, "Tour", session.Info().Name)
+	})
+
+	t.Run("wrong secret is unauthorized", func(t *testing.T) {
+		w := importSession("", encode(t, models.MigratedSession{}))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = importSession("other", encode(t, models.MigratedSession{}))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("import is unauthorized without migrator secret", func(t *testing.T) {
+		handler := HandleSessionImport(newTestMigrator(t, ""))
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, hwebsocket.MigrationImportPath, bytes.NewReader(encode(t, models.MigratedSession{}))))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("invalid body is rejected", func(t *testing.T) {
+		w := importSession("secret", []byte("{"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid migration is rejected", func(t *testing.T) {
+		w := importSession("secret", encode(t, models.MigratedSession{
+			Settings: models.SessionSettings{MaxParticipants: -1},
+		}))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("taken session name conflicts", func(t *testing.T) {
+		w := importSession("secret", encode(t, models.MigratedSession{
+			AppKey: "app",
+			Info:   models.SessionInfo{Name: "Lobby"},
+		}))
+		require.Equal(t, http.StatusConflict, w.Code)
+
+		var res struct {
+			Error string `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.NotEmpty(t, res.Error)
+	})
+
+	t.Run("only post is allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, hwebsocket.MigrationImportPath, nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"net/http"
+
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	"github.com/aukilabs/hagall/models"
+)
+
+// HandleSessionDiscovery returns a handler that lists the sessions that can be
+// joined with the app key of the caller user token, as JSON. Private sessions
+// are not listed.
+func HandleSessionDiscovery(sessions *models.SessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		appKey := httpcmn.GetAppKeyFromHagallUserToken(httpcmn.GetUserTokenFromHTTPRequest(r))
+
+		writeJSON(w, http.StatusOK, struct {
+			Sessions []models.SessionListing `json:"sessions"`
+		}{
+			Sessions: sessions.Discover(appKey),
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSessionDiscovery(t *testing.T) {
+	sessions := &models.SessionStore{}
+
+	public := newTestSession(t, sessions)
+	public.AppKey = "app"
+	public.SetInfo(models.SessionInfo{Name: "Lobby", Metadata: map[string]string{"map": "park"}})
+
+	private := newTestSession(t, sessions)
+	private.AppKey = "app"
+	private.SetInfo(models.SessionInfo{Private: true})
+
+	other := newTestSession(t, sessions)
+	other.AppKey = "other"
+
+	handler := HandleSessionDiscovery(sessions)
+
+	list := func(t *testing.T, method, appKey string) (int, []models.SessionListing) {
+		token, err := httpcmn.GenerateHagallUserAccessToken(appKey, "secret", time.Minute)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(method, "/sessions", nil)
+		r.Header.Set("Authorization", httpcmn.MakeAuthorizationHeader(token))
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var res struct {
+			Sessions []models.SessionListing `json:"sessions"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w.Code, res.Sessions
+	}
+
+	t.Run("public sessions of the app key are listed", func(t *testing.T) {
+		code, listings := list(t, http.MethodGet, "app")
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, listings, 1)
+		require.Equal(t, sessions.GlobalSessionID(public.ID), listings[0].ID)
+		require.Equal(t, "Lobby", listings[0].Name)
+		require.Equal(t, map[string]string{"map": "park"}, listings[0].Metadata)
+		require.Zero(t, listings[0].ParticipantCount)
+	})
+
+	t.Run("sessions of other app keys are not listed", func(t *testing.T) {
+		code, listings := list(t, http.MethodGet, "unknown")
+		require.Equal(t, http.StatusOK, code)
+		require.Empty(t, listings)
+	})
+
+	t.Run("only get is allowed", func(t *testing.T) {
+		code, _ := list(t, http.MethodPost, "app")
+		require.Equal(t, http.StatusMethodNotAllowed, code)
+	})
+}
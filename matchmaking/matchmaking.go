@@ -0,0 +1,260 @@
+// Package matchmaking groups clients that look for other participants and
+// creates a session for each formed group.
+package matchmaking
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+)
+
+const (
+	ErrTypeInvalidRequest = "matchmaking-invalid-request"
+	ErrTypeTimeout        = "matchmaking-timeout"
+
+	DefaultMaxGroupSize = 64
+	DefaultJoinTimeout  = time.Second * 30
+)
+
+// Request is a request to be grouped with other clients.
+type Request struct {
+	// The app key of the client. Clients are only grouped with clients of the
+	// same app key.
+	AppKey string
+
+	// The number of clients in the group, the client included.
+	GroupSize int
+
+	// Clients are only grouped with clients that have the same tags, such as
+	// a region or a game mode. Tag order doesn't matter.
+	Tags []string
+
+	// The client skill. It is ignored when the matchmaker max skill gap is
+	// zero.
+	Skill float64
+}
+
+// Match is the session formed for a group of clients.
+type Match struct {
+	// The global id of the session to join.
+	SessionID string
+
+	// The number of clients in the group.
+	GroupSize int
+}
+
+// Matchmaker queues matchmaking requests until enough compatible clients are
+// waiting to form a group, then creates a session for the group.
+//
+// Sessions are created empty and private. A session that nobody joined within
+// the join timeout is removed.
+type Matchmaker struct {
+	// The store where sessions are created.
+	Sessions *models.SessionStore
+
+	// Creates and starts the session of a formed group.
+	CreateSession func(ctx context.Context, appKey string, info models.SessionInfo) (*models.Session, error)
+
+	// The maximum skill difference between the clients of a group. Skill is
+	// ignored when zero.
+	MaxSkillGap float64
+
+	// The maximum number of clients in a group. Defaults to
+	// DefaultMaxGroupSize.
+	MaxGroupSize int
+
+	// The time clients have to join the session of their group. Defaults to
+	// DefaultJoinTimeout.
+	JoinTimeout time.Duration
+
+	mutex sync.Mutex
+	queue []*ticket
+}
+
+type ticket struct {
+	req    Request
+	key    string
+	result chan result
+}
+
+type result struct {
+	match Match
+	err   error
+}
+
+// Match queues the given request and waits until its group is formed. The
+// request is canceled when the context is done, in which case an error with
+// the ErrTypeTimeout type is returned on deadline.
+func (m *Matchmaker) Match(ctx context.Context, req Request) (Match, error) {
+	maxGroupSize := m.MaxGroupSize
+	if maxGroupSize == 0 {
+		maxGroupSize = DefaultMaxGroupSize
+	}
+	if req.GroupSize < 1 || req.GroupSize > maxGroupSize {
+		return Match{}, errors.New("invalid group size").
+			WithType(ErrTypeInvalidRequest).
+			WithTag("group_size", req.GroupSize).
+			WithTag("max_group_size", maxGroupSize)
+	}
+
+	t := &ticket{
+		req:    req,
+		key:    groupKey(req),
+		result: make(chan result, 1),
+	}
+
+	if group := m.enqueue(t); group != nil {
+		m.createGroupSession(group)
+	}
+
+	select {
+	case res := <-t.result:
+		return res.match, res.err
+
+	case <-ctx.Done():
+		if m.dequeue(t) {
+			if ctx.Err() == context.DeadlineExceeded {
+				return Match{}, errors.New("matchmaking timed out").
+					WithType(ErrTypeTimeout).
+					WithTag("group_size", req.GroupSize).
+					Wrap(ctx.Err())
+			}
+			return Match{}, ctx.Err()
+		}
+
+		// The group was formed concurrently.
+		res := <-t.result
+		return res.match, res.err
+	}
+}
+
+// Waiting returns the number of queued requests.
+func (m *Matchmaker) Waiting() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.queue)
+}
+
+// enqueue adds the given ticket to the queue. When it completes a group, the
+// tickets of the group are removed from the queue and returned.
+func (m *Matchmaker) enqueue(t *ticket) []*ticket {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	group := []*ticket{t}
+	for _, queued := range m.queue {
+		if len(group) == t.req.GroupSize {
+			break
+		}
+		if queued.key == t.key && m.skillMatches(group, queued) {
+			group = append(group, queued)
+		}
+	}
+
+	if len(group) < t.req.GroupSize {
+		m.queue = append(m.queue, t)
+		return nil
+	}
+
+	queue := m.queue[:0]
+	for _, queued := range m.queue {
+		if !containsTicket(group, queued) {
+			queue = append(queue, queued)
+		}
+	}
+	for i := len(queue); i < len(m.queue); i++ {
+		m.queue[i] = nil
+	}
+	m.queue = queue
+	return group
+}
+
+// dequeue removes the given ticket from the queue and reports whether it was
+// still queued.
+func (m *Matchmaker) dequeue(t *ticket) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, queued := range m.queue {
+		if queued == t {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matchmaker) skillMatches(group []*ticket, t *ticket) bool {
+	if m.MaxSkillGap == 0 {
+		return true
+	}
+
+	for _, member := range group {
+		if math.Abs(member.req.Skill-t.req.Skill) > m.MaxSkillGap {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Matchmaker) createGroupSession(group []*ticket) {
+	appKey := group[0].req.AppKey
+
+	// The session is created with a background context since it is shared by
+	// the clients of the group.
+	session, err := m.CreateSession(context.Background(), appKey, models.SessionInfo{Private: true})
+	if err != nil {
+		err = errors.New("creating matchmaking session failed").
+			WithTag("app_key", appKey).
+			Wrap(err)
+		for _, t := range group {
+			t.result <- result{err: err}
+		}
+		return
+	}
+
+	joinTimeout := m.JoinTimeout
+	if joinTimeout == 0 {
+		joinTimeout = DefaultJoinTimeout
+	}
+	time.AfterFunc(joinTimeout, func() {
+		if session.ParticipantCount() == 0 {
+			m.Sessions.Remove(context.Background(), session)
+		}
+	})
+
+	match := Match{
+		SessionID: m.Sessions.GlobalSessionID(session.ID),
+		GroupSize: len(group),
+	}
+	for _, t := range group {
+		t.result <- result{match: match}
+	}
+}
+
+// groupKey returns the key shared by the requests that can be grouped
+// together.
+func groupKey(req Request) string {
+	tags := append([]string(nil), req.Tags...)
+	sort.Strings(tags)
+
+	key := append([]string{req.AppKey, strconv.Itoa(req.GroupSize)}, tags...)
+	return strings.Join(key, "\x00")
+}
+
+func containsTicket(tickets []*ticket, t *ticket) bool {
+	for _, v := range tickets {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package matchmaking
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMatchmaker() *Matchmaker {
+	sessions := &models.SessionStore{}
+
+	return &Matchmaker{
+		Sessions: sessions,
+		CreateSession: func(ctx context.Context, appKey string, info models.SessionInfo) (*models.Session, error) {
+			session := models.NewSession(sessions.NewID(), time.Millisecond*10)
+			session.AppKey = appKey
+			session.SetInfo(info)
+			return session, sessions.Add(ctx, session)
+		},
+		JoinTimeout: time.Millisecond * 50,
+	}
+}
+
+func matchAll(t *testing.T, m *Matchmaker, timeout time.Duration, reqs ...Request) ([]Match, []error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	matches := make([]Match, len(reqs))
+	errs := make([]error, len(reqs))
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req Request) {
+			defer wg.Done()
+			matches[i], errs[i] = m.Match(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+	return matches, errs
+}
+
+func TestMatchmaker(t *testing.T) {
+	t.Run("group is formed", func(t *testing.T) {
+		m := newTestMatchmaker()
+
+		matches, errs := matchAll(t, m, time.Second,
+			Request{AppKey: "app", GroupSize: 2, Tags: []string{"eu", "coop"}},
+			Request{AppKey: "app", GroupSize: 2, Tags: []string{"coop", "eu"}},
+		)
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.NotEmpty(t, matches[0].SessionID)
+		require.Equal(t, matches[0], matches[1])
+		require.Equal(t, 2, matches[0].GroupSize)
+		require.Zero(t, m.Waiting())
+
+		session, ok := m.Sessions.GetByGlobalID(matches[0].SessionID)
+		require.True(t, ok)
+		require.Equal(t, "app", session.AppKey)
+		require.True(t, session.Info().Private)
+	})
+
+	t.Run("incompatible requests are not grouped", func(t *testing.T) {
+		m := newTestMatchmaker()
+
+		_, errs := matchAll(t, m, time.Millisecond*100,
+			Request{AppKey: "app", GroupSize: 2},
+			Request{AppKey: "other", GroupSize: 2},
+			Request{AppKey: "app", GroupSize: 3},
+			Request{AppKey: "app", GroupSize: 2, Tags: []string{"eu"}},
+		)
+		for _, err := range errs {
+			require.True(t, errors.IsType(err, ErrTypeTimeout))
+		}
+		require.Zero(t, m.Waiting())
+	})
+
+	t.Run("skill gap", func(t *testing.T) {
+		m := newTestMatchmaker()
+		m.MaxSkillGap = 100
+
+		matches, errs := matchAll(t, m, time.Millisecond*100,
+			Request{AppKey: "app", GroupSize: 2, Skill: 1000},
+			Request{AppKey: "app", GroupSize: 2, Skill: 2000},
+		)
+		require.True(t, errors.IsType(errs[0], ErrTypeTimeout))
+		require.True(t, errors.IsType(errs[1], ErrTypeTimeout))
+
+		matches, errs = matchAll(t, m, time.Second,
+			Request{AppKey: "app", GroupSize: 2, Skill: 1000},
+			Request{AppKey: "app", GroupSize: 2, Skill: 1050},
+		)
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.Equal(t, matches[0], matches[1])
+	})
+
+	t.Run("request is canceled", func(t *testing.T) {
+		m := newTestMatchmaker()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for m.Waiting() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		_, err := m.Match(ctx, Request{AppKey: "app", GroupSize: 2})
+		require.Equal(t, context.Canceled, err)
+		require.Zero(t, m.Waiting())
+	})
+
+	t.Run("invalid group size", func(t *testing.T) {
+		m := newTestMatchmaker()
+
+		_, err := m.Match(context.Background(), Request{AppKey: "app"})
+		require.True(t, errors.IsType(err, ErrTypeInvalidRequest))
+
+		_, err = m.Match(context.Background(), Request{AppKey: "app", GroupSize: DefaultMaxGroupSize + 1})
+		require.True(t, errors.IsType(err, ErrTypeInvalidRequest))
+	})
+
+	t.Run("unjoined session is removed", func(t *testing.T) {
+		m := newTestMatchmaker()
+
+		match, err := m.Match(context.Background(), Request{AppKey: "app", GroupSize: 1})
+		require.NoError(t, err)
+
+		_, ok := m.Sessions.GetByGlobalID(match.SessionID)
+		require.True(t, ok)
+
+		require.Eventually(t, func() bool {
+			_, ok := m.Sessions.GetByGlobalID(match.SessionID)
+			return !ok
+		}, time.Second, time.Millisecond*10)
+	})
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/segmentio/encoding/json"
+)
+
+// EntityComponentKind describes how the data of an entity component type is
+// stored and how concurrent updates are resolved.
+type EntityComponentKind int
+
+const (
+	// Opaque data where the last update wins.
+	EntityComponentKindOpaque EntityComponentKind = iota
+
+	// A last-writer-wins register. Data is a JSON encoded LWWRegister.
+	EntityComponentKindLWWRegister
+
+	// A positive-negative counter. Data is a JSON encoded Counter.
+	EntityComponentKindCounter
+
+	// An observed-remove set. Data is a JSON encoded ORSet.
+	EntityComponentKindORSet
+
+	// A map of last-writer-wins registers. Data is a JSON encoded LWWMap.
+	EntityComponentKindMap
+)
+
+// Error for when entity component data can't be decoded into the entity
+// component type kind.
+const ErrTypeEntityComponentInvalidData = "entity-component-invalid-data"
+
+// ParseEntityComponentKind returns the kind with the given name, as returned
+// by EntityComponentKind.String.
+func ParseEntityComponentKind(name string) (EntityComponentKind, error) {
+	for k := EntityComponentKindOpaque; k <= EntityComponentKindMap; k++ {
+		if k.String() == name {
+			return k, nil
+		}
+	}
+	return 0, errors.New("unknown entity component kind").WithTag("kind", name)
+}
+
+func (k EntityComponentKind) String() string {
+	switch k {
+	case EntityComponentKindOpaque:
+		return "opaque"
+	case EntityComponentKindLWWRegister:
+		return "lww-register"
+	case EntityComponentKindCounter:
+		return "counter"
+	case EntityComponentKindORSet:
+		return "or-set"
+	case EntityComponentKindMap:
+		return "map"
+	default:
+		return "unknown"
+	}
+}
+
+// LWWRegister is a register where the write with the highest timestamp wins.
+// Ties are broken by the highest replica id, then by the highest value.
+type LWWRegister struct {
+	Value     []byte `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Replica   uint32 `json:"replica"`
+}
+
+// Merge returns the register that wins between r and v.
+func (r LWWRegister) Merge(v LWWRegister) LWWRegister {
+	switch {
+	case v.Timestamp != r.Timestamp:
+		if v.Timestamp > r.Timestamp {
+			return v
+		}
+	case v.Replica != r.Replica:
+		if v.Replica > r.Replica {
+			return v
+		}
+	case bytes.Compare(v.Value, r.Value) > 0:
+		return v
+	}
+	return r
+}
+
+// Counter is a counter that supports increments and decrements. Each replica
+// only ever grows its own positive and negative totals.
+type Counter struct {
+	P map[uint32]uint64 `json:"p,omitempty"`
+	N map[uint32]uint64 `json:"n,omitempty"`
+}
+
+// Value returns the current counter value.
+func (c Counter) Value() int64 {
+	var v int64
+	for _, p := range c.P {
+		v += int64(p)
+	}
+	for _, n := range c.N {
+		v -= int64(n)
+	}
+	return v
+}
+
+// Merge returns a counter that holds, for each replica, the maximum of the
+// totals in c and v.
+func (c Counter) Merge(v Counter) Counter {
+	return Counter{
+		P: mergeMax(c.P, v.P),
+		N: mergeMax(c.N, v.N),
+	}
+}
+
+func mergeMax(a, b map[uint32]uint64) map[uint32]uint64 {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	res := make(map[uint32]uint64, len(a)+len(b))
+	for k, v := range a {
+		res[k] = v
+	}
+	for k, v := range b {
+		if v > res[k] {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// ORSet is an observed-remove set. An element is added with a unique tag and
+// removed by tombstoning the tags that were observed at removal time.
+type ORSet struct {
+	Adds    map[string][]string `json:"adds,omitempty"`
+	Removes map[string][]string `json:"removes,omitempty"`
+}
+
+// Contains reports whether the element is in the set.
+func (s ORSet) Contains(element string) bool {
+	removed := make(map[string]struct{}, len(s.Removes[element]))
+	for _, tag := range s.Removes[element] {
+		removed[tag] = struct{}{}
+	}
+
+	for _, tag := range s.Adds[element] {
+		if _, ok := removed[tag]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Elements returns the elements that are in the set.
+func (s ORSet) Elements() []string {
+	var elements []string
+	for element := range s.Adds {
+		if s.Contains(element) {
+			elements = append(elements, element)
+		}
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// Merge returns the union of the adds and removes of s and v.
+func (s ORSet) Merge(v ORSet) ORSet {
+	return ORSet{
+		Adds:    mergeTags(s.Adds, v.Adds),
+		Removes: mergeTags(s.Removes, v.Removes),
+	}
+}
+
+func mergeTags(a, b map[string][]string) map[string][]string {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	res := make(map[string][]string, len(a)+len(b))
+	for _, m := range []map[string][]string{a, b} {
+		for element, tags := range m {
+			for _, tag := range tags {
+				if !containsString(res[element], tag) {
+					res[element] = append(res[element], tag)
+				}
+			}
+		}
+	}
+
+	for _, tags := range res {
+		sort.Strings(tags)
+	}
+	return res
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// LWWMap is a map where each key is an independent last-writer-wins register.
+type LWWMap struct {
+	Entries map[string]LWWRegister `json:"entries,omitempty"`
+}
+
+// Merge returns a map where each key holds the winning register between m
+// and v.
+func (m LWWMap) Merge(v LWWMap) LWWMap {
+	if len(m.Entries) == 0 && len(v.Entries) == 0 {
+		return LWWMap{}
+	}
+
+	entries := make(map[string]LWWRegister, len(m.Entries)+len(v.Entries))
+	for k, r := range m.Entries {
+		entries[k] = r
+	}
+	for k, r := range v.Entries {
+		if current, ok := entries[k]; ok {
+			r = current.Merge(r)
+		}
+		entries[k] = r
+	}
+	return LWWMap{Entries: entries}
+}
+
+// mergeEntityComponentData merges the update data into the current data
+// according to the given kind. Opaque data is replaced by the update.
+func mergeEntityComponentData(kind EntityComponentKind, current, update []byte) ([]byte, error) {
+	switch kind {
+	case EntityComponentKindLWWRegister:
+		return mergeJSON(current, update, LWWRegister.Merge)
+
+	case EntityComponentKindCounter:
+		return mergeJSON(current, update, Counter.Merge)
+
+	case EntityComponentKindORSet:
+		return mergeJSON(current, update, ORSet.Merge)
+
+	case EntityComponentKindMap:
+		return mergeJSON(current, update, LWWMap.Merge)
+
+	default:
+		return update, nil
+	}
+}
+
+func mergeJSON[T any](current, update []byte, merge func(T, T) T) ([]byte, error) {
+	var a, b T
+
+	if len(current) != 0 {
+		if err := json.Unmarshal(current, &a); err != nil {
+			return nil, errors.New("decoding current entity component data failed").
+				WithType(ErrTypeEntityComponentInvalidData).
+				Wrap(err)
+		}
+	}
+
+	if len(update) != 0 {
+		if err := json.Unmarshal(update, &b); err != nil {
+			return nil, errors.New("decoding entity component data failed").
+				WithType(ErrTypeEntityComponentInvalidData).
+				Wrap(err)
+		}
+	}
+
+	return json.Marshal(merge(a, b))
+}
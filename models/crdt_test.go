@@ -0,0 +1,151 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEntityComponentKind(t *testing.T) {
+	for k := EntityComponentKindOpaque; k <= EntityComponentKindMap; k++ {
+		kind, err := ParseEntityComponentKind(k.String())
+		require.NoError(t, err)
+		require.Equal(t, k, kind)
+	}
+
+	_, err := ParseEntityComponentKind("counter:")
+	require.Error(t, err)
+}
+
+func TestLWWRegisterMerge(t *testing.T) {
+	a := LWWRegister{Value: []byte("a"), Timestamp: 1, Replica: 2}
+	b := LWWRegister{Value: []byte("b"), Timestamp: 2, Replica: 1}
+	c := LWWRegister{Value: []byte("c"), Timestamp: 2, Replica: 3}
+
+	require.Equal(t, b, a.Merge(b))
+	require.Equal(t, b, b.Merge(a))
+	require.Equal(t, c, b.Merge(c))
+	require.Equal(t, c, c.Merge(b))
+}
+
+func TestCounterMerge(t *testing.T) {
+	a := Counter{P: map[uint32]uint64{1: 3}}
+	b := Counter{P: map[uint32]uint64{1: 1, 2: 2}, N: map[uint32]uint64{2: 1}}
+
+	merged := a.Merge(b)
+	require.Equal(t, merged, b.Merge(a))
+	require.Equal(t, int64(4), merged.Value())
+}
+
+func TestORSetMerge(t *testing.T) {
+	a := ORSet{Adds: map[string][]string{"x": {"t1"}, "y": {"t2"}}}
+	b := ORSet{
+		Adds:    map[string][]string{"x": {"t3"}},
+		Removes: map[string][]string{"x": {"t1"}, "y": {"t2"}},
+	}
+
+	merged := a.Merge(b)
+	require.Equal(t, merged, b.Merge(a))
+	require.True(t, merged.Contains("x"))
+	require.False(t, merged.Contains("y"))
+	require.Equal(t, []string{"x"}, merged.Elements())
+}
+
+func TestLWWMapMerge(t *testing.T) {
+	a := LWWMap{Entries: map[string]LWWRegister{
+		"color": {Value: []byte("red"), Timestamp: 2},
+		"size":  {Value: []byte("big"), Timestamp: 1},
+	}}
+	b := LWWMap{Entries: map[string]LWWRegister{
+		"color": {Value: []byte("blue"), Timestamp: 1},
+		"size":  {Value: []byte("small"), Timestamp: 2},
+	}}
+
+	merged := a.Merge(b)
+	require.Equal(t, merged, b.Merge(a))
+	require.Equal(t, []byte("red"), merged.Entries["color"].Value)
+	require.Equal(t, []byte("small"), merged.Entries["size"].Value)
+}
+
+func TestEntityComponentStoreMerge(t *testing.T) {
+	var schemas EntityComponentSchemaRegistry
+	schemas.Register("score", EntityComponentSchema{Kind: EntityComponentKindCounter})
+	schemas.Register("tags", EntityComponentSchema{Kind: EntityComponentKindORSet})
+
+	newStore := func() *EntityComponentStore {
+		s := newEntityComponentStore()
+		s.SetSchemas(&schemas)
+		return s
+	}
+
+	t.Run("kinds are opt-in", func(t *testing.T) {
+		s := newStore()
+
+		kind, err := s.GetTypeKind(s.AddType("counter:score"))
+		require.NoError(t, err)
+		require.Equal(t, EntityComponentKindOpaque, kind)
+	})
+
+	t.Run("counter updates are merged", func(t *testing.T) {
+		s := newStore()
+		ectID := s.AddType("score")
+
+		kind, err := s.GetTypeKind(ectID)
+		require.NoError(t, err)
+		require.Equal(t, EntityComponentKindCounter, kind)
+
+		created, err := s.Create(&hagallpb.EntityComponent{
+			EntityComponentTypeId: ectID,
+			EntityId:              21,
+			Data:                  []byte(`{"p": {"1": 2}}`),
+		})
+		require.NoError(t, err)
+
+		stored, _, err := s.Get(ectID, 21)
+		require.NoError(t, err)
+		require.Equal(t, stored, created)
+		require.NotEqual(t, []byte(`{"p": {"1": 2}}`), created.Data)
+
+		ec, version, err := s.Apply(&hagallpb.EntityComponent{
+			EntityComponentTypeId: ectID,
+			EntityId:              21,
+			Data:                  []byte(`{"p":{"2":3},"n":{"1":1}}`),
+		})
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), version)
+
+		var counter Counter
+		err = json.Unmarshal(ec.Data, &counter)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), counter.Value())
+	})
+
+	t.Run("invalid data is rejected", func(t *testing.T) {
+		s := newStore()
+		ectID := s.AddType("tags")
+
+		err := s.Add(&hagallpb.EntityComponent{
+			EntityComponentTypeId: ectID,
+			EntityId:              21,
+			Data:                  []byte("hello"),
+		})
+		require.Error(t, err)
+		require.Equal(t, ErrTypeEntityComponentInvalidData, errors.Type(err))
+	})
+
+	t.Run("adding a type with another kind returns an error", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID, err := s.AddTypeWithKind("score", EntityComponentKindCounter)
+		require.NoError(t, err)
+
+		id, err := s.AddTypeWithKind("score", EntityComponentKindCounter)
+		require.NoError(t, err)
+		require.Equal(t, ectID, id)
+
+		_, err = s.AddTypeWithKind("score", EntityComponentKindMap)
+		require.Error(t, err)
+	})
+}
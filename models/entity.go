@@ -2,6 +2,7 @@ package models
 
 import (
 	"sync"
+	"time"
 
 	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/hagallpb"
@@ -74,6 +75,10 @@ func (p Pose) ToProtobuf() *hagallpb.Pose {
 	}
 }
 
+// Error for when a conditional entity component update or delete is made
+// against a version that is not the current one.
+const ErrTypeEntityComponentVersionConflict = "entity-component-version-conflict"
+
 type EntityComponentHandler func([]uint32)
 
 type EntityComponentStore struct {
@@ -81,21 +86,43 @@ type EntityComponentStore struct {
 	ids              SequentialIDGenerator
 	nameIndex        map[uint32]string
 	idIndex          map[string]uint32
+	kinds            map[uint32]EntityComponentKind
 	entityComponents map[uint32]map[uint32]*hagallpb.EntityComponent
+	versions         map[uint32]map[uint32]uint64
+	schemas          *EntityComponentSchemaRegistry
+
+	historyMutex sync.RWMutex
+	historySize  int
+	historySeq   uint64
+	history      map[uint32]map[uint32][]EntityComponentChange
 
 	subscriptionMutex sync.RWMutex
-	subscriptions     map[uint32]map[uint32]struct{}
+	subscriptions     map[uint32]map[uint32]*entityComponentSubscription
 }
 
 func newEntityComponentStore() *EntityComponentStore {
 	return &EntityComponentStore{
 		nameIndex:        make(map[uint32]string),
 		idIndex:          make(map[string]uint32),
+		kinds:            make(map[uint32]EntityComponentKind),
 		entityComponents: make(map[uint32]map[uint32]*hagallpb.EntityComponent),
-		subscriptions:    make(map[uint32]map[uint32]struct{}),
+		versions:         make(map[uint32]map[uint32]uint64),
+		subscriptions:    make(map[uint32]map[uint32]*entityComponentSubscription),
 	}
 }
 
+// SetSchemas sets the registry used to validate entity component data by type
+// name.
+func (s *EntityComponentStore) SetSchemas(r *EntityComponentSchemaRegistry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.schemas = r
+}
+
+// AddType adds an entity component type and returns its id. The type kind is
+// the one of the schema registered for its name, or opaque when there is none.
+// Adding an already added type returns the existing id.
 func (s *EntityComponentStore) AddType(name string) uint32 {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -104,12 +131,52 @@ func (s *EntityComponentStore) AddType(name string) uint32 {
 		return eaID
 	}
 
+	kind := EntityComponentKindOpaque
+	if schema, ok := s.schemas.Lookup(name); ok {
+		kind = schema.Kind
+	}
+	return s.addType(name, kind)
+}
+
+// AddTypeWithKind adds an entity component type with the given kind and
+// returns its id. An error is returned when the type is already added with a
+// different kind.
+func (s *EntityComponentStore) AddTypeWithKind(name string, kind EntityComponentKind) (uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if eaID, ok := s.idIndex[name]; ok {
+		if s.kinds[eaID] != kind {
+			return 0, errors.New("entity component type is already added with another kind").
+				WithType(hwebsocket.ErrEntityComponentTypeAlreadyAdded).
+				WithTag("name", name).
+				WithTag("kind", kind).
+				WithTag("current_kind", s.kinds[eaID])
+		}
+		return eaID, nil
+	}
+	return s.addType(name, kind), nil
+}
+
+func (s *EntityComponentStore) addType(name string, kind EntityComponentKind) uint32 {
 	id := s.ids.New()
 	s.nameIndex[id] = name
 	s.idIndex[name] = id
+	s.kinds[id] = kind
 	return id
 }
 
+// GetTypeKind returns the kind of an entity component type.
+func (s *EntityComponentStore) GetTypeKind(entityComponentTypeID uint32) (EntityComponentKind, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, ok := s.nameIndex[entityComponentTypeID]; !ok {
+		return 0, errors.New("entity component type is not added").WithTag("id", entityComponentTypeID)
+	}
+	return s.kinds[entityComponentTypeID], nil
+}
+
 func (s *EntityComponentStore) GetTypeName(entityComponentTypeID uint32) (string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -133,42 +200,89 @@ func (s *EntityComponentStore) GetTypeID(entityComponentTypeName string) (uint32
 }
 
 func (s *EntityComponentStore) Add(ec *hagallpb.EntityComponent) error {
+	_, err := s.Create(ec)
+	return err
+}
+
+// Create adds the entity component and returns the stored entity component,
+// whose data is merged into an empty state for mergeable kinds.
+func (s *EntityComponentStore) Create(ec *hagallpb.EntityComponent) (*hagallpb.EntityComponent, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	if _, ok := s.nameIndex[ec.EntityComponentTypeId]; !ok {
-		return errors.New("entity component type is not added").
+		return nil, errors.New("entity component type is not added").
 			WithType(hwebsocket.ErrEntityComponentTypeNotAdded).
 			WithTag("id", ec.EntityComponentTypeId)
 	}
 
 	if _, ok := s.entityComponents[ec.EntityComponentTypeId]; !ok {
 		s.entityComponents[ec.EntityComponentTypeId] = make(map[uint32]*hagallpb.EntityComponent)
+		s.versions[ec.EntityComponentTypeId] = make(map[uint32]uint64)
 	}
 
 	if _, ok := s.entityComponents[ec.EntityComponentTypeId][ec.EntityId]; ok {
-		return errors.New("entity component is already added").
+		return nil, errors.New("entity component is already added").
 			WithType(hwebsocket.ErrEntityComponentTypeAlreadyAdded).
 			WithTag("id", ec.EntityComponentTypeId).
 			WithTag("entity_id", ec.EntityId)
 	}
+
+	ec, err := s.merge(nil, ec)
+	if err != nil {
+		return nil, err
+	}
 	s.entityComponents[ec.EntityComponentTypeId][ec.EntityId] = ec
+	s.versions[ec.EntityComponentTypeId][ec.EntityId] = 1
 
-	return nil
+	return ec, nil
 }
 
 func (s *EntityComponentStore) Delete(entityComponentTypeID uint32, entityID uint32) bool {
+	_, _, ok := s.Remove(entityComponentTypeID, entityID)
+	return ok
+}
+
+// Remove deletes the entity component and returns its last state and version.
+func (s *EntityComponentStore) Remove(entityComponentTypeID uint32, entityID uint32) (*hagallpb.EntityComponent, uint64, bool) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	entityComponents, ok := s.entityComponents[entityComponentTypeID]
-	if !ok {
-		return false
+	ec, version, err := s.get(entityComponentTypeID, entityID)
+	if err != nil {
+		return nil, 0, false
 	}
 
-	_, ok = entityComponents[entityID]
-	delete(entityComponents, entityID)
-	return ok
+	delete(s.entityComponents[entityComponentTypeID], entityID)
+	delete(s.versions[entityComponentTypeID], entityID)
+	return ec, version, true
+}
+
+// DeleteIfVersion deletes the entity component only when its current version
+// matches the given one. When versions differ, an error typed
+// ErrTypeEntityComponentVersionConflict is returned along with the current
+// entity component and its version.
+func (s *EntityComponentStore) DeleteIfVersion(entityComponentTypeID, entityID uint32, version uint64) (*hagallpb.EntityComponent, uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, currentVersion, err := s.get(entityComponentTypeID, entityID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if currentVersion != version {
+		return current, currentVersion, errors.New("entity component version conflict").
+			WithType(ErrTypeEntityComponentVersionConflict).
+			WithTag("id", entityComponentTypeID).
+			WithTag("entity_id", entityID).
+			WithTag("version", version).
+			WithTag("current_version", currentVersion)
+	}
+
+	delete(s.entityComponents[entityComponentTypeID], entityID)
+	delete(s.versions[entityComponentTypeID], entityID)
+	return current, currentVersion, nil
 }
 
 func (s *EntityComponentStore) DeleteByEntityID(entityID uint32) {
@@ -178,28 +292,128 @@ func (s *EntityComponentStore) DeleteByEntityID(entityID uint32) {
 	for _, ecs := range s.entityComponents {
 		delete(ecs, entityID)
 	}
+	for _, versions := range s.versions {
+		delete(versions, entityID)
+	}
+
+	s.deleteHistoryByEntityID(entityID)
 }
 
+// Update updates the entity component and increments its version.
 func (s *EntityComponentStore) Update(ec *hagallpb.EntityComponent) error {
+	_, _, err := s.Apply(ec)
+	return err
+}
+
+// Apply updates the entity component and returns the stored entity component
+// with its new version. Data of mergeable kinds is merged with the current
+// data, other data is replaced.
+func (s *EntityComponentStore) Apply(ec *hagallpb.EntityComponent) (*hagallpb.EntityComponent, uint64, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	entityComponents, ok := s.entityComponents[ec.EntityComponentTypeId]
-	if !ok {
-		return errors.New("entity component has not been added").
+	current, _, err := s.get(ec.EntityComponentTypeId, ec.EntityId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	merged, err := s.merge(current, ec)
+	if err != nil {
+		return nil, 0, err
+	}
+	return merged, s.set(merged), nil
+}
+
+// UpdateIfVersion updates the entity component only when its current version
+// matches the given one and returns the stored entity component with its new
+// version. When versions differ, an error typed
+// ErrTypeEntityComponentVersionConflict is returned along with the current
+// entity component and its version.
+func (s *EntityComponentStore) UpdateIfVersion(ec *hagallpb.EntityComponent, version uint64) (*hagallpb.EntityComponent, uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, currentVersion, err := s.get(ec.EntityComponentTypeId, ec.EntityId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if currentVersion != version {
+		return current, currentVersion, errors.New("entity component version conflict").
+			WithType(ErrTypeEntityComponentVersionConflict).
 			WithTag("id", ec.EntityComponentTypeId).
-			WithTag("entity_id", ec.EntityId)
+			WithTag("entity_id", ec.EntityId).
+			WithTag("version", version).
+			WithTag("current_version", currentVersion)
+	}
+
+	merged, err := s.merge(current, ec)
+	if err != nil {
+		return nil, 0, err
 	}
+	return merged, s.set(merged), nil
+}
 
-	_, ok = entityComponents[ec.EntityId]
+// Get returns the entity component with its current version.
+func (s *EntityComponentStore) Get(entityComponentTypeID, entityID uint32) (*hagallpb.EntityComponent, uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.get(entityComponentTypeID, entityID)
+}
+
+func (s *EntityComponentStore) get(entityComponentTypeID, entityID uint32) (*hagallpb.EntityComponent, uint64, error) {
+	ec, ok := s.entityComponents[entityComponentTypeID][entityID]
 	if !ok {
-		return errors.New("entity component has not been added").
+		return nil, 0, errors.New("entity component has not been added").
+			WithTag("id", entityComponentTypeID).
+			WithTag("entity_id", entityID)
+	}
+	return ec, s.versions[entityComponentTypeID][entityID], nil
+}
+
+func (s *EntityComponentStore) merge(current, ec *hagallpb.EntityComponent) (*hagallpb.EntityComponent, error) {
+	if schema, ok := s.schemas.Lookup(s.nameIndex[ec.EntityComponentTypeId]); ok {
+		if err := schema.Validate(ec.Data); err != nil {
+			return nil, errors.New("validating entity component failed").
+				WithType(ErrTypeEntityComponentInvalidData).
+				WithTag("id", ec.EntityComponentTypeId).
+				WithTag("entity_id", ec.EntityId).
+				Wrap(err)
+		}
+	}
+
+	kind := s.kinds[ec.EntityComponentTypeId]
+	if kind == EntityComponentKindOpaque {
+		return ec, nil
+	}
+
+	var currentData []byte
+	if current != nil {
+		currentData = current.Data
+	}
+
+	data, err := mergeEntityComponentData(kind, currentData, ec.Data)
+	if err != nil {
+		return nil, errors.New("merging entity component failed").
+			WithType(ErrTypeEntityComponentInvalidData).
 			WithTag("id", ec.EntityComponentTypeId).
-			WithTag("entity_id", ec.EntityId)
+			WithTag("entity_id", ec.EntityId).
+			WithTag("kind", kind).
+			Wrap(err)
 	}
 
+	return &hagallpb.EntityComponent{
+		EntityComponentTypeId: ec.EntityComponentTypeId,
+		EntityId:              ec.EntityId,
+		Data:                  data,
+	}, nil
+}
+
+func (s *EntityComponentStore) set(ec *hagallpb.EntityComponent) uint64 {
 	s.entityComponents[ec.EntityComponentTypeId][ec.EntityId] = ec
-	return nil
+	s.versions[ec.EntityComponentTypeId][ec.EntityId]++
+	return s.versions[ec.EntityComponentTypeId][ec.EntityId]
 }
 
 func (s *EntityComponentStore) List(entityComponentTypeID uint32) []*hagallpb.EntityComponent {
@@ -244,6 +458,12 @@ func (s *EntityComponentStore) ListAll() []*hagallpb.EntityComponent {
 }
 
 func (s *EntityComponentStore) Subscribe(entityComponentTypeID, participantID uint32) error {
+	return s.SubscribeWithOptions(entityComponentTypeID, participantID, EntityComponentSubscriptionOptions{})
+}
+
+// SubscribeWithOptions subscribes a participant to an entity component type
+// with the given options. Subscribing again replaces the previous options.
+func (s *EntityComponentStore) SubscribeWithOptions(entityComponentTypeID, participantID uint32, opts EntityComponentSubscriptionOptions) error {
 	s.subscriptionMutex.Lock()
 	defer s.subscriptionMutex.Unlock()
 
@@ -257,9 +477,9 @@ func (s *EntityComponentStore) Subscribe(entityComponentTypeID, participantID ui
 	}
 
 	if _, ok := s.subscriptions[entityComponentTypeID]; !ok {
-		s.subscriptions[entityComponentTypeID] = make(map[uint32]struct{})
+		s.subscriptions[entityComponentTypeID] = make(map[uint32]*entityComponentSubscription)
 	}
-	s.subscriptions[entityComponentTypeID][participantID] = struct{}{}
+	s.subscriptions[entityComponentTypeID][participantID] = newEntityComponentSubscription(opts)
 	return nil
 }
 
@@ -283,6 +503,8 @@ func (s *EntityComponentStore) UnsubscribeByParticipant(participantID uint32) {
 	}
 }
 
+// Notify calls h with the ids of all the participants subscribed to the given
+// entity component type.
 func (s *EntityComponentStore) Notify(entityComponentTypeID uint32, h EntityComponentHandler) {
 	s.subscriptionMutex.RLock()
 	defer s.subscriptionMutex.RUnlock()
@@ -299,3 +521,113 @@ func (s *EntityComponentStore) Notify(entityComponentTypeID uint32, h EntityComp
 
 	h(participantIDs)
 }
+
+// NotifyEntityUpdate calls h with the ids of the participants subscribed to the
+// given entity component type that follow the given entity and are not
+// throttled. Throttled participants get the latest entity component state on a
+// subsequent call to FlushThrottled.
+func (s *EntityComponentStore) NotifyEntityUpdate(entityComponentTypeID, entityID uint32, now time.Time, h EntityComponentHandler) {
+	s.subscriptionMutex.Lock()
+	defer s.subscriptionMutex.Unlock()
+
+	var participantIDs []uint32
+	for participantID, sub := range s.subscriptions[entityComponentTypeID] {
+		if !sub.follows(entityID) {
+			continue
+		}
+
+		if !sub.allow(now) {
+			sub.pending[entityID] = struct{}{}
+			continue
+		}
+
+		delete(sub.pending, entityID)
+		participantIDs = append(participantIDs, participantID)
+	}
+
+	if len(participantIDs) != 0 {
+		h(participantIDs)
+	}
+}
+
+// FlushThrottled calls h with the latest state of the entity components whose
+// updates were held back by a subscription throttle, for each subscription
+// that is allowed to be notified again.
+func (s *EntityComponentStore) FlushThrottled(now time.Time, h func(participantID uint32, ec *hagallpb.EntityComponent)) {
+	s.subscriptionMutex.Lock()
+	defer s.subscriptionMutex.Unlock()
+
+	for entityComponentTypeID, subscriptions := range s.subscriptions {
+		for participantID, sub := range subscriptions {
+			if len(sub.pending) == 0 || !sub.allow(now) {
+				continue
+			}
+
+			for entityID := range sub.pending {
+				delete(sub.pending, entityID)
+
+				if ec, _, err := s.Get(entityComponentTypeID, entityID); err == nil {
+					h(participantID, ec)
+				}
+			}
+		}
+	}
+}
+
+// EntityComponentSubscriptionOptions represents the options of a subscription
+// to an entity component type.
+type EntityComponentSubscriptionOptions struct {
+	// The ids of the entities to follow. All entities are followed when empty.
+	EntityIDs []uint32
+
+	// The maximum number of update notifications per second. Updates are not
+	// throttled when zero.
+	MaxUpdateRate float64
+}
+
+type entityComponentSubscription struct {
+	entityIDs   map[uint32]struct{}
+	minInterval time.Duration
+	lastNotify  time.Time
+	pending     map[uint32]struct{}
+}
+
+func newEntityComponentSubscription(opts EntityComponentSubscriptionOptions) *entityComponentSubscription {
+	sub := &entityComponentSubscription{
+		pending: make(map[uint32]struct{}),
+	}
+
+	if len(opts.EntityIDs) != 0 {
+		sub.entityIDs = make(map[uint32]struct{}, len(opts.EntityIDs))
+		for _, id := range opts.EntityIDs {
+			sub.entityIDs[id] = struct{}{}
+		}
+	}
+
+	if opts.MaxUpdateRate > 0 {
+		sub.minInterval = time.Duration(float64(time.Second) / opts.MaxUpdateRate)
+	}
+	return sub
+}
+
+func (s *entityComponentSubscription) follows(entityID uint32) bool {
+	if s.entityIDs == nil {
+		return true
+	}
+
+	_, ok := s.entityIDs[entityID]
+	return ok
+}
+
+func (s *entityComponentSubscription) allow(now time.Time) bool {
+	if s.minInterval == 0 {
+		return true
+	}
+
+	if now.Sub(s.lastNotify) < s.minInterval {
+		return false
+	}
+
+	s.lastNotify = now
+	return true
+}
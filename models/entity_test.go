@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/hagallpb"
@@ -436,3 +437,154 @@ func TestEntityComponentStoreNotify(t *testing.T) {
 		require.True(t, isHandlerCalled)
 	})
 }
+
+func TestEntityComponentStoreVersions(t *testing.T) {
+	t.Run("added entity component starts at version 1", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+
+		err := s.Add(&hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21})
+		require.NoError(t, err)
+
+		_, version, err := s.Get(ectID, 21)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), version)
+	})
+
+	t.Run("update increments the version", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21}
+		require.NoError(t, s.Add(ec))
+		require.NoError(t, s.Update(ec))
+		require.NoError(t, s.Update(ec))
+
+		_, version, err := s.Get(ectID, 21)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), version)
+	})
+
+	t.Run("conditional update with current version succeeds", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+		require.NoError(t, s.Add(&hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21}))
+
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21, Data: []byte("bye")}
+		current, version, err := s.UpdateIfVersion(ec, 1)
+		require.NoError(t, err)
+		require.Equal(t, ec, current)
+		require.Equal(t, uint64(2), version)
+	})
+
+	t.Run("conditional update with stale version returns a conflict", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21, Data: []byte("hi")}
+		require.NoError(t, s.Add(ec))
+		require.NoError(t, s.Update(ec))
+
+		current, version, err := s.UpdateIfVersion(&hagallpb.EntityComponent{
+			EntityComponentTypeId: ectID,
+			EntityId:              21,
+			Data:                  []byte("bye"),
+		}, 1)
+		require.Error(t, err)
+		require.Equal(t, ErrTypeEntityComponentVersionConflict, errors.Type(err))
+		require.Equal(t, ec, current)
+		require.Equal(t, uint64(2), version)
+	})
+
+	t.Run("conditional update of a nonexisting entity component returns an error", func(t *testing.T) {
+		s := newEntityComponentStore()
+		_, _, err := s.UpdateIfVersion(&hagallpb.EntityComponent{EntityComponentTypeId: 42, EntityId: 21}, 1)
+		require.Error(t, err)
+	})
+
+	t.Run("conditional delete with stale version returns a conflict", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21}
+		require.NoError(t, s.Add(ec))
+		require.NoError(t, s.Update(ec))
+
+		_, version, err := s.DeleteIfVersion(ectID, 21, 1)
+		require.Error(t, err)
+		require.Equal(t, ErrTypeEntityComponentVersionConflict, errors.Type(err))
+		require.Equal(t, uint64(2), version)
+		require.Len(t, s.List(ectID), 1)
+	})
+
+	t.Run("conditional delete with current version succeeds", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+		require.NoError(t, s.Add(&hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21}))
+
+		_, _, err := s.DeleteIfVersion(ectID, 21, 1)
+		require.NoError(t, err)
+		require.Empty(t, s.List(ectID))
+
+		_, _, err = s.Get(ectID, 21)
+		require.Error(t, err)
+	})
+
+	t.Run("re-added entity component restarts at version 1", func(t *testing.T) {
+		s := newEntityComponentStore()
+		ectID := s.AddType("foo")
+
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21}
+		require.NoError(t, s.Add(ec))
+		require.NoError(t, s.Update(ec))
+		require.True(t, s.Delete(ectID, 21))
+		require.NoError(t, s.Add(ec))
+
+		_, version, err := s.Get(ectID, 21)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), version)
+	})
+}
+
+func TestEntityComponentStoreNotifyEntityUpdate(t *testing.T) {
+	s := newEntityComponentStore()
+	ectID := s.AddType("hello")
+
+	err := s.Add(&hagallpb.EntityComponent{EntityComponentTypeId: ectID, EntityId: 21})
+	require.NoError(t, err)
+
+	err = s.SubscribeWithOptions(ectID, 88, EntityComponentSubscriptionOptions{
+		MaxUpdateRate: 2,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	notify := func(at time.Time) []uint32 {
+		var participantIDs []uint32
+		s.NotifyEntityUpdate(ectID, 21, at, func(ids []uint32) {
+			participantIDs = ids
+		})
+		return participantIDs
+	}
+
+	require.Equal(t, []uint32{88}, notify(now))
+	require.Empty(t, notify(now.Add(time.Millisecond*100)))
+
+	var flushed []*hagallpb.EntityComponent
+	flush := func(at time.Time) {
+		s.FlushThrottled(at, func(participantID uint32, ec *hagallpb.EntityComponent) {
+			require.Equal(t, uint32(88), participantID)
+			flushed = append(flushed, ec)
+		})
+	}
+
+	flush(now.Add(time.Millisecond * 200))
+	require.Empty(t, flushed)
+
+	flush(now.Add(time.Millisecond * 500))
+	require.Len(t, flushed, 1)
+	require.Equal(t, uint32(21), flushed[0].EntityId)
+
+	flush(now.Add(time.Second * 2))
+	require.Len(t, flushed, 1)
+}
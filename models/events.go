@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+)
+
+// SessionEventType describes a session lifecycle event.
+type SessionEventType string
+
+const (
+	SessionEventSessionCreated     SessionEventType = "session_created"
+	SessionEventSessionClosed      SessionEventType = "session_closed"
+	SessionEventParticipantJoined  SessionEventType = "participant_joined"
+	SessionEventParticipantLeft    SessionEventType = "participant_left"
+	SessionEventParticipantUpdated SessionEventType = "participant_updated"
+	SessionEventHostChanged        SessionEventType = "host_changed"
+	SessionEventEntityAdded        SessionEventType = "entity_added"
+	SessionEventEntityRemoved      SessionEventType = "entity_removed"
+)
+
+// SessionEvent represents a session lifecycle event.
+type SessionEvent struct {
+	Type          SessionEventType `json:"type"`
+	Time          time.Time        `json:"time"`
+	SessionID     string           `json:"session_id"`
+	SessionUUID   string           `json:"session_uuid"`
+	AppKey        string           `json:"app_key,omitempty"`
+	ParticipantID uint32           `json:"participant_id,omitempty"`
+	Spectator     bool             `json:"spectator,omitempty"`
+	Participant   *ParticipantInfo `json:"participant,omitempty"`
+	Presence      Presence         `json:"presence,omitempty"`
+	Reason        LeaveReason      `json:"reason,omitempty"`
+	EntityID      uint32           `json:"entity_id,omitempty"`
+}
+
+// SessionEventHandler is a function called when a session lifecycle event
+// occurs. It is called synchronously and must not block.
+type SessionEventHandler func(SessionEvent)
+
+// HandleEvents registers a handler that is called for every session lifecycle
+// event.
+func (s *SessionStore) HandleEvents(h SessionEventHandler) (cancel func()) {
+	s.eventMutex.Lock()
+	defer s.eventMutex.Unlock()
+
+	if s.eventHandlers == nil {
+		s.eventHandlers = make(map[uint32]SessionEventHandler)
+	}
+
+	id := s.eventHandlerIDs.New()
+	s.eventHandlers[id] = h
+
+	return func() {
+		s.eventMutex.Lock()
+		defer s.eventMutex.Unlock()
+
+		delete(s.eventHandlers, id)
+		s.eventHandlerIDs.Reuse(id)
+	}
+}
+
+// Publish sends a lifecycle event of the given session to the registered event
+// handlers. Session fields and time are set from the session.
+func (s *SessionStore) Publish(session *Session, e SessionEvent) {
+	s.eventMutex.RLock()
+	defer s.eventMutex.RUnlock()
+
+	if len(s.eventHandlers) == 0 {
+		return
+	}
+
+	e.Time = time.Now()
+	e.SessionID = s.GlobalSessionID(session.ID)
+	e.SessionUUID = session.SessionUUID
+	e.AppKey = session.AppKey
+
+	for _, h := range s.eventHandlers {
+		h(e)
+	}
+}
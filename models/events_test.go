@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionStoreEvents(t *testing.T) {
+	var store SessionStore
+
+	var events []SessionEvent
+	cancel := store.HandleEvents(func(e SessionEvent) {
+		events = append(events, e)
+	})
+
+	session := NewSession(store.NewID(), time.Minute)
+	session.AppKey = "app"
+
+	err := store.Add(context.Background(), session)
+	require.NoError(t, err)
+
+	store.Publish(session, SessionEvent{
+		Type:          SessionEventParticipantJoined,
+		ParticipantID: 1,
+	})
+
+	store.Remove(context.Background(), session)
+
+	require.Len(t, events, 3)
+	require.Equal(t, SessionEventSessionCreated, events[0].Type)
+	require.Equal(t, SessionEventParticipantJoined, events[1].Type)
+	require.Equal(t, uint32(1), events[1].ParticipantID)
+	require.Equal(t, SessionEventSessionClosed, events[2].Type)
+
+	for _, e := range events {
+		require.Equal(t, store.GlobalSessionID(session.ID), e.SessionID)
+		require.Equal(t, session.SessionUUID, e.SessionUUID)
+		require.Equal(t, "app", e.AppKey)
+		require.NotZero(t, e.Time)
+	}
+
+	cancel()
+	store.Publish(session, SessionEvent{Type: SessionEventParticipantLeft})
+	require.Len(t, events, 3)
+}
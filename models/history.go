@@ -0,0 +1,131 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+)
+
+// EntityComponentOp describes an operation made on an entity component.
+type EntityComponentOp string
+
+const (
+	EntityComponentOpAdd    EntityComponentOp = "add"
+	EntityComponentOpUpdate EntityComponentOp = "update"
+	EntityComponentOpDelete EntityComponentOp = "delete"
+)
+
+// EntityComponentChange represents a change made on an entity component.
+type EntityComponentChange struct {
+	// The position of the change in the session history. Set when the change
+	// is recorded.
+	Sequence uint64 `json:"sequence"`
+
+	Op                    EntityComponentOp `json:"op"`
+	EntityComponentTypeID uint32            `json:"entity_component_type_id"`
+	EntityID              uint32            `json:"entity_id"`
+	Data                  []byte            `json:"data,omitempty"`
+	Version               uint64            `json:"version"`
+	ParticipantID         uint32            `json:"participant_id"`
+	Time                  time.Time         `json:"time"`
+}
+
+// NewEntityComponentChange creates a change that records the given entity
+// component state.
+func NewEntityComponentChange(op EntityComponentOp, ec *hagallpb.EntityComponent, version uint64, participantID uint32) EntityComponentChange {
+	return EntityComponentChange{
+		Op:                    op,
+		EntityComponentTypeID: ec.EntityComponentTypeId,
+		EntityID:              ec.EntityId,
+		Data:                  ec.Data,
+		Version:               version,
+		ParticipantID:         participantID,
+		Time:                  time.Now(),
+	}
+}
+
+// SetHistorySize sets the maximum number of changes retained for each entity
+// component. History is disabled when zero.
+func (s *EntityComponentStore) SetHistorySize(n int) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	s.historySize = n
+	if n == 0 {
+		s.history = nil
+	}
+}
+
+// RecordChange appends a change to the history of its entity component. The
+// oldest changes are discarded once the history size is reached.
+func (s *EntityComponentStore) RecordChange(c EntityComponentChange) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	if s.historySize <= 0 {
+		return
+	}
+
+	if s.history == nil {
+		s.history = make(map[uint32]map[uint32][]EntityComponentChange)
+	}
+
+	s.historySeq++
+	c.Sequence = s.historySeq
+
+	entities, ok := s.history[c.EntityComponentTypeID]
+	if !ok {
+		entities = make(map[uint32][]EntityComponentChange)
+		s.history[c.EntityComponentTypeID] = entities
+	}
+
+	changes := append(entities[c.EntityID], c)
+	if len(changes) > s.historySize {
+		changes = append(changes[:0:0], changes[len(changes)-s.historySize:]...)
+	}
+	entities[c.EntityID] = changes
+}
+
+// History returns the recorded changes of an entity component, from the
+// oldest to the newest.
+func (s *EntityComponentStore) History(entityComponentTypeID, entityID uint32) []EntityComponentChange {
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+
+	changes := s.history[entityComponentTypeID][entityID]
+	if len(changes) == 0 {
+		return nil
+	}
+	return append([]EntityComponentChange(nil), changes...)
+}
+
+// HistoryByEntityID returns the recorded changes of all the components of an
+// entity, from the oldest to the newest.
+func (s *EntityComponentStore) HistoryByEntityID(entityID uint32) []EntityComponentChange {
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+
+	var changes []EntityComponentChange
+	for _, entities := range s.history {
+		changes = append(changes, entities[entityID]...)
+	}
+
+	sortEntityComponentChanges(changes)
+	return changes
+}
+
+func (s *EntityComponentStore) deleteHistoryByEntityID(entityID uint32) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	for _, entities := range s.history {
+		delete(entities, entityID)
+	}
+}
+
+func sortEntityComponentChanges(changes []EntityComponentChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Sequence < changes[j].Sequence
+	})
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntityComponentStoreHistory(t *testing.T) {
+	t.Run("history is not recorded by default", func(t *testing.T) {
+		s := newEntityComponentStore()
+		s.RecordChange(NewEntityComponentChange(EntityComponentOpAdd, &hagallpb.EntityComponent{
+			EntityComponentTypeId: 1,
+			EntityId:              21,
+		}, 1, 88))
+
+		require.Empty(t, s.History(1, 21))
+	})
+
+	t.Run("history is bounded", func(t *testing.T) {
+		s := newEntityComponentStore()
+		s.SetHistorySize(2)
+
+		for i := 1; i <= 3; i++ {
+			s.RecordChange(NewEntityComponentChange(EntityComponentOpUpdate, &hagallpb.EntityComponent{
+				EntityComponentTypeId: 1,
+				EntityId:              21,
+				Data:                  []byte{byte(i)},
+			}, uint64(i), 88))
+		}
+
+		changes := s.History(1, 21)
+		require.Len(t, changes, 2)
+		require.Equal(t, uint64(2), changes[0].Version)
+		require.Equal(t, uint64(3), changes[1].Version)
+		require.Equal(t, uint32(88), changes[1].ParticipantID)
+		require.NotZero(t, changes[1].Time)
+	})
+
+	t.Run("history by entity id contains all component types", func(t *testing.T) {
+		s := newEntityComponentStore()
+		s.SetHistorySize(10)
+
+		s.RecordChange(NewEntityComponentChange(EntityComponentOpAdd, &hagallpb.EntityComponent{
+			EntityComponentTypeId: 1,
+			EntityId:              21,
+		}, 1, 88))
+		s.RecordChange(NewEntityComponentChange(EntityComponentOpAdd, &hagallpb.EntityComponent{
+			EntityComponentTypeId: 2,
+			EntityId:              21,
+		}, 1, 89))
+		s.RecordChange(NewEntityComponentChange(EntityComponentOpAdd, &hagallpb.EntityComponent{
+			EntityComponentTypeId: 2,
+			EntityId:              22,
+		}, 1, 89))
+
+		changes := s.HistoryByEntityID(21)
+		require.Len(t, changes, 2)
+		require.Equal(t, uint32(1), changes[0].EntityComponentTypeID)
+		require.Equal(t, uint32(2), changes[1].EntityComponentTypeID)
+	})
+
+	t.Run("history is deleted with its entity", func(t *testing.T) {
+		s := newEntityComponentStore()
+		s.SetHistorySize(10)
+
+		s.RecordChange(NewEntityComponentChange(EntityComponentOpAdd, &hagallpb.EntityComponent{
+			EntityComponentTypeId: 1,
+			EntityId:              21,
+		}, 1, 88))
+
+		s.DeleteByEntityID(21)
+		require.Empty(t, s.HistoryByEntityID(21))
+	})
+}
@@ -35,3 +35,21 @@ func (g *SequentialIDGenerator) Reuse(id uint32) {
 
 	g.reusableIDs[id] = struct{}{}
 }
+
+// Last returns the greatest id returned by New.
+func (g *SequentialIDGenerator) Last() uint32 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.currentID
+}
+
+// Skip makes New return ids greater than the given id, except reusable ones.
+func (g *SequentialIDGenerator) Skip(id uint32) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if id > g.currentID {
+		g.currentID = id
+	}
+}
@@ -28,3 +28,15 @@ func TestSequentialIDGeneratorNew(t *testing.T) {
 		require.Equal(t, uint32(2), id)
 	})
 }
+
+func TestSequentialIDGeneratorSkip(t *testing.T) {
+	var idGen SequentialIDGenerator
+
+	idGen.New()
+	idGen.Skip(41)
+	require.Equal(t, uint32(41), idGen.Last())
+	require.Equal(t, uint32(42), idGen.New())
+
+	idGen.Skip(7)
+	require.Equal(t, uint32(43), idGen.New())
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+)
+
+// JSONSchema is the subset of JSON Schema supported to validate entity
+// component data: type, enum, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, minimum and maximum.
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+}
+
+// Validate checks that the decoded JSON value v is valid against the schema.
+func (s *JSONSchema) Validate(v any) error {
+	return s.validate("$", v)
+}
+
+func (s *JSONSchema) validate(path string, v any) error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Type != "" && !jsonTypeMatches(s.Type, v) {
+		return errors.New("invalid json type").
+			WithTag("path", path).
+			WithTag("expected", s.Type).
+			WithTag("type", jsonTypeOf(v))
+	}
+
+	if len(s.Enum) != 0 {
+		var found bool
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("json value is not in enum").WithTag("path", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return errors.New("missing required json property").
+					WithTag("path", path).
+					WithTag("property", name)
+			}
+		}
+
+		for name, pv := range v {
+			ps, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return errors.New("json property is not allowed").
+						WithTag("path", path).
+						WithTag("property", name)
+				}
+				continue
+			}
+
+			if err := ps.validate(path+"."+name, pv); err != nil {
+				return err
+			}
+		}
+
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return errors.New("json array has too few items").WithTag("path", path)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return errors.New("json array has too many items").WithTag("path", path)
+		}
+
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return errors.New("json string is too short").WithTag("path", path)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return errors.New("json string is too long").WithTag("path", path)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return errors.New("json number is too small").WithTag("path", path)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return errors.New("json number is too big").WithTag("path", path)
+		}
+	}
+
+	return nil
+}
+
+func jsonTypeMatches(t string, v any) bool {
+	if t == "integer" {
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	}
+	return jsonTypeOf(v) == t
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
@@ -7,6 +7,10 @@ import (
 
 const (
 	appKeyLabel = "app_key"
+	roleLabel   = "role"
+
+	roleParticipant = "participant"
+	roleSpectator   = "spectator"
 )
 
 var (
@@ -19,6 +23,11 @@ var (
 		Name: "session_count_total",
 		Help: "The total number of sessions.",
 	}, []string{appKeyLabel})
+
+	hagallParticipantCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "session_participant_count",
+		Help: "The number of session participants, by role.",
+	}, []string{appKeyLabel, roleLabel})
 )
 
 func instrumentIncreaseSessionGauge(appKey string) {
@@ -38,3 +47,22 @@ func instrumentCountSession(appKey string) {
 		With(prometheus.Labels{appKeyLabel: appKey}).
 		Inc()
 }
+
+func instrumentIncreaseParticipantGauge(appKey string, spectator bool) {
+	hagallParticipantCount.
+		With(prometheus.Labels{appKeyLabel: appKey, roleLabel: participantRole(spectator)}).
+		Inc()
+}
+
+func instrumentDecreaseParticipantGauge(appKey string, spectator bool) {
+	hagallParticipantCount.
+		With(prometheus.Labels{appKeyLabel: appKey, roleLabel: participantRole(spectator)}).
+		Dec()
+}
+
+func participantRole(spectator bool) string {
+	if spectator {
+		return roleSpectator
+	}
+	return roleParticipant
+}
@@ -0,0 +1,341 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+)
+
+const (
+	ErrTypeInvalidMigration = "invalid-session-migration"
+)
+
+// MigratedSession is the state of a session moved to another server. Entity,
+// participant and entity component type ids are kept.
+type MigratedSession struct {
+	SessionUUID string          `json:"session_uuid"`
+	AppKey      string          `json:"app_key"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Info        SessionInfo     `json:"info"`
+	Settings    SessionSettings `json:"settings"`
+	Bans        []string        `json:"bans,omitempty"`
+
+	LastParticipantID uint32                `json:"last_participant_id"`
+	Participants      []MigratedParticipant `json:"participants"`
+
+	LastEntityID uint32           `json:"last_entity_id"`
+	Entities     []MigratedEntity `json:"entities"`
+
+	LastEntityComponentTypeID uint32                          `json:"last_entity_component_type_id"`
+	EntityComponentTypes      []MigratedEntityComponentType   `json:"entity_component_types"`
+	EntityComponents          []MigratedEntityComponent       `json:"entity_components"`
+	Subscriptions             []MigratedComponentSubscription `json:"subscriptions,omitempty"`
+
+	// The module states, by module name.
+	ModuleStates map[string][]byte `json:"module_states,omitempty"`
+}
+
+// MigratedParticipant is a participant of a migrated session.
+type MigratedParticipant struct {
+	ID            uint32          `json:"id"`
+	ClientID      string          `json:"client_id,omitempty"`
+	Spectator     bool            `json:"spectator,omitempty"`
+	Host          bool            `json:"host,omitempty"`
+	Info          ParticipantInfo `json:"info"`
+	WalletAddress string          `json:"wallet_address,omitempty"`
+}
+
+// MigratedEntity is an entity of a migrated session.
+type MigratedEntity struct {
+	ID            uint32              `json:"id"`
+	ParticipantID uint32              `json:"participant_id"`
+	Persist       bool                `json:"persist,omitempty"`
+	Flag          hagallpb.EntityFlag `json:"flag,omitempty"`
+	Pose          Pose                `json:"pose"`
+}
+
+// MigratedEntityComponentType is an entity component type of a migrated
+// session.
+type MigratedEntityComponentType struct {
+	ID   uint32              `json:"id"`
+	Name string              `json:"name"`
+	Kind EntityComponentKind `json:"kind"`
+}
+
+// MigratedEntityComponent is an entity component of a migrated session.
+type MigratedEntityComponent struct {
+	TypeID   uint32 `json:"type_id"`
+	EntityID uint32 `json:"entity_id"`
+	Data     []byte `json:"data"`
+	Version  uint64 `json:"version"`
+}
+
+// MigratedComponentSubscription is a subscription of a participant to an
+// entity component type of a migrated session.
+type MigratedComponentSubscription struct {
+	TypeID        uint32   `json:"type_id"`
+	ParticipantID uint32   `json:"participant_id"`
+	EntityIDs     []uint32 `json:"entity_ids,omitempty"`
+	MaxUpdateRate float64  `json:"max_update_rate,omitempty"`
+}
+
+// Migration returns the state of the session to move to another server,
+// without module states.
+func (s *Session) Migration() MigratedSession {
+	m := MigratedSession{
+		SessionUUID:       s.SessionUUID,
+		AppKey:            s.AppKey,
+		CreatedAt:         s.CreatedAt,
+		Info:              s.Info(),
+		Settings:          s.Settings(),
+		LastParticipantID: s.participantIDs.Last(),
+		LastEntityID:      s.entityIDs.Last(),
+	}
+
+	s.banMutex.RLock()
+	for id := range s.bans {
+		m.Bans = append(m.Bans, id)
+	}
+	s.banMutex.RUnlock()
+	sort.Strings(m.Bans)
+
+	participants := s.GetParticipants()
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].ID < participants[j].ID
+	})
+	for _, p := range participants {
+		m.Participants = append(m.Participants, MigratedParticipant{
+			ID:            p.ID,
+			ClientID:      p.ClientID,
+			Spectator:     p.Spectator,
+			Host:          s.IsHost(p),
+			Info:          p.Info(),
+			WalletAddress: p.WalletAddress(),
+		})
+	}
+
+	entities := s.Entities()
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].ID < entities[j].ID
+	})
+	for _, e := range entities {
+		m.Entities = append(m.Entities, MigratedEntity{
+			ID:            e.ID,
+			ParticipantID: e.ParticipantID,
+			Persist:       e.Persist,
+			Flag:          e.Flag,
+			Pose:          e.Pose(),
+		})
+	}
+
+	s.entityComponents.migrate(&m)
+	return m
+}
+
+// RestoreSession creates a session with the given id from a migrated session.
+// Migrated participants are not added: they join the session again.
+func RestoreSession(id uint32, frameDuration time.Duration, m MigratedSession) (*Session, error) {
+	if err := m.Settings.Validate(); err != nil {
+		return nil, errors.New("invalid migrated session settings").
+			WithType(ErrTypeInvalidMigration).
+			Wrap(err)
+	}
+	if err := m.Info.Validate(); err != nil {
+		return nil, errors.New("invalid migrated session info").
+			WithType(ErrTypeInvalidMigration).
+			Wrap(err)
+	}
+
+	s := NewSession(id, frameDuration)
+	s.SessionUUID = m.SessionUUID
+	s.CreatedAt = m.CreatedAt
+	s.AppKey = m.AppKey
+	s.SetInfo(m.Info)
+	s.settings = m.Settings
+	s.Ban(m.Bans...)
+	s.participantIDs.Skip(m.LastParticipantID)
+	s.entityIDs.Skip(m.LastEntityID)
+
+	for _, e := range m.Entities {
+		entity := &Entity{
+			ID:            e.ID,
+			ParticipantID: e.ParticipantID,
+			Persist:       e.Persist,
+			Flag:          e.Flag,
+		}
+		entity.SetPose(e.Pose)
+		s.AddEntity(entity)
+	}
+
+	if err := s.entityComponents.restore(m); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *EntityComponentStore) migrate(m *MigratedSession) {
+	s.mutex.RLock()
+	m.LastEntityComponentTypeID = s.ids.Last()
+	for id, name := range s.nameIndex {
+		m.EntityComponentTypes = append(m.EntityComponentTypes, MigratedEntityComponentType{
+			ID:   id,
+			Name: name,
+			Kind: s.kinds[id],
+		})
+	}
+	for typeID, ecs := range s.entityComponents {
+		for entityID, ec := range ecs {
+			m.EntityComponents = append(m.EntityComponents, MigratedEntityComponent{
+				TypeID:   typeID,
+				EntityID: entityID,
+				Data:     ec.Data,
+				Version:  s.versions[typeID][entityID],
+			})
+		}
+	}
+	s.mutex.RUnlock()
+
+	s.subscriptionMutex.RLock()
+	for typeID, subscriptions := range s.subscriptions {
+		for participantID, sub := range subscriptions {
+			ms := MigratedComponentSubscription{
+				TypeID:        typeID,
+				ParticipantID: participantID,
+			}
+			for id := range sub.entityIDs {
+				ms.EntityIDs = append(ms.EntityIDs, id)
+			}
+			sort.Slice(ms.EntityIDs, func(i, j int) bool {
+				return ms.EntityIDs[i] < ms.EntityIDs[j]
+			})
+			if sub.minInterval > 0 {
+				ms.MaxUpdateRate = float64(time.Second) / float64(sub.minInterval)
+			}
+			m.Subscriptions = append(m.Subscriptions, ms)
+		}
+	}
+	s.subscriptionMutex.RUnlock()
+
+	sort.Slice(m.EntityComponentTypes, func(i, j int) bool {
+		return m.EntityComponentTypes[i].ID < m.EntityComponentTypes[j].ID
+	})
+	sort.Slice(m.EntityComponents, func(i, j int) bool {
+		a, b := m.EntityComponents[i], m.EntityComponents[j]
+		if a.TypeID == b.TypeID {
+			return a.EntityID < b.EntityID
+		}
+		return a.TypeID < b.TypeID
+	})
+	sort.Slice(m.Subscriptions, func(i, j int) bool {
+		a, b := m.Subscriptions[i], m.Subscriptions[j]
+		if a.TypeID == b.TypeID {
+			return a.ParticipantID < b.ParticipantID
+		}
+		return a.TypeID < b.TypeID
+	})
+}
+
+func (s *EntityComponentStore) restore(m MigratedSession) error {
+	s.subscriptionMutex.Lock()
+	defer s.subscriptionMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ids.Skip(m.LastEntityComponentTypeID)
+	for _, t := range m.EntityComponentTypes {
+		if _, ok := s.idIndex[t.Name]; ok {
+			return errors.New("duplicate migrated entity component type").
+				WithType(ErrTypeInvalidMigration).
+				WithTag("name", t.Name)
+		}
+		s.nameIndex[t.ID] = t.Name
+		s.idIndex[t.Name] = t.ID
+		s.kinds[t.ID] = t.Kind
+	}
+
+	for _, ec := range m.EntityComponents {
+		if _, ok := s.nameIndex[ec.TypeID]; !ok {
+			return errors.New("migrated entity component has an unknown type").
+				WithType(ErrTypeInvalidMigration).
+				WithTag("type_id", ec.TypeID).
+				WithTag("entity_id", ec.EntityID)
+		}
+
+		if _, ok := s.entityComponents[ec.TypeID]; !ok {
+			s.entityComponents[ec.TypeID] = make(map[uint32]*hagallpb.EntityComponent)
+			s.versions[ec.TypeID] = make(map[uint32]uint64)
+		}
+		s.entityComponents[ec.TypeID][ec.EntityID] = &hagallpb.EntityComponent{
+			EntityComponentTypeId: ec.TypeID,
+			EntityId:              ec.EntityID,
+			Data:                  ec.Data,
+		}
+		s.versions[ec.TypeID][ec.EntityID] = ec.Version
+	}
+
+	for _, sub := range m.Subscriptions {
+		if _, ok := s.nameIndex[sub.TypeID]; !ok {
+			continue
+		}
+		if _, ok := s.subscriptions[sub.TypeID]; !ok {
+			s.subscriptions[sub.TypeID] = make(map[uint32]*entityComponentSubscription)
+		}
+		s.subscriptions[sub.TypeID][sub.ParticipantID] = newEntityComponentSubscription(EntityComponentSubscriptionOptions{
+			EntityIDs:     sub.EntityIDs,
+			MaxUpdateRate: sub.MaxUpdateRate,
+		})
+	}
+	return nil
+}
+
+// AddResume makes the given migrated participant resumable with the given
+// token until it is taken.
+func (s *Session) AddResume(token string, p MigratedParticipant) {
+	s.resumeMutex.Lock()
+	defer s.resumeMutex.Unlock()
+
+	if s.resumes == nil {
+		s.resumes = make(map[string]MigratedParticipant)
+	}
+	s.resumes[token] = p
+}
+
+// TakeResume returns and removes the migrated participant resumable with the
+// given token.
+func (s *Session) TakeResume(token string) (MigratedParticipant, bool) {
+	s.resumeMutex.Lock()
+	defer s.resumeMutex.Unlock()
+
+	p, ok := s.resumes[token]
+	delete(s.resumes, token)
+	return p, ok && token != ""
+}
+
+// TakeResumes returns and removes all the resumable migrated participants.
+func (s *Session) TakeResumes() []MigratedParticipant {
+	s.resumeMutex.Lock()
+	defer s.resumeMutex.Unlock()
+
+	participants := make([]MigratedParticipant, 0, len(s.resumes))
+	for token, p := range s.resumes {
+		participants = append(participants, p)
+		delete(s.resumes, token)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].ID < participants[j].ID
+	})
+	return participants
+}
+
+// ResumeCount returns the number of migrated participants that can still
+// resume.
+func (s *Session) ResumeCount() int {
+	s.resumeMutex.Lock()
+	defer s.resumeMutex.Unlock()
+
+	return len(s.resumes)
+}
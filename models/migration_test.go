@@ -0,0 +1,144 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionMigration(t *testing.T) {
+	session := NewSession(1, time.Second)
+	defer session.Close()
+	session.AppKey = "app"
+	session.SetInfo(SessionInfo{Name: "lobby"})
+	require.NoError(t, session.SetSettings(SessionSettings{MaxParticipants: 4}))
+	session.Ban("banned-client")
+
+	host := &Participant{ID: session.NewParticipantID(), ClientID: "host-client"}
+	host.SetInfo(ParticipantInfo{DisplayName: "host"})
+	host.SetWalletAddress("0xhost")
+	session.AddParticipant(host)
+
+	spectator := &Participant{ID: session.NewParticipantID(), Spectator: true}
+	session.AddParticipant(spectator)
+
+	entity := &Entity{ID: session.NewEntityID(), ParticipantID: host.ID, Flag: hagallpb.EntityFlag_ENTITY_FLAG_PARTICIPANT_ENTITY}
+	entity.SetPose(Pose{PX: 1, RW: 1})
+	session.AddEntity(entity)
+	host.AddEntity(entity)
+
+	components := session.GetEntityComponents()
+	typeID := components.AddType("color")
+	require.NoError(t, components.Add(&hagallpb.EntityComponent{
+		EntityComponentTypeId: typeID,
+		EntityId:              entity.ID,
+		Data:                  []byte("red"),
+	}))
+	require.NoError(t, components.SubscribeWithOptions(typeID, spectator.ID, EntityComponentSubscriptionOptions{
+		EntityIDs:     []uint32{entity.ID},
+		MaxUpdateRate: 10,
+	}))
+
+	migration := session.Migration()
+	data, err := json.Marshal(migration)
+	require.NoError(t, err)
+
+	var decoded MigratedSession
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	t.Run("participants are exported", func(t *testing.T) {
+		require.Equal(t, []MigratedParticipant{
+			{
+				ID:            host.ID,
+				ClientID:      "host-client",
+				Host:          true,
+				Info:          ParticipantInfo{DisplayName: "host"},
+				WalletAddress: "0xhost",
+			},
+			{
+				ID:        spectator.ID,
+				Spectator: true,
+			},
+		}, decoded.Participants)
+	})
+
+	t.Run("session is restored with the same ids", func(t *testing.T) {
+		restored, err := RestoreSession(7, time.Second, decoded)
+		require.NoError(t, err)
+		defer restored.Close()
+
+		require.Equal(t, uint32(7), restored.ID)
+		require.Equal(t, session.SessionUUID, restored.SessionUUID)
+		require.Equal(t, "app", restored.AppKey)
+		require.Equal(t, "lobby", restored.Info().Name)
+		require.Equal(t, 4, restored.Settings().MaxParticipants)
+		require.True(t, restored.IsBanned("banned-client"))
+		require.Zero(t, restored.ParticipantCount())
+
+		restoredEntity, ok := restored.EntityByID(entity.ID)
+		require.True(t, ok)
+		require.Equal(t, host.ID, restoredEntity.ParticipantID)
+		require.Equal(t, entity.Flag, restoredEntity.Flag)
+		require.Equal(t, entity.Pose(), restoredEntity.Pose())
+
+		restoredComponents := restored.GetEntityComponents()
+		restoredTypeID, err := restoredComponents.GetTypeID("color")
+		require.NoError(t, err)
+		require.Equal(t, typeID, restoredTypeID)
+
+		ec, version, err := restoredComponents.Get(typeID, entity.ID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("red"), ec.Data)
+		_, wantVersion, _ := components.Get(typeID, entity.ID)
+		require.Equal(t, wantVersion, version)
+
+		// Participants are not restored: they join the session again.
+		want := decoded
+		want.Participants = nil
+		require.Equal(t, want, restored.Migration())
+
+		require.Greater(t, restored.NewParticipantID(), spectator.ID)
+		require.Greater(t, restored.NewEntityID(), entity.ID)
+		require.Greater(t, restoredComponents.AddType("size"), typeID)
+	})
+
+	t.Run("session with an invalid migration is not restored", func(t *testing.T) {
+		invalid := decoded
+		invalid.EntityComponents = []MigratedEntityComponent{{TypeID: 42, EntityID: entity.ID}}
+
+		_, err := RestoreSession(8, time.Second, invalid)
+		require.True(t, errors.IsType(err, ErrTypeInvalidMigration))
+	})
+}
+
+func TestSessionResume(t *testing.T) {
+	session := NewSession(1, time.Second)
+	defer session.Close()
+
+	session.AddResume("token-a", MigratedParticipant{ID: 1})
+	session.AddResume("token-b", MigratedParticipant{ID: 2})
+	require.Equal(t, 2, session.ResumeCount())
+
+	t.Run("take a resume", func(t *testing.T) {
+		p, ok := session.TakeResume("token-a")
+		require.True(t, ok)
+		require.Equal(t, uint32(1), p.ID)
+
+		_, ok = session.TakeResume("token-a")
+		require.False(t, ok)
+	})
+
+	t.Run("take an empty token", func(t *testing.T) {
+		_, ok := session.TakeResume("")
+		require.False(t, ok)
+	})
+
+	t.Run("take remaining resumes", func(t *testing.T) {
+		require.Equal(t, []MigratedParticipant{{ID: 2}}, session.TakeResumes())
+		require.Zero(t, session.ResumeCount())
+	})
+}
@@ -1,18 +1,126 @@
 package models
 
 import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/hagallpb"
 	hwebsocket "github.com/aukilabs/hagall-common/websocket"
 )
 
+// ServerParticipantID is the participant id reserved to the server. Entities
+// and entity components authored by the server are attributed to it.
+const ServerParticipantID uint32 = math.MaxUint32
+
+// ServerMessagesParticipantID is the participant id used to exchange server
+// messages, such as host changes, through custom messages. It is never given
+// to a participant.
+const ServerMessagesParticipantID uint32 = 0
+
+const (
+	ErrTypeParticipantInfoTooLarge = "participant-info-too-large"
+	ErrTypeParticipantKicked       = "participant-kicked"
+
+	// The maximum size of the participant info, in bytes.
+	ParticipantInfoMaxSize = 4096
+)
+
+// ParticipantInfo describes a participant to the other participants.
+type ParticipantInfo struct {
+	DisplayName string `json:"display_name,omitempty"`
+
+	// A reference to the participant avatar, such as an URL or an asset id.
+	Avatar string `json:"avatar,omitempty"`
+
+	DeviceType string `json:"device_type,omitempty"`
+
+	// Arbitrary app metadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Validate returns an error when the info exceeds ParticipantInfoMaxSize.
+func (i ParticipantInfo) Validate() error {
+	size := len(i.DisplayName) + len(i.Avatar) + len(i.DeviceType)
+	for k, v := range i.Metadata {
+		size += len(k) + len(v)
+	}
+
+	if size > ParticipantInfoMaxSize {
+		return errors.New("participant info is too large").
+			WithType(ErrTypeParticipantInfoTooLarge).
+			WithTag("size", size).
+			WithTag("max_size", ParticipantInfoMaxSize)
+	}
+	return nil
+}
+
+// Presence is the presence state of a participant.
+type Presence string
+
+const (
+	// The participant recently sent messages other than heartbeats.
+	PresenceActive Presence = "active"
+
+	// The participant only sent heartbeats for a while.
+	PresenceIdle Presence = "idle"
+
+	// The participant stopped sending heartbeats, which happens when the app
+	// is in background.
+	PresenceBackgrounded Presence = "backgrounded"
+)
+
+// LeaveReason describes why a participant left a session.
+type LeaveReason string
+
+const (
+	// The participant left or disconnected.
+	LeaveReasonLeft LeaveReason = "left"
+
+	// The participant was kicked by the host or an operator.
+	LeaveReasonKicked LeaveReason = "kicked"
+
+	// The participant was kicked and banned from the session.
+	LeaveReasonBanned LeaveReason = "banned"
+
+	// The participant was asked to reconnect to the server where its session
+	// migrated.
+	LeaveReasonMigrated LeaveReason = "migrated"
+)
+
 // A session participant.
 type Participant struct {
 	ID        uint32
 	Responder hwebsocket.ResponseSender
 
+	// Reports whether the participant only watches the session. Spectators
+	// receive the session state and broadcasts but can't modify the session
+	// and are not visible to other participants.
+	Spectator bool
+
+	// Reports whether the participant exchanges server messages, such as host
+	// changes, through custom messages.
+	ServerMessages bool
+
+	// The posemesh client id of the participant connection.
+	ClientID string
+
+	// Disconnects the participant connection. Nil when the connection can't be
+	// disconnected by the server.
+	Disconnect func(error)
+
 	entityIDs map[uint32]struct{}
 
 	SignedLatency *SignedLatency
+
+	mutex         sync.RWMutex
+	info          ParticipantInfo
+	presence      Presence
+	lastHeartbeat time.Time
+	lastActivity  time.Time
+	walletAddress string
+	leaveReason   LeaveReason
 }
 
 func (p *Participant) AddEntity(e *Entity) {
@@ -30,16 +138,127 @@ func (p *Participant) EntityIDs() map[uint32]struct{} {
 	return p.entityIDs
 }
 
+// Info returns the participant info.
+func (p *Participant) Info() ParticipantInfo {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.info
+}
+
+// SetInfo replaces the participant info.
+func (p *Participant) SetInfo(info ParticipantInfo) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.info = info
+}
+
+// WalletAddress returns the wallet address given by the participant in signed
+// latency requests.
+func (p *Participant) WalletAddress() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.walletAddress
+}
+
+// SetWalletAddress sets the wallet address of the participant.
+func (p *Participant) SetWalletAddress(v string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.walletAddress = v
+}
+
+// LeaveReason returns why the participant leaves its session.
+func (p *Participant) LeaveReason() LeaveReason {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.leaveReason == "" {
+		return LeaveReasonLeft
+	}
+	return p.leaveReason
+}
+
+// Kick disconnects the participant connection with the given reason, which
+// makes the participant leave its session.
+func (p *Participant) Kick(reason LeaveReason) {
+	p.mutex.Lock()
+	p.leaveReason = reason
+	p.mutex.Unlock()
+
+	if p.Disconnect != nil {
+		p.Disconnect(errors.New("participant kicked").
+			WithType(ErrTypeParticipantKicked).
+			WithTag("participant_id", p.ID).
+			WithTag("reason", string(reason)))
+	}
+}
+
+// Presence returns the participant presence state. Participants are active
+// until UpdatePresence is called.
+func (p *Participant) Presence() Presence {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.presence == "" {
+		return PresenceActive
+	}
+	return p.presence
+}
+
+// Heartbeat records that a message was received from the participant at the
+// given time. Heartbeats that are not active, such as pings, keep the
+// participant from being backgrounded but not from being idle.
+func (p *Participant) Heartbeat(t time.Time, active bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lastHeartbeat = t
+	if active {
+		p.lastActivity = t
+	}
+}
+
+// UpdatePresence sets the presence state from the last heartbeats and reports
+// whether it changed. Participants without heartbeat since backgroundedAfter
+// are backgrounded and participants without activity since idleAfter are
+// idle. A zero duration disables the matching state.
+func (p *Participant) UpdatePresence(now time.Time, idleAfter, backgroundedAfter time.Duration) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	presence := PresenceActive
+	switch {
+	case backgroundedAfter > 0 && now.Sub(p.lastHeartbeat) >= backgroundedAfter:
+		presence = PresenceBackgrounded
+
+	case idleAfter > 0 && now.Sub(p.lastActivity) >= idleAfter:
+		presence = PresenceIdle
+	}
+
+	changed := presence != p.presence && (p.presence != "" || presence != PresenceActive)
+	p.presence = presence
+	return changed
+}
+
 func (p *Participant) ToProtobuf() *hagallpb.Participant {
 	return &hagallpb.Participant{
 		Id: p.ID,
 	}
 }
 
+// ParticipantsToProtobuf converts the given participants to protobuf.
+// Spectators are left out since they are not visible to other participants.
 func ParticipantsToProtobuf(participants []*Participant) []*hagallpb.Participant {
-	res := make([]*hagallpb.Participant, len(participants))
-	for i, p := range participants {
-		res[i] = p.ToProtobuf()
+	res := make([]*hagallpb.Participant, 0, len(participants))
+	for _, p := range participants {
+		if p.Spectator {
+			continue
+		}
+		res = append(res, p.ToProtobuf())
 	}
 	return res
 }
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -54,8 +57,78 @@ func TestParticipantsToProtobuf(t *testing.T) {
 		{
 			ID: 2,
 		},
+		{
+			ID:        3,
+			Spectator: true,
+		},
 	}
 
 	protoParticipants := ParticipantsToProtobuf(participants)
 	require.Len(t, protoParticipants, 2)
 }
+
+func TestParticipantInfoValidate(t *testing.T) {
+	t.Run("valid info", func(t *testing.T) {
+		info := ParticipantInfo{
+			DisplayName: "Ted",
+			Metadata:    map[string]string{"team": "red"},
+		}
+		require.NoError(t, info.Validate())
+	})
+
+	t.Run("too large info", func(t *testing.T) {
+		info := ParticipantInfo{
+			Metadata: map[string]string{"bio": strings.Repeat("a", ParticipantInfoMaxSize)},
+		}
+		require.True(t, errors.IsType(info.Validate(), ErrTypeParticipantInfoTooLarge))
+	})
+}
+
+func TestParticipantUpdatePresence(t *testing.T) {
+	start := time.Now()
+	idleAfter := time.Minute
+	backgroundedAfter := time.Second * 30
+
+	p := Participant{ID: 1}
+	p.Heartbeat(start, true)
+	require.False(t, p.UpdatePresence(start, idleAfter, backgroundedAfter))
+	require.Equal(t, PresenceActive, p.Presence())
+
+	p.Heartbeat(start.Add(time.Minute), false)
+	require.True(t, p.UpdatePresence(start.Add(time.Minute), idleAfter, backgroundedAfter))
+	require.Equal(t, PresenceIdle, p.Presence())
+
+	require.True(t, p.UpdatePresence(start.Add(time.Minute*2), idleAfter, backgroundedAfter))
+	require.Equal(t, PresenceBackgrounded, p.Presence())
+
+	p.Heartbeat(start.Add(time.Minute*2), true)
+	require.True(t, p.UpdatePresence(start.Add(time.Minute*2), idleAfter, backgroundedAfter))
+	require.Equal(t, PresenceActive, p.Presence())
+
+	require.False(t, p.UpdatePresence(start.Add(time.Hour), 0, 0))
+	require.Equal(t, PresenceActive, p.Presence())
+}
+
+func TestParticipantKick(t *testing.T) {
+	t.Run("participant is disconnected", func(t *testing.T) {
+		var disconnectErr error
+		p := &Participant{
+			ID: 1,
+			Disconnect: func(err error) {
+				disconnectErr = err
+			},
+		}
+		require.Equal(t, LeaveReasonLeft, p.LeaveReason())
+
+		p.Kick(LeaveReasonKicked)
+		require.Equal(t, LeaveReasonKicked, p.LeaveReason())
+		require.True(t, errors.IsType(disconnectErr, ErrTypeParticipantKicked))
+		require.Equal(t, string(LeaveReasonKicked), errors.Tag(disconnectErr, "reason"))
+	})
+
+	t.Run("participant without connection", func(t *testing.T) {
+		p := &Participant{ID: 1}
+		p.Kick(LeaveReasonBanned)
+		require.Equal(t, LeaveReasonBanned, p.LeaveReason())
+	})
+}
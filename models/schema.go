@@ -0,0 +1,226 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// EntityComponentSchema describes the data accepted for an entity component
+// type.
+type EntityComponentSchema struct {
+	// The maximum size of the data in bytes. No limit when zero.
+	MaxSize int
+
+	// The protobuf message the data must be a valid encoding of.
+	ProtoMessage protoreflect.MessageDescriptor
+
+	// The JSON schema the data must be valid against.
+	JSONSchema *JSONSchema
+
+	// The kind of the entity component type. Types are opaque unless their
+	// schema sets a mergeable kind.
+	Kind EntityComponentKind
+}
+
+// Validate returns an error typed ErrTypeEntityComponentInvalidData when the
+// given data does not match the schema.
+func (s EntityComponentSchema) Validate(data []byte) error {
+	if s.MaxSize > 0 && len(data) > s.MaxSize {
+		return errors.New("entity component data is too large").
+			WithType(ErrTypeEntityComponentInvalidData).
+			WithTag("size", len(data)).
+			WithTag("max_size", s.MaxSize)
+	}
+
+	if s.ProtoMessage != nil {
+		msg := dynamicpb.NewMessage(s.ProtoMessage)
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return errors.New("entity component data is not a valid protobuf message").
+				WithType(ErrTypeEntityComponentInvalidData).
+				WithTag("message", s.ProtoMessage.FullName()).
+				Wrap(err)
+		}
+		if len(msg.GetUnknown()) != 0 {
+			return errors.New("entity component data contains unknown protobuf fields").
+				WithType(ErrTypeEntityComponentInvalidData).
+				WithTag("message", s.ProtoMessage.FullName())
+		}
+	}
+
+	if s.JSONSchema != nil {
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return errors.New("entity component data is not valid json").
+				WithType(ErrTypeEntityComponentInvalidData).
+				Wrap(err)
+		}
+		if err := s.JSONSchema.Validate(v); err != nil {
+			return errors.New("entity component data does not match json schema").
+				WithType(ErrTypeEntityComponentInvalidData).
+				Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// EntityComponentSchemaRegistry holds entity component schemas indexed by
+// entity component type name. It is shared by all sessions.
+type EntityComponentSchemaRegistry struct {
+	mutex   sync.RWMutex
+	schemas map[string]EntityComponentSchema
+}
+
+// Register sets the schema of the entity component type with the given name.
+func (r *EntityComponentSchemaRegistry) Register(typeName string, schema EntityComponentSchema) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.schemas == nil {
+		r.schemas = make(map[string]EntityComponentSchema)
+	}
+	r.schemas[typeName] = schema
+}
+
+// Lookup returns the schema of the entity component type with the given name.
+func (r *EntityComponentSchemaRegistry) Lookup(typeName string) (EntityComponentSchema, bool) {
+	if r == nil {
+		return EntityComponentSchema{}, false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	schema, ok := r.schemas[typeName]
+	return schema, ok
+}
+
+// Len returns the number of registered schemas.
+func (r *EntityComponentSchemaRegistry) Len() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.schemas)
+}
+
+// The file format used to load entity component schemas.
+type entityComponentSchemaFile struct {
+	Types map[string]struct {
+		MaxSize int `json:"max_size"`
+
+		// A path to a serialized google.protobuf.FileDescriptorSet, relative to
+		// the schema file.
+		ProtoDescriptorSet string `json:"proto_descriptor_set"`
+
+		// The full name of the message in the descriptor set.
+		ProtoMessage string `json:"proto_message"`
+
+		JSONSchema *JSONSchema `json:"json_schema"`
+
+		// The name of the entity component kind. Opaque when empty.
+		Kind string `json:"kind"`
+	} `json:"types"`
+}
+
+// LoadEntityComponentSchemas loads entity component schemas from a JSON file.
+func LoadEntityComponentSchemas(filename string) (*EntityComponentSchemaRegistry, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("reading entity component schema file failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+
+	var file entityComponentSchemaFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, errors.New("decoding entity component schema file failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+
+	var registry EntityComponentSchemaRegistry
+	for name, t := range file.Types {
+		schema := EntityComponentSchema{
+			MaxSize:    t.MaxSize,
+			JSONSchema: t.JSONSchema,
+		}
+
+		if t.Kind != "" {
+			kind, err := ParseEntityComponentKind(t.Kind)
+			if err != nil {
+				return nil, errors.New("invalid entity component schema").
+					WithTag("type_name", name).
+					Wrap(err)
+			}
+			schema.Kind = kind
+		}
+
+		if t.ProtoMessage != "" {
+			descriptorSetFilename := t.ProtoDescriptorSet
+			if !filepath.IsAbs(descriptorSetFilename) {
+				descriptorSetFilename = filepath.Join(filepath.Dir(filename), descriptorSetFilename)
+			}
+
+			md, err := loadProtoMessageDescriptor(descriptorSetFilename, t.ProtoMessage)
+			if err != nil {
+				return nil, errors.New("loading entity component protobuf schema failed").
+					WithTag("type_name", name).
+					Wrap(err)
+			}
+			schema.ProtoMessage = md
+		}
+
+		registry.Register(name, schema)
+	}
+	return &registry, nil
+}
+
+func loadProtoMessageDescriptor(filename, messageName string) (protoreflect.MessageDescriptor, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("reading protobuf descriptor set failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		return nil, errors.New("decoding protobuf descriptor set failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, errors.New("building protobuf descriptors failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, errors.New("protobuf message not found").
+			WithTag("message", messageName).
+			Wrap(err)
+	}
+
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.New("protobuf descriptor is not a message").
+			WithTag("message", messageName)
+	}
+	return md, nil
+}
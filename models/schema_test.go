@@ -0,0 +1,145 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestEntityComponentSchemaValidate(t *testing.T) {
+	t.Run("data larger than max size is rejected", func(t *testing.T) {
+		schema := EntityComponentSchema{MaxSize: 2}
+		require.NoError(t, schema.Validate([]byte("hi")))
+
+		err := schema.Validate([]byte("hello"))
+		require.Error(t, err)
+		require.Equal(t, ErrTypeEntityComponentInvalidData, errors.Type(err))
+	})
+
+	t.Run("data is validated against a protobuf message", func(t *testing.T) {
+		schema := EntityComponentSchema{
+			ProtoMessage: (&hagallpb.Pose{}).ProtoReflect().Descriptor(),
+		}
+
+		data, err := proto.Marshal(&hagallpb.Pose{Px: 1})
+		require.NoError(t, err)
+		require.NoError(t, schema.Validate(data))
+
+		data, err = proto.Marshal(&hagallpb.ParticipantJoinRequest{SessionId: "foo"})
+		require.NoError(t, err)
+		require.Error(t, schema.Validate(data))
+
+		require.Error(t, schema.Validate([]byte{0xff}))
+	})
+
+	t.Run("data is validated against a json schema", func(t *testing.T) {
+		minimum := float64(0)
+		noAdditionalProperties := false
+
+		schema := EntityComponentSchema{
+			JSONSchema: &JSONSchema{
+				Type:     "object",
+				Required: []string{"value"},
+				Properties: map[string]*JSONSchema{
+					"value": {Type: "integer", Minimum: &minimum},
+					"tags":  {Type: "array", Items: &JSONSchema{Type: "string"}},
+				},
+				AdditionalProperties: &noAdditionalProperties,
+			},
+		}
+
+		require.NoError(t, schema.Validate([]byte(`{"value": 1, "tags": ["a"]}`)))
+		require.Error(t, schema.Validate([]byte(`{"value": -1}`)))
+		require.Error(t, schema.Validate([]byte(`{"value": 1.5}`)))
+		require.Error(t, schema.Validate([]byte(`{"tags": []}`)))
+		require.Error(t, schema.Validate([]byte(`{"value": 1, "tags": [1]}`)))
+		require.Error(t, schema.Validate([]byte(`{"value": 1, "color": "red"}`)))
+		require.Error(t, schema.Validate([]byte(`hello`)))
+	})
+}
+
+func TestEntityComponentStoreSchemas(t *testing.T) {
+	var registry EntityComponentSchemaRegistry
+	registry.Register("foo", EntityComponentSchema{MaxSize: 3})
+
+	s := newEntityComponentStore()
+	s.SetSchemas(&registry)
+	ectID := s.AddType("foo")
+
+	err := s.Add(&hagallpb.EntityComponent{
+		EntityComponentTypeId: ectID,
+		EntityId:              21,
+		Data:                  []byte("hello"),
+	})
+	require.Error(t, err)
+	require.Equal(t, ErrTypeEntityComponentInvalidData, errors.Type(err))
+
+	err = s.Add(&hagallpb.EntityComponent{
+		EntityComponentTypeId: ectID,
+		EntityId:              21,
+		Data:                  []byte("hi"),
+	})
+	require.NoError(t, err)
+
+	err = s.Update(&hagallpb.EntityComponent{
+		EntityComponentTypeId: ectID,
+		EntityId:              21,
+		Data:                  []byte("hello"),
+	})
+	require.Error(t, err)
+	require.Equal(t, ErrTypeEntityComponentInvalidData, errors.Type(err))
+}
+
+func TestLoadEntityComponentSchemas(t *testing.T) {
+	dir := t.TempDir()
+
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(hagallpb.File_messages_hagallpb_hagall_proto),
+		},
+	}
+	for i := 0; i < hagallpb.File_messages_hagallpb_hagall_proto.Imports().Len(); i++ {
+		fds.File = append([]*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(hagallpb.File_messages_hagallpb_hagall_proto.Imports().Get(i)),
+		}, fds.File...)
+	}
+	b, err := proto.Marshal(fds)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "hagall.binpb"), b, 0600)
+	require.NoError(t, err)
+
+	filename := filepath.Join(dir, "schemas.json")
+	err = os.WriteFile(filename, []byte(`{
+		"types": {
+			"score": {
+				"max_size": 16,
+				"json_schema": {"type": "integer"}
+			},
+			"pose": {
+				"proto_descriptor_set": "hagall.binpb",
+				"proto_message": "hagall.Pose"
+			}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+
+	registry, err := LoadEntityComponentSchemas(filename)
+	require.NoError(t, err)
+	require.Equal(t, 2, registry.Len())
+
+	score, ok := registry.Lookup("score")
+	require.True(t, ok)
+	require.Equal(t, 16, score.MaxSize)
+	require.NoError(t, score.Validate([]byte("42")))
+
+	pose, ok := registry.Lookup("pose")
+	require.True(t, ok)
+	require.NotNil(t, pose.ProtoMessage)
+}
@@ -3,25 +3,130 @@ package models
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/go-tooling/pkg/logs"
 	hwebsocket "github.com/aukilabs/hagall-common/websocket"
 	"github.com/google/uuid"
 )
 
+const (
+	ErrTypeSessionInfoTooLarge = "session-info-too-large"
+	ErrTypeSessionNameTaken    = "session-name-taken"
+	ErrTypeInvalidSettings     = "invalid-session-settings"
+	ErrTypeSessionLocked       = "session-locked"
+	ErrTypeSessionFull         = "session-full"
+
+	// The maximum size of the session info, in bytes.
+	SessionInfoMaxSize = 4096
+
+	// The maximum number of reserved client ids of a session.
+	SessionMaxReservations = 256
+)
+
+// SessionInfo describes a session to the clients that discover it.
+type SessionInfo struct {
+	// The session name. Names are unique by app key.
+	Name string `json:"name,omitempty"`
+
+	// Arbitrary app metadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Reports whether the session is hidden from session discovery. Private
+	// sessions can only be joined with their id.
+	Private bool `json:"private,omitempty"`
+}
+
+// Validate returns an error when the info exceeds SessionInfoMaxSize.
+func (i SessionInfo) Validate() error {
+	size := len(i.Name)
+	for k, v := range i.Metadata {
+		size += len(k) + len(v)
+	}
+
+	if size > SessionInfoMaxSize {
+		return errors.New("session info is too large").
+			WithType(ErrTypeSessionInfoTooLarge).
+			WithTag("size", size).
+			WithTag("max_size", SessionInfoMaxSize)
+	}
+	return nil
+}
+
+// SessionSettings restricts the participants that can join a session.
+type SessionSettings struct {
+	// Prevents participants and spectators from joining the session, except
+	// the ones with a reserved client id.
+	Locked bool `json:"locked,omitempty"`
+
+	// The maximum number of participants, spectators excluded. Unlimited when
+	// zero.
+	MaxParticipants int `json:"max_participants,omitempty"`
+
+	// The client ids of expected participants. Each keeps a slot within
+	// MaxParticipants until its client joins.
+	ReservedClientIDs []string `json:"reserved_client_ids,omitempty"`
+}
+
+// Validate returns an error when the settings are invalid.
+func (s SessionSettings) Validate() error {
+	if s.MaxParticipants < 0 {
+		return errors.New("negative max participants").
+			WithType(ErrTypeInvalidSettings).
+			WithTag("max_participants", s.MaxParticipants)
+	}
+
+	if len(s.ReservedClientIDs) > SessionMaxReservations {
+		return errors.New("too many reserved client ids").
+			WithType(ErrTypeInvalidSettings).
+			WithTag("reservations", len(s.ReservedClientIDs)).
+			WithTag("max_reservations", SessionMaxReservations)
+	}
+
+	if s.MaxParticipants != 0 && len(s.ReservedClientIDs) > s.MaxParticipants {
+		return errors.New("more reserved client ids than max participants").
+			WithType(ErrTypeInvalidSettings).
+			WithTag("reservations", len(s.ReservedClientIDs)).
+			WithTag("max_participants", s.MaxParticipants)
+	}
+	return nil
+}
+
+func (s SessionSettings) isReserved(clientID string) bool {
+	for _, id := range s.ReservedClientIDs {
+		if id != "" && id == clientID {
+			return true
+		}
+	}
+	return false
+}
+
 // Session represents a session that contains entities and participants who can
 // communicate between each other.
 type Session struct {
 	ID          uint32
 	SessionUUID string
+	CreatedAt   time.Time
 
 	AppKey string
 
+	infoMutex sync.RWMutex
+	info      SessionInfo
+
+	banMutex sync.RWMutex
+	bans     map[string]struct{}
+
+	resumeMutex sync.Mutex
+	resumes     map[string]MigratedParticipant
+
 	participantIDs   SequentialIDGenerator
 	participantMutex sync.RWMutex
 	participants     map[uint32]*Participant
+	hostID           uint32
+	settings         SessionSettings
 
 	entityIDs   SequentialIDGenerator
 	entityMutex sync.RWMutex
@@ -38,6 +143,7 @@ type Session struct {
 	frameMutex      sync.RWMutex
 
 	entityComponents *EntityComponentStore
+	undoHistory      UndoHistory
 
 	closeOnce sync.Once
 }
@@ -46,6 +152,7 @@ func NewSession(id uint32, frameDuration time.Duration) *Session {
 	return &Session{
 		ID:               id,
 		SessionUUID:      uuid.New().String(),
+		CreatedAt:        time.Now(),
 		closeFrameChan:   make(chan struct{}, 1),
 		frameTicker:      time.NewTicker(frameDuration),
 		participants:     make(map[uint32]*Participant),
@@ -63,22 +170,192 @@ func (s *Session) Close() {
 	})
 }
 
+// Info returns the session info.
+func (s *Session) Info() SessionInfo {
+	s.infoMutex.RLock()
+	defer s.infoMutex.RUnlock()
+
+	return s.info
+}
+
+// SetInfo replaces the session info.
+func (s *Session) SetInfo(info SessionInfo) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	s.info = info
+}
+
 func (s *Session) NewParticipantID() uint32 {
 	return s.participantIDs.New()
 }
 
+// Ban prevents the given client ids or wallet addresses from joining the
+// session. Empty ids are ignored.
+func (s *Session) Ban(ids ...string) {
+	s.banMutex.Lock()
+	defer s.banMutex.Unlock()
+
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if s.bans == nil {
+			s.bans = make(map[string]struct{})
+		}
+		s.bans[id] = struct{}{}
+	}
+}
+
+// IsBanned reports whether the given client id or wallet address is banned
+// from the session.
+func (s *Session) IsBanned(id string) bool {
+	s.banMutex.RLock()
+	defer s.banMutex.RUnlock()
+
+	_, ok := s.bans[id]
+	return ok && id != ""
+}
+
+// AddParticipant adds the given participant to the session. The participant
+// becomes the session host when the session has no host and it is not a
+// spectator.
 func (s *Session) AddParticipant(p *Participant) {
 	s.participantMutex.Lock()
 	defer s.participantMutex.Unlock()
 
+	s.addParticipant(p)
+}
+
+// AdmitParticipant adds the given participant to the session like
+// AddParticipant when the session settings allow it. It returns an error with
+// the ErrTypeSessionLocked or ErrTypeSessionFull type otherwise.
+func (s *Session) AdmitParticipant(p *Participant) error {
+	s.participantMutex.Lock()
+	defer s.participantMutex.Unlock()
+
+	reserved := s.settings.isReserved(p.ClientID)
+	if s.settings.Locked && !reserved {
+		return errors.New("session is locked").
+			WithType(ErrTypeSessionLocked).
+			WithTag("session_id", s.ID)
+	}
+
+	if max := s.settings.MaxParticipants; max != 0 && !p.Spectator {
+		var count int
+		joined := make(map[string]struct{}, len(s.participants))
+		for _, participant := range s.participants {
+			if !participant.Spectator {
+				joined[participant.ClientID] = struct{}{}
+				count++
+			}
+		}
+
+		// Slots of reserved clients that did not join yet are kept, except
+		// the one of the joining client.
+		for _, id := range s.settings.ReservedClientIDs {
+			if _, ok := joined[id]; !ok && id != p.ClientID {
+				count++
+			}
+		}
+
+		if count >= max {
+			return errors.New("session is full").
+				WithType(ErrTypeSessionFull).
+				WithTag("session_id", s.ID).
+				WithTag("max_participants", max)
+		}
+	}
+
+	s.addParticipant(p)
+	return nil
+}
+
+func (s *Session) addParticipant(p *Participant) {
 	s.participants[p.ID] = p
+	if s.hostID == 0 && !p.Spectator {
+		s.hostID = p.ID
+	}
+	instrumentIncreaseParticipantGauge(s.AppKey, p.Spectator)
+}
+
+// Settings returns the session settings.
+func (s *Session) Settings() SessionSettings {
+	s.participantMutex.RLock()
+	defer s.participantMutex.RUnlock()
+
+	return s.settings
+}
+
+// SetSettings validates and replaces the session settings. They don't affect
+// the participants that already joined.
+func (s *Session) SetSettings(settings SessionSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	settings.ReservedClientIDs = append([]string(nil), settings.ReservedClientIDs...)
+
+	s.participantMutex.Lock()
+	defer s.participantMutex.Unlock()
+
+	s.settings = settings
+	return nil
 }
 
+// RemoveParticipant removes the given participant from the session. When the
+// participant is the host, the remaining participant with the lowest id
+// becomes the host.
 func (s *Session) RemoveParticipant(p *Participant) {
 	s.participantMutex.Lock()
 	defer s.participantMutex.Unlock()
 
+	if _, ok := s.participants[p.ID]; !ok {
+		return
+	}
 	delete(s.participants, p.ID)
+	if s.hostID == p.ID {
+		s.hostID = s.nextHostID()
+	}
+	instrumentDecreaseParticipantGauge(s.AppKey, p.Spectator)
+}
+
+// Host returns the id of the session host. It returns 0 when the session has
+// no host, which happens when it only contains spectators.
+func (s *Session) Host() uint32 {
+	s.participantMutex.RLock()
+	defer s.participantMutex.RUnlock()
+
+	return s.hostID
+}
+
+// SetHost makes the participant with the given id the session host. It
+// returns false when the participant is not in the session or is a spectator.
+func (s *Session) SetHost(id uint32) bool {
+	s.participantMutex.Lock()
+	defer s.participantMutex.Unlock()
+
+	p, ok := s.participants[id]
+	if !ok || p.Spectator {
+		return false
+	}
+	s.hostID = id
+	return true
+}
+
+// IsHost reports whether the given participant is the session host.
+func (s *Session) IsHost(p *Participant) bool {
+	return p != nil && p.ID == s.Host()
+}
+
+func (s *Session) nextHostID() uint32 {
+	var id uint32
+	for _, p := range s.participants {
+		if !p.Spectator && (id == 0 || p.ID < id) {
+			id = p.ID
+		}
+	}
+	return id
 }
 
 func (s *Session) GetParticipants() []*Participant {
@@ -106,6 +383,15 @@ func (s *Session) GetParticipantsByIDs(ids ...uint32) []*Participant {
 	return participants
 }
 
+// ParticipantByID returns the participant with the given id.
+func (s *Session) ParticipantByID(id uint32) (*Participant, bool) {
+	s.participantMutex.RLock()
+	defer s.participantMutex.RUnlock()
+
+	p, ok := s.participants[id]
+	return p, ok
+}
+
 func (s *Session) ParticipantCount() int {
 	s.participantMutex.RLock()
 	defer s.participantMutex.RUnlock()
@@ -113,6 +399,21 @@ func (s *Session) ParticipantCount() int {
 	return len(s.participants)
 }
 
+// SpectatorCount returns the number of spectators in the session. They are
+// included in ParticipantCount.
+func (s *Session) SpectatorCount() int {
+	s.participantMutex.RLock()
+	defer s.participantMutex.RUnlock()
+
+	var count int
+	for _, p := range s.participants {
+		if p.Spectator {
+			count++
+		}
+	}
+	return count
+}
+
 func (s *Session) NewEntityID() uint32 {
 	return s.entityIDs.New()
 }
@@ -245,6 +546,11 @@ func (s *Session) GetEntityComponents() *EntityComponentStore {
 	return s.entityComponents
 }
 
+// UndoHistory returns the undo and redo stacks of the session participants.
+func (s *Session) UndoHistory() *UndoHistory {
+	return &s.undoHistory
+}
+
 type SessionStore struct {
 	// The session discovery service where sessions are registered.
 	DiscoveryService SessionDiscoveryService
@@ -253,6 +559,10 @@ type SessionStore struct {
 	mutex    sync.RWMutex
 	sessions map[string]*Session
 	ids      SequentialIDGenerator
+
+	eventMutex      sync.RWMutex
+	eventHandlers   map[uint32]SessionEventHandler
+	eventHandlerIDs SequentialIDGenerator
 }
 
 func (s *SessionStore) init() {
@@ -267,29 +577,52 @@ func (s *SessionStore) NewID() uint32 {
 	return s.ids.New()
 }
 
+// Add adds the given session to the store. It returns an error with the
+// ErrTypeSessionNameTaken type and releases the session id when a session with
+// the same app key and name exists.
 func (s *SessionStore) Add(ctx context.Context, session *Session) error {
 	s.initOnce.Do(s.init)
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
+	if name := session.Info().Name; name != "" {
+		if _, ok := s.getByName(session.AppKey, name); ok {
+			s.mutex.Unlock()
+			s.ids.Reuse(session.ID)
+			return errors.New("session name is taken").
+				WithType(ErrTypeSessionNameTaken).
+				WithTag("app_key", session.AppKey).
+				WithTag("name", name)
+		}
+	}
 	s.sessions[s.GlobalSessionID(session.ID)] = session
+	s.mutex.Unlock()
 
 	instrumentIncreaseSessionGauge(session.AppKey)
 	instrumentCountSession(session.AppKey)
+
+	s.Publish(session, SessionEvent{Type: SessionEventSessionCreated})
 	return nil
 }
 
+// Remove removes the given session from the store and closes it. It does
+// nothing when the session was already removed.
 func (s *SessionStore) Remove(ctx context.Context, session *Session) {
 	s.initOnce.Do(s.init)
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	id := s.GlobalSessionID(session.ID)
+	if s.sessions[id] != session {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.sessions, id)
+	s.mutex.Unlock()
 
-	delete(s.sessions, s.GlobalSessionID(session.ID))
 	session.Close()
 
 	s.ids.Reuse(session.ID)
 
 	instrumentDecreaseSessionGauge(session.AppKey)
+
+	s.Publish(session, SessionEvent{Type: SessionEventSessionClosed})
 }
 
 func (s *SessionStore) GetByGlobalID(v string) (*Session, bool) {
@@ -302,6 +635,121 @@ func (s *SessionStore) GetByGlobalID(v string) (*Session, bool) {
 	return session, ok
 }
 
+// GetByName returns the session with the given app key and name.
+func (s *SessionStore) GetByName(appKey, name string) (*Session, bool) {
+	s.initOnce.Do(s.init)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.getByName(appKey, name)
+}
+
+// UpdateInfo replaces the info of the given session. It returns an error with
+// the ErrTypeSessionNameTaken type when another session with the same app key
+// has the new name.
+func (s *SessionStore) UpdateInfo(session *Session, info SessionInfo) error {
+	if err := info.Validate(); err != nil {
+		return err
+	}
+
+	s.initOnce.Do(s.init)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if info.Name != "" {
+		if named, ok := s.getByName(session.AppKey, info.Name); ok && named != session {
+			return errors.New("session name is taken").
+				WithType(ErrTypeSessionNameTaken).
+				WithTag("app_key", session.AppKey).
+				WithTag("name", info.Name)
+		}
+	}
+
+	session.SetInfo(info)
+	return nil
+}
+
+func (s *SessionStore) getByName(appKey, name string) (*Session, bool) {
+	for _, session := range s.sessions {
+		if session.AppKey == appKey && session.Info().Name == name {
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// ListByAppKey returns the sessions of the given app key, sorted by creation
+// time.
+func (s *SessionStore) ListByAppKey(appKey string) []*Session {
+	return s.list(func(session *Session) bool {
+		return session.AppKey == appKey
+	})
+}
+
+// SessionListing is a session listed by session discovery.
+type SessionListing struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	ParticipantCount int               `json:"participant_count"`
+	MaxParticipants  int               `json:"max_participants,omitempty"`
+	Locked           bool              `json:"locked,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+// Discover returns the listings of the sessions that can be joined with the
+// given app key, sorted by creation time. Private sessions are not listed.
+func (s *SessionStore) Discover(appKey string) []SessionListing {
+	listings := []SessionListing{}
+	for _, session := range s.ListByAppKey(appKey) {
+		info := session.Info()
+		if info.Private {
+			continue
+		}
+		settings := session.Settings()
+
+		listings = append(listings, SessionListing{
+			ID:               s.GlobalSessionID(session.ID),
+			Name:             info.Name,
+			Metadata:         info.Metadata,
+			ParticipantCount: session.ParticipantCount() - session.SpectatorCount(),
+			MaxParticipants:  settings.MaxParticipants,
+			Locked:           settings.Locked,
+			CreatedAt:        session.CreatedAt,
+		})
+	}
+	return listings
+}
+
+// List returns all the sessions, sorted by creation time.
+func (s *SessionStore) List() []*Session {
+	return s.list(func(*Session) bool {
+		return true
+	})
+}
+
+func (s *SessionStore) list(filter func(*Session) bool) []*Session {
+	s.initOnce.Do(s.init)
+
+	s.mutex.RLock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if filter(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].ID < sessions[j].ID
+		}
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
 func (s *SessionStore) GlobalSessionID(sessionID uint32) string {
 	return fmt.Sprintf("%sx%x", s.DiscoveryService.ServerID(), sessionID)
 }
@@ -3,10 +3,12 @@ package models
 import (
 	"context"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/hagallpb"
 	hwebsocket "github.com/aukilabs/hagall-common/websocket"
 	"github.com/stretchr/testify/require"
@@ -37,6 +39,127 @@ func TestSessionRemoveParticipant(t *testing.T) {
 	require.Empty(t, session.participants)
 }
 
+func TestSessionSpectatorCount(t *testing.T) {
+	session := NewSession(42, time.Second)
+
+	session.AddParticipant(&Participant{ID: 1})
+	session.AddParticipant(&Participant{ID: 2, Spectator: true})
+	require.Equal(t, 2, session.ParticipantCount())
+	require.Equal(t, 1, session.SpectatorCount())
+}
+
+func TestSessionHost(t *testing.T) {
+	session := NewSession(42, time.Second)
+	require.Zero(t, session.Host())
+
+	spectator := &Participant{ID: 1, Spectator: true}
+	session.AddParticipant(spectator)
+	require.Zero(t, session.Host())
+
+	first := &Participant{ID: 2}
+	session.AddParticipant(first)
+	require.Equal(t, uint32(2), session.Host())
+	require.True(t, session.IsHost(first))
+
+	third := &Participant{ID: 4}
+	session.AddParticipant(third)
+	second := &Participant{ID: 3}
+	session.AddParticipant(second)
+	require.Equal(t, uint32(2), session.Host())
+
+	t.Run("host is transferred", func(t *testing.T) {
+		require.False(t, session.SetHost(spectator.ID))
+		require.False(t, session.SetHost(21))
+		require.Equal(t, uint32(2), session.Host())
+
+		require.True(t, session.SetHost(third.ID))
+		require.True(t, session.IsHost(third))
+
+		require.True(t, session.SetHost(first.ID))
+	})
+
+	t.Run("participant with the lowest id becomes host", func(t *testing.T) {
+		session.RemoveParticipant(first)
+		require.Equal(t, second.ID, session.Host())
+
+		session.RemoveParticipant(third)
+		require.Equal(t, second.ID, session.Host())
+
+		session.RemoveParticipant(second)
+		require.Zero(t, session.Host())
+	})
+}
+
+func TestSessionBan(t *testing.T) {
+	session := NewSession(42, time.Second)
+
+	session.Ban("client", "", "0x123")
+	require.True(t, session.IsBanned("client"))
+	require.True(t, session.IsBanned("0x123"))
+	require.False(t, session.IsBanned("other"))
+	require.False(t, session.IsBanned(""))
+}
+
+func TestSessionSettings(t *testing.T) {
+	t.Run("invalid settings", func(t *testing.T) {
+		session := NewSession(42, time.Second)
+
+		err := session.SetSettings(SessionSettings{MaxParticipants: -1})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSettings))
+
+		err = session.SetSettings(SessionSettings{
+			MaxParticipants:   1,
+			ReservedClientIDs: []string{"a", "b"},
+		})
+		require.True(t, errors.IsType(err, ErrTypeInvalidSettings))
+		require.Equal(t, SessionSettings{}, session.Settings())
+	})
+
+	t.Run("locked session", func(t *testing.T) {
+		session := NewSession(42, time.Second)
+		require.NoError(t, session.SetSettings(SessionSettings{
+			Locked:            true,
+			ReservedClientIDs: []string{"reserved"},
+		}))
+
+		err := session.AdmitParticipant(&Participant{ID: 1, ClientID: "other"})
+		require.True(t, errors.IsType(err, ErrTypeSessionLocked))
+
+		err = session.AdmitParticipant(&Participant{ID: 2, ClientID: "other", Spectator: true})
+		require.True(t, errors.IsType(err, ErrTypeSessionLocked))
+
+		require.NoError(t, session.AdmitParticipant(&Participant{ID: 3, ClientID: "reserved"}))
+		require.Equal(t, 1, session.ParticipantCount())
+	})
+
+	t.Run("full session", func(t *testing.T) {
+		session := NewSession(42, time.Second)
+		require.NoError(t, session.SetSettings(SessionSettings{MaxParticipants: 1}))
+
+		require.NoError(t, session.AdmitParticipant(&Participant{ID: 1, ClientID: "a"}))
+		require.NoError(t, session.AdmitParticipant(&Participant{ID: 2, ClientID: "b", Spectator: true}))
+
+		err := session.AdmitParticipant(&Participant{ID: 3, ClientID: "c"})
+		require.True(t, errors.IsType(err, ErrTypeSessionFull))
+	})
+
+	t.Run("reserved slots", func(t *testing.T) {
+		session := NewSession(42, time.Second)
+		require.NoError(t, session.SetSettings(SessionSettings{
+			MaxParticipants:   2,
+			ReservedClientIDs: []string{"reserved"},
+		}))
+
+		require.NoError(t, session.AdmitParticipant(&Participant{ID: 1, ClientID: "a"}))
+
+		err := session.AdmitParticipant(&Participant{ID: 2, ClientID: "b"})
+		require.True(t, errors.IsType(err, ErrTypeSessionFull))
+
+		require.NoError(t, session.AdmitParticipant(&Participant{ID: 3, ClientID: "reserved"}))
+		require.Equal(t, 2, session.ParticipantCount())
+	})
+}
+
 func TestSessionGetParticipants(t *testing.T) {
 	participant := &Participant{ID: 777}
 	session := NewSession(42, time.Second)
@@ -337,6 +460,21 @@ func TestSessionStoreRemove(t *testing.T) {
 		nextSessionID := sessions.NewID()
 		require.Equal(t, sessionID, nextSessionID)
 	})
+
+	t.Run("removed session is not removed twice", func(t *testing.T) {
+		var sessions SessionStore
+
+		ctx := context.Background()
+
+		session := NewSession(sessions.NewID(), time.Second)
+		err := sessions.Add(ctx, session)
+		require.NoError(t, err)
+
+		sessions.Remove(ctx, session)
+		sessions.Remove(ctx, session)
+
+		require.NotEqual(t, sessions.NewID(), sessions.NewID())
+	})
 }
 
 func TestSessionStoreGetByGlobalID(t *testing.T) {
@@ -401,3 +539,100 @@ func (r testResponseSender) Send(protoMsg hwebsocket.ProtoMsg) {
 func (r testResponseSender) SendMsg(msg hwebsocket.Msg) {
 	r.sendMsg(msg)
 }
+
+func TestSessionStoreListByAppKey(t *testing.T) {
+	var sessions SessionStore
+	ctx := context.Background()
+
+	first := NewSession(1, time.Second)
+	first.AppKey = "app"
+	require.NoError(t, sessions.Add(ctx, first))
+
+	other := NewSession(2, time.Second)
+	other.AppKey = "other"
+	require.NoError(t, sessions.Add(ctx, other))
+
+	second := NewSession(3, time.Second)
+	second.AppKey = "app"
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	require.NoError(t, sessions.Add(ctx, second))
+
+	require.Equal(t, []*Session{first, second}, sessions.ListByAppKey("app"))
+	require.Empty(t, sessions.ListByAppKey("unknown"))
+	require.Len(t, sessions.List(), 3)
+}
+
+func TestSessionInfoValidate(t *testing.T) {
+	info := SessionInfo{Name: "Lobby"}
+	require.NoError(t, info.Validate())
+
+	info.Metadata = map[string]string{"description": strings.Repeat("a", SessionInfoMaxSize)}
+	require.True(t, errors.IsType(info.Validate(), ErrTypeSessionInfoTooLarge))
+}
+
+func TestSessionStoreGetByName(t *testing.T) {
+	var sessions SessionStore
+	ctx := context.Background()
+
+	session := NewSession(sessions.NewID(), time.Second)
+	session.AppKey = "app"
+	session.SetInfo(SessionInfo{Name: "Room"})
+	require.NoError(t, sessions.Add(ctx, session))
+
+	t.Run("session is retrieved", func(t *testing.T) {
+		res, ok := sessions.GetByName("app", "Room")
+		require.True(t, ok)
+		require.Equal(t, session, res)
+
+		_, ok = sessions.GetByName("other", "Room")
+		require.False(t, ok)
+	})
+
+	t.Run("session name is taken", func(t *testing.T) {
+		id := sessions.NewID()
+		duplicate := NewSession(id, time.Second)
+		duplicate.AppKey = "app"
+		duplicate.SetInfo(SessionInfo{Name: "Room"})
+
+		err := sessions.Add(ctx, duplicate)
+		require.True(t, errors.IsType(err, ErrTypeSessionNameTaken))
+		require.Equal(t, id, sessions.NewID())
+	})
+}
+
+func TestSessionStoreUpdateInfo(t *testing.T) {
+	var sessions SessionStore
+	ctx := context.Background()
+
+	room := NewSession(sessions.NewID(), time.Second)
+	room.AppKey = "app"
+	room.SetInfo(SessionInfo{Name: "Room"})
+	require.NoError(t, sessions.Add(ctx, room))
+
+	session := NewSession(sessions.NewID(), time.Second)
+	session.AppKey = "app"
+	require.NoError(t, sessions.Add(ctx, session))
+
+	t.Run("info is updated", func(t *testing.T) {
+		info := SessionInfo{Name: "Hall", Private: true}
+		require.NoError(t, sessions.UpdateInfo(session, info))
+		require.Equal(t, info, session.Info())
+
+		res, ok := sessions.GetByName("app", "Hall")
+		require.True(t, ok)
+		require.Equal(t, session, res)
+
+		require.NoError(t, sessions.UpdateInfo(session, info))
+	})
+
+	t.Run("session name is taken", func(t *testing.T) {
+		err := sessions.UpdateInfo(session, SessionInfo{Name: "Room"})
+		require.True(t, errors.IsType(err, ErrTypeSessionNameTaken))
+		require.Equal(t, "Hall", session.Info().Name)
+	})
+
+	t.Run("info is too large", func(t *testing.T) {
+		err := sessions.UpdateInfo(session, SessionInfo{Name: strings.Repeat("a", SessionInfoMaxSize+1)})
+		require.True(t, errors.IsType(err, ErrTypeSessionInfoTooLarge))
+	})
+}
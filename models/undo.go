@@ -0,0 +1,294 @@
+package models
+
+import (
+	"sync"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+)
+
+// The default maximum number of operations kept in each participant undo
+// stack.
+const DefaultUndoStackSize = 64
+
+// UndoOpKind describes the kind of a reversible operation.
+type UndoOpKind int
+
+const (
+	// An entity is added. Reverting it deletes the entity.
+	UndoOpEntityAdd UndoOpKind = iota + 1
+
+	// An entity is deleted. Reverting it adds the entity back with its
+	// components.
+	UndoOpEntityDelete
+
+	// An entity pose is changed.
+	UndoOpEntityPose
+
+	// An entity component is added, updated or deleted.
+	UndoOpEntityComponent
+)
+
+// EntitySnapshot is a copy of an entity state.
+type EntitySnapshot struct {
+	ID      uint32
+	Persist bool
+	Flag    hagallpb.EntityFlag
+	Pose    Pose
+}
+
+// NewEntitySnapshot returns a copy of the given entity state.
+func NewEntitySnapshot(e *Entity) EntitySnapshot {
+	return EntitySnapshot{
+		ID:      e.ID,
+		Persist: e.Persist,
+		Flag:    e.Flag,
+		Pose:    e.Pose(),
+	}
+}
+
+// UndoOp represents a reversible operation made by a participant.
+type UndoOp struct {
+	Kind UndoOpKind
+
+	// The entity state for entity add and delete operations.
+	Entity EntitySnapshot
+
+	// The components of a deleted entity.
+	EntityComponents []*hagallpb.EntityComponent
+
+	// The entity poses before and after a pose operation.
+	PoseBefore Pose
+	PoseAfter  Pose
+
+	// The entity component states before and after an entity component
+	// operation. Nil represents a component that does not exist.
+	EntityComponentBefore *hagallpb.EntityComponent
+	EntityComponentAfter  *hagallpb.EntityComponent
+}
+
+// EntityID returns the id of the entity targeted by the operation.
+func (op UndoOp) EntityID() uint32 {
+	switch op.Kind {
+	case UndoOpEntityComponent:
+		if op.EntityComponentAfter != nil {
+			return op.EntityComponentAfter.EntityId
+		}
+		return op.EntityComponentBefore.EntityId
+
+	default:
+		return op.Entity.ID
+	}
+}
+
+func (op UndoOp) entityComponentTypeID() uint32 {
+	if op.Kind != UndoOpEntityComponent {
+		return 0
+	}
+	if op.EntityComponentAfter != nil {
+		return op.EntityComponentAfter.EntityComponentTypeId
+	}
+	return op.EntityComponentBefore.EntityComponentTypeId
+}
+
+// Inverse returns the operation that reverts op.
+func (op UndoOp) Inverse() UndoOp {
+	inv := op
+
+	switch op.Kind {
+	case UndoOpEntityAdd:
+		inv.Kind = UndoOpEntityDelete
+
+	case UndoOpEntityDelete:
+		inv.Kind = UndoOpEntityAdd
+
+	case UndoOpEntityPose:
+		inv.PoseBefore, inv.PoseAfter = op.PoseAfter, op.PoseBefore
+
+	case UndoOpEntityComponent:
+		inv.EntityComponentBefore, inv.EntityComponentAfter = op.EntityComponentAfter, op.EntityComponentBefore
+	}
+	return inv
+}
+
+// Conflicts reports whether op and v modify the same target. Entity add and
+// delete operations conflict with every operation on the same entity.
+func (op UndoOp) Conflicts(v UndoOp) bool {
+	if op.EntityID() != v.EntityID() {
+		return false
+	}
+
+	if op.isEntityLevel() || v.isEntityLevel() {
+		return true
+	}
+
+	return op.Kind == v.Kind && op.entityComponentTypeID() == v.entityComponentTypeID()
+}
+
+func (op UndoOp) isEntityLevel() bool {
+	return op.Kind == UndoOpEntityAdd || op.Kind == UndoOpEntityDelete
+}
+
+// UndoHistory holds the undo and redo stacks of the participants of a
+// session.
+type UndoHistory struct {
+	// The maximum number of operations kept in each undo stack.
+	// DefaultUndoStackSize is used when zero.
+	Size int
+
+	mutex  sync.Mutex
+	stacks map[uint32]*undoStacks
+}
+
+type undoStacks struct {
+	undo []UndoOp
+	redo []UndoOp
+}
+
+// Record pushes an operation made by a participant onto its undo stack and
+// clears its redo stack. Entries of other participants that target the same
+// object are invalidated.
+func (h *UndoHistory) Record(participantID uint32, op UndoOp) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.invalidate(participantID, op)
+
+	stacks := h.stacksOf(participantID)
+	stacks.redo = nil
+
+	// Consecutive pose updates of the same entity are merged so that a
+	// stream of pose updates is reverted at once.
+	if n := len(stacks.undo); n != 0 && op.Kind == UndoOpEntityPose {
+		if last := &stacks.undo[n-1]; last.Kind == UndoOpEntityPose && last.Entity.ID == op.Entity.ID {
+			last.PoseAfter = op.PoseAfter
+			return
+		}
+	}
+
+	h.pushUndo(stacks, op)
+}
+
+// Invalidate removes the entries of participants other than the given one that
+// target the same object as op.
+func (h *UndoHistory) Invalidate(participantID uint32, op UndoOp) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.invalidate(participantID, op)
+}
+
+func (h *UndoHistory) invalidate(participantID uint32, op UndoOp) {
+	for id, stacks := range h.stacks {
+		if id == participantID {
+			continue
+		}
+		stacks.undo = removeConflictingOps(stacks.undo, op)
+		stacks.redo = removeConflictingOps(stacks.redo, op)
+	}
+}
+
+func removeConflictingOps(ops []UndoOp, op UndoOp) []UndoOp {
+	res := ops[:0]
+	for _, o := range ops {
+		if !o.Conflicts(op) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// PopUndo removes and returns the last operation of a participant undo stack.
+func (h *UndoHistory) PopUndo(participantID uint32) (UndoOp, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stacks := h.stacksOf(participantID)
+	return popUndoOp(&stacks.undo)
+}
+
+// PopRedo removes and returns the last operation of a participant redo stack.
+func (h *UndoHistory) PopRedo(participantID uint32) (UndoOp, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stacks := h.stacksOf(participantID)
+	return popUndoOp(&stacks.redo)
+}
+
+// PushUndo pushes an operation onto a participant undo stack without clearing
+// its redo stack.
+func (h *UndoHistory) PushUndo(participantID uint32, op UndoOp) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.pushUndo(h.stacksOf(participantID), op)
+}
+
+// pushUndo pushes an operation onto the given undo stack and drops its oldest
+// operations beyond the stack size.
+func (h *UndoHistory) pushUndo(stacks *undoStacks, op UndoOp) {
+	stacks.undo = append(stacks.undo, op)
+	if size := h.size(); len(stacks.undo) > size {
+		stacks.undo = append(stacks.undo[:0:0], stacks.undo[len(stacks.undo)-size:]...)
+	}
+}
+
+// PushRedo pushes an operation onto a participant redo stack.
+func (h *UndoHistory) PushRedo(participantID uint32, op UndoOp) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stacks := h.stacksOf(participantID)
+	stacks.redo = append(stacks.redo, op)
+}
+
+// Len returns the number of operations in a participant undo and redo stacks.
+func (h *UndoHistory) Len(participantID uint32) (undo, redo int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stacks, ok := h.stacks[participantID]
+	if !ok {
+		return 0, 0
+	}
+	return len(stacks.undo), len(stacks.redo)
+}
+
+// Remove deletes the stacks of a participant.
+func (h *UndoHistory) Remove(participantID uint32) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(h.stacks, participantID)
+}
+
+func (h *UndoHistory) stacksOf(participantID uint32) *undoStacks {
+	if h.stacks == nil {
+		h.stacks = make(map[uint32]*undoStacks)
+	}
+
+	stacks, ok := h.stacks[participantID]
+	if !ok {
+		stacks = &undoStacks{}
+		h.stacks[participantID] = stacks
+	}
+	return stacks
+}
+
+func (h *UndoHistory) size() int {
+	if h.Size <= 0 {
+		return DefaultUndoStackSize
+	}
+	return h.Size
+}
+
+func popUndoOp(ops *[]UndoOp) (UndoOp, bool) {
+	n := len(*ops)
+	if n == 0 {
+		return UndoOp{}, false
+	}
+
+	op := (*ops)[n-1]
+	*ops = (*ops)[:n-1]
+	return op, true
+}
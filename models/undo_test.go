@@ -0,0 +1,158 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoHistory(t *testing.T) {
+	t.Run("record clears redo stack", func(t *testing.T) {
+		var h UndoHistory
+		h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 1}})
+		h.PushRedo(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 2}})
+
+		undo, redo := h.Len(1)
+		require.Equal(t, 1, undo)
+		require.Equal(t, 1, redo)
+
+		h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 3}})
+		undo, redo = h.Len(1)
+		require.Equal(t, 2, undo)
+		require.Zero(t, redo)
+	})
+
+	t.Run("undo stack is bounded", func(t *testing.T) {
+		h := UndoHistory{Size: 2}
+		for i := uint32(1); i <= 3; i++ {
+			h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: i}})
+		}
+
+		op, ok := h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, uint32(3), op.EntityID())
+
+		op, ok = h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, uint32(2), op.EntityID())
+
+		_, ok = h.PopUndo(1)
+		require.False(t, ok)
+	})
+
+	t.Run("redo does not grow undo stack past its size", func(t *testing.T) {
+		h := UndoHistory{Size: 2}
+		h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 1}})
+		h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 2}})
+		h.PushRedo(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 3}})
+
+		op, ok := h.PopRedo(1)
+		require.True(t, ok)
+		h.PushUndo(1, op)
+
+		undo, redo := h.Len(1)
+		require.Equal(t, 2, undo)
+		require.Zero(t, redo)
+
+		op, ok = h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, uint32(3), op.EntityID())
+
+		op, ok = h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, uint32(2), op.EntityID())
+	})
+
+	t.Run("consecutive pose updates are merged", func(t *testing.T) {
+		var h UndoHistory
+		h.Record(1, UndoOp{
+			Kind:       UndoOpEntityPose,
+			Entity:     EntitySnapshot{ID: 1},
+			PoseBefore: Pose{PX: 1},
+			PoseAfter:  Pose{PX: 2},
+		})
+		h.Record(1, UndoOp{
+			Kind:       UndoOpEntityPose,
+			Entity:     EntitySnapshot{ID: 1},
+			PoseBefore: Pose{PX: 2},
+			PoseAfter:  Pose{PX: 3},
+		})
+
+		undo, _ := h.Len(1)
+		require.Equal(t, 1, undo)
+
+		op, ok := h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, Pose{PX: 1}, op.PoseBefore)
+		require.Equal(t, Pose{PX: 3}, op.PoseAfter)
+	})
+
+	t.Run("operations of other participants on the same target are invalidated", func(t *testing.T) {
+		var h UndoHistory
+		h.Record(1, UndoOp{
+			Kind:                 UndoOpEntityComponent,
+			EntityComponentAfter: &hagallpb.EntityComponent{EntityComponentTypeId: 1, EntityId: 21},
+		})
+		h.Record(1, UndoOp{
+			Kind:                 UndoOpEntityComponent,
+			EntityComponentAfter: &hagallpb.EntityComponent{EntityComponentTypeId: 2, EntityId: 21},
+		})
+		h.PushRedo(1, UndoOp{
+			Kind:       UndoOpEntityPose,
+			Entity:     EntitySnapshot{ID: 21},
+			PoseBefore: Pose{PX: 1},
+		})
+
+		h.Record(2, UndoOp{
+			Kind:                  UndoOpEntityComponent,
+			EntityComponentBefore: &hagallpb.EntityComponent{EntityComponentTypeId: 1, EntityId: 21},
+		})
+
+		undo, redo := h.Len(1)
+		require.Equal(t, 1, undo)
+		require.Equal(t, 1, redo)
+
+		op, ok := h.PopUndo(1)
+		require.True(t, ok)
+		require.Equal(t, uint32(2), op.EntityComponentAfter.EntityComponentTypeId)
+
+		h.Invalidate(2, UndoOp{Kind: UndoOpEntityDelete, Entity: EntitySnapshot{ID: 21}})
+		_, redo = h.Len(1)
+		require.Zero(t, redo)
+	})
+
+	t.Run("remove participant stacks", func(t *testing.T) {
+		var h UndoHistory
+		h.Record(1, UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 1}})
+		h.Remove(1)
+
+		undo, redo := h.Len(1)
+		require.Zero(t, undo)
+		require.Zero(t, redo)
+	})
+}
+
+func TestUndoOpInverse(t *testing.T) {
+	t.Run("entity add", func(t *testing.T) {
+		op := UndoOp{Kind: UndoOpEntityAdd, Entity: EntitySnapshot{ID: 1}}
+		require.Equal(t, UndoOpEntityDelete, op.Inverse().Kind)
+		require.Equal(t, op, op.Inverse().Inverse())
+	})
+
+	t.Run("pose", func(t *testing.T) {
+		op := UndoOp{Kind: UndoOpEntityPose, PoseBefore: Pose{PX: 1}, PoseAfter: Pose{PX: 2}}
+		inv := op.Inverse()
+		require.Equal(t, Pose{PX: 2}, inv.PoseBefore)
+		require.Equal(t, Pose{PX: 1}, inv.PoseAfter)
+	})
+
+	t.Run("entity component", func(t *testing.T) {
+		ec := &hagallpb.EntityComponent{EntityComponentTypeId: 1, EntityId: 21}
+		op := UndoOp{Kind: UndoOpEntityComponent, EntityComponentAfter: ec}
+		inv := op.Inverse()
+		require.Nil(t, inv.EntityComponentAfter)
+		require.Equal(t, ec, inv.EntityComponentBefore)
+		require.Equal(t, uint32(21), inv.EntityID())
+	})
+}
@@ -0,0 +1,47 @@
+// Package builtin registers the modules that a Relay server can run, with
+// their configuration.
+package builtin
+
+import (
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/modules"
+	"github.com/aukilabs/hagall/modules/dagaz"
+	"github.com/aukilabs/hagall/modules/odal"
+	"github.com/aukilabs/hagall/modules/vikja"
+)
+
+// Config contains the configuration of each module. Custom modules add their
+// configuration here and are registered in NewRegistry.
+type Config struct {
+	Vikja vikja.Config `cli:",hidden" env:"-" help:"Vikja module configuration."`
+	Odal  odal.Config  `cli:",hidden" env:"-" help:"Odal module configuration."`
+	Dagaz dagaz.Config `cli:",hidden" env:"-" help:"Dagaz module configuration."`
+}
+
+// DefaultConfig returns the default configuration of the modules.
+func DefaultConfig() Config {
+	return Config{
+		Vikja: vikja.DefaultConfig(),
+		Odal:  odal.DefaultConfig(),
+		Dagaz: dagaz.DefaultConfig(),
+	}
+}
+
+// NewRegistry returns a registry with the modules that the server can run,
+// configured with the given configuration.
+func NewRegistry(conf Config) (*modules.Registry, error) {
+	var registry modules.Registry
+
+	registrations := []func(*modules.Registry) error{
+		func(r *modules.Registry) error { return vikja.Register(r, conf.Vikja) },
+		func(r *modules.Registry) error { return odal.Register(r, conf.Odal) },
+		func(r *modules.Registry) error { return dagaz.Register(r, conf.Dagaz) },
+	}
+	for _, register := range registrations {
+		if err := register(&registry); err != nil {
+			return nil, errors.New("registering module failed").Wrap(err)
+		}
+	}
+
+	return &registry, nil
+}
@@ -0,0 +1,36 @@
+package dagaz
+
+import (
+	"github.com/aukilabs/hagall/modules"
+)
+
+const (
+	// DefaultGridResolution is the default resolution of the grid where quad
+	// samples are merged.
+	DefaultGridResolution = 2
+)
+
+// Config is the configuration of the dagaz module.
+type Config struct {
+	Enabled        bool `cli:",hidden" env:"HAGALL_MODULES_DAGAZ_ENABLED"         help:"Enables the dagaz module."`
+	GridResolution uint `cli:",hidden" env:"HAGALL_MODULES_DAGAZ_GRID_RESOLUTION" help:"The resolution of the grid where quad samples are merged."`
+}
+
+// DefaultConfig returns the default configuration of the dagaz module.
+func DefaultConfig() Config {
+	return Config{
+		Enabled:        true,
+		GridResolution: DefaultGridResolution,
+	}
+}
+
+// Register registers the dagaz module with the given configuration.
+func Register(r *modules.Registry, conf Config) error {
+	return r.Register(modules.Registration{
+		Name:    (&Module{}).Name(),
+		Enabled: conf.Enabled,
+		New: func() modules.Module {
+			return &Module{GridResolution: conf.GridResolution}
+		},
+	})
+}
@@ -13,6 +13,10 @@ import (
 // TODO(jhenriques): Can we remove timestamps from protobuf messages? Dagaz does not use them...
 
 type Module struct {
+	// The resolution of the grid where quad samples are merged. Defaults to
+	// DefaultGridResolution.
+	GridResolution uint
+
 	currentSession     *models.Session
 	currentParticipant *models.Participant
 	state              *State
@@ -26,14 +30,18 @@ func (m *Module) Init(s *models.Session, p *models.Participant) {
 	m.currentSession = s
 	m.currentParticipant = p
 
+	// The spatial partition is shared by the session participants, so joining
+	// participants don't reset the collected or imported samples.
 	state, ok := s.ModuleState(m.Name())
 	if !ok {
-		state = &State{}
+		resolution := m.GridResolution
+		if resolution == 0 {
+			resolution = DefaultGridResolution
+		}
+		state = &State{SpatialPartition: NewRegularGrid(1, 1, resolution)}
 		s.SetModuleState(m.Name(), state)
 	}
 	m.state = state.(*State)
-
-	m.state.SpatialPartition = NewRegularGrid(1, 1, 2)
 }
 
 func (m *Module) HandleMsg(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
@@ -72,6 +80,11 @@ func (m *Module) HandleDagazQuadSample(ctx context.Context, msg hwebsocket.Msg)
 			WithTag("msg_type", msg.Type)
 	}
 
+	// Spectators can't modify the session.
+	if m.currentParticipant != nil && m.currentParticipant.Spectator {
+		return nil
+	}
+
 	for _, newQuad := range newQuadSample.Samples {
 		quad := NewQuadFromProtobuf(newQuad)
 		m.state.SpatialPartition.InsertQuad(quad)
@@ -0,0 +1,27 @@
+package dagaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleInit(t *testing.T) {
+	session := models.NewSession(1, time.Millisecond*10)
+	defer session.Close()
+
+	a := &Module{}
+	a.Init(session, &models.Participant{ID: 1})
+	a.state.SpatialPartition.InsertQuad(Quad{
+		Center:  Vector3f{0, 0, 0},
+		Extents: Vector3f{1, 0, 1},
+		Normal:  Vector3f{0, 1, 0},
+	})
+
+	b := &Module{}
+	b.Init(session, &models.Participant{ID: 2})
+	require.Same(t, a.state, b.state)
+	require.Equal(t, uint32(1), b.state.SpatialPartition.GetDebugInfo().Plane_count)
+}
@@ -1,5 +1,139 @@
 package dagaz
 
+import (
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+)
+
 type State struct {
 	SpatialPartition SpatialPartition
 }
+
+// migratedGrid is an exported regular grid. A quad is referenced by all the
+// cells it overlaps, so cells hold indexes in Quads.
+type migratedGrid struct {
+	Resolution uint           `json:"resolution"`
+	PlaneCount uint32         `json:"plane_count"`
+	MergeCount uint32         `json:"merge_count"`
+	Min        [3]float32     `json:"min"`
+	Max        [3]float32     `json:"max"`
+	Quads      []migratedQuad `json:"quads"`
+	Cells      [][][]int      `json:"cells"`
+}
+
+type migratedQuad struct {
+	Center     [3]float32 `json:"center"`
+	Extents    [3]float32 `json:"extents"`
+	Normal     [3]float32 `json:"normal"`
+	MergeCount uint32     `json:"merge_count"`
+}
+
+// ExportState returns the spatial partition of the given session, encoded.
+func (m *Module) ExportState(s *models.Session) ([]byte, error) {
+	state, ok := s.ModuleState(m.Name())
+	if !ok {
+		return nil, nil
+	}
+
+	grid, ok := state.(*State).SpatialPartition.(*RegularGrid)
+	if !ok {
+		return nil, errors.New("exporting spatial partition is not supported")
+	}
+
+	migration := migratedGrid{
+		Resolution: grid.Resolution,
+		PlaneCount: grid.PlaneCount,
+		MergeCount: grid.MergeCount,
+		Min:        vectorToArray(grid.Min),
+		Max:        vectorToArray(grid.Max),
+		Cells:      make([][][]int, len(grid.Grid)),
+	}
+
+	indexes := make(map[*Quad]int)
+	for i, row := range grid.Grid {
+		migration.Cells[i] = make([][]int, len(row))
+		for j, cell := range row {
+			for _, q := range cell {
+				index, ok := indexes[q]
+				if !ok {
+					index = len(migration.Quads)
+					indexes[q] = index
+					migration.Quads = append(migration.Quads, migratedQuad{
+						Center:     vectorToArray(q.Center),
+						Extents:    vectorToArray(q.Extents),
+						Normal:     vectorToArray(q.Normal),
+						MergeCount: q.MergeCount,
+					})
+				}
+				migration.Cells[i][j] = append(migration.Cells[i][j], index)
+			}
+		}
+	}
+	return json.Marshal(migration)
+}
+
+// ImportState sets the spatial partition of the given session from an
+// exported state.
+func (m *Module) ImportState(s *models.Session, data []byte) error {
+	var migration migratedGrid
+	if err := json.Unmarshal(data, &migration); err != nil {
+		return errors.New("decoding dagaz state failed").Wrap(err)
+	}
+
+	quads := make([]*Quad, len(migration.Quads))
+	for i, q := range migration.Quads {
+		quads[i] = &Quad{
+			Center:     vectorFromArray(q.Center),
+			Extents:    vectorFromArray(q.Extents),
+			Normal:     vectorFromArray(q.Normal),
+			MergeCount: q.MergeCount,
+		}
+	}
+
+	grid := &RegularGrid{
+		Resolution: migration.Resolution,
+		PlaneCount: migration.PlaneCount,
+		MergeCount: migration.MergeCount,
+		Min:        vectorFromArray(migration.Min),
+		Max:        vectorFromArray(migration.Max),
+		Grid:       make([][][]*Quad, len(migration.Cells)),
+	}
+	if grid.Resolution == 0 || len(migration.Cells) == 0 {
+		return errors.New("empty dagaz grid").
+			WithTag("resolution", grid.Resolution).
+			WithTag("rows", len(migration.Cells))
+	}
+
+	for i, row := range migration.Cells {
+		if len(row) != len(migration.Cells[0]) || len(row) == 0 {
+			return errors.New("invalid dagaz grid row").
+				WithTag("row", i).
+				WithTag("cols", len(row))
+		}
+
+		grid.Grid[i] = make([][]*Quad, len(row))
+		for j, cell := range row {
+			for _, index := range cell {
+				if index < 0 || index >= len(quads) {
+					return errors.New("invalid dagaz quad index").
+						WithTag("row", i).
+						WithTag("col", j).
+						WithTag("index", index)
+				}
+				grid.Grid[i][j] = append(grid.Grid[i][j], quads[index])
+			}
+		}
+	}
+
+	s.SetModuleState(m.Name(), &State{SpatialPartition: grid})
+	return nil
+}
+
+func vectorToArray(v Vector3f) [3]float32 {
+	return [3]float32{v.x, v.y, v.z}
+}
+
+func vectorFromArray(a [3]float32) Vector3f {
+	return Vector3f{a[0], a[1], a[2]}
+}
@@ -0,0 +1,92 @@
+package dagaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleMigrateState(t *testing.T) {
+	source := models.NewSession(1, time.Millisecond*10)
+	defer source.Close()
+
+	m := &Module{GridResolution: 2}
+
+	t.Run("session without state exports nothing", func(t *testing.T) {
+		data, err := m.ExportState(source)
+		require.NoError(t, err)
+		require.Nil(t, data)
+	})
+
+	m.Init(source, &models.Participant{ID: 1})
+	for _, q := range []Quad{
+		{Center: Vector3f{0, 0, 0}, Extents: Vector3f{3, 0, 3}, Normal: Vector3f{0, 1, 0}},
+		{Center: Vector3f{0, 0.2, 0}, Extents: Vector3f{1, 0, 1}, Normal: Vector3f{0, 1, 0}},
+		{Center: Vector3f{9, 2, 9}, Extents: Vector3f{1, 0, 1}, Normal: Vector3f{0, 1, 0}},
+	} {
+		m.state.SpatialPartition.InsertQuad(q)
+	}
+	grid := m.state.SpatialPartition.(*RegularGrid)
+	require.Greater(t, len(grid.Grid), 1)
+
+	data, err := m.ExportState(source)
+	require.NoError(t, err)
+
+	t.Run("spatial partition is imported", func(t *testing.T) {
+		target := models.NewSession(2, time.Millisecond*10)
+		defer target.Close()
+
+		require.NoError(t, m.ImportState(target, data))
+
+		imported := &Module{}
+		imported.Init(target, &models.Participant{ID: 1})
+		importedGrid := imported.state.SpatialPartition.(*RegularGrid)
+		require.Equal(t, grid, importedGrid)
+		require.Equal(t, grid.GetDebugInfo(), importedGrid.GetDebugInfo())
+
+		ray := Ray{From: Vector3f{9, 5, 9}, To: Vector3f{9, -5, 9}}
+		quad, _ := grid.IntersectQuad(ray)
+		importedQuad, _ := importedGrid.IntersectQuad(ray)
+		require.NotNil(t, importedQuad)
+		require.Equal(t, *quad, *importedQuad)
+	})
+
+	t.Run("quads shared by cells stay shared", func(t *testing.T) {
+		target := models.NewSession(2, time.Millisecond*10)
+		defer target.Close()
+
+		require.NoError(t, m.ImportState(target, data))
+		state, _ := target.ModuleState(m.Name())
+		importedGrid := state.(*State).SpatialPartition.(*RegularGrid)
+
+		quads := make(map[*Quad]struct{})
+		importedQuads := make(map[*Quad]struct{})
+		for i := range grid.Grid {
+			for j := range grid.Grid[i] {
+				for k := range grid.Grid[i][j] {
+					quads[grid.Grid[i][j][k]] = struct{}{}
+					importedQuads[importedGrid.Grid[i][j][k]] = struct{}{}
+				}
+			}
+		}
+		require.Len(t, importedQuads, len(quads))
+	})
+
+	t.Run("invalid state is not imported", func(t *testing.T) {
+		target := models.NewSession(2, time.Millisecond*10)
+		defer target.Close()
+
+		for _, data := range []string{
+			`{`,
+			`{"resolution": 1, "cells": []}`,
+			`{"resolution": 1, "cells": [[[]], []]}`,
+			`{"resolution": 1, "quads": [], "cells": [[[0]]]}`,
+		} {
+			require.Error(t, m.ImportState(target, []byte(data)), data)
+		}
+		_, ok := target.ModuleState(m.Name())
+		require.False(t, ok)
+	})
+}
@@ -28,3 +28,14 @@ type Module interface {
 	// Handles a client disconnection.
 	HandleDisconnect()
 }
+
+// StateMigrator is implemented by the modules whose session state moves with
+// the session when it migrates to another server.
+type StateMigrator interface {
+	// Returns the encoded module state of the given session. It returns nil
+	// when the session has no module state.
+	ExportState(*models.Session) ([]byte, error)
+
+	// Sets the module state of the given session from an exported state.
+	ImportState(*models.Session, []byte) error
+}
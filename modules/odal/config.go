@@ -0,0 +1,28 @@
+package odal
+
+import (
+	"github.com/aukilabs/hagall/modules"
+)
+
+// Config is the configuration of the odal module.
+type Config struct {
+	Enabled bool `cli:",hidden" env:"HAGALL_MODULES_ODAL_ENABLED" help:"Enables the odal module."`
+}
+
+// DefaultConfig returns the default configuration of the odal module.
+func DefaultConfig() Config {
+	return Config{
+		Enabled: true,
+	}
+}
+
+// Register registers the odal module with the given configuration.
+func Register(r *modules.Registry, conf Config) error {
+	return r.Register(modules.Registration{
+		Name:    (&Module{}).Name(),
+		Enabled: conf.Enabled,
+		New: func() modules.Module {
+			return &Module{}
+		},
+	})
+}
@@ -3,8 +3,11 @@ package odal
 import (
 	"sync"
 
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/odalpb"
 	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/proto"
 )
 
 // State represents a state that keeps track of assets instances.
@@ -54,3 +57,53 @@ func (s *State) AssetInstances() []*odalpb.AssetInstance {
 	}
 	return assetInstances
 }
+
+type exportedState struct {
+	LastAssetInstanceID uint32   `json:"last_asset_instance_id"`
+	AssetInstances      [][]byte `json:"asset_instances"`
+}
+
+// ExportState returns the asset instances of the given session, encoded.
+func (m *Module) ExportState(s *models.Session) ([]byte, error) {
+	v, ok := s.ModuleState(m.Name())
+	if !ok {
+		return nil, nil
+	}
+	state := v.(*State)
+
+	exported := exportedState{
+		LastAssetInstanceID: state.assetInstanceIDs.Last(),
+	}
+	for _, ai := range state.AssetInstances() {
+		data, err := proto.Marshal(ai)
+		if err != nil {
+			return nil, errors.New("encoding asset instance failed").
+				WithTag("entity_id", ai.EntityId).
+				Wrap(err)
+		}
+		exported.AssetInstances = append(exported.AssetInstances, data)
+	}
+	return json.Marshal(exported)
+}
+
+// ImportState sets the asset instances of the given session from an exported
+// state.
+func (m *Module) ImportState(s *models.Session, data []byte) error {
+	var exported exportedState
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return errors.New("decoding odal state failed").Wrap(err)
+	}
+
+	state := &State{}
+	state.assetInstanceIDs.Skip(exported.LastAssetInstanceID)
+	for _, data := range exported.AssetInstances {
+		var ai odalpb.AssetInstance
+		if err := proto.Unmarshal(data, &ai); err != nil {
+			return errors.New("decoding asset instance failed").Wrap(err)
+		}
+		state.SetAssetInstance(&ai)
+	}
+
+	s.SetModuleState(m.Name(), state)
+	return nil
+}
@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"github.com/aukilabs/go-tooling/pkg/errors"
+)
+
+// Registration describes a module that a Relay server can run.
+type Registration struct {
+	// The module name, as returned by the Name method of its instances.
+	Name string
+
+	// Reports whether the module runs. Disabled modules are neither created
+	// nor advertised to HDS.
+	Enabled bool
+
+	// Creates an instance of the module, for each connection and for each
+	// session that is not created by a connection.
+	New func() Module
+}
+
+// Registry contains the modules that a Relay server can run.
+type Registry struct {
+	registrations []Registration
+}
+
+// Register adds the given module to the registry. It returns an error when a
+// module with the same name is already registered.
+func (r *Registry) Register(reg Registration) error {
+	if reg.Name == "" || reg.New == nil {
+		return errors.New("module registration without name or factory").
+			WithTag("module", reg.Name)
+	}
+
+	for _, registered := range r.registrations {
+		if registered.Name == reg.Name {
+			return errors.New("module already registered").
+				WithTag("module", reg.Name)
+		}
+	}
+
+	r.registrations = append(r.registrations, reg)
+	return nil
+}
+
+// Names returns the names of the enabled modules, in registration order.
+func (r *Registry) Names() []string {
+	var names []string
+	for _, reg := range r.registrations {
+		if reg.Enabled {
+			names = append(names, reg.Name)
+		}
+	}
+	return names
+}
+
+// New creates an instance of each enabled module, in registration order.
+func (r *Registry) New() []Module {
+	var modules []Module
+	for _, reg := range r.registrations {
+		if reg.Enabled {
+			modules = append(modules, reg.New())
+		}
+	}
+	return modules
+}
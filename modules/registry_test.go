@@ -0,0 +1,70 @@
+package modules
+
+import (
+	"context"
+	"testing"
+
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+type testModule struct {
+	name string
+}
+
+func (m *testModule) Name() string {
+	return m.name
+}
+
+func (m *testModule) Init(*models.Session, *models.Participant) {
+}
+
+func (m *testModule) HandleMsg(context.Context, hwebsocket.ResponseSender, hwebsocket.Msg) error {
+	return nil
+}
+
+func (m *testModule) HandleDisconnect() {
+}
+
+func testRegistration(name string, enabled bool) Registration {
+	return Registration{
+		Name:    name,
+		Enabled: enabled,
+		New: func() Module {
+			return &testModule{name: name}
+		},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	require.NoError(t, r.Register(testRegistration("b", true)))
+	require.NoError(t, r.Register(testRegistration("disabled", false)))
+	require.NoError(t, r.Register(testRegistration("a", true)))
+
+	t.Run("enabled modules are listed in registration order", func(t *testing.T) {
+		require.Equal(t, []string{"b", "a"}, r.Names())
+	})
+
+	t.Run("enabled modules are created in registration order", func(t *testing.T) {
+		modules := r.New()
+		require.Len(t, modules, 2)
+		require.Equal(t, "b", modules[0].Name())
+		require.Equal(t, "a", modules[1].Name())
+	})
+
+	t.Run("each call creates new instances", func(t *testing.T) {
+		require.NotSame(t, r.New()[0], r.New()[0])
+	})
+
+	t.Run("duplicate module is rejected", func(t *testing.T) {
+		require.Error(t, r.Register(testRegistration("a", true)))
+		require.Error(t, r.Register(testRegistration("disabled", true)))
+	})
+
+	t.Run("registration without name or factory is rejected", func(t *testing.T) {
+		require.Error(t, r.Register(testRegistration("", true)))
+		require.Error(t, r.Register(Registration{Name: "c", Enabled: true}))
+	})
+}
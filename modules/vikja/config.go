@@ -0,0 +1,28 @@
+package vikja
+
+import (
+	"github.com/aukilabs/hagall/modules"
+)
+
+// Config is the configuration of the vikja module.
+type Config struct {
+	Enabled bool `cli:",hidden" env:"HAGALL_MODULES_VIKJA_ENABLED" help:"Enables the vikja module."`
+}
+
+// DefaultConfig returns the default configuration of the vikja module.
+func DefaultConfig() Config {
+	return Config{
+		Enabled: true,
+	}
+}
+
+// Register registers the vikja module with the given configuration.
+func Register(r *modules.Registry, conf Config) error {
+	return r.Register(modules.Registration{
+		Name:    (&Module{}).Name(),
+		Enabled: conf.Enabled,
+		New: func() modules.Module {
+			return &Module{}
+		},
+	})
+}
@@ -3,7 +3,11 @@ package vikja
 import (
 	"sync"
 
+	"github.com/aukilabs/go-tooling/pkg/errors"
 	"github.com/aukilabs/hagall-common/messages/vikjapb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/proto"
 )
 
 type State struct {
@@ -60,3 +64,45 @@ func (s *State) EntityActions() []*vikjapb.EntityAction {
 	}
 	return entityActions
 }
+
+// ExportState returns the entity actions of the given session, encoded.
+func (m *Module) ExportState(s *models.Session) ([]byte, error) {
+	state, ok := s.ModuleState(m.Name())
+	if !ok {
+		return nil, nil
+	}
+
+	var entityActions [][]byte
+	for _, ea := range state.(*State).EntityActions() {
+		data, err := proto.Marshal(ea)
+		if err != nil {
+			return nil, errors.New("encoding entity action failed").
+				WithTag("entity_id", ea.EntityId).
+				WithTag("name", ea.Name).
+				Wrap(err)
+		}
+		entityActions = append(entityActions, data)
+	}
+	return json.Marshal(entityActions)
+}
+
+// ImportState sets the entity actions of the given session from an exported
+// state.
+func (m *Module) ImportState(s *models.Session, data []byte) error {
+	var entityActions [][]byte
+	if err := json.Unmarshal(data, &entityActions); err != nil {
+		return errors.New("decoding vikja state failed").Wrap(err)
+	}
+
+	state := &State{}
+	for _, data := range entityActions {
+		var ea vikjapb.EntityAction
+		if err := proto.Unmarshal(data, &ea); err != nil {
+			return errors.New("decoding entity action failed").Wrap(err)
+		}
+		state.SetEntityAction(&ea)
+	}
+
+	s.SetModuleState(m.Name(), state)
+	return nil
+}
@@ -105,6 +105,16 @@ func (m *Module) handleSetEntityAction(ctx context.Context, respond hwebsocket.R
 			WithTag("msg_type", msg.Type)
 	}
 
+	if participant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	entityAction := req.EntityAction
 
 	if entityAction == nil ||
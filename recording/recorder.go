@@ -0,0 +1,418 @@
+package recording
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+)
+
+const (
+	// The number of messages received by a connection before joining a
+	// session that are kept to be written once the session is known.
+	pendingMsgsSize = 8
+
+	// The maximum duration records are buffered before being written to the
+	// recording file.
+	flushInterval = time.Second
+)
+
+// Recorder records the messages received by the participants of the sessions
+// created with one of the configured app keys or explicitly started.
+type Recorder struct {
+	// The directory where recordings are written.
+	Dir string
+
+	// The app keys whose sessions are recorded.
+	AppKeys []string
+
+	initOnce sync.Once
+	mutex    sync.Mutex
+	appKeys  map[string]struct{}
+	sessions map[string]struct{}
+	files    map[string]*sessionFile
+}
+
+func (r *Recorder) init() {
+	r.appKeys = make(map[string]struct{}, len(r.AppKeys))
+	for _, k := range r.AppKeys {
+		r.appKeys[k] = struct{}{}
+	}
+
+	r.sessions = make(map[string]struct{})
+	r.files = make(map[string]*sessionFile)
+}
+
+// Start starts recording the session with the given global id. Messages are
+// recorded from the next message received by a participant of the session.
+func (r *Recorder) Start(sessionID string) {
+	r.initOnce.Do(r.init)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.sessions[sessionID] = struct{}{}
+}
+
+// Stop stops recording the session with the given global id and closes its
+// recording file.
+func (r *Recorder) Stop(sessionID string) {
+	r.initOnce.Do(r.init)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.sessions, sessionID)
+	r.closeFile(sessionID)
+}
+
+// Recordings returns the paths of the recordings that are being written,
+// indexed by session id.
+func (r *Recorder) Recordings() map[string]string {
+	r.initOnce.Do(r.init)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	recordings := make(map[string]string, len(r.files))
+	for sessionID, f := range r.files {
+		if f != nil {
+			recordings[sessionID] = f.path
+		}
+	}
+	return recordings
+}
+
+// HandleEvent closes the recording of sessions that are closed. It is meant
+// to be registered with SessionStore.HandleEvents.
+func (r *Recorder) HandleEvent(e models.SessionEvent) {
+	if e.Type != models.SessionEventSessionClosed {
+		return
+	}
+
+	r.initOnce.Do(r.init)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.sessions, e.SessionID)
+	r.closeFile(e.SessionID)
+}
+
+// Close closes all the recording files.
+func (r *Recorder) Close() {
+	r.initOnce.Do(r.init)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for sessionID := range r.files {
+		r.closeFile(sessionID)
+	}
+}
+
+// NewConnection creates a connection that records the messages it receives
+// when its session is recorded.
+func (r *Recorder) NewConnection(clientID, appKey string) *Connection {
+	r.initOnce.Do(r.init)
+
+	return &Connection{
+		recorder: r,
+		clientID: clientID,
+		appKey:   appKey,
+	}
+}
+
+// file returns the recording file of the given session, creating it when the
+// session is recorded. It returns nil when the session is not recorded.
+func (r *Recorder) file(sessionID, appKey string) *sessionFile {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if f, ok := r.files[sessionID]; ok {
+		return f
+	}
+
+	_, appKeyRecorded := r.appKeys[appKey]
+	_, sessionRecorded := r.sessions[sessionID]
+	if !appKeyRecorded && !sessionRecorded {
+		return nil
+	}
+
+	f, err := r.createFile(sessionID, appKey)
+	if err != nil {
+		logs.WithTag("session_id", sessionID).Error(err)
+	}
+
+	// A nil file is kept on failure to not retry on every message.
+	r.files[sessionID] = f
+	return f
+}
+
+func (r *Recorder) createFile(sessionID, appKey string) (*sessionFile, error) {
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return nil, errors.New("creating recording directory failed").
+			WithTag("dir", r.Dir).
+			Wrap(err)
+	}
+
+	start := time.Now()
+	filename := fmt.Sprintf("%s_%s%s",
+		strings.ReplaceAll(sessionID, string(filepath.Separator), "_"),
+		start.UTC().Format("20060102T150405.000"),
+		FileExt,
+	)
+	path := filepath.Join(r.Dir, filename)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.New("creating recording file failed").
+			WithTag("path", path).
+			Wrap(err)
+	}
+
+	writer, err := NewWriter(file, Header{
+		SessionID: sessionID,
+		AppKey:    appKey,
+		Start:     start,
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	logs.WithTag("session_id", sessionID).
+		WithTag("path", path).
+		Info("session recording started")
+
+	return &sessionFile{
+		path:        path,
+		start:       start,
+		lastFlush:   start,
+		file:        file,
+		writer:      writer,
+		connections: make(map[*Connection]uint32),
+	}, nil
+}
+
+func (r *Recorder) closeFile(sessionID string) {
+	f, ok := r.files[sessionID]
+	if !ok {
+		return
+	}
+	delete(r.files, sessionID)
+
+	if f == nil {
+		return
+	}
+
+	if err := f.close(); err != nil {
+		logs.WithTag("session_id", sessionID).Error(err)
+		return
+	}
+
+	logs.WithTag("session_id", sessionID).
+		WithTag("path", f.path).
+		Info("session recording stopped")
+}
+
+type sessionFile struct {
+	path  string
+	start time.Time
+
+	mutex       sync.Mutex
+	lastFlush   time.Time
+	file        *os.File
+	writer      *Writer
+	connections map[*Connection]uint32
+	nextID      uint32
+	closed      bool
+	err         error
+}
+
+func (f *sessionFile) writeMsg(c *Connection, t time.Time, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id, ok := f.connections[c]
+	if !ok {
+		f.nextID++
+		id = f.nextID
+		f.connections[c] = id
+
+		f.write(Record{
+			Kind:         RecordConnect,
+			ConnectionID: id,
+			Offset:       t.Sub(f.start),
+			ClientID:     c.clientID,
+			AppKey:       c.appKey,
+		})
+	}
+
+	f.write(Record{
+		Kind:         RecordMessage,
+		ConnectionID: id,
+		Offset:       t.Sub(f.start),
+		Data:         data,
+	})
+}
+
+func (f *sessionFile) disconnect(c *Connection) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	id, ok := f.connections[c]
+	if !ok {
+		return
+	}
+	delete(f.connections, c)
+
+	f.write(Record{
+		Kind:         RecordDisconnect,
+		ConnectionID: id,
+		Offset:       time.Since(f.start),
+	})
+}
+
+func (f *sessionFile) write(r Record) {
+	if f.closed || f.err != nil {
+		return
+	}
+
+	if f.err = f.writer.Write(r); f.err == nil && time.Since(f.lastFlush) >= flushInterval {
+		f.lastFlush = time.Now()
+		f.err = f.writer.Flush()
+	}
+
+	if f.err != nil {
+		logs.WithTag("path", f.path).Error(f.err)
+	}
+}
+
+func (f *sessionFile) close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	err := f.writer.Flush()
+	if cerr := f.file.Close(); err == nil && cerr != nil {
+		err = errors.New("closing recording file failed").Wrap(cerr)
+	}
+	return err
+}
+
+// Connection records the messages received by a client connection.
+type Connection struct {
+	recorder *Recorder
+	clientID string
+	appKey   string
+
+	mutex         sync.Mutex
+	sessionID     string
+	sessionAppKey string
+	pending       []pendingMsg
+}
+
+type pendingMsg struct {
+	time time.Time
+	data []byte
+}
+
+// Receive records the given message when the session of the connection is
+// recorded. Messages received before joining a session are kept until the
+// session is joined.
+func (c *Connection) Receive(msg hwebsocket.Msg) {
+	now := time.Now()
+
+	switch msg.Type.Number() {
+	case hagallpb.MsgType_MSG_TYPE_RECEIPT_REQUEST.Number():
+		// Receipts are payment proofs that do not affect sessions.
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Join requests are kept until the joined session is known since they
+	// belong to the new session.
+	if c.sessionID == "" || msg.Type.Number() == hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST.Number() {
+		data, err := EncodeMsg(msg)
+		if err != nil {
+			logs.WithClientID(c.clientID).Debug(err)
+			return
+		}
+
+		if len(c.pending) == pendingMsgsSize {
+			c.pending = c.pending[1:]
+		}
+		c.pending = append(c.pending, pendingMsg{time: now, data: data})
+		return
+	}
+
+	f := c.recorder.file(c.sessionID, c.sessionAppKey)
+	if f == nil {
+		return
+	}
+
+	data, err := EncodeMsg(msg)
+	if err != nil {
+		logs.WithClientID(c.clientID).Debug(err)
+		return
+	}
+	f.writeMsg(c, now, data)
+}
+
+// Join sets the session of the connection. It is called after a join request
+// is handled with the global id and app key of the current session.
+func (c *Connection) Join(sessionID, appKey string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sessionID != sessionID {
+		c.leave()
+	}
+	c.sessionID = sessionID
+	c.sessionAppKey = appKey
+
+	pending := c.pending
+	c.pending = nil
+
+	f := c.recorder.file(sessionID, appKey)
+	if f == nil {
+		return
+	}
+	for _, msg := range pending {
+		f.writeMsg(c, msg.time, msg.data)
+	}
+}
+
+// Close records the disconnection of the connection.
+func (c *Connection) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.leave()
+	c.sessionID = ""
+	c.pending = nil
+}
+
+func (c *Connection) leave() {
+	if c.sessionID == "" {
+		return
+	}
+
+	c.recorder.mutex.Lock()
+	f := c.recorder.files[c.sessionID]
+	c.recorder.mutex.Unlock()
+
+	if f != nil {
+		f.disconnect(c)
+	}
+}
@@ -0,0 +1,317 @@
+// Package recording records the messages received by the participants of a
+// session into a compact file so the session can be replayed later.
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ErrTypeInvalidRecording is the error type returned when a recording is
+	// malformed.
+	ErrTypeInvalidRecording = "invalid-recording"
+
+	// The file extension of recordings.
+	FileExt = ".hrec"
+
+	magic   = "HREC"
+	version = 1
+
+	// The maximum size of a recorded string or message. It prevents corrupted
+	// recordings from allocating huge buffers.
+	maxFieldSize = 1 << 24
+)
+
+// RecordKind is the kind of a record.
+type RecordKind byte
+
+const (
+	// RecordConnect is the record of a connection that joined the recorded
+	// session.
+	RecordConnect RecordKind = iota + 1
+
+	// RecordMessage is the record of a message received by a connection.
+	RecordMessage
+
+	// RecordDisconnect is the record of a connection that left the recorded
+	// session.
+	RecordDisconnect
+)
+
+func (k RecordKind) String() string {
+	switch k {
+	case RecordConnect:
+		return "connect"
+	case RecordMessage:
+		return "message"
+	case RecordDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// Header describes a recording.
+type Header struct {
+	// The global id of the recorded session.
+	SessionID string
+
+	// The app key of the recorded session.
+	AppKey string
+
+	// The time when the recording started.
+	Start time.Time
+}
+
+// Record is an entry of a recording.
+type Record struct {
+	Kind RecordKind
+
+	// The id of the connection, unique within the recording.
+	ConnectionID uint32
+
+	// The time elapsed since the start of the recording.
+	Offset time.Duration
+
+	// The client id and app key of the connection. Only set with
+	// RecordConnect.
+	ClientID string
+	AppKey   string
+
+	// The protobuf encoded message. Only set with RecordMessage.
+	Data []byte
+}
+
+// Msg returns the message of a RecordMessage record.
+func (r Record) Msg() (hwebsocket.Msg, error) {
+	var msg hagallpb.Msg
+	if err := proto.Unmarshal(r.Data, &msg); err != nil {
+		return hwebsocket.Msg{}, errors.New("decoding recorded message failed").
+			WithType(ErrTypeInvalidRecording).
+			Wrap(err)
+	}
+
+	// Unknown fields are kept by protobuf, so the message is re-encoded with
+	// the fields of the concrete message type.
+	return hwebsocket.MsgFromProto(&msg)
+}
+
+// EncodeMsg returns the data of a message, as recorded in a RecordMessage
+// record.
+func EncodeMsg(msg hwebsocket.Msg) ([]byte, error) {
+	var m hagallpb.Msg
+	if err := msg.DataTo(&m); err != nil {
+		return nil, errors.New("decoding message failed").Wrap(err)
+	}
+
+	b, err := proto.Marshal(&m)
+	if err != nil {
+		return nil, errors.New("encoding message failed").Wrap(err)
+	}
+	return b, nil
+}
+
+// Writer writes a recording.
+type Writer struct {
+	writer *bufio.Writer
+	buf    []byte
+}
+
+// NewWriter creates a writer and writes the recording header.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	writer := &Writer{
+		writer: bufio.NewWriter(w),
+	}
+
+	b := append(writer.buf[:0], magic...)
+	b = append(b, version)
+	b = appendString(b, h.SessionID)
+	b = appendString(b, h.AppKey)
+	b = binary.AppendVarint(b, h.Start.UnixNano())
+	writer.buf = b
+
+	if _, err := writer.writer.Write(b); err != nil {
+		return nil, errors.New("writing recording header failed").Wrap(err)
+	}
+	return writer, nil
+}
+
+// Write writes the given record.
+func (w *Writer) Write(r Record) error {
+	offset := r.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	b := append(w.buf[:0], byte(r.Kind))
+	b = binary.AppendUvarint(b, uint64(r.ConnectionID))
+	b = binary.AppendUvarint(b, uint64(offset))
+
+	switch r.Kind {
+	case RecordConnect:
+		b = appendString(b, r.ClientID)
+		b = appendString(b, r.AppKey)
+
+	case RecordMessage:
+		b = binary.AppendUvarint(b, uint64(len(r.Data)))
+		b = append(b, r.Data...)
+	}
+	w.buf = b
+
+	if _, err := w.writer.Write(b); err != nil {
+		return errors.New("writing record failed").
+			WithTag("kind", r.Kind).
+			Wrap(err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if err := w.writer.Flush(); err != nil {
+		return errors.New("flushing recording failed").Wrap(err)
+	}
+	return nil
+}
+
+// Reader reads a recording.
+type Reader struct {
+	reader *bufio.Reader
+	header Header
+}
+
+// NewReader creates a reader and reads the recording header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r)}
+
+	b := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(reader.reader, b); err != nil {
+		return nil, invalidRecording("reading recording header failed", err)
+	}
+	if string(b[:len(magic)]) != magic {
+		return nil, errors.New("not a recording").WithType(ErrTypeInvalidRecording)
+	}
+	if v := b[len(magic)]; v != version {
+		return nil, errors.New("unsupported recording version").
+			WithType(ErrTypeInvalidRecording).
+			WithTag("version", v)
+	}
+
+	sessionID, err := reader.readString()
+	if err != nil {
+		return nil, invalidRecording("reading recorded session id failed", err)
+	}
+	appKey, err := reader.readString()
+	if err != nil {
+		return nil, invalidRecording("reading recorded app key failed", err)
+	}
+	start, err := binary.ReadVarint(reader.reader)
+	if err != nil {
+		return nil, invalidRecording("reading recording start failed", err)
+	}
+
+	reader.header = Header{
+		SessionID: sessionID,
+		AppKey:    appKey,
+		Start:     time.Unix(0, start),
+	}
+	return reader, nil
+}
+
+// Header returns the recording header.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next record. It returns io.EOF when there are no more
+// records.
+func (r *Reader) Next() (Record, error) {
+	kind, err := r.reader.ReadByte()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	if err != nil {
+		return Record{}, invalidRecording("reading record kind failed", err)
+	}
+
+	rec := Record{Kind: RecordKind(kind)}
+
+	connID, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return Record{}, invalidRecording("reading record connection id failed", err)
+	}
+	rec.ConnectionID = uint32(connID)
+
+	offset, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return Record{}, invalidRecording("reading record offset failed", err)
+	}
+	rec.Offset = time.Duration(offset)
+
+	switch rec.Kind {
+	case RecordConnect:
+		if rec.ClientID, err = r.readString(); err != nil {
+			return Record{}, invalidRecording("reading record client id failed", err)
+		}
+		if rec.AppKey, err = r.readString(); err != nil {
+			return Record{}, invalidRecording("reading record app key failed", err)
+		}
+
+	case RecordMessage:
+		if rec.Data, err = r.readBytes(); err != nil {
+			return Record{}, invalidRecording("reading record message failed", err)
+		}
+
+	case RecordDisconnect:
+
+	default:
+		return Record{}, errors.New("unknown record kind").
+			WithType(ErrTypeInvalidRecording).
+			WithTag("kind", kind)
+	}
+
+	return rec, nil
+}
+
+func (r *Reader) readString() (string, error) {
+	b, err := r.readBytes()
+	return string(b), err
+}
+
+func (r *Reader) readBytes() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFieldSize {
+		return nil, errors.New("field is too large").WithTag("size", size)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func invalidRecording(msg string, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errors.New(msg).
+		WithType(ErrTypeInvalidRecording).
+		Wrap(err)
+}
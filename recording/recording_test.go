@@ -0,0 +1,245 @@
+package recording
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRecording(t *testing.T) {
+	t.Run("write and read records", func(t *testing.T) {
+		msg, err := hwebsocket.MsgFromProto(&hagallpb.EntityAddRequest{
+			Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+			Timestamp: timestamppb.Now(),
+			RequestId: 42,
+			Pose:      &hagallpb.Pose{Px: 1, Rw: 1},
+			Persist:   true,
+		})
+		require.NoError(t, err)
+
+		data, err := EncodeMsg(msg)
+		require.NoError(t, err)
+
+		header := Header{
+			SessionID: "ted0x1",
+			AppKey:    "app",
+			Start:     time.Unix(0, time.Now().UnixNano()),
+		}
+		records := []Record{
+			{Kind: RecordConnect, ConnectionID: 1, ClientID: "client", AppKey: "app"},
+			{Kind: RecordMessage, ConnectionID: 1, Offset: time.Millisecond, Data: data},
+			{Kind: RecordDisconnect, ConnectionID: 1, Offset: time.Second},
+		}
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, header)
+		require.NoError(t, err)
+		for _, r := range records {
+			require.NoError(t, w.Write(r))
+		}
+		require.NoError(t, w.Flush())
+
+		r, err := NewReader(&buf)
+		require.NoError(t, err)
+		require.Equal(t, header, r.Header())
+
+		for _, expected := range records {
+			rec, err := r.Next()
+			require.NoError(t, err)
+			require.Equal(t, expected, rec)
+		}
+
+		_, err = r.Next()
+		require.Equal(t, io.EOF, err)
+
+		replayed, err := records[1].Msg()
+		require.NoError(t, err)
+		require.Equal(t, hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST, replayed.Type)
+
+		var req hagallpb.EntityAddRequest
+		require.NoError(t, replayed.DataTo(&req))
+		require.Equal(t, uint32(42), req.RequestId)
+		require.Equal(t, float32(1), req.Pose.Px)
+		require.True(t, req.Persist)
+	})
+
+	t.Run("read invalid recording", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader([]byte("NOPE\x01")))
+		require.True(t, errors.IsType(err, ErrTypeInvalidRecording))
+	})
+
+	t.Run("read truncated record", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, Header{SessionID: "ted0x1"})
+		require.NoError(t, err)
+		require.NoError(t, w.Write(Record{Kind: RecordMessage, ConnectionID: 1, Data: []byte("hello")}))
+		require.NoError(t, w.Flush())
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+		require.NoError(t, err)
+
+		_, err = r.Next()
+		require.True(t, errors.IsType(err, ErrTypeInvalidRecording))
+	})
+}
+
+func TestRecorder(t *testing.T) {
+	newMsg := func(t *testing.T, protoMsg hwebsocket.ProtoMsg) hwebsocket.Msg {
+		msg, err := hwebsocket.MsgFromProto(protoMsg)
+		require.NoError(t, err)
+		return msg
+	}
+
+	joinRequest := func(t *testing.T) hwebsocket.Msg {
+		return newMsg(t, &hagallpb.ParticipantJoinRequest{
+			Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+			Timestamp: timestamppb.Now(),
+		})
+	}
+
+	entityAddRequest := func(t *testing.T) hwebsocket.Msg {
+		return newMsg(t, &hagallpb.EntityAddRequest{
+			Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+			Timestamp: timestamppb.Now(),
+		})
+	}
+
+	readRecords := func(t *testing.T, path string) []Record {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		r, err := NewReader(f)
+		require.NoError(t, err)
+
+		var records []Record
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				return records
+			}
+			require.NoError(t, err)
+			records = append(records, rec)
+		}
+	}
+
+	kinds := func(records []Record) []RecordKind {
+		var kinds []RecordKind
+		for _, r := range records {
+			kinds = append(kinds, r.Kind)
+		}
+		return kinds
+	}
+
+	t.Run("record session of app key", func(t *testing.T) {
+		recorder := &Recorder{
+			Dir:     t.TempDir(),
+			AppKeys: []string{"app"},
+		}
+
+		conn := recorder.NewConnection("client", "app")
+		conn.Receive(joinRequest(t))
+		conn.Join("ted0x1", "app")
+		conn.Receive(entityAddRequest(t))
+
+		path := recorder.Recordings()["ted0x1"]
+		require.NotEmpty(t, path)
+
+		conn.Close()
+		recorder.HandleEvent(models.SessionEvent{
+			Type:      models.SessionEventSessionClosed,
+			SessionID: "ted0x1",
+		})
+		require.Empty(t, recorder.Recordings())
+
+		records := readRecords(t, path)
+		require.Equal(t, []RecordKind{
+			RecordConnect,
+			RecordMessage,
+			RecordMessage,
+			RecordDisconnect,
+		}, kinds(records))
+		require.Equal(t, "client", records[0].ClientID)
+
+		msg, err := records[1].Msg()
+		require.NoError(t, err)
+		require.Equal(t, hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST, msg.Type)
+	})
+
+	t.Run("skip session of other app key", func(t *testing.T) {
+		recorder := &Recorder{
+			Dir:     t.TempDir(),
+			AppKeys: []string{"app"},
+		}
+
+		conn := recorder.NewConnection("client", "app")
+		conn.Receive(joinRequest(t))
+		conn.Join("ted0x1", "other")
+		conn.Receive(entityAddRequest(t))
+		conn.Close()
+
+		require.Empty(t, recorder.Recordings())
+	})
+
+	t.Run("start and stop session", func(t *testing.T) {
+		recorder := &Recorder{Dir: t.TempDir()}
+
+		conn := recorder.NewConnection("client", "app")
+		conn.Receive(joinRequest(t))
+		conn.Join("ted0x1", "app")
+		conn.Receive(entityAddRequest(t))
+		require.Empty(t, recorder.Recordings())
+
+		recorder.Start("ted0x1")
+		conn.Receive(entityAddRequest(t))
+
+		path := recorder.Recordings()["ted0x1"]
+		require.NotEmpty(t, path)
+
+		recorder.Stop("ted0x1")
+		conn.Receive(entityAddRequest(t))
+		require.Empty(t, recorder.Recordings())
+
+		require.Equal(t, []RecordKind{
+			RecordConnect,
+			RecordMessage,
+		}, kinds(readRecords(t, path)))
+	})
+
+	t.Run("join another session", func(t *testing.T) {
+		recorder := &Recorder{
+			Dir:     t.TempDir(),
+			AppKeys: []string{"app"},
+		}
+
+		conn := recorder.NewConnection("client", "app")
+		conn.Receive(joinRequest(t))
+		conn.Join("ted0x1", "app")
+		first := recorder.Recordings()["ted0x1"]
+
+		conn.Receive(joinRequest(t))
+		conn.Join("ted0x2", "app")
+		second := recorder.Recordings()["ted0x2"]
+		recorder.Close()
+
+		require.Equal(t, []RecordKind{
+			RecordConnect,
+			RecordMessage,
+			RecordDisconnect,
+		}, kinds(readRecords(t, first)))
+
+		require.Equal(t, []RecordKind{
+			RecordConnect,
+			RecordMessage,
+		}, kinds(readRecords(t, second)))
+	})
+}
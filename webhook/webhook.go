@@ -0,0 +1,491 @@
+// Package webhook implements a dispatcher that posts session lifecycle events
+// to webhook URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// The header that contains the session event type.
+	HeaderEvent = "X-Hagall-Event"
+
+	// The header that contains the unique id of a delivery. It is kept across
+	// retries and can be used to deduplicate events.
+	HeaderDelivery = "X-Hagall-Delivery"
+
+	// The header that contains the unix time when a delivery attempt is sent.
+	HeaderTimestamp = "X-Hagall-Timestamp"
+
+	// The header that contains the hex encoded HMAC-SHA256 of
+	// "<timestamp>.<body>", prefixed by "sha256=".
+	HeaderSignature = "X-Hagall-Signature"
+
+	DefaultQueueSize   = 1024
+	DefaultMaxAttempts = 10
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = time.Minute * 5
+	DefaultTimeout     = time.Second * 10
+)
+
+// Dispatcher posts signed session events as JSON to webhook URLs.
+//
+// Each URL has its own worker that sends its deliveries in order, so that a
+// slow URL doesn't delay the others. Failed deliveries are retried with an
+// exponential backoff. When a queue directory is set, deliveries are stored on
+// disk as soon as they are handled, and resumed when the dispatcher is
+// restarted.
+type Dispatcher struct {
+	// The URLs where events are posted.
+	URLs []string
+
+	// The secret used to sign requests. Requests are not signed when empty.
+	Secret string
+
+	// The directory where pending deliveries are stored. Deliveries are only
+	// kept in memory when empty.
+	QueueDir string
+
+	// The maximum number of deliveries waiting in memory to be sent to a URL.
+	// When reached, the following deliveries are only kept in the queue
+	// directory until the worker of the URL catches up, or dropped when no
+	// queue directory is set.
+	QueueSize int
+
+	// The number of attempts before a delivery is dropped.
+	MaxAttempts int
+
+	// The delay before the first retry. It is doubled after each failed
+	// attempt, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// The transport used to send requests.
+	Transport http.RoundTripper
+
+	initOnce sync.Once
+	client   *http.Client
+	queues   map[string]*queue
+	sequence uint64
+}
+
+// queue holds the deliveries waiting to be sent to a URL. Once its channel is
+// full, the queue overflows: deliveries are only stored on disk, in order,
+// until the worker sends all of them and clears the overflow.
+type queue struct {
+	url        string
+	deliveries chan *delivery
+
+	mutex    sync.Mutex
+	overflow bool
+}
+
+func (q *queue) overflowed() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.overflow
+}
+
+type delivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+}
+
+func (d *Dispatcher) init() {
+	if d.QueueSize <= 0 {
+		d.QueueSize = DefaultQueueSize
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = DefaultMaxAttempts
+	}
+	if d.MinBackoff <= 0 {
+		d.MinBackoff = DefaultMinBackoff
+	}
+	if d.MaxBackoff < d.MinBackoff {
+		d.MaxBackoff = DefaultMaxBackoff
+	}
+
+	d.client = &http.Client{
+		Transport: d.Transport,
+		Timeout:   DefaultTimeout,
+	}
+	d.queues = make(map[string]*queue, len(d.URLs))
+	for _, u := range d.URLs {
+		d.queues[u] = &queue{
+			url:        u,
+			deliveries: make(chan *delivery, d.QueueSize),
+		}
+	}
+}
+
+// Start resumes the deliveries stored in the queue directory and starts the
+// workers that send queued deliveries until the given context is canceled.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.initOnce.Do(d.init)
+
+	if d.QueueDir != "" {
+		if err := os.MkdirAll(d.QueueDir, 0700); err != nil {
+			return errors.New("creating webhook queue directory failed").
+				WithTag("dir", d.QueueDir).
+				Wrap(err)
+		}
+
+		deliveries, err := d.load()
+		if err != nil {
+			return err
+		}
+		for _, dl := range deliveries {
+			if _, ok := d.queues[dl.URL]; !ok {
+				logs.WithTag("delivery_id", dl.ID).
+					WithTag("url", dl.URL).
+					Warn(errors.New("webhook delivery dropped: url is not configured"))
+				d.remove(dl)
+			}
+		}
+
+		// The workers start by sending the deliveries stored on disk.
+		for _, q := range d.queues {
+			q.mutex.Lock()
+			q.overflow = true
+			q.mutex.Unlock()
+		}
+	}
+
+	for _, q := range d.queues {
+		go d.work(ctx, q)
+	}
+	return nil
+}
+
+// Handle queues the given event for delivery to every webhook URL. It is meant
+// to be registered with models.SessionStore.HandleEvents and doesn't block:
+// the deliveries are stored in the queue directory before being queued, and
+// are only kept there when the queue of their URL is full.
+func (d *Dispatcher) Handle(e models.SessionEvent) {
+	d.initOnce.Do(d.init)
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		logs.Error(errors.New("encoding webhook event failed").
+			WithTag("event", e.Type).
+			Wrap(err))
+		return
+	}
+
+	for _, u := range d.URLs {
+		dl := &delivery{
+			ID:    d.newDeliveryID(),
+			URL:   u,
+			Event: string(e.Type),
+			Body:  body,
+		}
+
+		if err := d.store(dl); err != nil {
+			logs.Warn(err)
+		}
+		d.enqueue(d.queues[u], dl)
+	}
+}
+
+func (d *Dispatcher) enqueue(q *queue, dl *delivery) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.overflow {
+		return
+	}
+
+	select {
+	case q.deliveries <- dl:
+
+	default:
+		if d.QueueDir == "" {
+			logs.WithTag("delivery_id", dl.ID).
+				WithTag("url", dl.URL).
+				Error(errors.New("webhook delivery dropped: queue is full"))
+			return
+		}
+		q.overflow = true
+	}
+}
+
+func (d *Dispatcher) newDeliveryID() string {
+	seq := atomic.AddUint64(&d.sequence, 1)
+	return fmt.Sprintf("%020d-%010d", time.Now().UnixNano(), seq)
+}
+
+// work sends the deliveries of the given queue until the given context is
+// canceled. When the queue overflowed, the deliveries stored on disk are sent
+// once its channel is empty.
+func (d *Dispatcher) work(ctx context.Context, q *queue) {
+	for {
+		if len(q.deliveries) == 0 && q.overflowed() {
+			if !d.resume(ctx, q) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case dl := <-q.deliveries:
+			if !d.deliver(ctx, dl) {
+				return
+			}
+		}
+	}
+}
+
+// resume sends the deliveries of an overflowed queue that are stored on disk,
+// until none is left and the overflow is cleared. Sent deliveries are removed
+// from disk, so each load only returns the deliveries stored since the
+// previous one. It returns false when the context is canceled before.
+func (d *Dispatcher) resume(ctx context.Context, q *queue) bool {
+	for {
+		deliveries, err := d.loadURL(q.url)
+		if err == nil && len(deliveries) == 0 {
+			// Deliveries stored after the load are only known once the
+			// overflow is cleared, so the last load happens under the lock.
+			q.mutex.Lock()
+			if deliveries, err = d.loadURL(q.url); err == nil && len(deliveries) == 0 {
+				q.overflow = false
+			}
+			q.mutex.Unlock()
+		}
+
+		if err != nil {
+			logs.Warn(err)
+
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(d.MinBackoff):
+			}
+			continue
+		}
+
+		if len(deliveries) == 0 {
+			return true
+		}
+		for _, dl := range deliveries {
+			if !d.deliver(ctx, dl) {
+				return false
+			}
+		}
+	}
+}
+
+// deliver sends the given delivery until it succeeds or reaches the maximum
+// number of attempts. It returns false when the context is canceled before,
+// in which case the stored delivery is resumed on the next start.
+func (d *Dispatcher) deliver(ctx context.Context, dl *delivery) bool {
+	for {
+		err := d.send(ctx, dl)
+		if err == nil {
+			d.remove(dl)
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		dl.Attempts++
+		if dl.Attempts >= d.MaxAttempts {
+			logs.WithTag("delivery_id", dl.ID).
+				WithTag("attempts", dl.Attempts).
+				Error(errors.New("webhook delivery dropped").Wrap(err))
+			d.remove(dl)
+			return true
+		}
+
+		logs.WithTag("delivery_id", dl.ID).
+			WithTag("attempts", dl.Attempts).
+			Debug(err)
+
+		if err := d.store(dl); err != nil {
+			logs.Warn(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+
+		case <-time.After(d.backoff(dl.Attempts)):
+		}
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, dl *delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return errors.New("creating webhook request failed").
+			WithTag("url", dl.URL).
+			Wrap(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if d.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, dl.Body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return errors.New("sending webhook request failed").
+			WithTag("url", dl.URL).
+			Wrap(err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("webhook request failed").
+			WithTag("url", dl.URL).
+			WithTag("status", res.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.MinBackoff
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > d.MaxBackoff {
+		return d.MaxBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) filename(dl *delivery) string {
+	return filepath.Join(d.QueueDir, dl.ID+".json")
+}
+
+func (d *Dispatcher) store(dl *delivery) error {
+	if d.QueueDir == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return errors.New("encoding webhook delivery failed").Wrap(err)
+	}
+
+	// The delivery is written to a temporary file first to not leave a
+	// partially written file when the process stops.
+	filename := d.filename(dl)
+	if err := os.WriteFile(filename+".tmp", b, 0600); err != nil {
+		return errors.New("writing webhook delivery failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return errors.New("writing webhook delivery failed").
+			WithTag("file_name", filename).
+			Wrap(err)
+	}
+	return nil
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	if d.QueueDir == "" {
+		return
+	}
+
+	if err := os.Remove(d.filename(dl)); err != nil && !os.IsNotExist(err) {
+		logs.Warn(errors.New("removing webhook delivery failed").Wrap(err))
+	}
+}
+
+func (d *Dispatcher) load() ([]*delivery, error) {
+	entries, err := os.ReadDir(d.QueueDir)
+	if err != nil {
+		return nil, errors.New("reading webhook queue directory failed").
+			WithTag("dir", d.QueueDir).
+			Wrap(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	deliveries := make([]*delivery, 0, len(names))
+	for _, name := range names {
+		filename := filepath.Join(d.QueueDir, name)
+
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, errors.New("reading webhook delivery failed").
+				WithTag("file_name", filename).
+				Wrap(err)
+		}
+
+		var dl delivery
+		if err := json.Unmarshal(b, &dl); err != nil {
+			logs.Warn(errors.New("decoding webhook delivery failed").
+				WithTag("file_name", filename).
+				Wrap(err))
+			continue
+		}
+		deliveries = append(deliveries, &dl)
+	}
+	return deliveries, nil
+}
+
+func (d *Dispatcher) loadURL(u string) ([]*delivery, error) {
+	deliveries, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	res := deliveries[:0]
+	for _, dl := range deliveries {
+		if dl.URL == u {
+			res = append(res, dl)
+		}
+	}
+	return res, nil
+}
+
+// Sign returns the signature of a webhook request body, as set in the
+// X-Hagall-Signature header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
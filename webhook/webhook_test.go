@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+type testRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newTestServer(t *testing.T, failures int) (*httptest.Server, chan testRequest) {
+	reqs := make(chan testRequest, 16)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		reqs <- testRequest{
+			header: r.Header.Clone(),
+			body:   body,
+		}
+	}))
+	return server, reqs
+}
+
+func receive(t *testing.T, reqs chan testRequest) testRequest {
+	select {
+	case req := <-reqs:
+		return req
+
+	case <-time.After(time.Second * 2):
+		t.Fatal("webhook request not received")
+		return testRequest{}
+	}
+}
+
+// newBlockingTestServer returns a server that only responds once the returned
+// channel is closed.
+func newBlockingTestServer() (*httptest.Server, chan struct{}) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	return server, unblock
+}
+
+func firstFile(t *testing.T, dir string) string {
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	if len(files) == 0 {
+		return ""
+	}
+	return files[0].Name()
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("event is signed", func(t *testing.T) {
+		server, reqs := newTestServer(t, 0)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := Dispatcher{
+			URLs:   []string{server.URL},
+			Secret: "secret",
+		}
+		require.NoError(t, d.Start(ctx))
+
+		d.Handle(models.SessionEvent{
+			Type:      models.SessionEventSessionCreated,
+			SessionID: "ted0x1",
+		})
+
+		req := receive(t, reqs)
+		require.Equal(t, string(models.SessionEventSessionCreated), req.header.Get(HeaderEvent))
+		require.NotEmpty(t, req.header.Get(HeaderDelivery))
+		require.Equal(t, Sign("secret", req.header.Get(HeaderTimestamp), req.body), req.header.Get(HeaderSignature))
+
+		var e models.SessionEvent
+		require.NoError(t, json.Unmarshal(req.body, &e))
+		require.Equal(t, "ted0x1", e.SessionID)
+	})
+
+	t.Run("failed delivery is retried", func(t *testing.T) {
+		server, reqs := newTestServer(t, 2)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := Dispatcher{
+			URLs:       []string{server.URL},
+			MinBackoff: time.Millisecond,
+			MaxBackoff: time.Millisecond * 10,
+		}
+		require.NoError(t, d.Start(ctx))
+
+		d.Handle(models.SessionEvent{Type: models.SessionEventSessionClosed})
+
+		req := receive(t, reqs)
+		require.Equal(t, string(models.SessionEventSessionClosed), req.header.Get(HeaderEvent))
+	})
+
+	t.Run("queued delivery is resumed", func(t *testing.T) {
+		server, reqs := newTestServer(t, 1)
+		defer server.Close()
+
+		dir := t.TempDir()
+
+		// The first attempt fails and the delivery waits for a retry when the
+		// dispatcher is stopped.
+		ctx, cancel := context.WithCancel(context.Background())
+		d := Dispatcher{
+			URLs:       []string{server.URL},
+			QueueDir:   dir,
+			MinBackoff: time.Hour,
+		}
+		require.NoError(t, d.Start(ctx))
+		d.Handle(models.SessionEvent{Type: models.SessionEventParticipantJoined})
+
+		require.Eventually(t, func() bool {
+			b, err := os.ReadFile(filepath.Join(dir, firstFile(t, dir)))
+			if err != nil {
+				return false
+			}
+
+			var dl delivery
+			return json.Unmarshal(b, &dl) == nil && dl.Attempts == 1
+		}, time.Second, time.Millisecond*10)
+		cancel()
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+
+		resumed := Dispatcher{
+			URLs:     []string{server.URL},
+			QueueDir: dir,
+		}
+		require.NoError(t, resumed.Start(ctx))
+
+		req := receive(t, reqs)
+		require.Equal(t, string(models.SessionEventParticipantJoined), req.header.Get(HeaderEvent))
+
+		require.Eventually(t, func() bool {
+			files, err := os.ReadDir(dir)
+			return err == nil && len(files) == 0
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("slow url does not delay other urls", func(t *testing.T) {
+		slow, unblock := newBlockingTestServer()
+		defer slow.Close()
+		defer close(unblock)
+
+		server, reqs := newTestServer(t, 0)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := Dispatcher{URLs: []string{slow.URL, server.URL}}
+		require.NoError(t, d.Start(ctx))
+
+		d.Handle(models.SessionEvent{Type: models.SessionEventSessionCreated})
+		receive(t, reqs)
+	})
+
+	t.Run("stalled url does not block publish", func(t *testing.T) {
+		unblock := make(chan struct{})
+		entityIDs := make(chan uint32, 16)
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+
+			var e models.SessionEvent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+			entityIDs <- e.EntityID
+		}))
+		defer stalled.Close()
+		defer func() {
+			select {
+			case <-unblock:
+			default:
+				close(unblock)
+			}
+		}()
+
+		dir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := Dispatcher{
+			URLs:      []string{stalled.URL},
+			QueueDir:  dir,
+			QueueSize: 1,
+		}
+		require.NoError(t, d.Start(ctx))
+
+		sessions := &models.SessionStore{}
+		session := models.NewSession(sessions.NewID(), time.Millisecond*10)
+		require.NoError(t, sessions.Add(ctx, session))
+		defer sessions.Remove(ctx, session)
+		sessions.HandleEvents(d.Handle)
+
+		published := make(chan struct{})
+		go func() {
+			defer close(published)
+
+			for i := uint32(1); i <= 8; i++ {
+				sessions.Publish(session, models.SessionEvent{
+					Type:     models.SessionEventEntityAdded,
+					EntityID: i,
+				})
+			}
+		}()
+
+		select {
+		case <-published:
+		case <-time.After(time.Second * 2):
+			t.Fatal("publish is blocked by a stalled url")
+		}
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 8)
+
+		close(unblock)
+		for i := uint32(1); i <= 8; i++ {
+			select {
+			case id := <-entityIDs:
+				require.Equal(t, i, id)
+			case <-time.After(time.Second * 2):
+				t.Fatal("webhook request not received")
+			}
+		}
+
+		require.Eventually(t, func() bool {
+			files, err := os.ReadDir(dir)
+			return err == nil && len(files) == 0
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("full queue drops deliveries without queue directory", func(t *testing.T) {
+		slow, unblock := newBlockingTestServer()
+		defer slow.Close()
+		defer close(unblock)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := Dispatcher{
+			URLs:      []string{slow.URL},
+			QueueSize: 1,
+		}
+		require.NoError(t, d.Start(ctx))
+
+		handled := make(chan struct{})
+		go func() {
+			defer close(handled)
+
+			for i := 0; i < 4; i++ {
+				d.Handle(models.SessionEvent{Type: models.SessionEventSessionCreated})
+			}
+		}()
+
+		select {
+		case <-handled:
+		case <-time.After(time.Second * 2):
+			t.Fatal("handle is blocked")
+		}
+		require.LessOrEqual(t, len(d.queues[slow.URL].deliveries), 1)
+	})
+
+	t.Run("backoff is bounded", func(t *testing.T) {
+		d := Dispatcher{
+			MinBackoff: time.Second,
+			MaxBackoff: time.Second * 5,
+		}
+		d.initOnce.Do(d.init)
+
+		require.Equal(t, time.Second, d.backoff(1))
+		require.Equal(t, time.Second*2, d.backoff(2))
+		require.Equal(t, time.Second*4, d.backoff(3))
+		require.Equal(t, time.Second*5, d.backoff(4))
+	})
+}
@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+)
+
+// DrainNotice is what the participants of a draining server are told to do.
+type DrainNotice struct {
+	// The delay suggested to participants before they reconnect.
+	ReconnectDelay time.Duration
+
+	// The endpoint of another server suggested to participants.
+	Endpoint string
+}
+
+// Drainer drains a server before it shuts down: joins are rejected, the
+// participants are asked to move to another server, and Wait waits for the
+// sessions to be empty.
+type Drainer struct {
+	// The store that contains all the server sessions.
+	Sessions *models.SessionStore
+
+	// Called once when draining starts, for example to deregister the server
+	// from HDS.
+	OnDrain func()
+
+	mutex    sync.RWMutex
+	draining bool
+	notice   DrainNotice
+}
+
+// Drain starts draining the server and sends a going away server message with
+// the given notice to all the participants that opted into server messages.
+// It returns false when the server is already draining.
+func (d *Drainer) Drain(notice DrainNotice) bool {
+	d.mutex.Lock()
+	if d.draining {
+		d.mutex.Unlock()
+		return false
+	}
+	d.draining = true
+	d.notice = notice
+	d.mutex.Unlock()
+
+	if d.OnDrain != nil {
+		d.OnDrain()
+	}
+
+	msg := ServerMessage{
+		Type:             ServerMessageGoingAway,
+		ReconnectDelayMS: notice.ReconnectDelay.Milliseconds(),
+		Endpoint:         notice.Endpoint,
+	}
+	for _, session := range d.Sessions.List() {
+		sendServerMessage(session, msg)
+	}
+	return true
+}
+
+// Draining reports whether the server is draining.
+func (d *Drainer) Draining() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.draining
+}
+
+// Notice returns the notice given to Drain.
+func (d *Drainer) Notice() DrainNotice {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.notice
+}
+
+// ParticipantCount returns the number of participants in the server sessions,
+// spectators included.
+func (d *Drainer) ParticipantCount() int {
+	var count int
+	for _, session := range d.Sessions.List() {
+		count += session.ParticipantCount()
+	}
+	return count
+}
+
+// Wait waits until the server sessions have no participant. It returns the
+// context error when the context is done first.
+func (d *Drainer) Wait(ctx context.Context) error {
+	changed := make(chan struct{}, 1)
+	cancel := d.Sessions.HandleEvents(func(e models.SessionEvent) {
+		switch e.Type {
+		case models.SessionEventParticipantLeft, models.SessionEventSessionClosed:
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer cancel()
+
+	for d.ParticipantCount() != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-changed:
+		}
+	}
+	return nil
+}
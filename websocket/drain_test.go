@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainer(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	var drained int
+	drainer := &Drainer{
+		Sessions: sessions,
+		OnDrain: func() {
+			drained++
+		},
+	}
+
+	h, respond := joinTestSession(t, sessions, "")
+	defer h.leaveSession()
+	h.CurrentParticipant().ServerMessages = true
+	sessionID := sessions.GlobalSessionID(h.CurrentSession().ID)
+
+	t.Run("drain notifies participants", func(t *testing.T) {
+		require.True(t, drainer.Drain(DrainNotice{
+			ReconnectDelay: time.Second * 5,
+			Endpoint:       "https://hagall.example.com",
+		}))
+		require.True(t, drainer.Draining())
+		require.Equal(t, 1, drained)
+
+		broadcast, ok := respond.last().(*hagallpb.CustomMessageBroadcast)
+		require.True(t, ok)
+		require.Equal(t, models.ServerMessagesParticipantID, broadcast.ParticipantId)
+
+		var msg ServerMessage
+		require.NoError(t, json.Unmarshal(broadcast.Body, &msg))
+		require.Equal(t, ServerMessage{
+			Type:             ServerMessageGoingAway,
+			ReconnectDelayMS: 5000,
+			Endpoint:         "https://hagall.example.com",
+		}, msg)
+	})
+
+	t.Run("drain twice", func(t *testing.T) {
+		require.False(t, drainer.Drain(DrainNotice{}))
+		require.Equal(t, 1, drained)
+		require.Equal(t, time.Second*5, drainer.Notice().ReconnectDelay)
+	})
+
+	t.Run("join is rejected", func(t *testing.T) {
+		joining := &RealtimeHandler{
+			FrameDuration: time.Millisecond * 50,
+			Sessions:      sessions,
+			Drainer:       drainer,
+		}
+		respond := &recordingResponder{}
+
+		handleTestMsg(t, func(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
+			return joining.HandleParticipantJoin(ctx, func() {}, respond, msg)
+		}, respond, &hagallpb.ParticipantJoinRequest{
+			Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+			SessionId: sessionID,
+		})
+
+		res, ok := respond.last().(*hagallpb.ErrorResponse)
+		require.True(t, ok)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_SERVER_TOO_BUSY, res.Code)
+		require.Equal(t, 1, drainer.ParticipantCount())
+	})
+
+	t.Run("wait times out", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+
+		require.Equal(t, context.DeadlineExceeded, drainer.Wait(ctx))
+	})
+
+	t.Run("wait for sessions to empty", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		waited := make(chan error, 1)
+		go func() {
+			waited <- drainer.Wait(ctx)
+		}()
+
+		h.leaveSession()
+		require.NoError(t, <-waited)
+		require.Zero(t, drainer.ParticipantCount())
+	})
+}
@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealtimeHandlerScopedFeatureFlags(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+	featureFlags := &featureflag.Registry{}
+
+	hA, respondA := joinTestSession(t, sessions, "")
+	defer hA.leaveSession()
+	hA.FeatureFlags = featureFlags
+	session := hA.CurrentSession()
+
+	hB, respondB := joinTestSession(t, sessions, sessions.GlobalSessionID(session.ID))
+	defer hB.leaveSession()
+
+	addEntity := func() int {
+		respondB.mutex.Lock()
+		before := len(respondB.received)
+		respondB.mutex.Unlock()
+
+		handleTestMsg(t, hA.HandleEntityAdd, respondA, &hagallpb.EntityAddRequest{
+			Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+			Pose: &hagallpb.Pose{Px: 1},
+		})
+
+		respondB.mutex.Lock()
+		defer respondB.mutex.Unlock()
+		return len(respondB.received) - before
+	}
+
+	t.Run("session flag disables a broadcast", func(t *testing.T) {
+		require.NoError(t, featureFlags.SetRule(featureflag.Rule{
+			Flag:        featureflag.FlagDisableEntityAddBroadcast,
+			SessionUUID: session.SessionUUID,
+			Percentage:  100,
+		}))
+		require.Zero(t, addEntity())
+	})
+
+	t.Run("flag of another session is ignored", func(t *testing.T) {
+		featureFlags.DeleteSessionRules(session.SessionUUID)
+		require.NoError(t, featureFlags.SetRule(featureflag.Rule{
+			Flag:        featureflag.FlagDisableEntityAddBroadcast,
+			SessionUUID: "another-session",
+			Percentage:  100,
+		}))
+		require.Equal(t, 1, addEntity())
+	})
+}
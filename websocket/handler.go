@@ -65,7 +65,7 @@ type Handler interface {
 	HandleEntityComponentDelete(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error
 
 	// Handles a request to update an entity component.
-	HandleEntityComponentUpdate(ctx context.Context, msg hwebsocket.Msg) error
+	HandleEntityComponentUpdate(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error
 
 	// Handles a request to list entity components.
 	HandleEntityComponentList(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error
@@ -142,6 +142,7 @@ type handler struct {
 	consumer       hwebsocket.Consumer
 	receiver       hwebsocket.Receiver
 	disconnectChan chan error
+	flushChan      chan error
 }
 
 func (h *handler) Handle(ctx context.Context) {
@@ -160,6 +161,7 @@ func (h *handler) Handle(ctx context.Context) {
 	var wg sync.WaitGroup
 
 	h.sendChan = make(chan hwebsocket.Msg, sendChanSize)
+	h.flushChan = make(chan error, 1)
 	h.sender = h.Handler.Sender()
 
 	wg.Add(1)
@@ -188,8 +190,9 @@ func (h *handler) Handle(ctx context.Context) {
 	defer syncClockTicker.Stop()
 
 	var responder = responseSender{
-		send:    h.send,
-		sendMsg: h.sendMsg,
+		send:       h.send,
+		sendMsg:    h.sendMsg,
+		disconnect: h.tryDisconnect,
 	}
 
 	for ctx.Err() == nil {
@@ -257,6 +260,15 @@ func (h *handler) startSending(ctx context.Context) {
 				h.disconnect(errors.New("sending message failed").Wrap(err))
 				return
 			}
+
+		case err := <-h.flushChan:
+			for len(h.sendChan) != 0 {
+				if _, serr := h.sender(<-h.sendChan); serr != nil {
+					break
+				}
+			}
+			h.disconnect(err)
+			return
 		}
 	}
 }
@@ -333,7 +345,7 @@ func (h *handler) handleMessage(ctx context.Context, msg hwebsocket.Msg, respond
 		err = h.Handler.HandleEntityComponentList(ctx, responder, msg)
 
 	case hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE:
-		err = h.Handler.HandleEntityComponentUpdate(ctx, msg)
+		err = h.Handler.HandleEntityComponentUpdate(ctx, responder, msg)
 
 	case hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_SUBSCRIBE_REQUEST:
 		err = h.Handler.HandleEntityComponentSubscribe(ctx, responder, msg)
@@ -349,10 +361,17 @@ func (h *handler) handleMessage(ctx context.Context, msg hwebsocket.Msg, respond
 		return err
 	}
 
-	if h.Handler.CurrentParticipant() == nil || h.Handler.CurrentSession() == nil {
+	participant := h.Handler.CurrentParticipant()
+	if participant == nil || h.Handler.CurrentSession() == nil {
 		return nil
 	}
 
+	// Pings are heartbeats that don't make idle participants active.
+	participant.Heartbeat(time.Now(),
+		msg.Type != hagallpb.MsgType_MSG_TYPE_PING_REQUEST &&
+			msg.Type != hagallpb.MsgType_MSG_TYPE_PING_RESPONSE,
+	)
+
 	for _, m := range h.Handler.GetModules() {
 		if err = h.Handler.HandleWithModule(ctx, m, responder, msg); err != nil {
 			return err
@@ -365,14 +384,35 @@ func (h *handler) disconnect(err error) {
 	h.disconnectChan <- err
 }
 
+// tryDisconnect disconnects the connection from another goroutine once the
+// messages queued before the call are sent. It does nothing when the
+// connection is already disconnecting.
+func (h *handler) tryDisconnect(err error) {
+	select {
+	case h.flushChan <- err:
+	default:
+	}
+}
+
 func (h *handler) handleDisconnect(err error) {
 	h.Conn.Close()
 	h.Handler.HandleDisconnect(err)
 }
 
+// disconnecter is implemented by the response senders that can disconnect
+// their connection.
+type disconnecter interface {
+	Disconnect(error)
+}
+
 type responseSender struct {
-	send    func(hwebsocket.ProtoMsg)
-	sendMsg func(hwebsocket.Msg)
+	send       func(hwebsocket.ProtoMsg)
+	sendMsg    func(hwebsocket.Msg)
+	disconnect func(error)
+}
+
+func (r responseSender) Disconnect(err error) {
+	r.disconnect(err)
 }
 
 func (r responseSender) Send(protoMsg hwebsocket.ProtoMsg) {
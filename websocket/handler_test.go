@@ -3832,3 +3832,154 @@ func TestHandlerFeatureFlagFilter(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorIs(t, err, context.DeadlineExceeded)
 }
+
+func TestHandlerEntityComponentSchemaValidation(t *testing.T) {
+	schemas := &models.EntityComponentSchemaRegistry{}
+	schemas.Register("foo", models.EntityComponentSchema{MaxSize: 3})
+
+	sessionStore := &models.SessionStore{
+		DiscoveryService: &testClient{},
+	}
+	clientA, _, close := NewTestingEnv(t, func() Handler {
+		var h Handler = &RealtimeHandler{
+			ClientSyncClockInterval: time.Millisecond * 250,
+			ClientIdleTimeout:       time.Minute,
+			FrameDuration:           time.Millisecond * 50,
+			Sessions:                sessionStore,
+			EntityComponentSchemas:  schemas,
+		}
+
+		h = HandlerWithLogs(h, time.Millisecond*100)
+		h = HandlerWithMetrics(h, "https://auki-test.com")
+		return h
+	})
+	defer close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var entityID uint32
+	var entityComponentTypeID uint32
+
+	err := scenario.NewScenario(clientA).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.ParticipantJoinRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 1,
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(1),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_RESPONSE),
+		).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityAddRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 2,
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(2),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_RESPONSE),
+			func(msg hwebsocket.Msg) error {
+				var res hagallpb.EntityAddResponse
+				err := msg.DataTo(&res)
+				entityID = res.EntityId
+				return err
+			},
+		).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityComponentTypeAddRequest{
+				Type:                    hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_ADD_REQUEST,
+				Timestamp:               timestamppb.Now(),
+				RequestId:               3,
+				EntityComponentTypeName: "foo",
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(3),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_ADD_RESPONSE),
+			func(msg hwebsocket.Msg) error {
+				var res hagallpb.EntityComponentTypeAddResponse
+				err := msg.DataTo(&res)
+				entityComponentTypeID = res.EntityComponentTypeId
+				return err
+			},
+		).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityComponentAddRequest{
+				Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_REQUEST,
+				Timestamp:             timestamppb.Now(),
+				RequestId:             4,
+				EntityComponentTypeId: entityComponentTypeID,
+				EntityId:              entityID,
+				Data:                  []byte("hello"),
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(4),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE),
+			func(msg hwebsocket.Msg) error {
+				var res hagallpb.ErrorResponse
+				err := msg.DataTo(&res)
+				require.NoError(t, err)
+				require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, res.Code)
+				return err
+			},
+		).
+		Run(ctx)
+	require.NoError(t, err)
+}
+
+func TestHandlerEntityComponentAddMerge(t *testing.T) {
+	schemas := &models.EntityComponentSchemaRegistry{}
+	schemas.Register("score", models.EntityComponentSchema{Kind: models.EntityComponentKindCounter})
+
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	hA, respondA := joinTestSession(t, sessions, "")
+	defer hA.leaveSession()
+	session := hA.CurrentSession()
+
+	hB, respondB := joinTestSession(t, sessions, sessions.GlobalSessionID(session.ID))
+	defer hB.leaveSession()
+
+	store := session.GetEntityComponents()
+	store.SetSchemas(schemas)
+	store.SetHistorySize(10)
+	typeID := store.AddType("score")
+	require.NoError(t, store.Subscribe(typeID, hB.CurrentParticipant().ID))
+
+	handleTestMsg(t, hA.HandleEntityAdd, respondA, &hagallpb.EntityAddRequest{
+		Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		Pose: &hagallpb.Pose{},
+	})
+	entityID := respondA.last().(*hagallpb.EntityAddResponse).EntityId
+
+	data := []byte(`{"p": {"1": 2}}`)
+	handleTestMsg(t, hA.HandleEntityComponentAdd, respondA, &hagallpb.EntityComponentAddRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_REQUEST,
+		EntityComponentTypeId: typeID,
+		EntityId:              entityID,
+		Data:                  data,
+	})
+	require.IsType(t, &hagallpb.EntityComponentAddResponse{}, respondA.last())
+
+	stored, _, err := store.Get(typeID, entityID)
+	require.NoError(t, err)
+	require.NotEqual(t, data, stored.Data)
+
+	t.Run("broadcast carries the merged value", func(t *testing.T) {
+		var broadcast hagallpb.EntityComponentAddBroadcast
+		require.NoError(t, respondB.lastReceived().DataTo(&broadcast))
+		require.Equal(t, stored.Data, broadcast.EntityComponent.Data)
+	})
+
+	t.Run("history records the merged value", func(t *testing.T) {
+		history := store.History(typeID, entityID)
+		require.Len(t, history, 1)
+		require.Equal(t, stored.Data, history[0].Data)
+	})
+}
@@ -0,0 +1,271 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/go-tooling/pkg/logs"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/modules"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// The header that carries the secret shared by the servers that migrate
+	// sessions between each other.
+	MigrationSecretHeader = "X-Hagall-Migration-Secret"
+
+	// The admin path where a server imports migrated sessions.
+	MigrationImportPath = "/sessions/import"
+
+	ErrTypeMigrationFailed = "session-migration-failed"
+)
+
+// MigrationPeer is a server where sessions migrate.
+type MigrationPeer struct {
+	// The admin endpoint of the server, where sessions are imported.
+	AdminEndpoint string
+
+	// The public endpoint of the server, where participants reconnect.
+	Endpoint string
+}
+
+// MigrationResult describes a session imported by a server.
+type MigrationResult struct {
+	// The global id of the imported session.
+	SessionID string `json:"session_id"`
+
+	// The tokens that resume the migrated participants, by participant id.
+	ResumeTokens map[uint32]string `json:"resume_tokens"`
+}
+
+// Migrator moves live sessions between servers. A migrated session keeps its
+// participant, entity and entity component type ids: the participants are
+// asked to reconnect to the new server where they resume with a token.
+type Migrator struct {
+	// Creates the imported sessions. Its modules export and import their
+	// session state when they implement modules.StateMigrator.
+	Handler *RealtimeHandler
+
+	// The time the participants of an imported session have to resume before
+	// their entities are removed.
+	ResumeTimeout time.Duration
+
+	// The secret shared with peer servers.
+	Secret string
+
+	// The client that sends sessions to peer servers. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Export returns the state of the given session, module states included.
+func (m *Migrator) Export(session *models.Session) (models.MigratedSession, error) {
+	migration := session.Migration()
+
+	for _, module := range m.Handler.Modules {
+		migrator, ok := module.(modules.StateMigrator)
+		if !ok {
+			continue
+		}
+
+		state, err := migrator.ExportState(session)
+		if err != nil {
+			return models.MigratedSession{}, errors.New("exporting module state failed").
+				WithTag("module", module.Name()).
+				Wrap(err)
+		}
+		if state == nil {
+			continue
+		}
+
+		if migration.ModuleStates == nil {
+			migration.ModuleStates = make(map[string][]byte)
+		}
+		migration.ModuleStates[module.Name()] = state
+	}
+
+	return migration, nil
+}
+
+// Import creates and starts a session from a migrated session and returns the
+// tokens that resume its participants. Participants that don't resume within
+// the resume timeout lose their non persistent entities.
+func (m *Migrator) Import(ctx context.Context, migration models.MigratedSession) (MigrationResult, error) {
+	h := m.Handler
+
+	session, err := models.RestoreSession(0, h.FrameDuration, migration)
+	if err != nil {
+		return MigrationResult{}, err
+	}
+
+	for _, module := range h.Modules {
+		state, ok := migration.ModuleStates[module.Name()]
+		if !ok {
+			continue
+		}
+
+		migrator, ok := module.(modules.StateMigrator)
+		if !ok {
+			continue
+		}
+
+		if err := migrator.ImportState(session, state); err != nil {
+			session.Close()
+			return MigrationResult{}, errors.New("importing module state failed").
+				WithType(models.ErrTypeInvalidMigration).
+				WithTag("module", module.Name()).
+				Wrap(err)
+		}
+	}
+
+	res := MigrationResult{
+		ResumeTokens: make(map[uint32]string, len(migration.Participants)),
+	}
+	for _, p := range migration.Participants {
+		token, err := newResumeToken()
+		if err != nil {
+			session.Close()
+			return MigrationResult{}, err
+		}
+		session.AddResume(token, p)
+		res.ResumeTokens[p.ID] = token
+	}
+
+	session.ID = h.Sessions.NewID()
+	if err := h.startSession(ctx, session); err != nil {
+		session.Close()
+		return MigrationResult{}, err
+	}
+	res.SessionID = h.Sessions.GlobalSessionID(session.ID)
+
+	time.AfterFunc(m.ResumeTimeout, func() {
+		m.expireResumes(session)
+	})
+	return res, nil
+}
+
+// Migrate moves the given session to the given peer server. The session is
+// locked during the migration, then its participants receive a migrate server
+// message and are disconnected. The session is removed once its last
+// participant is disconnected.
+func (m *Migrator) Migrate(ctx context.Context, session *models.Session, peer MigrationPeer) error {
+	settings := session.Settings()
+	locked := settings
+	locked.Locked = true
+	if err := session.SetSettings(locked); err != nil {
+		return err
+	}
+
+	res, err := m.send(ctx, session, settings, peer)
+	if err != nil {
+		session.SetSettings(settings)
+		return errors.New("migrating session failed").
+			WithType(ErrTypeMigrationFailed).
+			WithTag("session_id", m.Handler.Sessions.GlobalSessionID(session.ID)).
+			WithTag("peer", peer.AdminEndpoint).
+			Wrap(err)
+	}
+
+	for _, p := range session.GetParticipants() {
+		sendServerMessage(session, ServerMessage{
+			Type:        ServerMessageMigrate,
+			Endpoint:    peer.Endpoint,
+			SessionID:   res.SessionID,
+			ResumeToken: res.ResumeTokens[p.ID],
+		}, p.ID)
+		p.Kick(models.LeaveReasonMigrated)
+	}
+	return nil
+}
+
+// MigrateAll moves all the server sessions to the given peer server. Sessions
+// that fail to migrate are logged and stay on the server.
+func (m *Migrator) MigrateAll(ctx context.Context, peer MigrationPeer) {
+	for _, session := range m.Handler.Sessions.List() {
+		if err := m.Migrate(ctx, session, peer); err != nil {
+			logs.Warn(err)
+		}
+	}
+}
+
+// send exports the given session with the given settings and sends it to the
+// peer server.
+func (m *Migrator) send(ctx context.Context, session *models.Session, settings models.SessionSettings, peer MigrationPeer) (MigrationResult, error) {
+	migration, err := m.Export(session)
+	if err != nil {
+		return MigrationResult{}, err
+	}
+	migration.Settings = settings
+
+	body, err := json.Marshal(migration)
+	if err != nil {
+		return MigrationResult{}, errors.New("encoding session failed").Wrap(err)
+	}
+
+	url := strings.TrimSuffix(peer.AdminEndpoint, "/") + MigrationImportPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return MigrationResult{}, errors.New("creating import request failed").Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(MigrationSecretHeader, m.Secret)
+
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpRes, err := client.Do(req)
+	if err != nil {
+		return MigrationResult{}, errors.New("sending session failed").Wrap(err)
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		return MigrationResult{}, errors.New("peer rejected session").
+			WithTag("status", httpRes.StatusCode)
+	}
+
+	var res MigrationResult
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return MigrationResult{}, errors.New("decoding import response failed").Wrap(err)
+	}
+	return res, nil
+}
+
+// expireResumes removes the non persistent entities of the migrated
+// participants that didn't resume, and removes the session when it is empty.
+func (m *Migrator) expireResumes(session *models.Session) {
+	h := m.Handler
+
+	for _, p := range session.TakeResumes() {
+		entityIDs := make(map[uint32]struct{})
+		for _, e := range session.Entities() {
+			if e.ParticipantID == p.ID {
+				entityIDs[e.ID] = struct{}{}
+			}
+		}
+
+		session.GetEntityComponents().UnsubscribeByParticipant(p.ID)
+		h.removeEntities(session, nil, p.ID, entityIDs)
+	}
+
+	if session.ParticipantCount() == 0 {
+		h.Sessions.Remove(context.Background(), session)
+	}
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("generating resume token failed").Wrap(err)
+	}
+	return hex.EncodeToString(b), nil
+}
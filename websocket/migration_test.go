@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall-common/messages/vikjapb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/modules"
+	"github.com/aukilabs/hagall/modules/vikja"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	source := &models.SessionStore{DiscoveryService: &testClient{}}
+	target := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	importer := &Migrator{
+		Handler: &RealtimeHandler{
+			FrameDuration: time.Millisecond * 50,
+			Sessions:      target,
+			Modules:       []modules.Module{&vikja.Module{}},
+		},
+		ResumeTimeout: time.Hour,
+		Secret:        "secret",
+	}
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, MigrationImportPath, r.URL.Path)
+		require.Equal(t, "secret", r.Header.Get(MigrationSecretHeader))
+
+		var migration models.MigratedSession
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&migration))
+
+		res, err := importer.Import(r.Context(), migration)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer peer.Close()
+
+	exporter := &Migrator{
+		Handler: &RealtimeHandler{
+			FrameDuration: time.Millisecond * 50,
+			Sessions:      source,
+			Modules:       []modules.Module{&vikja.Module{}},
+		},
+		Secret: "secret",
+	}
+
+	hA, respondA := joinTestSession(t, source, "")
+	defer hA.leaveSession()
+	hA.CurrentParticipant().ServerMessages = true
+	session := hA.CurrentSession()
+	participantA := hA.CurrentParticipant().ID
+
+	hB, _ := joinTestSession(t, source, source.GlobalSessionID(session.ID))
+	defer hB.leaveSession()
+	participantB := hB.CurrentParticipant().ID
+
+	handleTestMsg(t, hA.HandleEntityAdd, respondA, &hagallpb.EntityAddRequest{
+		Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		Pose: &hagallpb.Pose{Px: 1},
+	})
+	entityA := respondA.last().(*hagallpb.EntityAddResponse).EntityId
+
+	respondB := &recordingResponder{}
+	handleTestMsg(t, hB.HandleEntityAdd, respondB, &hagallpb.EntityAddRequest{
+		Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		Pose: &hagallpb.Pose{Px: 2},
+	})
+	entityB := respondB.last().(*hagallpb.EntityAddResponse).EntityId
+
+	vikjaState := &vikja.State{}
+	vikjaState.SetEntityAction(&vikjapb.EntityAction{EntityId: entityA, Name: "wave"})
+	session.SetModuleState("vikja", vikjaState)
+
+	var migration ServerMessage
+	t.Run("session migrates", func(t *testing.T) {
+		require.NoError(t, exporter.Migrate(context.Background(), session, MigrationPeer{
+			AdminEndpoint: peer.URL,
+			Endpoint:      "wss://peer.example.com",
+		}))
+		require.True(t, session.Settings().Locked)
+
+		broadcast, ok := respondA.last().(*hagallpb.CustomMessageBroadcast)
+		require.True(t, ok)
+		require.NoError(t, json.Unmarshal(broadcast.Body, &migration))
+		require.Equal(t, ServerMessageMigrate, migration.Type)
+		require.Equal(t, "wss://peer.example.com", migration.Endpoint)
+		require.NotEmpty(t, migration.SessionID)
+		require.NotEmpty(t, migration.ResumeToken)
+
+		hA.leaveSession()
+		hB.leaveSession()
+		require.Empty(t, source.List())
+	})
+
+	imported, ok := target.GetByGlobalID(migration.SessionID)
+	require.True(t, ok)
+
+	t.Run("participant resumes", func(t *testing.T) {
+		resuming := &RealtimeHandler{
+			FrameDuration: time.Millisecond * 50,
+			Sessions:      target,
+			Modules:       []modules.Module{&vikja.Module{}},
+			resumeToken:   migration.ResumeToken,
+		}
+		respond := &recordingResponder{}
+		defer resuming.leaveSession()
+
+		handleTestMsg(t, func(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
+			return resuming.HandleParticipantJoin(ctx, func() {}, respond, msg)
+		}, respond, &hagallpb.ParticipantJoinRequest{
+			Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+			SessionId: migration.SessionID,
+		})
+
+		participant := resuming.CurrentParticipant()
+		require.NotNil(t, participant)
+		require.Equal(t, participantA, participant.ID)
+		require.True(t, imported.IsHost(participant))
+		require.Contains(t, participant.EntityIDs(), entityA)
+
+		state, ok := imported.ModuleState("vikja")
+		require.True(t, ok)
+		_, ok = state.(*vikja.State).EntityAction(entityA, "wave")
+		require.True(t, ok)
+
+		t.Run("participants that don't resume lose their entities", func(t *testing.T) {
+			importer.expireResumes(imported)
+
+			_, ok := imported.EntityByID(entityB)
+			require.False(t, ok)
+			_, ok = imported.EntityByID(entityA)
+			require.True(t, ok)
+
+			var broadcast hagallpb.EntityDeleteBroadcast
+			require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+			require.Equal(t, entityB, broadcast.EntityId)
+		})
+
+		t.Run("resume token can't be reused", func(t *testing.T) {
+			_, ok := imported.TakeResume(migration.ResumeToken)
+			require.False(t, ok)
+		})
+
+		t.Run("new participants get new ids", func(t *testing.T) {
+			h, _ := joinTestSession(t, target, migration.SessionID)
+			defer h.leaveSession()
+
+			require.NotNil(t, h.CurrentParticipant())
+			require.Greater(t, h.CurrentParticipant().ID, participantB)
+		})
+	})
+
+	t.Run("empty session is removed", func(t *testing.T) {
+		require.Empty(t, target.List())
+	})
+}
+
+type importRecorder struct {
+	vikja.Module
+	imported *models.Session
+}
+
+func (m *importRecorder) ImportState(s *models.Session, data []byte) error {
+	m.imported = s
+	return m.Module.ImportState(s, data)
+}
+
+func TestMigratorImport(t *testing.T) {
+	t.Run("taken session name closes the imported session", func(t *testing.T) {
+		sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+		module := &importRecorder{}
+		importer := &Migrator{
+			Handler: &RealtimeHandler{
+				FrameDuration: time.Millisecond * 50,
+				Sessions:      sessions,
+				Modules:       []modules.Module{module},
+			},
+			ResumeTimeout: time.Hour,
+			Secret:        "secret",
+		}
+
+		lobby := models.NewSession(sessions.NewID(), time.Millisecond*50)
+		defer lobby.Close()
+		lobby.AppKey = "app"
+		lobby.SetInfo(models.SessionInfo{Name: "Lobby"})
+		require.NoError(t, sessions.Add(context.Background(), lobby))
+		defer sessions.Remove(context.Background(), lobby)
+
+		_, err := importer.Import(context.Background(), models.MigratedSession{
+			AppKey:       "app",
+			Info:         models.SessionInfo{Name: "Lobby"},
+			ModuleStates: map[string][]byte{"vikja": []byte(`[]`)},
+		})
+		require.True(t, errors.IsType(err, models.ErrTypeSessionNameTaken))
+		require.Len(t, sessions.List(), 1)
+		require.NotNil(t, module.imported)
+
+		// A closed session stops dispatching frames right away.
+		stopped := make(chan struct{})
+		go func() {
+			module.imported.StartDispatchFrames()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("imported session is not closed")
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/aukilabs/hagall/models"
+)
+
+// The minimum interval between presence updates of a session.
+const presenceUpdateInterval = time.Second
+
+// presenceUpdater returns a session frame handler that updates the presence of
+// the session participants and publishes their changes.
+func presenceUpdater(sessions *models.SessionStore, session *models.Session, idleAfter, backgroundedAfter time.Duration) func() {
+	var lastUpdate time.Time
+
+	return func() {
+		if idleAfter == 0 && backgroundedAfter == 0 {
+			return
+		}
+
+		now := time.Now()
+		if now.Sub(lastUpdate) < presenceUpdateInterval {
+			return
+		}
+		lastUpdate = now
+
+		for _, p := range session.GetParticipants() {
+			if !p.UpdatePresence(now, idleAfter, backgroundedAfter) {
+				continue
+			}
+
+			publishParticipantUpdate(sessions, session, p)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceUpdater(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	var events []models.SessionEvent
+	cancel := sessions.HandleEvents(func(e models.SessionEvent) {
+		if e.Type == models.SessionEventParticipantUpdated {
+			events = append(events, e)
+		}
+	})
+	defer cancel()
+
+	h, respond := joinTestSession(t, sessions, "")
+	defer h.leaveSession()
+
+	participant := h.CurrentParticipant()
+	participant.SetInfo(models.ParticipantInfo{DisplayName: "Ted"})
+	participant.ServerMessages = true
+	participant.Heartbeat(time.Now().Add(-time.Minute), true)
+
+	update := presenceUpdater(sessions, h.CurrentSession(), time.Second*30, time.Second*45)
+	update()
+	require.Len(t, events, 1)
+	require.Equal(t, participant.ID, events[0].ParticipantID)
+	require.Equal(t, models.PresenceBackgrounded, events[0].Presence)
+	require.Equal(t, "Ted", events[0].Participant.DisplayName)
+
+	broadcast, ok := respond.last().(*hagallpb.CustomMessageBroadcast)
+	require.True(t, ok)
+
+	var msg ServerMessage
+	require.NoError(t, json.Unmarshal(broadcast.Body, &msg))
+	require.Equal(t, ServerMessageParticipantUpdated, msg.Type)
+	require.Equal(t, participant.ID, msg.ParticipantID)
+	require.Equal(t, models.PresenceBackgrounded, msg.Presence)
+	require.Equal(t, "Ted", msg.Participant.DisplayName)
+
+	// Updates are throttled.
+	participant.Heartbeat(time.Now(), true)
+	update()
+	require.Len(t, events, 1)
+}
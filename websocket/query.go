@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+)
+
+// participantInfoFromQuery returns the participant info set in the query
+// parameters of a connection request.
+func participantInfoFromQuery(query url.Values) (models.ParticipantInfo, error) {
+	info := models.ParticipantInfo{
+		DisplayName: query.Get(DisplayNameQueryParam),
+		Avatar:      query.Get(AvatarQueryParam),
+		DeviceType:  query.Get(DeviceTypeQueryParam),
+	}
+
+	if metadata := query.Get(MetadataQueryParam); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &info.Metadata); err != nil {
+			return models.ParticipantInfo{}, errors.New("parsing participant metadata failed").
+				WithTag("metadata", metadata).
+				Wrap(err)
+		}
+	}
+
+	return info, info.Validate()
+}
+
+// sessionInfoFromQuery returns the info of the sessions created by a
+// connection, set in the query parameters of the connection request.
+func sessionInfoFromQuery(query url.Values) (models.SessionInfo, error) {
+	info := models.SessionInfo{
+		Name: query.Get(SessionNameQueryParam),
+	}
+	info.Private, _ = strconv.ParseBool(query.Get(PrivateSessionQueryParam))
+
+	if metadata := query.Get(SessionMetadataQueryParam); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &info.Metadata); err != nil {
+			return models.SessionInfo{}, errors.New("parsing session metadata failed").
+				WithTag("metadata", metadata).
+				Wrap(err)
+		}
+	}
+
+	return info, info.Validate()
+}
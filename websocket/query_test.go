@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParticipantInfoFromQuery(t *testing.T) {
+	t.Run("info", func(t *testing.T) {
+		info, err := participantInfoFromQuery(url.Values{
+			DisplayNameQueryParam: {"Ted"},
+			AvatarQueryParam:      {"https://example.com/ted.png"},
+			DeviceTypeQueryParam:  {"phone"},
+			MetadataQueryParam:    {`{"team":"red"}`},
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ParticipantInfo{
+			DisplayName: "Ted",
+			Avatar:      "https://example.com/ted.png",
+			DeviceType:  "phone",
+			Metadata:    map[string]string{"team": "red"},
+		}, info)
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		_, err := participantInfoFromQuery(url.Values{
+			MetadataQueryParam: {`{"team":42}`},
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("too large info", func(t *testing.T) {
+		_, err := participantInfoFromQuery(url.Values{
+			DisplayNameQueryParam: {string(make([]byte, models.ParticipantInfoMaxSize+1))},
+		})
+		require.True(t, errors.IsType(err, models.ErrTypeParticipantInfoTooLarge))
+	})
+}
+
+func TestSessionInfoFromQuery(t *testing.T) {
+	t.Run("info", func(t *testing.T) {
+		info, err := sessionInfoFromQuery(url.Values{
+			SessionNameQueryParam:     {"Lobby"},
+			SessionMetadataQueryParam: {`{"map":"park"}`},
+			PrivateSessionQueryParam:  {"true"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.SessionInfo{
+			Name:     "Lobby",
+			Metadata: map[string]string{"map": "park"},
+			Private:  true,
+		}, info)
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		_, err := sessionInfoFromQuery(url.Values{
+			SessionMetadataQueryParam: {"park"},
+		})
+		require.Error(t, err)
+	})
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"crypto/ecdsa"
+	"strconv"
 	"time"
 
 	"github.com/aukilabs/go-tooling/pkg/errors"
@@ -17,7 +18,34 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-const customMessageMaxSize = 10240
+const (
+	customMessageMaxSize = 10240
+
+	// The query parameter that makes the participants joined by a connection
+	// spectators.
+	SpectatorQueryParam = "spectator"
+
+	// The query parameters that set the info of the participants joined by a
+	// connection. Metadata is a JSON object with string values.
+	DisplayNameQueryParam = "display_name"
+	AvatarQueryParam      = "avatar"
+	DeviceTypeQueryParam  = "device_type"
+	MetadataQueryParam    = "metadata"
+
+	// The query parameters that set the info of the sessions created by a
+	// connection. Metadata is a JSON object with string values.
+	SessionNameQueryParam     = "session_name"
+	SessionMetadataQueryParam = "session_metadata"
+	PrivateSessionQueryParam  = "private_session"
+
+	// The query parameter that makes the connection exchange server messages.
+	// See ServerMessage.
+	ServerMessagesQueryParam = "server_messages"
+
+	// The query parameter that resumes a participant of a session migrated
+	// from another server. See ServerMessageMigrate.
+	ResumeTokenQueryParam = "resume_token"
+)
 
 // RealtimeHandler represents a service that manages multiple client connections
 // and relays their actions in realtime.
@@ -38,7 +66,26 @@ type RealtimeHandler struct {
 	// The module that expand Hagall features.
 	Modules []modules.Module
 
-	FeatureFlags featureflag.FeatureFlag
+	// The feature flags, evaluated for the session of each message.
+	FeatureFlags featureflag.Provider
+
+	// The schemas used to validate entity component data.
+	EntityComponentSchemas *models.EntityComponentSchemaRegistry
+
+	// The number of changes retained for each entity component. History is
+	// disabled when zero.
+	EntityComponentHistorySize int
+
+	// The time without activity before a participant is idle. Pings are not
+	// considered as activity. Idle presence is disabled when zero.
+	ParticipantIdleAfter time.Duration
+
+	// The time without any message, pings included, before a participant is
+	// backgrounded. Backgrounded presence is disabled when zero.
+	ParticipantBackgroundedAfter time.Duration
+
+	// Rejects joins while the server is draining. Optional.
+	Drainer *Drainer
 
 	// channel for sending incoming receipts to ReceiptHandler goroutine
 	ReceiptChan chan ncsclient.ReceiptPayload
@@ -51,14 +98,26 @@ type RealtimeHandler struct {
 
 	stopFrameHandling func()
 
-	clientID string
-	appKey   string
+	clientID           string
+	appKey             string
+	spectator          bool
+	serverMessages     bool
+	resumeToken        string
+	participantInfo    models.ParticipantInfo
+	participantInfoErr error
+	sessionInfo        models.SessionInfo
+	sessionInfoErr     error
 }
 
 func (h *RealtimeHandler) HandleConnect(conn *websocket.Conn) {
 	req := conn.Request()
 	h.clientID = req.Header.Get(httpcmn.HeaderPosemeshClientID)
 	h.appKey = httpcmn.GetAppKeyFromHagallUserToken(httpcmn.GetUserTokenFromHTTPRequest(req))
+	h.spectator, _ = strconv.ParseBool(req.URL.Query().Get(SpectatorQueryParam))
+	h.serverMessages, _ = strconv.ParseBool(req.URL.Query().Get(ServerMessagesQueryParam))
+	h.resumeToken = req.URL.Query().Get(ResumeTokenQueryParam)
+	h.participantInfo, h.participantInfoErr = participantInfoFromQuery(req.URL.Query())
+	h.sessionInfo, h.sessionInfoErr = sessionInfoFromQuery(req.URL.Query())
 
 	h.conn = conn
 }
@@ -142,6 +201,19 @@ func (h *RealtimeHandler) HandleSignedLatency(ctx context.Context, respond hwebs
 
 	}
 
+	// Banned wallets can't be used in the session.
+	h.currentParticipant.SetWalletAddress(req.WalletAddress)
+	if h.currentSession.IsBanned(req.WalletAddress) {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		kickParticipant(h.currentSession, h.currentParticipant, true)
+		return nil
+	}
+
 	h.currentParticipant.SignedLatency.Start(h.PrivateKey, respond, req.RequestId, req.IterationCount,
 		h.currentSession.SessionUUID, h.clientID, req.WalletAddress)
 
@@ -164,10 +236,33 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 		return nil
 	}
 
+	// Draining servers don't accept joins. Participants keep their current
+	// session.
+	if h.Drainer != nil && h.Drainer.Draining() {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_SERVER_TOO_BUSY,
+		})
+		return nil
+	}
+
 	if h.currentParticipant != nil {
 		h.leaveSession()
 	}
 
+	// Participants can't join or create sessions with an invalid info.
+	if h.participantInfoErr != nil || (req.SessionId == "" && h.sessionInfoErr != nil) {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST,
+		})
+		return nil
+	}
+
 	session, ok := h.Sessions.GetByGlobalID(req.SessionId)
 	if !ok && req.SessionId != "" {
 		respond.Send(&hagallpb.ErrorResponse{
@@ -179,10 +274,42 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 		return nil
 	}
 
+	// Joining without session id joins the session named by the connection
+	// when it exists.
+	if !ok && h.sessionInfo.Name != "" {
+		session, ok = h.Sessions.GetByName(h.appKey, h.sessionInfo.Name)
+	}
+
+	// Banned clients can't join the session again.
+	if ok && session.IsBanned(h.clientID) {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
+	// Spectators only join existing sessions.
+	if !ok && h.spectator {
+		code := hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST
+		if h.sessionInfo.Name != "" {
+			code = hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND
+		}
+
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      code,
+		})
+		return nil
+	}
+
 	if !ok {
-		session = models.NewSession(h.Sessions.NewID(), h.FrameDuration)
-		session.AppKey = h.appKey
-		if err := h.Sessions.Add(ctx, session); err != nil {
+		var err error
+		if session, err = h.CreateSession(ctx, h.appKey, h.sessionInfo); err != nil {
 			respond.Send(&hagallpb.ErrorResponse{
 				Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
 				Timestamp: timestamppb.Now(),
@@ -191,16 +318,58 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 			})
 			return nil
 		}
-		go session.StartDispatchFrames()
 	}
 
 	participant := &models.Participant{
-		ID:            session.NewParticipantID(),
-		Responder:     respond,
-		SignedLatency: &models.SignedLatency{},
+		Responder:      respond,
+		Spectator:      h.spectator,
+		ServerMessages: h.serverMessages,
+		ClientID:       h.clientID,
+		SignedLatency:  &models.SignedLatency{},
+	}
+	if d, ok := respond.(disconnecter); ok {
+		participant.Disconnect = d.Disconnect
+	}
+	participant.SetInfo(h.participantInfo)
+	participant.Heartbeat(time.Now(), true)
+
+	// Participants resuming from a migration keep their id, info and entities,
+	// and bypass the session lock and capacity.
+	resumed, resuming := session.TakeResume(h.resumeToken)
+	if resuming {
+		participant.ID = resumed.ID
+		participant.Spectator = resumed.Spectator
+		participant.SetInfo(resumed.Info)
+		participant.SetWalletAddress(resumed.WalletAddress)
+		for _, e := range session.Entities() {
+			if e.ParticipantID == resumed.ID {
+				participant.AddEntity(e)
+			}
+		}
+	} else {
+		participant.ID = session.NewParticipantID()
 	}
 
-	session.AddParticipant(participant)
+	host := session.Host()
+	if resuming {
+		session.AddParticipant(participant)
+		if resumed.Host {
+			session.SetHost(participant.ID)
+		}
+	} else if err := session.AdmitParticipant(participant); err != nil {
+		code := hagallpb.ErrorCode_ERROR_CODE_CONFLICT
+		if errors.IsType(err, models.ErrTypeSessionFull) {
+			code = hagallpb.ErrorCode_ERROR_CODE_SERVER_TOO_BUSY
+		}
+
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      code,
+		})
+		return nil
+	}
 	h.stopFrameHandling = session.HandleFrame(handleFrame)
 
 	respond.Send(&hagallpb.ParticipantJoinResponse{
@@ -215,7 +384,16 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 	h.currentSession = session
 	h.currentParticipant = participant
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableSessionState, func() {
+	participantInfo := participant.Info()
+	h.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventParticipantJoined,
+		ParticipantID: participant.ID,
+		Spectator:     participant.Spectator,
+		Participant:   &participantInfo,
+		Presence:      participant.Presence(),
+	})
+
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableSessionState, func() {
 		respond.Send(&hagallpb.SessionState{
 			Type:             hagallpb.MsgType_MSG_TYPE_SESSION_STATE,
 			Timestamp:        timestamppb.Now(),
@@ -225,7 +403,11 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 		})
 	})
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableParticipantJoinBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableParticipantJoinBroadcast, func() {
+		if participant.Spectator {
+			return
+		}
+
 		session.Broadcast(participant, &hagallpb.ParticipantJoinBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_BROADCAST,
 			Timestamp:       timestamppb.Now(),
@@ -234,6 +416,33 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 		})
 	})
 
+	// The participant becomes the host when it creates the session or joins a
+	// session that only contains spectators.
+	if session.Host() != host {
+		h.publishHostChange(session)
+	} else if host != 0 {
+		sendServerMessage(session, ServerMessage{
+			Type:   ServerMessageHostChanged,
+			HostID: host,
+		}, participant.ID)
+	}
+
+	// The info of the participants is not part of the session state, so it is
+	// sent to the joining participant and the joining participant info to the
+	// others.
+	sendServerMessage(session, ServerMessage{
+		Type:         ServerMessageParticipants,
+		Participants: participantStates(session.GetParticipants()),
+	}, participant.ID)
+	if others := otherParticipantIDs(session, participant); !participant.Spectator && len(others) != 0 {
+		sendServerMessage(session, ServerMessage{
+			Type:          ServerMessageParticipantUpdated,
+			ParticipantID: participant.ID,
+			Participant:   &participantInfo,
+			Presence:      participant.Presence(),
+		}, others...)
+	}
+
 	for _, m := range h.Modules {
 		m.Init(session, participant)
 	}
@@ -241,6 +450,44 @@ func (h *RealtimeHandler) HandleParticipantJoin(ctx context.Context, handleFrame
 	return nil
 }
 
+// CreateSession creates and starts a session with the given app key and info.
+// When a session with the same app key and name was created concurrently, that
+// session is returned instead.
+func (h *RealtimeHandler) CreateSession(ctx context.Context, appKey string, info models.SessionInfo) (*models.Session, error) {
+	session := models.NewSession(h.Sessions.NewID(), h.FrameDuration)
+	session.AppKey = appKey
+	session.SetInfo(info)
+
+	if err := h.startSession(ctx, session); err != nil {
+		if errors.IsType(err, models.ErrTypeSessionNameTaken) {
+			if named, ok := h.Sessions.GetByName(appKey, info.Name); ok {
+				return named, nil
+			}
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// startSession adds the given session to the store and starts dispatching its
+// frames. The session is closed when it can't be added.
+func (h *RealtimeHandler) startSession(ctx context.Context, session *models.Session) error {
+	session.GetEntityComponents().SetSchemas(h.EntityComponentSchemas)
+	session.GetEntityComponents().SetHistorySize(h.EntityComponentHistorySize)
+
+	if err := h.Sessions.Add(ctx, session); err != nil {
+		session.Close()
+		return err
+	}
+
+	session.HandleFrame(func() {
+		flushThrottledEntityComponents(session)
+	})
+	session.HandleFrame(presenceUpdater(h.Sessions, session, h.ParticipantIdleAfter, h.ParticipantBackgroundedAfter))
+	go session.StartDispatchFrames()
+	return nil
+}
+
 func (h *RealtimeHandler) HandleDisconnect(_ error) {
 	if h.currentParticipant != nil {
 		h.leaveSession()
@@ -261,6 +508,16 @@ func (h *RealtimeHandler) HandleEntityAdd(ctx context.Context, respond hwebsocke
 			WithTag("msg_type", msg.Type)
 	}
 
+	if participant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	entity := &models.Entity{
 		ID:            session.NewEntityID(),
 		ParticipantID: participant.ID,
@@ -282,6 +539,15 @@ func (h *RealtimeHandler) HandleEntityAdd(ctx context.Context, respond hwebsocke
 
 	session.AddEntity(entity)
 	participant.AddEntity(entity)
+	session.UndoHistory().Record(participant.ID, models.UndoOp{
+		Kind:   models.UndoOpEntityAdd,
+		Entity: models.NewEntitySnapshot(entity),
+	})
+	h.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventEntityAdded,
+		ParticipantID: participant.ID,
+		EntityID:      entity.ID,
+	})
 
 	now := timestamppb.Now()
 
@@ -292,7 +558,7 @@ func (h *RealtimeHandler) HandleEntityAdd(ctx context.Context, respond hwebsocke
 		EntityId:  entity.ID,
 	})
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityAddBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityAddBroadcast, func() {
 		session.Broadcast(participant, &hagallpb.EntityAddBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_BROADCAST,
 			Timestamp:       now,
@@ -318,6 +584,16 @@ func (h *RealtimeHandler) HandleEntityDelete(ctx context.Context, respond hwebso
 			WithTag("msg_type", msg.Type)
 	}
 
+	if participant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	entity, ok := session.EntityByID(req.EntityId)
 	if !ok {
 		respond.Send(&hagallpb.ErrorResponse{
@@ -341,9 +617,20 @@ func (h *RealtimeHandler) HandleEntityDelete(ctx context.Context, respond hwebso
 
 	now := timestamppb.Now()
 
+	session.UndoHistory().Record(participant.ID, models.UndoOp{
+		Kind:             models.UndoOpEntityDelete,
+		Entity:           models.NewEntitySnapshot(entity),
+		EntityComponents: session.GetEntityComponents().ListByEntityID(entity.ID),
+	})
+
 	session.GetEntityComponents().DeleteByEntityID(entity.ID)
 	session.RemoveEntity(entity)
 	participant.RemoveEntity(entity)
+	h.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventEntityRemoved,
+		ParticipantID: participant.ID,
+		EntityID:      entity.ID,
+	})
 
 	respond.Send(&hagallpb.EntityDeleteResponse{
 		Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_RESPONSE,
@@ -351,7 +638,7 @@ func (h *RealtimeHandler) HandleEntityDelete(ctx context.Context, respond hwebso
 		RequestId: req.RequestId,
 	})
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
 		session.Broadcast(participant, &hagallpb.EntityDeleteBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST,
 			Timestamp:       now,
@@ -377,6 +664,11 @@ func (h *RealtimeHandler) HandleEntityUpdatePose(ctx context.Context, msg hwebso
 			WithTag("msg_type", msg.Type)
 	}
 
+	// Spectators can't modify the session.
+	if participant.Spectator {
+		return nil
+	}
+
 	entity, ok := session.EntityByID(update.EntityId)
 	if !ok {
 		return nil
@@ -386,6 +678,7 @@ func (h *RealtimeHandler) HandleEntityUpdatePose(ctx context.Context, msg hwebso
 		return nil
 	}
 
+	poseBefore := entity.Pose()
 	entity.SetPose(models.Pose{
 		PX: update.Pose.Px,
 		PY: update.Pose.Py,
@@ -395,8 +688,14 @@ func (h *RealtimeHandler) HandleEntityUpdatePose(ctx context.Context, msg hwebso
 		RZ: update.Pose.Rz,
 		RW: update.Pose.Rw,
 	})
+	session.UndoHistory().Record(participant.ID, models.UndoOp{
+		Kind:       models.UndoOpEntityPose,
+		Entity:     models.EntitySnapshot{ID: entity.ID},
+		PoseBefore: poseBefore,
+		PoseAfter:  entity.Pose(),
+	})
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityUpdatePoseBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityUpdatePoseBroadcast, func() {
 		session.Broadcast(participant, &hagallpb.EntityUpdatePoseBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE_BROADCAST,
 			Timestamp:       timestamppb.Now(),
@@ -423,6 +722,20 @@ func (h *RealtimeHandler) HandleCustomMessage(ctx context.Context, respond hwebs
 			WithTag("msg_type", msg.Type)
 	}
 
+	if isServerRequest(participant, &customMessage) {
+		h.handleServerRequest(customMessage.Body)
+		return nil
+	}
+
+	if participant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	if len(customMessage.Body) > customMessageMaxSize {
 		respond.Send(&hagallpb.ErrorResponse{
 			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
@@ -432,7 +745,7 @@ func (h *RealtimeHandler) HandleCustomMessage(ctx context.Context, respond hwebs
 		return nil
 	}
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableCustomMessageBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableCustomMessageBroadcast, func() {
 		customMessageBroadcast := hagallpb.CustomMessageBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE_BROADCAST,
 			Timestamp:       timestamppb.Now(),
@@ -474,6 +787,16 @@ func (h *RealtimeHandler) HandleEntityComponentTypeAdd(ctx context.Context, resp
 			WithTag("msg_type", msg.Type)
 	}
 
+	if h.currentParticipant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	respond.Send(&hagallpb.EntityComponentTypeAddResponse{
 		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_TYPE_ADD_RESPONSE,
 		Timestamp:             timestamppb.Now(),
@@ -593,6 +916,16 @@ func (h *RealtimeHandler) HandleEntityComponentAdd(ctx context.Context, respond
 			WithTag("msg_type", msg.Type)
 	}
 
+	if participant.Spectator {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
+		})
+		return nil
+	}
+
 	entity, ok := session.EntityByID(req.EntityId)
 	if !ok {
 		respond.Send(&hagallpb.ErrorResponse{
@@ -604,30 +937,31 @@ func (h *RealtimeHandler) HandleEntityComponentAdd(ctx context.Context, respond
 		return nil
 	}
 
-	entityComponent := hagallpb.EntityComponent{
+	// The stored entity component is broadcasted since the data of mergeable
+	// kinds differs from the request data.
+	entityComponent, err := session.GetEntityComponents().Create(&hagallpb.EntityComponent{
 		EntityComponentTypeId: req.EntityComponentTypeId,
 		EntityId:              entity.ID,
 		Data:                  req.Data,
-	}
-
-	if err := session.GetEntityComponents().Add(&entityComponent); err != nil {
-		var errCode hagallpb.ErrorCode
-		switch errors.Type(err) {
-		case hwebsocket.ErrEntityComponentTypeAlreadyAdded:
-			errCode = hagallpb.ErrorCode_ERROR_CODE_CONFLICT
-		default:
-			errCode = hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND
-		}
-
+	})
+	if err != nil {
 		respond.Send(&hagallpb.ErrorResponse{
 			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
 			Timestamp: timestamppb.Now(),
 			RequestId: req.RequestId,
-			Code:      errCode,
+			Code:      entityComponentErrorCode(err),
 		})
 		return nil
 	}
 
+	session.GetEntityComponents().RecordChange(models.NewEntityComponentChange(
+		models.EntityComponentOpAdd,
+		entityComponent,
+		1,
+		participant.ID,
+	))
+	recordEntityComponentUndo(session, participant, nil, entityComponent)
+
 	now := timestamppb.Now()
 
 	respond.Send(&hagallpb.EntityComponentAddResponse{
@@ -636,13 +970,13 @@ func (h *RealtimeHandler) HandleEntityComponentAdd(ctx context.Context, respond
 		RequestId: req.RequestId,
 	})
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityComponentAddBroadcast, func() {
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityComponentAddBroadcast, func() {
 		session.GetEntityComponents().Notify(entityComponent.EntityComponentTypeId, func(participantIDs []uint32) {
 			session.Broadcast(participant, &hagallpb.EntityComponentAddBroadcast{
 				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_BROADCAST,
 				Timestamp:       now,
 				OriginTimestamp: req.Timestamp,
-				EntityComponent: &entityComponent,
+				EntityComponent: entityComponent,
 			})
 		})
 	})
@@ -674,18 +1008,18 @@ func (h *RealtimeHandler) HandleEntityComponentDelete(ctx context.Context, respo
 			WithTag("msg_type", msg.Type)
 	}
 
-	entity, ok := session.EntityByID(req.EntityId)
-	if !ok {
+	if participant.Spectator {
 		respond.Send(&hagallpb.ErrorResponse{
 			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
 			Timestamp: timestamppb.Now(),
 			RequestId: req.RequestId,
-			Code:      hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND,
+			Code:      hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED,
 		})
 		return nil
 	}
 
-	if !session.GetEntityComponents().Delete(req.EntityComponentTypeId, entity.ID) {
+	entity, ok := session.EntityByID(req.EntityId)
+	if !ok {
 		respond.Send(&hagallpb.ErrorResponse{
 			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
 			Timestamp: timestamppb.Now(),
@@ -695,19 +1029,15 @@ func (h *RealtimeHandler) HandleEntityComponentDelete(ctx context.Context, respo
 		return nil
 	}
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityComponentDeleteBroadcast, func() {
-		session.GetEntityComponents().Notify(req.EntityComponentTypeId, func(participantIDs []uint32) {
-			session.Broadcast(participant, &hagallpb.EntityComponentDeleteBroadcast{
-				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_BROADCAST,
-				Timestamp:       timestamppb.Now(),
-				OriginTimestamp: req.Timestamp,
-				EntityComponent: &hagallpb.EntityComponent{
-					EntityComponentTypeId: req.EntityComponentTypeId,
-					EntityId:              entity.ID,
-				},
-			})
+	if _, _, err := h.removeEntityComponent(req.EntityComponentTypeId, entity.ID, 0, req.Timestamp); err != nil {
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			RequestId: req.RequestId,
+			Code:      entityComponentErrorCode(err),
 		})
-	})
+		return nil
+	}
 
 	respond.Send(&hagallpb.EntityComponentDeleteResponse{
 		Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_RESPONSE,
@@ -717,7 +1047,7 @@ func (h *RealtimeHandler) HandleEntityComponentDelete(ctx context.Context, respo
 	return nil
 }
 
-func (h *RealtimeHandler) HandleEntityComponentUpdate(ctx context.Context, msg hwebsocket.Msg) error {
+func (h *RealtimeHandler) HandleEntityComponentUpdate(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
 	var req hagallpb.EntityComponentUpdate
 	if err := msg.DataTo(&req); err != nil {
 		return err
@@ -735,31 +1065,190 @@ func (h *RealtimeHandler) HandleEntityComponentUpdate(ctx context.Context, msg h
 			WithTag("msg_type", msg.Type)
 	}
 
+	// Spectators can't modify the session.
+	if participant.Spectator {
+		return nil
+	}
+
 	entity, ok := session.EntityByID(req.EntityId)
 	if !ok {
 		return nil
 	}
 
-	entityComponent := hagallpb.EntityComponent{
+	_, _, err := h.updateEntityComponent(&hagallpb.EntityComponent{
 		EntityComponentTypeId: req.EntityComponentTypeId,
 		EntityId:              entity.ID,
 		Data:                  req.Data,
+	}, 0, req.Timestamp)
+	if err != nil {
+		// Updates have no request id: the error response is not related to a
+		// request.
+		respond.Send(&hagallpb.ErrorResponse{
+			Type:      hagallpb.MsgType_MSG_TYPE_ERROR_RESPONSE,
+			Timestamp: timestamppb.Now(),
+			Code:      entityComponentErrorCode(err),
+		})
 	}
 
-	session.GetEntityComponents().Update(&entityComponent)
+	return nil
+}
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityComponentUpdateBroadcast, func() {
-		session.GetEntityComponents().Notify(entityComponent.EntityComponentTypeId, func(participantIDs []uint32) {
+// serveEntityComponentRequest executes an entity component server request of
+// the current participant.
+func (h *RealtimeHandler) serveEntityComponentRequest(req ServerMessage, res *ServerMessage) (hagallpb.ErrorCode, bool) {
+	session := h.currentSession
+	participant := h.currentParticipant
+
+	if req.EntityComponentTypeID == 0 || req.EntityID == 0 {
+		return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+	}
+	if participant.Spectator {
+		return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+	}
+	if _, ok := session.EntityByID(req.EntityID); !ok {
+		return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, false
+	}
+
+	var ec *hagallpb.EntityComponent
+	var version uint64
+	var err error
+	if req.Type == ServerRequestUpdateEntityComponent {
+		ec, version, err = h.updateEntityComponent(&hagallpb.EntityComponent{
+			EntityComponentTypeId: req.EntityComponentTypeID,
+			EntityId:              req.EntityID,
+			Data:                  req.Data,
+		}, req.Version, timestamppb.Now())
+	} else {
+		ec, version, err = h.removeEntityComponent(req.EntityComponentTypeID, req.EntityID, req.Version, timestamppb.Now())
+	}
+
+	// Successful changes return the stored entity component, and version
+	// conflicts the current one so that the participant can resolve the
+	// conflict and retry.
+	if ec != nil && (err == nil || errors.IsType(err, models.ErrTypeEntityComponentVersionConflict)) {
+		res.EntityComponentTypeID = ec.EntityComponentTypeId
+		res.EntityID = ec.EntityId
+		res.Data = ec.Data
+		res.Version = version
+	}
+	if err != nil {
+		return entityComponentErrorCode(err), false
+	}
+	return hagallpb.ErrorCode_ERROR_CODE_UNKNOWN, true
+}
+
+// updateEntityComponent updates an entity component of the current session,
+// records the change and broadcasts it to the subscribers, the current
+// participant excluded. When version is not zero, the entity component is only
+// updated when version is its current version.
+//
+// It returns the stored entity component with its new version, or the current
+// ones on a version conflict.
+func (h *RealtimeHandler) updateEntityComponent(ec *hagallpb.EntityComponent, version uint64, originTimestamp *timestamppb.Timestamp) (*hagallpb.EntityComponent, uint64, error) {
+	session := h.currentSession
+	participant := h.currentParticipant
+	store := session.GetEntityComponents()
+
+	before, _, _ := store.Get(ec.EntityComponentTypeId, ec.EntityId)
+
+	var updated *hagallpb.EntityComponent
+	var err error
+	if version != 0 {
+		updated, version, err = store.UpdateIfVersion(ec, version)
+	} else {
+		updated, version, err = store.Apply(ec)
+	}
+	if err != nil {
+		return updated, version, err
+	}
+
+	store.RecordChange(models.NewEntityComponentChange(
+		models.EntityComponentOpUpdate,
+		updated,
+		version,
+		participant.ID,
+	))
+	recordEntityComponentUndo(session, participant, before, updated)
+
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityComponentUpdateBroadcast, func() {
+		store.NotifyEntityUpdate(updated.EntityComponentTypeId, updated.EntityId, time.Now(), func(participantIDs []uint32) {
 			session.BroadcastTo(participant, &hagallpb.EntityComponentUpdateBroadcast{
 				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE_BROADCAST,
 				Timestamp:       timestamppb.Now(),
-				OriginTimestamp: req.Timestamp,
-				EntityComponent: &entityComponent,
+				OriginTimestamp: originTimestamp,
+				EntityComponent: updated,
 			}, participantIDs...)
 		})
 	})
 
-	return nil
+	return updated, version, nil
+}
+
+// removeEntityComponent deletes an entity component of the current session,
+// records the change and broadcasts it to the session when the type has
+// subscribers, the current participant excluded. When version is not zero,
+// the entity component is only deleted when version is its current version.
+//
+// It returns the deleted entity component with its last version, or the
+// current ones on a version conflict.
+func (h *RealtimeHandler) removeEntityComponent(entityComponentTypeID, entityID uint32, version uint64, originTimestamp *timestamppb.Timestamp) (*hagallpb.EntityComponent, uint64, error) {
+	session := h.currentSession
+	participant := h.currentParticipant
+	store := session.GetEntityComponents()
+
+	var deleted *hagallpb.EntityComponent
+	var err error
+	if version != 0 {
+		deleted, version, err = store.DeleteIfVersion(entityComponentTypeID, entityID, version)
+	} else {
+		var ok bool
+		if deleted, version, ok = store.Remove(entityComponentTypeID, entityID); !ok {
+			err = errors.New("entity component not found").
+				WithType(ErrTypeEntityComponentNotFound).
+				WithTag("entity_component_type_id", entityComponentTypeID).
+				WithTag("entity_id", entityID)
+		}
+	}
+	if err != nil {
+		return deleted, version, err
+	}
+
+	store.RecordChange(models.NewEntityComponentChange(
+		models.EntityComponentOpDelete,
+		deleted,
+		version,
+		participant.ID,
+	))
+	recordEntityComponentUndo(session, participant, deleted, nil)
+
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityComponentDeleteBroadcast, func() {
+		store.Notify(entityComponentTypeID, func(participantIDs []uint32) {
+			session.Broadcast(participant, &hagallpb.EntityComponentDeleteBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_BROADCAST,
+				Timestamp:       timestamppb.Now(),
+				OriginTimestamp: originTimestamp,
+				EntityComponent: &hagallpb.EntityComponent{
+					EntityComponentTypeId: entityComponentTypeID,
+					EntityId:              entityID,
+				},
+			})
+		})
+	})
+
+	return deleted, version, nil
+}
+
+// entityComponentErrorCode returns the error code of a failed entity component
+// change.
+func entityComponentErrorCode(err error) hagallpb.ErrorCode {
+	switch errors.Type(err) {
+	case hwebsocket.ErrEntityComponentTypeAlreadyAdded, models.ErrTypeEntityComponentVersionConflict:
+		return hagallpb.ErrorCode_ERROR_CODE_CONFLICT
+	case models.ErrTypeEntityComponentInvalidData:
+		return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST
+	default:
+		return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND
+	}
 }
 
 func (h *RealtimeHandler) HandleEntityComponentList(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
@@ -990,34 +1479,33 @@ func (h *RealtimeHandler) leaveSession() {
 	}
 
 	session.GetEntityComponents().UnsubscribeByParticipant(participant.ID)
+	session.UndoHistory().Remove(participant.ID)
 
-	now := timestamppb.Now()
-
-	for id := range participant.EntityIDs() {
-		entity, ok := session.EntityByID(id)
-		if !ok || entity.Persist {
-			continue
-		}
-
-		session.GetEntityComponents().DeleteByEntityID(entity.ID)
-		session.RemoveEntity(entity)
-
-		h.FeatureFlags.IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
-			session.Broadcast(participant, &hagallpb.EntityDeleteBroadcast{
-				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST,
-				Timestamp:       now,
-				OriginTimestamp: now,
-				EntityId:        entity.ID,
-			})
-		})
+	// The entities of migrated participants are kept: they moved with the
+	// session.
+	reason := participant.LeaveReason()
+	if reason != models.LeaveReasonMigrated {
+		h.removeEntities(session, participant, participant.ID, participant.EntityIDs())
 	}
 
 	if h.stopFrameHandling != nil {
 		h.stopFrameHandling()
 	}
+	host := session.Host()
 	session.RemoveParticipant(participant)
+	h.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventParticipantLeft,
+		ParticipantID: participant.ID,
+		Spectator:     participant.Spectator,
+		Reason:        reason,
+	})
+
+	now := timestamppb.Now()
+	featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableParticipantLeaveBroadcast, func() {
+		if participant.Spectator {
+			return
+		}
 
-	h.FeatureFlags.IfNotSet(featureflag.FlagDisableParticipantLeaveBroadcast, func() {
 		session.Broadcast(participant, &hagallpb.ParticipantLeaveBroadcast{
 			Type:            hagallpb.MsgType_MSG_TYPE_PARTICIPANT_LEAVE_BROADCAST,
 			Timestamp:       now,
@@ -1026,7 +1514,21 @@ func (h *RealtimeHandler) leaveSession() {
 		})
 	})
 
-	if session.ParticipantCount() == 0 {
+	if !participant.Spectator {
+		sendServerMessage(session, ServerMessage{
+			Type:          ServerMessageParticipantLeft,
+			ParticipantID: participant.ID,
+			Reason:        reason,
+		})
+	}
+
+	if session.Host() != host && session.ParticipantCount() != 0 {
+		h.publishHostChange(session)
+	}
+
+	// Migrated sessions are kept until their participants resume or their
+	// resume tokens expire.
+	if session.ParticipantCount() == 0 && session.ResumeCount() == 0 {
 		// Here we use a context.Background to ensure the session to be deleted
 		// on the session discovery service (eg HDS).
 		h.Sessions.Remove(context.Background(), session)
@@ -1037,6 +1539,64 @@ func (h *RealtimeHandler) leaveSession() {
 	h.currentSession = nil
 }
 
+// removeEntities removes the non persistent entities with the given ids, owned
+// by the given participant, and notifies the other participants. The sender is
+// excluded from the broadcasts and can be nil.
+func (h *RealtimeHandler) removeEntities(session *models.Session, sender *models.Participant, participantID uint32, entityIDs map[uint32]struct{}) {
+	now := timestamppb.Now()
+
+	for id := range entityIDs {
+		entity, ok := session.EntityByID(id)
+		if !ok || entity.Persist {
+			continue
+		}
+
+		session.GetEntityComponents().DeleteByEntityID(entity.ID)
+		session.RemoveEntity(entity)
+		session.UndoHistory().Invalidate(participantID, models.UndoOp{
+			Kind:   models.UndoOpEntityDelete,
+			Entity: models.EntitySnapshot{ID: entity.ID},
+		})
+		h.Sessions.Publish(session, models.SessionEvent{
+			Type:          models.SessionEventEntityRemoved,
+			ParticipantID: participantID,
+			EntityID:      entity.ID,
+		})
+
+		featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
+			session.Broadcast(sender, &hagallpb.EntityDeleteBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				EntityId:        entity.ID,
+			})
+		})
+	}
+}
+
+// flushThrottledEntityComponents sends the latest state of entity components
+// whose updates were held back by subscription throttles.
+func flushThrottledEntityComponents(session *models.Session) {
+	session.GetEntityComponents().FlushThrottled(time.Now(), func(participantID uint32, ec *hagallpb.EntityComponent) {
+		now := timestamppb.Now()
+		session.BroadcastTo(nil, &hagallpb.EntityComponentUpdateBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			EntityComponent: ec,
+		}, participantID)
+	})
+}
+
+// featureFlags returns the feature flags of the given provider evaluated for
+// the given session.
+func featureFlags(p featureflag.Provider, session *models.Session) featureflag.Scoped {
+	return featureflag.In(p, featureflag.Scope{
+		AppKey:      session.AppKey,
+		SessionUUID: session.SessionUUID,
+	})
+}
+
 func (h *RealtimeHandler) GetClientID() string {
 	return h.clientID
 }
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"context"
+
+	httpcmn "github.com/aukilabs/hagall-common/http"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/recording"
+	"golang.org/x/net/websocket"
+)
+
+// HandlerWithRecording returns a handler that records the received messages
+// when the joined session is recorded by the given recorder.
+func HandlerWithRecording(h Handler, recorder *recording.Recorder) Handler {
+	return &handlerWithRecording{
+		Handler:  h,
+		recorder: recorder,
+	}
+}
+
+type handlerWithRecording struct {
+	Handler
+
+	recorder *recording.Recorder
+	conn     *recording.Connection
+}
+
+func (h *handlerWithRecording) HandleConnect(conn *websocket.Conn) {
+	req := conn.Request()
+	h.conn = h.recorder.NewConnection(
+		req.Header.Get(httpcmn.HeaderPosemeshClientID),
+		httpcmn.GetAppKeyFromHagallUserToken(httpcmn.GetUserTokenFromHTTPRequest(req)),
+	)
+
+	h.Handler.HandleConnect(conn)
+}
+
+func (h *handlerWithRecording) HandleParticipantJoin(ctx context.Context, handleFrame func(), sender hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
+	err := h.Handler.HandleParticipantJoin(ctx, handleFrame, sender, msg)
+
+	if session := h.Handler.CurrentSession(); session != nil {
+		h.conn.Join(h.Handler.GetSessions().GlobalSessionID(session.ID), session.AppKey)
+	}
+	return err
+}
+
+func (h *handlerWithRecording) HandleDisconnect(err error) {
+	// The disconnection is recorded before leaving the session since leaving
+	// can close the session and its recording.
+	h.conn.Close()
+
+	h.Handler.HandleDisconnect(err)
+}
+
+func (h *handlerWithRecording) Receiver() hwebsocket.Receiver {
+	receive := h.Handler.Receiver()
+
+	return func() (hwebsocket.Msg, int, error) {
+		msg, n, err := receive()
+		if err == nil {
+			h.conn.Receive(msg)
+		}
+		return msg, n, err
+	}
+}
@@ -0,0 +1,278 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/recording"
+)
+
+// Replayer replays a session recording through realtime handlers, one by
+// recorded connection, to reproduce the server-side state of the session.
+//
+// Records are handled one at a time in the recorded order. Signed latency and
+// receipt requests are skipped since they have effects outside of the session.
+type Replayer struct {
+	// Creates the handler of a recorded connection. Handlers should share the
+	// same session store.
+	NewHandler func() *RealtimeHandler
+
+	// The replay speed relative to the recorded timing. Records are replayed
+	// without waiting when zero.
+	Speed float64
+
+	// The function called after each replayed record.
+	OnStep func(ReplayStep)
+
+	connections map[uint32]*replayConnection
+	sessionID   string
+
+	// The messages sent to disconnected connections since the previous step.
+	sent []ReplaySentMsg
+}
+
+// ReplayStep is the result of a replayed record.
+type ReplayStep struct {
+	Record recording.Record
+
+	// The replayed message. Only set with message records.
+	Msg hwebsocket.Msg
+
+	// The session and participant of the connection after the record is
+	// replayed.
+	Session     *models.Session
+	Participant *models.Participant
+
+	// The messages sent to the replayed connections since the previous step.
+	Sent []ReplaySentMsg
+
+	// The error that disconnected the connection.
+	Err error
+}
+
+// ReplaySentMsg is a message sent to a replayed connection.
+type ReplaySentMsg struct {
+	ConnectionID uint32
+	Msg          hwebsocket.Msg
+}
+
+type replayConnection struct {
+	handler   *RealtimeHandler
+	responder *replayResponder
+}
+
+// Replay replays the records of the given recording. Connections that are
+// still connected at the end of the recording stay in their session until
+// Close is called.
+func (r *Replayer) Replay(ctx context.Context, reader *recording.Reader) error {
+	if r.connections == nil {
+		r.connections = make(map[uint32]*replayConnection)
+	}
+
+	recordedSessionID := reader.Header().SessionID
+	start := time.Now()
+
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if r.Speed > 0 {
+			delay := time.Until(start.Add(time.Duration(float64(rec.Offset) / r.Speed)))
+			if delay > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(delay):
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		step, err := r.replay(ctx, rec, recordedSessionID)
+		if err != nil {
+			return err
+		}
+
+		if r.OnStep != nil {
+			r.OnStep(step)
+		}
+	}
+}
+
+func (r *Replayer) replay(ctx context.Context, rec recording.Record, recordedSessionID string) (ReplayStep, error) {
+	step := ReplayStep{Record: rec}
+
+	if rec.Kind == recording.RecordConnect {
+		h := r.NewHandler()
+		h.clientID = rec.ClientID
+		h.appKey = rec.AppKey
+
+		r.connections[rec.ConnectionID] = &replayConnection{
+			handler:   h,
+			responder: &replayResponder{},
+		}
+		return step, nil
+	}
+
+	conn, ok := r.connections[rec.ConnectionID]
+	if !ok {
+		return step, errors.New("record of an unknown connection").
+			WithType(recording.ErrTypeInvalidRecording).
+			WithTag("connection_id", rec.ConnectionID).
+			WithTag("kind", rec.Kind)
+	}
+
+	switch rec.Kind {
+	case recording.RecordMessage:
+		msg, err := rec.Msg()
+		if err != nil {
+			return step, err
+		}
+
+		if msg, err = r.mapSessionID(msg, recordedSessionID); err != nil {
+			return step, err
+		}
+		step.Msg = msg
+
+		switch msg.Type.Number() {
+		case hagallpb.MsgType_MSG_TYPE_SIGNED_LATENCY_REQUEST.Number(),
+			hagallpb.MsgType_MSG_TYPE_RECEIPT_REQUEST.Number():
+
+		default:
+			h := handler{
+				Handler:    conn.handler,
+				dispatcher: replayDispatcher{},
+			}
+			step.Err = h.handleMessage(ctx, msg, conn.responder)
+		}
+
+		if session := conn.handler.CurrentSession(); session != nil && r.sessionID == "" {
+			r.sessionID = conn.handler.Sessions.GlobalSessionID(session.ID)
+		}
+
+		if step.Err != nil {
+			r.disconnect(rec.ConnectionID, step.Err)
+		}
+
+	case recording.RecordDisconnect:
+		r.disconnect(rec.ConnectionID, nil)
+	}
+
+	step.Session = conn.handler.CurrentSession()
+	step.Participant = conn.handler.CurrentParticipant()
+	step.Sent = r.flushSent()
+	return step, nil
+}
+
+func (r *Replayer) flushSent() []ReplaySentMsg {
+	ids := make([]uint32, 0, len(r.connections))
+	for id := range r.connections {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	sent := r.sent
+	r.sent = nil
+	for _, id := range ids {
+		for _, msg := range r.connections[id].responder.flush() {
+			sent = append(sent, ReplaySentMsg{ConnectionID: id, Msg: msg})
+		}
+	}
+	return sent
+}
+
+// mapSessionID replaces the recorded session id of join requests by the id of
+// the replayed session.
+func (r *Replayer) mapSessionID(msg hwebsocket.Msg, recordedSessionID string) (hwebsocket.Msg, error) {
+	if msg.Type.Number() != hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST.Number() {
+		return msg, nil
+	}
+
+	var req hagallpb.ParticipantJoinRequest
+	if err := msg.DataTo(&req); err != nil {
+		return msg, err
+	}
+	if req.SessionId == "" || req.SessionId != recordedSessionID {
+		return msg, nil
+	}
+
+	// The id is empty when the recording started after the session creation,
+	// which makes the join request create the replayed session.
+	req.SessionId = r.sessionID
+	return hwebsocket.MsgFromProto(&req)
+}
+
+func (r *Replayer) disconnect(connectionID uint32, err error) {
+	conn, ok := r.connections[connectionID]
+	if !ok {
+		return
+	}
+	delete(r.connections, connectionID)
+
+	conn.handler.HandleDisconnect(err)
+	conn.handler.Close()
+
+	for _, msg := range conn.responder.flush() {
+		r.sent = append(r.sent, ReplaySentMsg{ConnectionID: connectionID, Msg: msg})
+	}
+}
+
+// Close disconnects the connections that are still connected.
+func (r *Replayer) Close() {
+	for id := range r.connections {
+		r.disconnect(id, nil)
+	}
+}
+
+type replayDispatcher struct{}
+
+func (d replayDispatcher) Dispatch(ctx context.Context, msg hwebsocket.Msg) error {
+	return nil
+}
+
+// HandleFrame does nothing since replayed messages are handled as soon as
+// they are read.
+func (d replayDispatcher) HandleFrame() {
+}
+
+type replayResponder struct {
+	mutex sync.Mutex
+	msgs  []hwebsocket.Msg
+}
+
+func (r *replayResponder) Send(protoMsg hwebsocket.ProtoMsg) {
+	msg, err := hwebsocket.MsgFromProto(protoMsg)
+	if err != nil {
+		return
+	}
+	r.SendMsg(msg)
+}
+
+func (r *replayResponder) SendMsg(msg hwebsocket.Msg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.msgs = append(r.msgs, msg)
+}
+
+func (r *replayResponder) flush() []hwebsocket.Msg {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	msgs := r.msgs
+	r.msgs = nil
+	return msgs
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall-common/scenario"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/aukilabs/hagall/modules"
+	"github.com/aukilabs/hagall/recording"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestReplayer(t *testing.T) {
+	// Test clients connect without token, so their app key is empty.
+	recorder := &recording.Recorder{
+		Dir:     t.TempDir(),
+		AppKeys: []string{""},
+	}
+
+	newHandler := newTestHandler(newVikjaTestModule)
+	clientA, clientB, close := NewTestingEnv(t, func() Handler {
+		return HandlerWithRecording(newHandler(), recorder)
+	})
+	defer close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var sessionID string
+	var entityID uint32
+
+	err := scenario.NewScenario(clientA).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.ParticipantJoinRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 1,
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(1),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_RESPONSE),
+			func(msg hwebsocket.Msg) error {
+				var res hagallpb.ParticipantJoinResponse
+				err := msg.DataTo(&res)
+				sessionID = res.SessionId
+				return err
+			},
+		).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityAddRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 2,
+				Pose:      &hagallpb.Pose{Rw: 1},
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(2),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_RESPONSE),
+			func(msg hwebsocket.Msg) error {
+				var res hagallpb.EntityAddResponse
+				err := msg.DataTo(&res)
+				entityID = res.EntityId
+				return err
+			},
+		).
+		Run(ctx)
+	require.NoError(t, err)
+
+	err = scenario.NewScenario(clientB).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.ParticipantJoinRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 1,
+				SessionId: sessionID,
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(1),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_RESPONSE),
+		).
+		Run(ctx)
+	require.NoError(t, err)
+
+	err = scenario.NewScenario(clientA).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityUpdatePose{
+				Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE,
+				Timestamp: timestamppb.Now(),
+				EntityId:  entityID,
+				Pose:      &hagallpb.Pose{Px: 42, Rw: 1},
+			}
+		}).
+		Send(func() hwebsocket.ProtoMsg {
+			return &hagallpb.EntityAddRequest{
+				Type:      hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+				Timestamp: timestamppb.Now(),
+				RequestId: 3,
+				Pose:      &hagallpb.Pose{Py: 21, Rw: 1},
+			}
+		}).
+		Receive(
+			scenario.FilterByRequestID(3),
+			scenario.FilterByType(hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_RESPONSE),
+		).
+		Run(ctx)
+	require.NoError(t, err)
+
+	recordings := recorder.Recordings()
+	require.Contains(t, recordings, sessionID)
+	recorder.Close()
+
+	f, err := os.Open(recordings[sessionID])
+	require.NoError(t, err)
+	defer f.Close()
+	require.Equal(t, recording.FileExt, filepath.Ext(f.Name()))
+
+	reader, err := recording.NewReader(f)
+	require.NoError(t, err)
+	require.Equal(t, sessionID, reader.Header().SessionID)
+
+	sessions := &models.SessionStore{DiscoveryService: testClient{}}
+
+	var steps []ReplayStep
+	replayer := Replayer{
+		NewHandler: func() *RealtimeHandler {
+			return &RealtimeHandler{
+				FrameDuration: time.Millisecond * 50,
+				Sessions:      sessions,
+				Modules:       []modules.Module{newVikjaTestModule()},
+			}
+		},
+		OnStep: func(s ReplayStep) {
+			steps = append(steps, s)
+		},
+	}
+	defer replayer.Close()
+
+	err = replayer.Replay(ctx, reader)
+	require.NoError(t, err)
+
+	for _, s := range steps {
+		require.NoError(t, s.Err)
+	}
+
+	last := steps[len(steps)-1]
+	require.NotNil(t, last.Session)
+	require.Len(t, last.Session.GetParticipants(), 2)
+	require.Len(t, last.Session.Entities(), 2)
+
+	entity, ok := last.Session.EntityByID(entityID)
+	require.True(t, ok)
+	require.Equal(t, float32(42), entity.Pose().PX)
+
+	var joinResponses int
+	for _, s := range steps {
+		for _, sent := range s.Sent {
+			if sent.Msg.Type == hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_RESPONSE {
+				joinResponses++
+			}
+		}
+	}
+	require.Equal(t, 2, joinResponses)
+}
@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	ErrTypeSessionNotFound          = "session-not-found"
+	ErrTypeParticipantNotFound      = "participant-not-found"
+	ErrTypeEntityNotFound           = "entity-not-found"
+	ErrTypeEntityNotOwned           = "entity-not-owned"
+	ErrTypeEntityComponentNotFound  = "entity-component-not-found"
+	ErrTypeCustomMessageTooLarge    = "custom-message-too-large"
+	ErrTypeEntityComponentTypeEmpty = "entity-component-type-empty"
+)
+
+// ServerParticipant creates and owns entities and entity components in
+// sessions on behalf of the server. It acts as a headless participant whose id
+// is models.ServerParticipantID.
+//
+// Changes are broadcasted to all the session participants, like changes made
+// by connected clients. Server entities are persistent and remain in the
+// session until they are deleted or the session is closed.
+type ServerParticipant struct {
+	// The store that contains all the server sessions.
+	Sessions *models.SessionStore
+
+	// The feature flags, evaluated for the session of each change.
+	FeatureFlags featureflag.Provider
+}
+
+// AddEntity adds an entity owned by the server to the given session.
+func (p *ServerParticipant) AddEntity(sessionID string, pose models.Pose, flag hagallpb.EntityFlag) (*models.Entity, error) {
+	session, err := p.session(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := &models.Entity{
+		ID:            session.NewEntityID(),
+		ParticipantID: models.ServerParticipantID,
+		Persist:       true,
+		Flag:          flag,
+	}
+	entity.SetPose(pose)
+
+	session.AddEntity(entity)
+	p.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventEntityAdded,
+		ParticipantID: models.ServerParticipantID,
+		EntityID:      entity.ID,
+	})
+
+	featureFlags(p.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityAddBroadcast, func() {
+		now := timestamppb.Now()
+		session.Broadcast(nil, &hagallpb.EntityAddBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			Entity:          entity.ToProtobuf(),
+		})
+	})
+
+	return entity, nil
+}
+
+// DeleteEntity deletes an entity owned by the server and its components.
+func (p *ServerParticipant) DeleteEntity(sessionID string, entityID uint32) error {
+	session, entity, err := p.ownedEntity(sessionID, entityID)
+	if err != nil {
+		return err
+	}
+
+	session.GetEntityComponents().DeleteByEntityID(entity.ID)
+	session.RemoveEntity(entity)
+	session.UndoHistory().Invalidate(models.ServerParticipantID, models.UndoOp{
+		Kind:   models.UndoOpEntityDelete,
+		Entity: models.EntitySnapshot{ID: entity.ID},
+	})
+	p.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventEntityRemoved,
+		ParticipantID: models.ServerParticipantID,
+		EntityID:      entity.ID,
+	})
+
+	featureFlags(p.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
+		now := timestamppb.Now()
+		session.Broadcast(nil, &hagallpb.EntityDeleteBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			EntityId:        entity.ID,
+		})
+	})
+
+	return nil
+}
+
+// UpdateEntityPose sets the pose of an entity owned by the server.
+func (p *ServerParticipant) UpdateEntityPose(sessionID string, entityID uint32, pose models.Pose) error {
+	session, entity, err := p.ownedEntity(sessionID, entityID)
+	if err != nil {
+		return err
+	}
+
+	entity.SetPose(pose)
+
+	featureFlags(p.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityUpdatePoseBroadcast, func() {
+		now := timestamppb.Now()
+		session.Broadcast(nil, &hagallpb.EntityUpdatePoseBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			EntityId:        entity.ID,
+			Pose:            pose.ToProtobuf(),
+		})
+	})
+
+	return nil
+}
+
+// SetEntityComponent adds or updates the component of an entity. The entity
+// component type is added to the session when it does not exist.
+func (p *ServerParticipant) SetEntityComponent(sessionID, entityComponentTypeName string, entityID uint32, data []byte) error {
+	if entityComponentTypeName == "" {
+		return errors.New("entity component type name is empty").
+			WithType(ErrTypeEntityComponentTypeEmpty)
+	}
+
+	session, entity, err := p.entity(sessionID, entityID)
+	if err != nil {
+		return err
+	}
+
+	typeID := session.GetEntityComponents().AddType(entityComponentTypeName)
+	ec := &hagallpb.EntityComponent{
+		EntityComponentTypeId: typeID,
+		EntityId:              entity.ID,
+		Data:                  data,
+	}
+
+	_, err = setEntityComponent(featureFlags(p.FeatureFlags, session), session, models.ServerParticipantID, ec)
+	if err != nil {
+		return errors.New("setting entity component failed").
+			WithTag("session_id", sessionID).
+			WithTag("entity_id", entityID).
+			WithTag("entity_component_type_name", entityComponentTypeName).
+			Wrap(err)
+	}
+
+	session.UndoHistory().Invalidate(models.ServerParticipantID, models.UndoOp{
+		Kind:                 models.UndoOpEntityComponent,
+		EntityComponentAfter: ec,
+	})
+	return nil
+}
+
+// DeleteEntityComponent deletes the component of an entity.
+func (p *ServerParticipant) DeleteEntityComponent(sessionID, entityComponentTypeName string, entityID uint32) error {
+	session, entity, err := p.entity(sessionID, entityID)
+	if err != nil {
+		return err
+	}
+
+	typeID, err := session.GetEntityComponents().GetTypeID(entityComponentTypeName)
+	if err != nil {
+		return errors.New("entity component not found").
+			WithType(ErrTypeEntityComponentNotFound).
+			WithTag("entity_component_type_name", entityComponentTypeName).
+			Wrap(err)
+	}
+
+	deleted, err := deleteEntityComponent(featureFlags(p.FeatureFlags, session), session, models.ServerParticipantID, typeID, entity.ID)
+	if err != nil {
+		return err
+	}
+
+	session.UndoHistory().Invalidate(models.ServerParticipantID, models.UndoOp{
+		Kind:                  models.UndoOpEntityComponent,
+		EntityComponentBefore: deleted,
+	})
+	return nil
+}
+
+// SendCustomMessage sends a custom message from the server to the given
+// participants. The message is sent to all the session participants when no
+// participant ids are given.
+func (p *ServerParticipant) SendCustomMessage(sessionID string, body []byte, participantIDs ...uint32) error {
+	if len(body) > customMessageMaxSize {
+		return errors.New("custom message is too large").
+			WithType(ErrTypeCustomMessageTooLarge).
+			WithTag("size", len(body)).
+			WithTag("max_size", customMessageMaxSize)
+	}
+
+	session, err := p.session(sessionID)
+	if err != nil {
+		return err
+	}
+
+	featureFlags(p.FeatureFlags, session).IfNotSet(featureflag.FlagDisableCustomMessageBroadcast, func() {
+		now := timestamppb.Now()
+		customMessageBroadcast := hagallpb.CustomMessageBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			ParticipantId:   models.ServerParticipantID,
+			Body:            body,
+		}
+
+		if len(participantIDs) != 0 {
+			session.BroadcastTo(nil, &customMessageBroadcast, participantIDs...)
+			return
+		}
+
+		session.Broadcast(nil, &customMessageBroadcast)
+	})
+
+	return nil
+}
+
+// UpdateParticipantInfo replaces the info of a participant of the given
+// session.
+func (p *ServerParticipant) UpdateParticipantInfo(sessionID string, participantID uint32, info models.ParticipantInfo) error {
+	if err := info.Validate(); err != nil {
+		return err
+	}
+
+	session, err := p.session(sessionID)
+	if err != nil {
+		return err
+	}
+
+	participant, ok := session.ParticipantByID(participantID)
+	if !ok {
+		return errors.New("participant not found").
+			WithType(ErrTypeParticipantNotFound).
+			WithTag("session_id", sessionID).
+			WithTag("participant_id", participantID)
+	}
+
+	participant.SetInfo(info)
+	publishParticipantUpdate(p.Sessions, session, participant)
+	return nil
+}
+
+// KickParticipant makes a participant of the given session leave by
+// disconnecting its connection. When ban is true, the participant can't join
+// the session again with the same client id or wallet address.
+func (p *ServerParticipant) KickParticipant(sessionID string, participantID uint32, ban bool) error {
+	session, err := p.session(sessionID)
+	if err != nil {
+		return err
+	}
+
+	participant, ok := session.ParticipantByID(participantID)
+	if !ok {
+		return errors.New("participant not found").
+			WithType(ErrTypeParticipantNotFound).
+			WithTag("session_id", sessionID).
+			WithTag("participant_id", participantID)
+	}
+
+	kickParticipant(session, participant, ban)
+	return nil
+}
+
+func (p *ServerParticipant) session(sessionID string) (*models.Session, error) {
+	session, ok := p.Sessions.GetByGlobalID(sessionID)
+	if !ok {
+		return nil, errors.New("session not found").
+			WithType(ErrTypeSessionNotFound).
+			WithTag("session_id", sessionID)
+	}
+	return session, nil
+}
+
+func (p *ServerParticipant) entity(sessionID string, entityID uint32) (*models.Session, *models.Entity, error) {
+	session, err := p.session(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entity, ok := session.EntityByID(entityID)
+	if !ok {
+		return nil, nil, errors.New("entity not found").
+			WithType(ErrTypeEntityNotFound).
+			WithTag("session_id", sessionID).
+			WithTag("entity_id", entityID)
+	}
+	return session, entity, nil
+}
+
+func (p *ServerParticipant) ownedEntity(sessionID string, entityID uint32) (*models.Session, *models.Entity, error) {
+	session, entity, err := p.entity(sessionID, entityID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if entity.ParticipantID != models.ServerParticipantID {
+		return nil, nil, errors.New("entity is not owned by the server").
+			WithType(ErrTypeEntityNotOwned).
+			WithTag("session_id", sessionID).
+			WithTag("entity_id", entityID)
+	}
+	return session, entity, nil
+}
@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerParticipant(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	h, respond := joinTestSession(t, sessions, "")
+	defer h.leaveSession()
+
+	sessionID := sessions.GlobalSessionID(h.CurrentSession().ID)
+	server := ServerParticipant{Sessions: sessions}
+
+	var entityID uint32
+
+	t.Run("add entity", func(t *testing.T) {
+		entity, err := server.AddEntity(sessionID, models.Pose{PX: 1}, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+		require.NoError(t, err)
+		require.Equal(t, models.ServerParticipantID, entity.ParticipantID)
+		require.True(t, entity.Persist)
+		entityID = entity.ID
+
+		var broadcast hagallpb.EntityAddBroadcast
+		require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+		require.Equal(t, entityID, broadcast.Entity.Id)
+		require.Equal(t, models.ServerParticipantID, broadcast.Entity.ParticipantId)
+	})
+
+	t.Run("participants can't delete server entities", func(t *testing.T) {
+		handleTestMsg(t, h.HandleEntityDelete, respond, &hagallpb.EntityDeleteRequest{
+			Type:     hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_REQUEST,
+			EntityId: entityID,
+		})
+
+		res, ok := respond.last().(*hagallpb.ErrorResponse)
+		require.True(t, ok)
+		require.Equal(t, hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, res.Code)
+	})
+
+	t.Run("update entity pose", func(t *testing.T) {
+		err := server.UpdateEntityPose(sessionID, entityID, models.Pose{PX: 2})
+		require.NoError(t, err)
+
+		var broadcast hagallpb.EntityUpdatePoseBroadcast
+		require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+		require.Equal(t, float32(2), broadcast.Pose.Px)
+	})
+
+	t.Run("set and delete entity component", func(t *testing.T) {
+		err := server.SetEntityComponent(sessionID, "label", entityID, []byte("hello"))
+		require.NoError(t, err)
+
+		err = server.SetEntityComponent(sessionID, "label", entityID, []byte("bye"))
+		require.NoError(t, err)
+
+		store := h.CurrentSession().GetEntityComponents()
+		typeID, err := store.GetTypeID("label")
+		require.NoError(t, err)
+
+		ec, version, err := store.Get(typeID, entityID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("bye"), ec.Data)
+		require.Equal(t, uint64(2), version)
+
+		err = server.DeleteEntityComponent(sessionID, "label", entityID)
+		require.NoError(t, err)
+
+		_, _, err = store.Get(typeID, entityID)
+		require.Error(t, err)
+
+		err = server.DeleteEntityComponent(sessionID, "label", entityID)
+		require.True(t, errors.IsType(err, ErrTypeEntityComponentNotFound))
+	})
+
+	t.Run("entity component updates are throttled", func(t *testing.T) {
+		store := h.CurrentSession().GetEntityComponents()
+		typeID := store.AddType("score")
+		err := store.SubscribeWithOptions(typeID, h.CurrentParticipant().ID, models.EntityComponentSubscriptionOptions{
+			MaxUpdateRate: 1,
+		})
+		require.NoError(t, err)
+
+		for _, data := range []string{"1", "2", "3"} {
+			err := server.SetEntityComponent(sessionID, "score", entityID, []byte(data))
+			require.NoError(t, err)
+		}
+
+		var broadcast hagallpb.EntityComponentUpdateBroadcast
+		require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+		require.Equal(t, []byte("2"), broadcast.EntityComponent.Data)
+	})
+
+	t.Run("send custom message", func(t *testing.T) {
+		err := server.SendCustomMessage(sessionID, []byte("announcement"))
+		require.NoError(t, err)
+
+		var broadcast hagallpb.CustomMessageBroadcast
+		require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+		require.Equal(t, models.ServerParticipantID, broadcast.ParticipantId)
+		require.Equal(t, []byte("announcement"), broadcast.Body)
+
+		err = server.SendCustomMessage(sessionID, make([]byte, customMessageMaxSize+1))
+		require.True(t, errors.IsType(err, ErrTypeCustomMessageTooLarge))
+	})
+
+	t.Run("delete entity", func(t *testing.T) {
+		err := server.DeleteEntity(sessionID, entityID)
+		require.NoError(t, err)
+
+		_, ok := h.CurrentSession().EntityByID(entityID)
+		require.False(t, ok)
+
+		var broadcast hagallpb.EntityDeleteBroadcast
+		require.NoError(t, respond.lastReceived().DataTo(&broadcast))
+		require.Equal(t, entityID, broadcast.EntityId)
+	})
+
+	t.Run("entities of participants are not owned", func(t *testing.T) {
+		handleTestMsg(t, h.HandleEntityAdd, respond, &hagallpb.EntityAddRequest{
+			Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		})
+		clientEntityID := respond.last().(*hagallpb.EntityAddResponse).EntityId
+
+		err := server.DeleteEntity(sessionID, clientEntityID)
+		require.True(t, errors.IsType(err, ErrTypeEntityNotOwned))
+	})
+
+	t.Run("update participant info", func(t *testing.T) {
+		participantID := h.CurrentParticipant().ID
+		h.CurrentParticipant().ServerMessages = true
+
+		err := server.UpdateParticipantInfo(sessionID, participantID, models.ParticipantInfo{DisplayName: "Ted"})
+		require.NoError(t, err)
+		require.Equal(t, "Ted", h.CurrentParticipant().Info().DisplayName)
+
+		broadcast, ok := respond.last().(*hagallpb.CustomMessageBroadcast)
+		require.True(t, ok)
+
+		var msg ServerMessage
+		require.NoError(t, json.Unmarshal(broadcast.Body, &msg))
+		require.Equal(t, ServerMessageParticipantUpdated, msg.Type)
+		require.Equal(t, "Ted", msg.Participant.DisplayName)
+
+		err = server.UpdateParticipantInfo(sessionID, participantID+1, models.ParticipantInfo{})
+		require.True(t, errors.IsType(err, ErrTypeParticipantNotFound))
+	})
+
+	t.Run("kick participant", func(t *testing.T) {
+		participant := h.CurrentParticipant()
+		participant.ClientID = "troll"
+
+		var disconnectErr error
+		participant.Disconnect = func(err error) {
+			disconnectErr = err
+		}
+
+		err := server.KickParticipant(sessionID, participant.ID+1, true)
+		require.True(t, errors.IsType(err, ErrTypeParticipantNotFound))
+
+		err = server.KickParticipant(sessionID, participant.ID, true)
+		require.NoError(t, err)
+		require.True(t, errors.IsType(disconnectErr, models.ErrTypeParticipantKicked))
+		require.Equal(t, models.LeaveReasonBanned, participant.LeaveReason())
+		require.True(t, h.CurrentSession().IsBanned("troll"))
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		_, err := server.AddEntity("ted0xff", models.Pose{}, hagallpb.EntityFlag_ENTITY_FLAG_EMPTY)
+		require.True(t, errors.IsType(err, ErrTypeSessionNotFound))
+	})
+}
@@ -0,0 +1,409 @@
+package websocket
+
+import (
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	"github.com/aukilabs/hagall/models"
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ServerMessageType describes a server message.
+type ServerMessageType string
+
+const (
+	// Sent to participants when the session host changes, and to joining
+	// participants.
+	ServerMessageHostChanged ServerMessageType = "host_changed"
+
+	// Sent to participants when a participant leaves the session, with the
+	// reason why it left. Kicked participants receive it before being
+	// disconnected.
+	ServerMessageParticipantLeft ServerMessageType = "participant_left"
+
+	// Sent to joining participants with the info and presence of the session
+	// participants, spectators excluded.
+	ServerMessageParticipants ServerMessageType = "participants"
+
+	// Sent to participants when a participant joins the session, or when its
+	// info or presence changes.
+	ServerMessageParticipantUpdated ServerMessageType = "participant_updated"
+
+	// Sent to participants when the server is draining before shutting down,
+	// with a suggested reconnect delay or alternate endpoint.
+	ServerMessageGoingAway ServerMessageType = "going_away"
+
+	// Sent to participants when their session migrates to another server,
+	// with the endpoint to reconnect to, the session to join and the token
+	// that resumes the participant. Participants are disconnected right after.
+	ServerMessageMigrate ServerMessageType = "migrate"
+
+	// The response to a server request.
+	ServerMessageResponse ServerMessageType = "response"
+
+	// Requests the host to be transferred to another participant. Host only.
+	ServerRequestTransferHost ServerMessageType = "transfer_host"
+
+	// Requests the session info to be replaced. Host only.
+	ServerRequestUpdateSessionInfo ServerMessageType = "update_session_info"
+
+	// Requests the session settings to be replaced. Host only.
+	ServerRequestUpdateSessionSettings ServerMessageType = "update_session_settings"
+
+	// Requests the info of the participant to be replaced.
+	ServerRequestUpdateParticipantInfo ServerMessageType = "update_participant_info"
+
+	// Requests a participant to be kicked, and banned when ban is set. Host
+	// only.
+	ServerRequestKick ServerMessageType = "kick"
+
+	// Requests an entity component to be updated, only when version is its
+	// current version if set. The response contains the stored data and its
+	// new version, or the current ones on a version conflict.
+	ServerRequestUpdateEntityComponent ServerMessageType = "update_entity_component"
+
+	// Requests an entity component to be deleted, only when version is its
+	// current version if set. The response contains the current data and
+	// version on a version conflict.
+	ServerRequestDeleteEntityComponent ServerMessageType = "delete_entity_component"
+
+	// Requests a subscription to an entity component type, only following the
+	// given entities if set and limiting the update broadcasts to the given
+	// rate per second if set. Subscribing again replaces the previous options.
+	ServerRequestSubscribeEntityComponent ServerMessageType = "subscribe_entity_component"
+
+	// Requests the recorded changes of the entity components of an entity,
+	// restricted to a single entity component type if set.
+	ServerRequestEntityComponentHistory ServerMessageType = "entity_component_history"
+
+	// Requests the listings of the sessions that can be joined with the app
+	// key of the participant. Private sessions are not listed.
+	ServerRequestListSessions ServerMessageType = "list_sessions"
+
+	// Requests the last operation of the participant to be reverted.
+	ServerRequestUndo ServerMessageType = "undo"
+
+	// Requests the last operation reverted by the participant to be
+	// reapplied.
+	ServerRequestRedo ServerMessageType = "redo"
+)
+
+// ServerMessage is a message exchanged between the server and the participants
+// that joined with ServerMessagesQueryParam set.
+//
+// Since hagall-common has no message types for them, server messages are the
+// JSON body of custom messages: participants send requests in custom messages
+// addressed to models.ServerMessagesParticipantID only, and receive server
+// messages in custom message broadcasts from models.ServerMessagesParticipantID.
+type ServerMessage struct {
+	Type ServerMessageType `json:"type"`
+
+	// The id chosen by the participant to match a request with its response.
+	RequestID uint32 `json:"request_id,omitempty"`
+
+	// The name of the hagallpb error code of a failed request.
+	Error string `json:"error,omitempty"`
+
+	HostID          uint32                  `json:"host_id,omitempty"`
+	ParticipantID   uint32                  `json:"participant_id,omitempty"`
+	SessionInfo     *models.SessionInfo     `json:"session_info,omitempty"`
+	SessionSettings *models.SessionSettings `json:"session_settings,omitempty"`
+	Reason          models.LeaveReason      `json:"reason,omitempty"`
+	Ban             bool                    `json:"ban,omitempty"`
+
+	// The info and presence of a participant, and of all the participants of
+	// a participants message.
+	Participant  *models.ParticipantInfo `json:"participant,omitempty"`
+	Presence     models.Presence         `json:"presence,omitempty"`
+	Participants []ParticipantState      `json:"participants,omitempty"`
+
+	// The delay suggested before reconnecting, in milliseconds.
+	ReconnectDelayMS int64 `json:"reconnect_delay_ms,omitempty"`
+
+	// The endpoint of another server to reconnect to.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// The session to join on the other server, and the token given in
+	// ResumeTokenQueryParam to join it as the same participant.
+	SessionID   string `json:"session_id,omitempty"`
+	ResumeToken string `json:"resume_token,omitempty"`
+
+	// The entity component of an entity component request.
+	EntityComponentTypeID uint32 `json:"entity_component_type_id,omitempty"`
+	EntityID              uint32 `json:"entity_id,omitempty"`
+	Data                  []byte `json:"data,omitempty"`
+	Version               uint64 `json:"version,omitempty"`
+
+	// The options of an entity component subscription request.
+	EntityIDs     []uint32 `json:"entity_ids,omitempty"`
+	MaxUpdateRate float64  `json:"max_update_rate,omitempty"`
+
+	// The changes of an entity component history response.
+	Changes []models.EntityComponentChange `json:"changes,omitempty"`
+
+	// The sessions of a list sessions response.
+	Sessions []models.SessionListing `json:"sessions,omitempty"`
+}
+
+// ParticipantState describes a participant of a participants message.
+type ParticipantState struct {
+	ParticipantID uint32                 `json:"participant_id"`
+	Participant   models.ParticipantInfo `json:"participant"`
+	Presence      models.Presence        `json:"presence"`
+}
+
+// isServerRequest reports whether the given custom message is a server request
+// of the given participant.
+func isServerRequest(participant *models.Participant, msg *hagallpb.CustomMessage) bool {
+	return participant.ServerMessages &&
+		len(msg.ParticipantIds) == 1 &&
+		msg.ParticipantIds[0] == models.ServerMessagesParticipantID
+}
+
+// sendServerMessage sends the given server message to the given participants,
+// or to all the session participants when no id is given. Participants that
+// don't exchange server messages are skipped.
+func sendServerMessage(session *models.Session, msg ServerMessage, participantIDs ...uint32) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	participants := session.GetParticipantsByIDs(participantIDs...)
+	if len(participantIDs) == 0 {
+		participants = session.GetParticipants()
+	}
+
+	now := timestamppb.Now()
+	for _, p := range participants {
+		if !p.ServerMessages {
+			continue
+		}
+
+		p.Responder.Send(&hagallpb.CustomMessageBroadcast{
+			Type:            hagallpb.MsgType_MSG_TYPE_CUSTOM_MESSAGE_BROADCAST,
+			Timestamp:       now,
+			OriginTimestamp: now,
+			ParticipantId:   models.ServerMessagesParticipantID,
+			Body:            body,
+		})
+	}
+}
+
+// handleServerRequest handles a server request sent by the current participant
+// and responds to it.
+func (h *RealtimeHandler) handleServerRequest(body []byte) {
+	var req ServerMessage
+	res := ServerMessage{Type: ServerMessageResponse}
+	code, ok := hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+	if err := json.Unmarshal(body, &req); err == nil {
+		code, ok = h.serveServerRequest(req, &res)
+	}
+
+	res.RequestID = req.RequestID
+	if !ok {
+		res.Error = code.String()
+	}
+	sendServerMessage(h.currentSession, res, h.currentParticipant.ID)
+}
+
+// serveServerRequest executes the given server request and sets the result in
+// the given response. It returns the error code of the response and false when
+// the request failed.
+func (h *RealtimeHandler) serveServerRequest(req ServerMessage, res *ServerMessage) (hagallpb.ErrorCode, bool) {
+	session := h.currentSession
+	participant := h.currentParticipant
+
+	switch req.Type {
+	case ServerRequestTransferHost:
+		if !session.IsHost(participant) {
+			return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+		}
+		if !session.SetHost(req.ParticipantID) {
+			return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, false
+		}
+		h.publishHostChange(session)
+
+	case ServerRequestUpdateSessionInfo:
+		if !session.IsHost(participant) {
+			return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+		}
+		if req.SessionInfo == nil {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+		err := h.Sessions.UpdateInfo(session, *req.SessionInfo)
+		if errors.IsType(err, models.ErrTypeSessionNameTaken) {
+			return hagallpb.ErrorCode_ERROR_CODE_CONFLICT, false
+		}
+		if err != nil {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+	case ServerRequestUpdateSessionSettings:
+		if !session.IsHost(participant) {
+			return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+		}
+		if req.SessionSettings == nil {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+		if err := session.SetSettings(*req.SessionSettings); err != nil {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+	case ServerRequestUpdateParticipantInfo:
+		if participant.Spectator {
+			return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+		}
+		if req.Participant == nil || req.Participant.Validate() != nil {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+		participant.SetInfo(*req.Participant)
+		publishParticipantUpdate(h.Sessions, session, participant)
+
+	case ServerRequestKick:
+		if !session.IsHost(participant) {
+			return hagallpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, false
+		}
+		if req.ParticipantID == participant.ID {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+		target, ok := session.ParticipantByID(req.ParticipantID)
+		if !ok {
+			return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, false
+		}
+		kickParticipant(session, target, req.Ban)
+
+	case ServerRequestUpdateEntityComponent, ServerRequestDeleteEntityComponent:
+		return h.serveEntityComponentRequest(req, res)
+
+	case ServerRequestSubscribeEntityComponent:
+		if req.EntityComponentTypeID == 0 || req.MaxUpdateRate < 0 {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+		err := session.GetEntityComponents().SubscribeWithOptions(req.EntityComponentTypeID, participant.ID, models.EntityComponentSubscriptionOptions{
+			EntityIDs:     req.EntityIDs,
+			MaxUpdateRate: req.MaxUpdateRate,
+		})
+		if err != nil {
+			return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, false
+		}
+
+	case ServerRequestEntityComponentHistory:
+		if req.EntityID == 0 {
+			return hagallpb.ErrorCode_ERROR_CODE_BAD_REQUEST, false
+		}
+
+		store := session.GetEntityComponents()
+		if req.EntityComponentTypeID != 0 {
+			res.Changes = store.History(req.EntityComponentTypeID, req.EntityID)
+		} else {
+			res.Changes = store.HistoryByEntityID(req.EntityID)
+		}
+
+	case ServerRequestListSessions:
+		res.Sessions = h.Sessions.Discover(h.appKey)
+
+	case ServerRequestUndo, ServerRequestRedo:
+		return h.serveUndoRequest(req.Type)
+
+	default:
+		return hagallpb.ErrorCode_ERROR_CODE_NOT_IMPLEMENTED, false
+	}
+
+	return hagallpb.ErrorCode_ERROR_CODE_UNKNOWN, true
+}
+
+// publishHostChange notifies the session participants and the event handlers
+// that the session host changed.
+func (h *RealtimeHandler) publishHostChange(session *models.Session) {
+	host := session.Host()
+
+	sendServerMessage(session, ServerMessage{
+		Type:   ServerMessageHostChanged,
+		HostID: host,
+	})
+
+	h.Sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventHostChanged,
+		ParticipantID: host,
+	})
+}
+
+// publishParticipantUpdate notifies the session participants and the event
+// handlers that the info or presence of the given participant changed.
+// Spectators are only notified to the event handlers since they are not
+// visible to the participants.
+func publishParticipantUpdate(sessions *models.SessionStore, session *models.Session, participant *models.Participant) {
+	info := participant.Info()
+	presence := participant.Presence()
+
+	if !participant.Spectator {
+		sendServerMessage(session, ServerMessage{
+			Type:          ServerMessageParticipantUpdated,
+			ParticipantID: participant.ID,
+			Participant:   &info,
+			Presence:      presence,
+		})
+	}
+
+	sessions.Publish(session, models.SessionEvent{
+		Type:          models.SessionEventParticipantUpdated,
+		ParticipantID: participant.ID,
+		Spectator:     participant.Spectator,
+		Participant:   &info,
+		Presence:      presence,
+	})
+}
+
+// participantStates returns the info and presence of the given participants,
+// spectators excluded.
+func participantStates(participants []*models.Participant) []ParticipantState {
+	states := make([]ParticipantState, 0, len(participants))
+	for _, p := range participants {
+		if p.Spectator {
+			continue
+		}
+
+		states = append(states, ParticipantState{
+			ParticipantID: p.ID,
+			Participant:   p.Info(),
+			Presence:      p.Presence(),
+		})
+	}
+	return states
+}
+
+// otherParticipantIDs returns the ids of the session participants other than
+// the given one.
+func otherParticipantIDs(session *models.Session, participant *models.Participant) []uint32 {
+	var ids []uint32
+	for _, p := range session.GetParticipants() {
+		if p != participant {
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids
+}
+
+// kickParticipant makes the given participant leave its session by
+// disconnecting its connection. Banned participants can't join the session
+// again with the same client id or wallet address.
+func kickParticipant(session *models.Session, participant *models.Participant, ban bool) {
+	reason := models.LeaveReasonKicked
+	if ban {
+		reason = models.LeaveReasonBanned
+		session.Ban(participant.ClientID, participant.WalletAddress())
+	}
+
+	sendServerMessage(session, ServerMessage{
+		Type:          ServerMessageParticipantLeft,
+		ParticipantID: participant.ID,
+		Reason:        reason,
+	}, participant.ID)
+
+	participant.Kick(reason)
+}
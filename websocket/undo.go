@@ -0,0 +1,345 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/featureflag"
+	"github.com/aukilabs/hagall/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrTypeUndoNotApplicable is the error type returned when an undo or redo
+// operation can't be applied to the current session state.
+const ErrTypeUndoNotApplicable = "undo-not-applicable"
+
+// Undo reverts the last operation made by the current participant. It returns
+// false when there is nothing to undo.
+//
+// The reverted state is broadcasted to all the session participants,
+// including the current one.
+func (h *RealtimeHandler) Undo() (bool, error) {
+	session := h.currentSession
+	participant := h.currentParticipant
+	if participant == nil || session == nil {
+		return false, errors.New("session not joined").
+			WithType(hwebsocket.ErrTypeSessionNotJoined)
+	}
+
+	history := session.UndoHistory()
+
+	op, ok := history.PopUndo(participant.ID)
+	if !ok {
+		return false, nil
+	}
+
+	applied, err := h.applyUndoOp(session, participant, op.Inverse())
+	if err != nil {
+		return false, errors.New("undo failed").Wrap(err)
+	}
+
+	history.Invalidate(participant.ID, applied)
+	history.PushRedo(participant.ID, applied.Inverse())
+	return true, nil
+}
+
+// Redo reapplies the last operation reverted by the current participant. It
+// returns false when there is nothing to redo.
+func (h *RealtimeHandler) Redo() (bool, error) {
+	session := h.currentSession
+	participant := h.currentParticipant
+	if participant == nil || session == nil {
+		return false, errors.New("session not joined").
+			WithType(hwebsocket.ErrTypeSessionNotJoined)
+	}
+
+	history := session.UndoHistory()
+
+	op, ok := history.PopRedo(participant.ID)
+	if !ok {
+		return false, nil
+	}
+
+	applied, err := h.applyUndoOp(session, participant, op)
+	if err != nil {
+		return false, errors.New("redo failed").Wrap(err)
+	}
+
+	history.Invalidate(participant.ID, applied)
+	history.PushUndo(participant.ID, applied)
+	return true, nil
+}
+
+// serveUndoRequest executes an undo or redo server request of the current
+// participant.
+func (h *RealtimeHandler) serveUndoRequest(t ServerMessageType) (hagallpb.ErrorCode, bool) {
+	apply := h.Undo
+	if t == ServerRequestRedo {
+		apply = h.Redo
+	}
+
+	ok, err := apply()
+	switch {
+	case errors.IsType(err, ErrTypeUndoNotApplicable):
+		return hagallpb.ErrorCode_ERROR_CODE_CONFLICT, false
+	case err != nil:
+		return hagallpb.ErrorCode_ERROR_CODE_INTERNAL_SERVER_ERROR, false
+	case !ok:
+		return hagallpb.ErrorCode_ERROR_CODE_NOT_FOUND, false
+	}
+	return hagallpb.ErrorCode_ERROR_CODE_UNKNOWN, true
+}
+
+// applyUndoOp applies the given operation and returns it with the state it
+// replaced, so that its inverse restores it.
+func (h *RealtimeHandler) applyUndoOp(session *models.Session, participant *models.Participant, op models.UndoOp) (models.UndoOp, error) {
+	now := timestamppb.Now()
+
+	switch op.Kind {
+	case models.UndoOpEntityAdd:
+		if _, ok := session.EntityByID(op.Entity.ID); ok {
+			return op, errors.New("entity already exists").
+				WithType(ErrTypeUndoNotApplicable).
+				WithTag("entity_id", op.Entity.ID)
+		}
+
+		entity := &models.Entity{
+			ID:            op.Entity.ID,
+			ParticipantID: participant.ID,
+			Persist:       op.Entity.Persist,
+			Flag:          op.Entity.Flag,
+		}
+		entity.SetPose(op.Entity.Pose)
+
+		session.AddEntity(entity)
+		participant.AddEntity(entity)
+		h.Sessions.Publish(session, models.SessionEvent{
+			Type:          models.SessionEventEntityAdded,
+			ParticipantID: participant.ID,
+			EntityID:      entity.ID,
+		})
+
+		featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityAddBroadcast, func() {
+			session.Broadcast(nil, &hagallpb.EntityAddBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				Entity:          entity.ToProtobuf(),
+			})
+		})
+
+		for _, ec := range op.EntityComponents {
+			if _, err := setEntityComponent(featureFlags(h.FeatureFlags, session), session, participant.ID, ec); err != nil {
+				return op, errors.New("restoring entity component failed").
+					WithType(ErrTypeUndoNotApplicable).
+					Wrap(err)
+			}
+		}
+		return op, nil
+
+	case models.UndoOpEntityDelete:
+		entity, err := ownedEntity(session, participant, op.Entity.ID)
+		if err != nil {
+			return op, err
+		}
+
+		op.Entity = models.NewEntitySnapshot(entity)
+		op.EntityComponents = session.GetEntityComponents().ListByEntityID(entity.ID)
+
+		session.GetEntityComponents().DeleteByEntityID(entity.ID)
+		session.RemoveEntity(entity)
+		participant.RemoveEntity(entity)
+		h.Sessions.Publish(session, models.SessionEvent{
+			Type:          models.SessionEventEntityRemoved,
+			ParticipantID: participant.ID,
+			EntityID:      entity.ID,
+		})
+
+		featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityDeleteBroadcast, func() {
+			session.Broadcast(nil, &hagallpb.EntityDeleteBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_DELETE_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				EntityId:        entity.ID,
+			})
+		})
+		return op, nil
+
+	case models.UndoOpEntityPose:
+		entity, err := ownedEntity(session, participant, op.Entity.ID)
+		if err != nil {
+			return op, err
+		}
+
+		op.PoseBefore = entity.Pose()
+		entity.SetPose(op.PoseAfter)
+
+		featureFlags(h.FeatureFlags, session).IfNotSet(featureflag.FlagDisableEntityUpdatePoseBroadcast, func() {
+			session.Broadcast(nil, &hagallpb.EntityUpdatePoseBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				EntityId:        entity.ID,
+				Pose:            op.PoseAfter.ToProtobuf(),
+			})
+		})
+		return op, nil
+
+	case models.UndoOpEntityComponent:
+		if _, ok := session.EntityByID(op.EntityID()); !ok {
+			return op, errors.New("entity not found").
+				WithType(ErrTypeUndoNotApplicable).
+				WithTag("entity_id", op.EntityID())
+		}
+
+		var err error
+		if after := op.EntityComponentAfter; after != nil {
+			op.EntityComponentBefore, err = setEntityComponent(featureFlags(h.FeatureFlags, session), session, participant.ID, after)
+		} else {
+			before := op.EntityComponentBefore
+			op.EntityComponentBefore, err = deleteEntityComponent(featureFlags(h.FeatureFlags, session), session, participant.ID, before.EntityComponentTypeId, before.EntityId)
+		}
+		if err != nil {
+			return op, errors.New("applying entity component change failed").
+				WithType(ErrTypeUndoNotApplicable).
+				Wrap(err)
+		}
+		return op, nil
+
+	default:
+		return op, errors.New("unknown undo operation").
+			WithType(ErrTypeUndoNotApplicable).
+			WithTag("kind", op.Kind)
+	}
+}
+
+func ownedEntity(session *models.Session, participant *models.Participant, entityID uint32) (*models.Entity, error) {
+	entity, ok := session.EntityByID(entityID)
+	if !ok {
+		return nil, errors.New("entity not found").
+			WithType(ErrTypeUndoNotApplicable).
+			WithTag("entity_id", entityID)
+	}
+
+	if entity.ParticipantID != participant.ID {
+		return nil, errors.New("entity is owned by another participant").
+			WithType(ErrTypeUndoNotApplicable).
+			WithTag("entity_id", entityID).
+			WithTag("participant_id", entity.ParticipantID)
+	}
+	return entity, nil
+}
+
+// setEntityComponent adds or replaces an entity component and returns its
+// previous state.
+func setEntityComponent(flags featureflag.Scoped, session *models.Session, participantID uint32, ec *hagallpb.EntityComponent) (*hagallpb.EntityComponent, error) {
+	store := session.GetEntityComponents()
+	now := timestamppb.Now()
+
+	current, _, err := store.Get(ec.EntityComponentTypeId, ec.EntityId)
+	if err != nil {
+		ec, err := store.Create(copyEntityComponent(ec))
+		if err != nil {
+			return nil, errors.New("adding entity component failed").Wrap(err)
+		}
+		store.RecordChange(models.NewEntityComponentChange(models.EntityComponentOpAdd, ec, 1, participantID))
+
+		flags.IfNotSet(featureflag.FlagDisableEntityComponentAddBroadcast, func() {
+			store.Notify(ec.EntityComponentTypeId, func(participantIDs []uint32) {
+				session.Broadcast(nil, &hagallpb.EntityComponentAddBroadcast{
+					Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_BROADCAST,
+					Timestamp:       now,
+					OriginTimestamp: now,
+					EntityComponent: ec,
+				})
+			})
+		})
+		return nil, nil
+	}
+
+	updated, version, err := store.Apply(copyEntityComponent(ec))
+	if err != nil {
+		return nil, errors.New("updating entity component failed").Wrap(err)
+	}
+	store.RecordChange(models.NewEntityComponentChange(models.EntityComponentOpUpdate, updated, version, participantID))
+
+	flags.IfNotSet(featureflag.FlagDisableEntityComponentUpdateBroadcast, func() {
+		store.NotifyEntityUpdate(updated.EntityComponentTypeId, updated.EntityId, time.Now(), func(participantIDs []uint32) {
+			session.BroadcastTo(nil, &hagallpb.EntityComponentUpdateBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				EntityComponent: updated,
+			}, participantIDs...)
+		})
+	})
+	return current, nil
+}
+
+// deleteEntityComponent deletes an entity component and returns its previous
+// state.
+func deleteEntityComponent(flags featureflag.Scoped, session *models.Session, participantID uint32, entityComponentTypeID, entityID uint32) (*hagallpb.EntityComponent, error) {
+	store := session.GetEntityComponents()
+	now := timestamppb.Now()
+
+	deleted, version, ok := store.Remove(entityComponentTypeID, entityID)
+	if !ok {
+		return nil, errors.New("entity component not found").
+			WithType(ErrTypeEntityComponentNotFound).
+			WithTag("entity_component_type_id", entityComponentTypeID).
+			WithTag("entity_id", entityID)
+	}
+	store.RecordChange(models.NewEntityComponentChange(models.EntityComponentOpDelete, deleted, version, participantID))
+
+	flags.IfNotSet(featureflag.FlagDisableEntityComponentDeleteBroadcast, func() {
+		store.Notify(entityComponentTypeID, func(participantIDs []uint32) {
+			session.Broadcast(nil, &hagallpb.EntityComponentDeleteBroadcast{
+				Type:            hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_DELETE_BROADCAST,
+				Timestamp:       now,
+				OriginTimestamp: now,
+				EntityComponent: &hagallpb.EntityComponent{
+					EntityComponentTypeId: entityComponentTypeID,
+					EntityId:              entityID,
+				},
+			})
+		})
+	})
+	return deleted, nil
+}
+
+// recordEntityComponentUndo records an entity component change in the
+// participant undo stack. Changes to mergeable component kinds can't be
+// reverted and only invalidate the entries of other participants.
+func recordEntityComponentUndo(session *models.Session, participant *models.Participant, before, after *hagallpb.EntityComponent) {
+	op := models.UndoOp{
+		Kind:                  models.UndoOpEntityComponent,
+		EntityComponentBefore: copyEntityComponent(before),
+		EntityComponentAfter:  copyEntityComponent(after),
+	}
+
+	typeID := op.EntityComponentAfter.GetEntityComponentTypeId()
+	if op.EntityComponentAfter == nil {
+		typeID = op.EntityComponentBefore.GetEntityComponentTypeId()
+	}
+
+	kind, _ := session.GetEntityComponents().GetTypeKind(typeID)
+	if kind != models.EntityComponentKindOpaque {
+		session.UndoHistory().Invalidate(participant.ID, op)
+		return
+	}
+	session.UndoHistory().Record(participant.ID, op)
+}
+
+func copyEntityComponent(ec *hagallpb.EntityComponent) *hagallpb.EntityComponent {
+	if ec == nil {
+		return nil
+	}
+
+	return &hagallpb.EntityComponent{
+		EntityComponentTypeId: ec.EntityComponentTypeId,
+		EntityId:              ec.EntityId,
+		Data:                  append([]byte(nil), ec.Data...),
+	}
+}
@@ -0,0 +1,199 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aukilabs/go-tooling/pkg/errors"
+	"github.com/aukilabs/hagall-common/messages/hagallpb"
+	hwebsocket "github.com/aukilabs/hagall-common/websocket"
+	"github.com/aukilabs/hagall/models"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingResponder struct {
+	mutex    sync.Mutex
+	msgs     []hwebsocket.ProtoMsg
+	received []hwebsocket.Msg
+}
+
+func (r *recordingResponder) Send(msg hwebsocket.ProtoMsg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+}
+
+func (r *recordingResponder) SendMsg(msg hwebsocket.Msg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.received = append(r.received, msg)
+}
+
+func (r *recordingResponder) lastReceived() hwebsocket.Msg {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.received[len(r.received)-1]
+}
+
+func (r *recordingResponder) last() hwebsocket.ProtoMsg {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.msgs[len(r.msgs)-1]
+}
+
+func handleTestMsg(t *testing.T, handle func(context.Context, hwebsocket.ResponseSender, hwebsocket.Msg) error, respond hwebsocket.ResponseSender, protoMsg hwebsocket.ProtoMsg) {
+	msg, err := hwebsocket.MsgFromProto(protoMsg)
+	require.NoError(t, err)
+	require.NoError(t, handle(context.Background(), respond, msg))
+}
+
+// joinTestSession joins the given session with a new handler whose messages
+// are recorded. A new session is created when sessionID is empty.
+func joinTestSession(t *testing.T, sessions *models.SessionStore, sessionID string) (*RealtimeHandler, *recordingResponder) {
+	h := &RealtimeHandler{
+		FrameDuration: time.Millisecond * 50,
+		Sessions:      sessions,
+	}
+	respond := &recordingResponder{}
+
+	handleTestMsg(t, func(ctx context.Context, respond hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
+		return h.HandleParticipantJoin(ctx, func() {}, respond, msg)
+	}, respond, &hagallpb.ParticipantJoinRequest{
+		Type:      hagallpb.MsgType_MSG_TYPE_PARTICIPANT_JOIN_REQUEST,
+		SessionId: sessionID,
+	})
+	return h, respond
+}
+
+func TestRealtimeHandlerUndo(t *testing.T) {
+	sessions := &models.SessionStore{DiscoveryService: &testClient{}}
+
+	hA, respondA := joinTestSession(t, sessions, "")
+	defer hA.leaveSession()
+	session := hA.CurrentSession()
+
+	hB, _ := joinTestSession(t, sessions, sessions.GlobalSessionID(session.ID))
+	defer hB.leaveSession()
+
+	handleTestMsg(t, hA.HandleEntityAdd, respondA, &hagallpb.EntityAddRequest{
+		Type: hagallpb.MsgType_MSG_TYPE_ENTITY_ADD_REQUEST,
+		Pose: &hagallpb.Pose{Px: 1},
+	})
+	entityID := respondA.last().(*hagallpb.EntityAddResponse).EntityId
+
+	for i := 2; i <= 3; i++ {
+		handleTestMsg(t, func(ctx context.Context, _ hwebsocket.ResponseSender, msg hwebsocket.Msg) error {
+			return hA.HandleEntityUpdatePose(ctx, msg)
+		}, respondA, &hagallpb.EntityUpdatePose{
+			Type:     hagallpb.MsgType_MSG_TYPE_ENTITY_UPDATE_POSE,
+			EntityId: entityID,
+			Pose:     &hagallpb.Pose{Px: float32(i)},
+		})
+	}
+
+	typeID := session.GetEntityComponents().AddType("color")
+	handleTestMsg(t, hA.HandleEntityComponentAdd, respondA, &hagallpb.EntityComponentAddRequest{
+		Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_ADD_REQUEST,
+		EntityComponentTypeId: typeID,
+		EntityId:              entityID,
+		Data:                  []byte("red"),
+	})
+
+	t.Run("undo entity component add", func(t *testing.T) {
+		ok, err := hA.Undo()
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		_, _, err = session.GetEntityComponents().Get(typeID, entityID)
+		require.Error(t, err)
+	})
+
+	t.Run("undo merged pose updates", func(t *testing.T) {
+		ok, err := hA.Undo()
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		entity, ok := session.EntityByID(entityID)
+		require.True(t, ok)
+		require.Equal(t, models.Pose{PX: 1}, entity.Pose())
+	})
+
+	t.Run("undo entity add", func(t *testing.T) {
+		ok, err := hA.Undo()
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		_, ok = session.EntityByID(entityID)
+		require.False(t, ok)
+
+		ok, err = hA.Undo()
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("redo restores entity and components", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			ok, err := hA.Redo()
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+
+		entity, ok := session.EntityByID(entityID)
+		require.True(t, ok)
+		require.Equal(t, models.Pose{PX: 3}, entity.Pose())
+
+		ec, _, err := session.GetEntityComponents().Get(typeID, entityID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("red"), ec.Data)
+	})
+
+	t.Run("changes from another participant invalidate undo", func(t *testing.T) {
+		handleTestMsg(t, hB.HandleEntityComponentUpdate, nil, &hagallpb.EntityComponentUpdate{
+			Type:                  hagallpb.MsgType_MSG_TYPE_ENTITY_COMPONENT_UPDATE,
+			EntityComponentTypeId: typeID,
+			EntityId:              entityID,
+			Data:                  []byte("blue"),
+		})
+
+		ok, err := hA.Undo()
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ec, _, err := session.GetEntityComponents().Get(typeID, entityID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("blue"), ec.Data)
+
+		entity, ok := session.EntityByID(entityID)
+		require.True(t, ok)
+		require.Equal(t, models.Pose{PX: 1}, entity.Pose())
+	})
+
+	t.Run("undo respects entity ownership", func(t *testing.T) {
+		ok, err := hB.Undo()
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ec, _, err := session.GetEntityComponents().Get(typeID, entityID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("red"), ec.Data)
+
+		// Component changes from another participant invalidate the entity
+		// add operation.
+		ok, err = hA.Undo()
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		entity, _ := session.EntityByID(entityID)
+		entity.ParticipantID = hB.CurrentParticipant().ID
+
+		_, err = hA.Redo()
+		require.Error(t, err)
+		require.True(t, errors.IsType(err, ErrTypeUndoNotApplicable))
+	})
+}